@@ -4,9 +4,21 @@
 package main
 
 import (
+	"bytes"
+	"compress/flate"
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
+	"log"
+	"runtime"
+	"runtime/debug"
+	"sort"
 	"sync"
 	"time"
 )
@@ -14,33 +26,187 @@ import (
 const (
 	CompactBlockSize    = 8   // 64-bit blocks for embedded systems
 	LightweightKeySize  = 10  // 80-bit key for resource efficiency
-	StreamBufferSize    = 32  // Stream cipher buffer
+	StreamBufferSize    = 32  // Stream cipher block, and default buffer size
 	DigestOutputSize    = 16  // 128-bit digest output
 	MaxDeviceConnections = 256
+	DefaultStreamRounds  = 8   // Stream cipher rounds
+
+	// Shortest permitted authentication tag. Below 64 bits an online forger's
+	// per-attempt success chance (2^-8n for n bytes) is no longer negligible.
+	MinAuthenticationTagSize = 8
+
+	DefaultTicketLifetime    = time.Hour
+	MaxSessionsPerDevice     = 8 // oldest session is dropped beyond this
+	DefaultChallengeLifetime = 30 * time.Second
+
+	// Replay detection: nonces are remembered for the window, up to the
+	// capacity; see CheckNonce for what happens beyond either bound
+	DefaultReplayWindow   = 5 * time.Minute
+	DefaultNonceCacheSize = 1 << 16
+
+	// Session keys. Authentication derives a session secret from the device key
+	// and the exchange, then expands it into a compact cipher key and a separate
+	// stream key, so neither cipher's key size constrains the other.
+	SessionSecretSize       = sha256.Size
+	MinSessionKeyLength     = 16 // StreamProcessor keys on the first 16 bytes
+	DefaultSessionKeyLength = MinSessionKeyLength
+
+	// Session nonces are derived from the session's EncryptionState, which
+	// must hold at least one cipher block
+	SessionNonceSize         = 8
+	MinEncryptionStateLength = CompactBlockSize
+
+	// Random per-payload nonce mixed into the session nonce, so payloads of a
+	// session don't share keystream. The mixed nonce is still 64 bits, so
+	// re-authenticate well before 2^32 payloads.
+	MessageNonceSize = 8
+)
+
+// Domain-separation labels, prefixed to every keyed digest input so output
+// computed for one purpose is never valid for another. No label is a prefix
+// of another.
+const (
+	keyDerivationLabel     = "iot-device-key-derivation"
+	authTagLabel           = "iot-authentication-tag"
+	payloadTagLabel        = "iot-session-payload"
+	headerPayloadTagLabel  = "iot-session-header-payload"
+	transmissionTagLabel   = "iot-transmission-tag"
+	transmissionChunkLabel = "iot-transmission-chunk-tag"
+	sessionSecretLabel     = "iot-session-secret"
+	sessionCipherKeyLabel  = "iot-session-cipher-key"
+	sessionStreamKeyLabel  = "iot-session-stream-key"
+	streamKeyExpandLabel   = "iot-stream-key-expansion"
+	sessionNonceLabel      = "iot-session-nonce"
+	messageNonceLabel      = "iot-message-nonce"
 )
 
 type SecurityController struct {
-	deviceSessions   map[string]*DeviceSession
+	deviceSessions   map[string]*DeviceSession // by session ID
+	sessionsByDevice map[string][]string       // session IDs per device, oldest first
 	sessionMutex     sync.RWMutex
-	compactCipher    *CompactCipherEngine
-	streamProcessor  *StreamProcessor
+	compactCipher    *CompactCipherEngine // template, cloned per operation
+	streamProcessor  *StreamProcessor     // template, cloned per operation
 	digestCalculator *DigestCalculator
 	keyManager       *KeyManager
+	tagLength        int        // authentication tag bytes, guarded by sessionMutex
+	authDigest       AuthDigest // guarded by sessionMutex
+	sessionKeyLength int        // stream key bytes, guarded by sessionMutex
+	ticketKey        []byte
+	ticketLifetime   time.Duration
+
+	challengeMutex    sync.Mutex
+	pendingChallenges map[string]*pendingChallenge
+	challengeLifetime time.Duration
+
+	nonces *nonceCache
+
+	// Authentication admission, nil when unlimited; see SetAuthLimits
+	authSlots   chan struct{} // held while authenticating
+	authQueue   chan struct{} // held while authenticating or waiting
+	authTimeout time.Duration
+
+	clock Clock
+}
+
+// Returned in place of a panic inside a public controller method. The panic and
+// its stack are logged rather than returned, so callers see no internals.
+var ErrInternal = errors.New("internal error")
+
+// Deferred by public methods: convert a panic into ErrInternal
+func recoverInternal(err *error, method string) {
+	if r := recover(); r != nil {
+		log.Printf("%s: recovered panic: %v\n%s", method, r, debug.Stack())
+		*err = fmt.Errorf("%w in %s", ErrInternal, method)
+	}
+}
+
+// Returned when authentication can't start in time, or its queue is full
+var ErrAuthTimeout = errors.New("authentication timed out")
+
+// Time source for session bookkeeping and expiry checks
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Manually advanced clock, so expiry can be tested without sleeping
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	c.mutex.Unlock()
+}
+
+// Challenge issued to a device and not yet answered
+type pendingChallenge struct {
+	deviceID  string
+	challenge []byte
+	expiresAt time.Time
 }
 
 type DeviceSession struct {
 	DeviceID         string
-	SessionKey       []byte
+	SessionID        string // random per authentication, safe to log
+	SessionKey       []byte // stream key for payloads and transmission tags
+	CipherKey        []byte // compact cipher key, LightweightKeySize bytes
 	LastActivity     time.Time
 	EncryptionState  []byte
 	AuthenticationTag []byte
+	Challenge        []byte // challenge the session was authenticated against
+	CompressPayloads bool // deflate payloads before encryption
+	PaddingBucket    int  // pad payloads to a multiple of this size, 0 disables
+	CreatedAt        time.Time
+	MessageCount     uint64
+	BytesTransmitted uint64
+}
+
+// Per-device traffic summary for dashboards
+type DeviceStats struct {
+	MessageCount     uint64
+	BytesTransmitted uint64
+	LastActivity     time.Time
+	SessionAge       time.Duration
+}
+
+// Non-secret view of a device session; keys and cipher state are never copied
+type SessionSnapshot struct {
+	DeviceID         string
+	SessionID        string
+	CreatedAt        time.Time
+	LastActivity     time.Time
+	SessionAge       time.Duration
+	MessageCount     uint64
+	BytesTransmitted uint64
+	CompressPayloads bool
+	PaddingBucket    int
 }
 
 // Compact cipher for resource-constrained environments
 type CompactCipherEngine struct {
 	keySchedule [32]uint16
 	sboxes      [4][16]uint8
+	sboxLayout  [8]int // S-box applied to each nibble of the F-function input
 	rounds      int
+	byteOrder   binary.ByteOrder
 }
 
 // Lightweight stream processor
@@ -49,6 +215,24 @@ type StreamProcessor struct {
 	counter  uint64
 	keystream []byte
 	position int
+	rounds   int
+	stats    KeystreamStats
+}
+
+// Keystream utilization since the processor was created. Bytes still buffered
+// when the processor is re-initialized or resized are discarded unused.
+type KeystreamStats struct {
+	Generated uint64
+	Consumed  uint64
+	Discarded uint64
+}
+
+// Fraction of generated keystream that was consumed; 0 before any generation
+func (ks KeystreamStats) Utilization() float64 {
+	if ks.Generated == 0 {
+		return 0
+	}
+	return float64(ks.Consumed) / float64(ks.Generated)
 }
 
 // Efficient digest calculation
@@ -56,6 +240,7 @@ type DigestCalculator struct {
 	state  [4]uint32
 	buffer []byte
 	length uint64
+	byteOrder binary.ByteOrder
 }
 
 // Key management for device authentication
@@ -63,23 +248,188 @@ type KeyManager struct {
 	masterKey    []byte
 	deviceKeys   map[string][]byte
 	keyDerivation func([]byte, string) []byte
+	keyMutex     sync.Mutex
+	salt         []byte // deployment-wide derivation salt, empty for unsalted
+	provider     KeyProvider
+}
+
+// Source of device keys. The default derives from the key manager's in-memory
+// master key; external providers keep the master key out of process entirely.
+type KeyProvider interface {
+	DeriveDeviceKey(deviceID string) ([]byte, error)
 }
 
 func NewSecurityController() *SecurityController {
 	sc := &SecurityController{
 		deviceSessions:   make(map[string]*DeviceSession),
+		sessionsByDevice: make(map[string][]string),
 		compactCipher:    NewCompactCipherEngine(),
 		streamProcessor:  NewStreamProcessor(),
 		digestCalculator: NewDigestCalculator(),
 		keyManager:       NewKeyManager(),
+		tagLength:        DigestOutputSize,
+		sessionKeyLength: DefaultSessionKeyLength,
+		ticketLifetime:   DefaultTicketLifetime,
+
+		pendingChallenges: make(map[string]*pendingChallenge),
+		challengeLifetime: DefaultChallengeLifetime,
+		nonces:            newNonceCache(DefaultReplayWindow, DefaultNonceCacheSize),
+		clock:             systemClock{},
 	}
 
+	// Server-only key sealing session resumption tickets
+	sc.ticketKey = make([]byte, 16)
+	rand.Read(sc.ticketKey)
+
 	return sc
 }
 
+// Replace the controller's time source. Set it before the controller is in use;
+// the clock itself is not synchronized.
+func (sc *SecurityController) SetClock(clock Clock) {
+	sc.clock = clock
+}
+
+// Digest computing authentication tags. Both ends of a link must agree, and
+// changing it invalidates responses to outstanding challenges.
+type AuthDigest int
+
+const (
+	LegacyAuthDigest AuthDigest = iota // DigestCalculator, MD5-like and weak
+	StrongAuthDigest                   // HMAC-SHA-256, the transaction processor's digest
+)
+
+// Select the authentication tag digest; tags are truncated to the configured
+// tag length either way
+func (sc *SecurityController) SetAuthDigest(digest AuthDigest) error {
+	if digest != LegacyAuthDigest && digest != StrongAuthDigest {
+		return fmt.Errorf("unknown authentication digest: %d", digest)
+	}
+
+	sc.sessionMutex.Lock()
+	sc.authDigest = digest
+	sc.sessionMutex.Unlock()
+	return nil
+}
+
+// Length of the stream keys derived for new sessions. Devices must use the same
+// length; existing sessions keep theirs.
+func (sc *SecurityController) SetSessionKeyLength(length int) error {
+	if length < MinSessionKeyLength || length > SessionSecretSize {
+		return fmt.Errorf("session key length must be between %d and %d bytes, got %d",
+			MinSessionKeyLength, SessionSecretSize, length)
+	}
+
+	sc.sessionMutex.Lock()
+	sc.sessionKeyLength = length
+	sc.sessionMutex.Unlock()
+	return nil
+}
+
+// Session secret for the exchange of challenge and response under deviceKey
+func deriveSessionSecret(deviceKey, challenge, response []byte) []byte {
+	mac := hmac.New(sha256.New, deviceKey)
+	mac.Write([]byte(sessionSecretLabel))
+	mac.Write(challenge)
+	mac.Write(response)
+	return mac.Sum(nil)
+}
+
+// HKDF-Expand (RFC 5869) of secret to length bytes, with label as the info
+func expandSessionSecret(secret []byte, label string, length int) []byte {
+	var okm, block []byte
+	for counter := byte(1); len(okm) < length; counter++ {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(block)
+		mac.Write([]byte(label))
+		mac.Write([]byte{counter})
+		block = mac.Sum(nil)
+		okm = append(okm, block...)
+	}
+	return okm[:length]
+}
+
+// Key for StreamProcessor.Initialize. Keys shorter than MinSessionKeyLength, such
+// as a LightweightKeySize device key passed to SealTransmission, are expanded
+// by HKDF instead of tripping Initialize's length check. Longer keys pass
+// through, and an empty key is still rejected by Initialize.
+func streamKey(key []byte) []byte {
+	if len(key) == 0 || len(key) >= MinSessionKeyLength {
+		return key
+	}
+
+	return extractAndExpand(key, streamKeyExpandLabel, MinSessionKeyLength)
+}
+
+// HKDF (RFC 5869) of material to length bytes, with label as both salt and info
+func extractAndExpand(material []byte, label string, length int) []byte {
+	extract := hmac.New(sha256.New, []byte(label))
+	extract.Write(material)
+	return expandSessionSecret(extract.Sum(nil), label, length)
+}
+
+// Stream nonce for a session, derived from its EncryptionState so the nonce no
+// longer depends on the block cipher's output size. Payloads mix it with their
+// own message nonce; see messageStreamNonce.
+func sessionNonce(encryptionState []byte) ([]byte, error) {
+	if len(encryptionState) < MinEncryptionStateLength {
+		return nil, fmt.Errorf("session encryption state too short: %d bytes, need %d",
+			len(encryptionState), MinEncryptionStateLength)
+	}
+	return extractAndExpand(encryptionState, sessionNonceLabel, SessionNonceSize), nil
+}
+
+// Stream nonce for one session payload. The session nonce alone is fixed for
+// the session's lifetime; reusing it for every payload would reuse keystream.
+func messageStreamNonce(sessionNonce, messageNonce []byte) []byte {
+	material := make([]byte, 0, len(sessionNonce)+len(messageNonce))
+	material = append(material, sessionNonce...)
+	material = append(material, messageNonce...)
+	return extractAndExpand(material, messageNonceLabel, SessionNonceSize)
+}
+
+// Compact cipher and stream keys for a session; both ends derive the same pair
+func deriveSessionKeys(deviceKey, challenge, response []byte, streamKeyLength int) (cipherKey, streamKey []byte) {
+	secret := deriveSessionSecret(deviceKey, challenge, response)
+	cipherKey = expandSessionSecret(secret, sessionCipherKeyLabel, LightweightKeySize)
+	streamKey = expandSessionSecret(secret, sessionStreamKeyLabel, streamKeyLength)
+	return cipherKey, streamKey
+}
+
+// Truncate authentication tags to length bytes for bandwidth-constrained links
+func (sc *SecurityController) SetAuthenticationTagLength(length int) error {
+	if length < MinAuthenticationTagSize || length > DigestOutputSize {
+		return fmt.Errorf("authentication tag length must be between %d and %d bytes, got %d",
+			MinAuthenticationTagSize, DigestOutputSize, length)
+	}
+
+	sc.sessionMutex.Lock()
+	sc.tagLength = length
+	sc.sessionMutex.Unlock()
+	return nil
+}
+
+// Default F-function layout cycles through the four S-boxes
+var DefaultSboxLayout = [8]int{0, 1, 2, 3, 0, 1, 2, 3}
+
 func NewCompactCipherEngine() *CompactCipherEngine {
+	engine, _ := NewCompactCipherEngineWithLayout(DefaultSboxLayout)
+	return engine
+}
+
+// Alternate S-box orderings for diffusion analysis; ciphertext is not
+// compatible across layouts
+func NewCompactCipherEngineWithLayout(layout [8]int) (*CompactCipherEngine, error) {
+	for _, box := range layout {
+		if box < 0 || box >= 4 {
+			return nil, fmt.Errorf("invalid S-box index in layout: %d", box)
+		}
+	}
+
 	engine := &CompactCipherEngine{
-		rounds: 16,
+		sboxLayout: layout,
+		rounds:     16,
+		byteOrder:  binary.LittleEndian,
 	}
 
 	// Initialize substitution boxes for lightweight operation
@@ -89,7 +439,7 @@ func NewCompactCipherEngine() *CompactCipherEngine {
 		}
 	}
 
-	return engine
+	return engine, nil
 }
 
 func (ce *CompactCipherEngine) SetKey(key []byte) {
@@ -127,6 +477,54 @@ func (ce *CompactCipherEngine) SetKey(key []byte) {
 	}
 }
 
+// Known-answer vector for SetKeyChecked: the default engine (DefaultSboxLayout,
+// little-endian halves) encrypts compactKATPlaintext under compactKATKey to
+// compactKATCiphertext
+var (
+	compactKATKey        = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF, 0xFE, 0xDC}
+	compactKATPlaintext  = []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0xAB, 0xCD, 0xEF}
+	compactKATCiphertext = []byte{0x04, 0x17, 0xE0, 0xCC, 0x1E, 0x74, 0xBC, 0x08}
+)
+
+// SetKey that reports a bad key length as an error and first runs a
+// known-answer test, so a corrupted S-box table, round count or schedule
+// derivation fails here rather than silently miskeying. A Feistel network
+// decrypts correctly under any schedule, so a round trip alone proves nothing.
+func (ce *CompactCipherEngine) SetKeyChecked(key []byte) error {
+	if len(key) != LightweightKeySize {
+		return fmt.Errorf("compact cipher key must be %d bytes, got %d", LightweightKeySize, len(key))
+	}
+	if err := ce.selfTest(); err != nil {
+		return err
+	}
+
+	ce.SetKey(key)
+	return nil
+}
+
+// Known-answer test of the engine's S-boxes, rounds and key schedule. Layout
+// and byte order are validated when set, so the test runs under the defaults
+// the vector was recorded with.
+func (ce *CompactCipherEngine) selfTest() error {
+	kat := ce.Clone()
+	kat.sboxLayout = DefaultSboxLayout
+	kat.byteOrder = binary.LittleEndian
+	kat.SetKey(compactKATKey)
+
+	if !bytes.Equal(kat.EncryptBlock(compactKATPlaintext), compactKATCiphertext) {
+		return fmt.Errorf("compact cipher known-answer test failed")
+	}
+	return nil
+}
+
+// Independent engine with the same S-boxes, layout and byte order; the
+// key schedule must be set again before use
+func (ce *CompactCipherEngine) Clone() *CompactCipherEngine {
+	clone := *ce
+	clone.keySchedule = [32]uint16{}
+	return &clone
+}
+
 func (ce *CompactCipherEngine) applySbox(input uint8, boxIndex int) uint16 {
 	return uint16(ce.sboxes[boxIndex][input&0xF])
 }
@@ -137,8 +535,8 @@ func (ce *CompactCipherEngine) EncryptBlock(plaintext []byte) []byte {
 	}
 
 	// Convert to 16-bit words for compact processing
-	left := binary.LittleEndian.Uint32(plaintext[0:4])
-	right := binary.LittleEndian.Uint32(plaintext[4:8])
+	left := ce.byteOrder.Uint32(plaintext[0:4])
+	right := ce.byteOrder.Uint32(plaintext[4:8])
 
 	// Feistel network with compact F-function
 	for round := 0; round < ce.rounds; round++ {
@@ -149,12 +547,40 @@ func (ce *CompactCipherEngine) EncryptBlock(plaintext []byte) []byte {
 
 	// Convert back to bytes
 	result := make([]byte, CompactBlockSize)
-	binary.LittleEndian.PutUint32(result[0:4], right)
-	binary.LittleEndian.PutUint32(result[4:8], left)
+	ce.byteOrder.PutUint32(result[0:4], right)
+	ce.byteOrder.PutUint32(result[4:8], left)
+
+	return result
+}
+
+// Inverse of EncryptBlock: the same network with round keys reversed
+func (ce *CompactCipherEngine) DecryptBlock(ciphertext []byte) []byte {
+	if len(ciphertext) != CompactBlockSize {
+		panic("Invalid block size")
+	}
+
+	left := ce.byteOrder.Uint32(ciphertext[0:4])
+	right := ce.byteOrder.Uint32(ciphertext[4:8])
+
+	for round := ce.rounds - 1; round >= 0; round-- {
+		temp := right
+		right = left ^ ce.fFunction(right, ce.keySchedule[round])
+		left = temp
+	}
+
+	result := make([]byte, CompactBlockSize)
+	ce.byteOrder.PutUint32(result[0:4], right)
+	ce.byteOrder.PutUint32(result[4:8], left)
 
 	return result
 }
 
+// Byte order used to split blocks into Feistel halves (little-endian by
+// default). The key schedule always reads key words little-endian.
+func (ce *CompactCipherEngine) SetByteOrder(order binary.ByteOrder) {
+	ce.byteOrder = order
+}
+
 func (ce *CompactCipherEngine) fFunction(input uint32, roundKey uint16) uint32 {
 	// XOR with round key (extended to 32 bits)
 	expandedKey := uint32(roundKey) | (uint32(roundKey) << 16)
@@ -164,7 +590,7 @@ func (ce *CompactCipherEngine) fFunction(input uint32, roundKey uint16) uint32 {
 	var output uint32
 	for i := 0; i < 8; i++ {
 		nibble := uint8((input >> (i * 4)) & 0xF)
-		substituted := ce.applySbox(nibble, i%4)
+		substituted := ce.applySbox(nibble, ce.sboxLayout[i])
 		output |= uint32(substituted&0xF) << (i * 4)
 	}
 
@@ -176,9 +602,48 @@ func (ce *CompactCipherEngine) fFunction(input uint32, roundKey uint16) uint32 {
 }
 
 func NewStreamProcessor() *StreamProcessor {
+	sp, _ := NewStreamProcessorWithRounds(DefaultStreamRounds)
+	return sp
+}
+
+// Round count trades throughput for security margin (8, 12 or 20).
+// Both ends of a link must agree: changing it changes the keystream and
+// breaks interop with peers using a different count.
+func NewStreamProcessorWithRounds(rounds int) (*StreamProcessor, error) {
+	switch rounds {
+	case 8, 12, 20:
+	default:
+		return nil, fmt.Errorf("unsupported stream round count: %d", rounds)
+	}
+
 	return &StreamProcessor{
 		keystream: make([]byte, StreamBufferSize),
 		position:  StreamBufferSize, // Force initial generation
+		rounds:    rounds,
+	}, nil
+}
+
+// Buffer more keystream per regeneration for bulk transfers. The size must
+// be a multiple of StreamBufferSize; keystream is produced in blocks of that
+// size either way, so output is identical for any buffer size. Call before
+// Initialize, since resizing discards buffered keystream.
+func (sp *StreamProcessor) SetBufferSize(size int) error {
+	if size <= 0 || size%StreamBufferSize != 0 {
+		return fmt.Errorf("stream buffer size must be a positive multiple of %d, got %d", StreamBufferSize, size)
+	}
+
+	sp.discardBuffered()
+	sp.keystream = make([]byte, size)
+	sp.position = size
+	return nil
+}
+
+// Fresh processor with the same configuration; must be initialized before use
+func (sp *StreamProcessor) Clone() *StreamProcessor {
+	return &StreamProcessor{
+		keystream: make([]byte, len(sp.keystream)),
+		position:  len(sp.keystream),
+		rounds:    sp.rounds,
 	}
 }
 
@@ -199,11 +664,43 @@ func (sp *StreamProcessor) Initialize(key []byte, nonce []byte) {
 		sp.state[3] ^= binary.LittleEndian.Uint32(nonce[4:8])
 	}
 
+	sp.discardBuffered()
 	sp.counter = 0
-	sp.position = StreamBufferSize
+	sp.position = len(sp.keystream)
+}
+
+// Position the keystream byteOffset bytes past the start set by Initialize, for
+// random-access decryption. Block n of the keystream depends only on counter
+// n, so seeking regenerates just the buffer holding the offset.
+func (sp *StreamProcessor) Seek(byteOffset uint64) {
+	sp.discardBuffered()
+	sp.counter = byteOffset / StreamBufferSize
+	sp.generateKeystream()
+
+	// Keystream ahead of the offset in its block is never used
+	sp.position = int(byteOffset % StreamBufferSize)
+	sp.stats.Discarded += uint64(sp.position)
+}
+
+func (sp *StreamProcessor) discardBuffered() {
+	sp.stats.Discarded += uint64(len(sp.keystream) - sp.position)
+}
+
+// Keystream counters. Successive EncryptData calls between Initialize calls
+// continue one keystream, so only re-initialization and Seek discard bytes.
+func (sp *StreamProcessor) Stats() KeystreamStats {
+	return sp.stats
 }
 
 func (sp *StreamProcessor) generateKeystream() {
+	for offset := 0; offset < len(sp.keystream); offset += StreamBufferSize {
+		sp.generateBlock(sp.keystream[offset : offset+StreamBufferSize])
+	}
+	sp.position = 0
+	sp.stats.Generated += uint64(len(sp.keystream))
+}
+
+func (sp *StreamProcessor) generateBlock(block []byte) {
 	// Working state for stream generation
 	working := sp.state
 
@@ -212,7 +709,7 @@ func (sp *StreamProcessor) generateKeystream() {
 	working[3] ^= uint32(sp.counter >> 32)
 
 	// Lightweight stream cipher rounds
-	for round := 0; round < 8; round++ {
+	for round := 0; round < sp.rounds; round++ {
 		// Quarter-round like operations
 		working[0] += working[1]
 		working[3] ^= working[0]
@@ -234,29 +731,29 @@ func (sp *StreamProcessor) generateKeystream() {
 	// Convert to keystream bytes
 	for i := 0; i < 4; i++ {
 		result := working[i] + sp.state[i] // Add original state
-		binary.LittleEndian.PutUint32(sp.keystream[i*4:(i+1)*4], result)
+		binary.LittleEndian.PutUint32(block[i*4:(i+1)*4], result)
 	}
 
 	// Generate additional bytes with simple LFSR
 	block_cipher_128 := working[0] ^ working[1] ^ working[2] ^ working[3]
-	for i := 16; i < StreamBufferSize; i++ {
+	for i := 16; i < len(block); i++ {
 		block_cipher_128 = ((block_cipher_128 << 1) | (block_cipher_128 >> 31)) & 0xFFFFFFFF
 		feedback := ((block_cipher_128 >> 31) ^ (block_cipher_128 >> 21) ^ (block_cipher_128 >> 1) ^ (block_cipher_128 >> 0)) & 1
 		block_cipher_128 = (block_cipher_128 << 1) | feedback
-		sp.keystream[i] = uint8(block_cipher_128 & 0xFF)
+		block[i] = uint8(block_cipher_128 & 0xFF)
 	}
 
 	sp.counter++
-	sp.position = 0
 }
 
 func (sp *StreamProcessor) GetStreamByte() uint8 {
-	if sp.position >= StreamBufferSize {
+	if sp.position >= len(sp.keystream) {
 		sp.generateKeystream()
 	}
 
 	result := sp.keystream[sp.position]
 	sp.position++
+	sp.stats.Consumed++
 	return result
 }
 
@@ -272,14 +769,30 @@ func NewDigestCalculator() *DigestCalculator {
 	return &DigestCalculator{
 		state:  [4]uint32{0x67452301, 0xEFCDAB89, 0x98BADCFE, 0x10325476},
 		buffer: make([]byte, 64),
+		byteOrder: binary.LittleEndian,
 	}
 }
 
-func (dc *DigestCalculator) Update(data []byte) {
-	dc.length += uint64(len(data))
+// Digest with a domain-separation label already absorbed
+func newLabeledDigest(label string) *DigestCalculator {
+	dc := NewDigestCalculator()
+	dc.Update([]byte(label))
+	return dc
+}
+
+// Byte order for message words, the length trailer and the digest output
+// (little-endian by default, as in the Hash128 family)
+func (dc *DigestCalculator) SetByteOrder(order binary.ByteOrder) {
+	dc.byteOrder = order
+}
 
+// Each byte is buffered before it is counted. Older versions counted the whole
+// call first, so its bytes all landed in one buffer slot and most input never
+// reached processBlock; digests, derived keys and tags from those versions differ.
+func (dc *DigestCalculator) Update(data []byte) {
 	for _, b := range data {
 		dc.buffer[dc.length%64] = b
+		dc.length++
 
 		if (dc.length % 64) == 0 {
 			dc.processBlock()
@@ -291,7 +804,7 @@ func (dc *DigestCalculator) processBlock() {
 	// Convert buffer to 32-bit words
 	words := make([]uint32, 16)
 	for i := 0; i < 16; i++ {
-		words[i] = binary.LittleEndian.Uint32(dc.buffer[i*4 : (i+1)*4])
+		words[i] = dc.byteOrder.Uint32(dc.buffer[i*4 : (i+1)*4])
 	}
 
 	// Initialize working vKoreanAdvancedCipherbles
@@ -340,36 +853,211 @@ func (dc *DigestCalculator) Finalize() []byte {
 
 	// Append length
 	lengthBytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(lengthBytes, originalLength*8)
+	dc.byteOrder.PutUint64(lengthBytes, originalLength*8)
 	dc.Update(lengthBytes)
 
 	// Extract digest
 	result := make([]byte, DigestOutputSize)
 	for i := 0; i < 4; i++ {
-		binary.LittleEndian.PutUint32(result[i*4:(i+1)*4], dc.state[i])
+		dc.byteOrder.PutUint32(result[i*4:(i+1)*4], dc.state[i])
 	}
 
 	return result
 }
 
+const (
+	// Version 2 added the byte order; version 1 states are always little-endian
+	digestStateMagic   = "dgc\x02"
+	digestStateMagicV1 = "dgc\x01"
+	digestStateSize    = len(digestStateMagic) + 1 + 4*4 + 64 + 8
+	digestStateSizeV1  = len(digestStateMagicV1) + 4*4 + 64 + 8
+
+	digestLittleEndian byte = 0
+	digestBigEndian    byte = 1
+)
+
+// Checkpoint the running digest so large inputs can be hashed across runs
+func (dc *DigestCalculator) MarshalBinary() ([]byte, error) {
+	var order byte
+	switch dc.byteOrder {
+	case binary.LittleEndian:
+		order = digestLittleEndian
+	case binary.BigEndian:
+		order = digestBigEndian
+	default:
+		return nil, fmt.Errorf("digest byte order %v cannot be checkpointed", dc.byteOrder)
+	}
+
+	data := make([]byte, 0, digestStateSize)
+	data = append(data, digestStateMagic...)
+	data = append(data, order)
+	for i := 0; i < 4; i++ {
+		data = binary.LittleEndian.AppendUint32(data, dc.state[i])
+	}
+	data = append(data, dc.buffer[:64]...)
+	data = binary.LittleEndian.AppendUint64(data, dc.length)
+	return data, nil
+}
+
+// Restores the state and byte order saved by MarshalBinary, replacing any byte
+// order set on dc
+func (dc *DigestCalculator) UnmarshalBinary(data []byte) error {
+	var order binary.ByteOrder
+	switch {
+	case len(data) == digestStateSize && string(data[:len(digestStateMagic)]) == digestStateMagic:
+		switch data[len(digestStateMagic)] {
+		case digestLittleEndian:
+			order = binary.LittleEndian
+		case digestBigEndian:
+			order = binary.BigEndian
+		default:
+			return fmt.Errorf("invalid digest state")
+		}
+		data = data[len(digestStateMagic)+1:]
+	case len(data) == digestStateSizeV1 && string(data[:len(digestStateMagicV1)]) == digestStateMagicV1:
+		order = binary.LittleEndian
+		data = data[len(digestStateMagicV1):]
+	default:
+		return fmt.Errorf("invalid digest state")
+	}
+
+	for i := 0; i < 4; i++ {
+		dc.state[i] = binary.LittleEndian.Uint32(data[i*4 : (i+1)*4])
+	}
+	data = data[16:]
+
+	dc.buffer = make([]byte, 64)
+	copy(dc.buffer, data[:64])
+	dc.length = binary.LittleEndian.Uint64(data[64:72])
+	dc.byteOrder = order
+
+	return nil
+}
+
 func NewKeyManager() *KeyManager {
+	return NewKeyManagerWithSalt(nil)
+}
+
+// Key manager whose derivations mix in a deployment-wide salt, so the same master
+// key and device ID yield unrelated keys in different deployments
+func NewKeyManagerWithSalt(salt []byte) *KeyManager {
+	km, err := NewKeyManagerFromSource(rand.Reader, salt, DefaultMasterKeyPolicy)
+	if err != nil {
+		// Only a broken system RNG gets here; no key manager is safe to return
+		panic(err)
+	}
+	return km
+}
+
+// Returned when the RNG keeps producing master keys that fail the sanity check
+var ErrLowEntropyKey = errors.New("master key failed entropy check")
+
+// Sanity check applied to a freshly drawn master key. It catches a stuck or
+// broken RNG (all zeros, a repeated byte, a short cycle) by counting distinct
+// byte values; it is not an entropy estimate. 16 uniform bytes have fewer than
+// 8 distinct values with probability around 10^-12.
+type MasterKeyPolicy struct {
+	MinDistinctBytes int // distinct byte values the key must contain
+	MaxDraws         int // draws attempted before giving up
+}
+
+var DefaultMasterKeyPolicy = MasterKeyPolicy{MinDistinctBytes: 8, MaxDraws: 3}
+
+// Key manager drawing its master key from source, redrawing keys that fail policy
+func NewKeyManagerFromSource(source io.Reader, salt []byte, policy MasterKeyPolicy) (*KeyManager, error) {
+	if policy.MaxDraws < 1 {
+		policy.MaxDraws = 1
+	}
+
+	masterKey := make([]byte, 16)
+	for draw := 1; ; draw++ {
+		if _, err := io.ReadFull(source, masterKey); err != nil {
+			return nil, fmt.Errorf("reading master key: %w", err)
+		}
+		if distinctBytes(masterKey) >= policy.MinDistinctBytes {
+			break
+		}
+		if draw == policy.MaxDraws {
+			return nil, fmt.Errorf("%w: %d draws with fewer than %d distinct bytes",
+				ErrLowEntropyKey, draw, policy.MinDistinctBytes)
+		}
+	}
+
 	km := &KeyManager{
 		deviceKeys: make(map[string][]byte),
+		salt:       append([]byte(nil), salt...),
+		masterKey:  masterKey,
 	}
 
-	// Initialize master key
-	km.masterKey = make([]byte, 16)
-	rand.Read(km.masterKey)
-
 	// Set key derivation function
 	km.keyDerivation = km.deriveDeviceKey
+	km.provider = localKeyProvider{km: km}
 
-	return km
+	return km, nil
+}
+
+func distinctBytes(data []byte) int {
+	var seen [256]bool
+	count := 0
+	for _, b := range data {
+		if !seen[b] {
+			seen[b] = true
+			count++
+		}
+	}
+	return count
+}
+
+// Key manager delegating every derivation to provider; no master key is held locally
+func NewKeyManagerWithProvider(provider KeyProvider) *KeyManager {
+	return &KeyManager{
+		deviceKeys: make(map[string][]byte),
+		provider:   provider,
+	}
+}
+
+// Derives from the key manager's local master key
+type localKeyProvider struct {
+	km *KeyManager
+}
+
+func (p localKeyProvider) DeriveDeviceKey(deviceID string) ([]byte, error) {
+	return p.km.keyDerivation(p.km.masterKey, deviceID), nil
+}
+
+// Stub provider for an external KMS such as AWS KMS (GenerateMac) or Vault
+// (transit HMAC). Derive performs the client call; only derived device keys
+// ever enter this process.
+type KMSKeyProvider struct {
+	KeyID  string // KMS key ARN or Vault transit key name
+	Derive func(keyID, deviceID string) ([]byte, error)
+}
+
+func (p *KMSKeyProvider) DeriveDeviceKey(deviceID string) ([]byte, error) {
+	if p.Derive == nil {
+		return nil, fmt.Errorf("KMS client not configured for key %q", p.KeyID)
+	}
+
+	key, err := p.Derive(p.KeyID, deviceID)
+	if err != nil {
+		return nil, fmt.Errorf("KMS derivation failed for device %s: %w", deviceID, err)
+	}
+	if len(key) < LightweightKeySize {
+		return nil, fmt.Errorf("KMS returned %d key bytes, need %d", len(key), LightweightKeySize)
+	}
+	return key[:LightweightKeySize], nil
 }
 
 func (km *KeyManager) deriveDeviceKey(masterKey []byte, deviceID string) []byte {
 	// Simple key derivation based on device ID
-	dc := NewDigestCalculator()
+	dc := newLabeledDigest(keyDerivationLabel)
+	if len(km.salt) > 0 {
+		// Length-prefix the salt so salt and master key bytes can't trade places
+		saltLength := make([]byte, 4)
+		binary.LittleEndian.PutUint32(saltLength, uint32(len(km.salt)))
+		dc.Update(saltLength)
+		dc.Update(km.salt)
+	}
 	dc.Update(masterKey)
 	dc.Update([]byte(deviceID))
 
@@ -377,74 +1065,1717 @@ func (km *KeyManager) deriveDeviceKey(masterKey []byte, deviceID string) []byte
 	return digest[:LightweightKeySize] // Return first 80 bits
 }
 
-func (km *KeyManager) GetDeviceKey(deviceID string) []byte {
-	if key, exists := km.deviceKeys[deviceID]; exists {
-		return key
-	}
+func (km *KeyManager) GetDeviceKey(deviceID string) ([]byte, error) {
+	km.keyMutex.Lock()
+	key, exists := km.deviceKeys[deviceID]
+	km.keyMutex.Unlock()
 
-	// Derive new key
-	key := km.keyDerivation(km.masterKey, deviceID)
-	km.deviceKeys[deviceID] = key
-	return key
-}
+	if exists {
+		return key, nil
+	}
 
-func (sc *SecurityController) AuthenticateDevice(deviceID string, challenge []byte) ([]byte, error) {
-	// Get device-specific key
-	deviceKey := sc.keyManager.GetDeviceKey(deviceID)
+	// Derive outside the lock; external providers may make a network call
+	key, err := km.derive(deviceID)
+	if err != nil {
+		return nil, err
+	}
 
-	// Setup compact cipher
-	sc.compactCipher.SetKey(deviceKey)
+	km.keyMutex.Lock()
+	defer km.keyMutex.Unlock()
 
-	// Process challenge with block cipher
-	if len(challenge) != CompactBlockSize {
-		return nil, fmt.Errorf("invalid challenge size")
+	// Keep a key cached concurrently, if any
+	if cached, exists := km.deviceKeys[deviceID]; exists {
+		return cached, nil
 	}
+	km.deviceKeys[deviceID] = key
+	return key, nil
+}
 
-	response := sc.compactCipher.EncryptBlock(challenge)
+// Drop a cached key, e.g. for a throwaway device
+func (km *KeyManager) forgetDeviceKey(deviceID string) {
+	km.keyMutex.Lock()
+	delete(km.deviceKeys, deviceID)
+	km.keyMutex.Unlock()
+}
 
-	// Calculate authentication tag
-	dc := NewDigestCalculator()
-	dc.Update(deviceKey)
-	dc.Update(challenge)
-	dc.Update(response)
-	authTag := dc.Finalize()
+func (km *KeyManager) derive(deviceID string) ([]byte, error) {
+	key, err := km.provider.DeriveDeviceKey(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	if isWeakKey(key) {
+		return nil, fmt.Errorf("weak key derived for device %s", deviceID)
+	}
+	return key, nil
+}
 
-	// Store session
-	sc.sessionMutex.Lock()
-	sc.deviceSessions[deviceID] = &DeviceSession{
-		DeviceID:         deviceID,
-		SessionKey:       deviceKey,
-		LastActivity:     time.Now(),
-		EncryptionState:  response,
-		AuthenticationTag: authTag,
+// Reject keys a broken RNG or provider would plausibly produce: empty,
+// all-zero or a single repeated byte. There is deliberately no Hamming-weight
+// test; at 80 bits any bound tight enough to catch a biased source also
+// rejects a measurable share of genuine keys, locking those devices out.
+func isWeakKey(key []byte) bool {
+	if len(key) == 0 {
+		return true
 	}
-	sc.sessionMutex.Unlock()
 
-	return append(response, authTag...), nil
+	for _, b := range key {
+		if b != key[0] {
+			return false
+		}
+	}
+	return true
 }
 
-func (sc *SecurityController) SecureDataTransmission(deviceID string, data []byte) ([]byte, error) {
-	sc.sessionMutex.RLock()
-	session, exists := sc.deviceSessions[deviceID]
-	sc.sessionMutex.RUnlock()
+// Bulk provisioning: derivations run in parallel outside the lock, then the
+// cache is filled in a single locked pass. Returns each ID's key, as
+// GetDeviceKey would. Derivation can fail (an external provider erroring or
+// panicking), so unlike a plain map lookup this also returns an error; on error
+// the map is nil and nothing is cached.
+func (km *KeyManager) ProvisionDevices(ids []string) (_ map[string][]byte, err error) {
+	defer recoverInternal(&err, "ProvisionDevices")
+
+	result := make(map[string][]byte, len(ids))
+	var pending []string
+
+	km.keyMutex.Lock()
+	for _, deviceID := range ids {
+		if _, seen := result[deviceID]; seen {
+			continue
+		}
+		if key, exists := km.deviceKeys[deviceID]; exists {
+			result[deviceID] = key
+			continue
+		}
+		result[deviceID] = nil
+		pending = append(pending, deviceID)
+	}
+	km.keyMutex.Unlock()
 
-	if !exists {
-		return nil, fmt.Errorf("device not authenticated")
+	derived := make([][]byte, len(pending))
+	errs := make([]error, len(pending))
+	workers := runtime.NumCPU()
+	if workers > len(pending) {
+		workers = len(pending)
 	}
 
-	// Initialize stream processor with session key
-	nonce := session.EncryptionState[:8]
-	sc.streamProcessor.Initialize(session.SessionKey, nonce)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(offset int) {
+			defer wg.Done()
+			for i := offset; i < len(pending); i += workers {
+				derived[i], errs[i] = km.provisionOne(pending[i])
+			}
+		}(w)
+	}
+	wg.Wait()
 
-	// Encrypt data
-	encryptedData := sc.streamProcessor.EncryptData(data)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	km.keyMutex.Lock()
+	for i, deviceID := range pending {
+		// Keep a key derived concurrently via GetDeviceKey, if any
+		if key, exists := km.deviceKeys[deviceID]; exists {
+			result[deviceID] = key
+			continue
+		}
+		km.deviceKeys[deviceID] = derived[i]
+		result[deviceID] = derived[i]
+	}
+	km.keyMutex.Unlock()
+
+	return result, nil
+}
+
+// A derivation on a provisioning worker; a panic there would escape the caller's
+// recover, so it is converted here
+func (km *KeyManager) provisionOne(deviceID string) (_ []byte, err error) {
+	defer recoverInternal(&err, "ProvisionDevices")
+	return km.derive(deviceID)
+}
+
+// Authenticated encryption for device payloads: stream cipher plus keyed digest tag.
+// Sealed layout is commitment || ciphertext || tag. The commitment is a
+// collision-resistant hash of the key, checked on open and bound into the tag,
+// so a ciphertext only ever opens under the key that sealed it.
+const (
+	TransmissionCommitmentSize = sha256.Size
+	TransmissionTagSize        = DigestOutputSize
+	TransmissionOverhead       = TransmissionCommitmentSize + TransmissionTagSize
+)
+
+func transmissionCommitment(key []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte("iot-transmission-commit"))
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// HMAC-SHA256 under key over label and the length-prefixed nonce; callers write
+// the remaining fields and truncate the sum. A secret-prefix DigestCalculator tag
+// would be length-extendable, since its output is its whole internal state.
+func newTransmissionMAC(key []byte, label string, nonce []byte) hash.Hash {
+	nonceLength := make([]byte, 4)
+	binary.LittleEndian.PutUint32(nonceLength, uint32(len(nonce)))
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	mac.Write(nonceLength)
+	mac.Write(nonce)
+	return mac
+}
+
+// Tags from versions that used a secret-prefix digest here no longer verify
+func transmissionTag(key, nonce, commitment, ciphertext []byte) []byte {
+	mac := newTransmissionMAC(key, transmissionTagLabel, nonce)
+	mac.Write(commitment)
+	mac.Write(ciphertext)
+	return mac.Sum(nil)[:TransmissionTagSize]
+}
+
+func SealTransmission(key, nonce, plaintext []byte) []byte {
+	sp := NewStreamProcessor()
+	sp.Initialize(streamKey(key), nonce)
+	ciphertext := sp.EncryptData(plaintext)
+
+	commitment := transmissionCommitment(key)
+	tag := transmissionTag(key, nonce, commitment, ciphertext)
+
+	sealed := make([]byte, 0, len(ciphertext)+TransmissionOverhead)
+	sealed = append(sealed, commitment...)
+	sealed = append(sealed, ciphertext...)
+	sealed = append(sealed, tag...)
+	return sealed
+}
+
+func OpenTransmission(key, nonce, sealed []byte) ([]byte, error) {
+	if err := verifyTransmission(key, nonce, sealed); err != nil {
+		return nil, err
+	}
+	ciphertext := sealed[TransmissionCommitmentSize : len(sealed)-TransmissionTagSize]
+
+	sp := NewStreamProcessor()
+	sp.Initialize(streamKey(key), nonce)
+	return sp.EncryptData(ciphertext), nil
+}
+
+// Check commitment and tag of a sealed transmission without decrypting it
+func verifyTransmission(key, nonce, sealed []byte) error {
+	if len(sealed) < TransmissionOverhead {
+		return fmt.Errorf("sealed transmission too short")
+	}
+
+	commitment := sealed[:TransmissionCommitmentSize]
+	ciphertext := sealed[TransmissionCommitmentSize : len(sealed)-TransmissionTagSize]
+	tag := sealed[len(sealed)-TransmissionTagSize:]
+
+	if subtle.ConstantTimeCompare(commitment, transmissionCommitment(key)) != 1 {
+		return fmt.Errorf("transmission not committed to this key")
+	}
+	if subtle.ConstantTimeCompare(tag, transmissionTag(key, nonce, commitment, ciphertext)) != 1 {
+		return fmt.Errorf("transmission authentication failed")
+	}
+	return nil
+}
+
+// One sealed transmission from a device, as produced by SealTransmission under
+// the device's session key
+type TransmissionItem struct {
+	DeviceID string
+	Nonce    []byte
+	Sealed   []byte
+}
+
+// Verify many sealed transmissions at once. Session keys are snapshotted under
+// a single read lock and tags are checked in parallel outside it. Items from
+// unauthenticated devices report false and are listed in the returned error;
+// the result vector is complete either way.
+func (sc *SecurityController) VerifyBatch(items []TransmissionItem) ([]bool, error) {
+	keys := make([][]byte, len(items))
+	var unknown []string
+
+	sc.sessionMutex.RLock()
+	for i, item := range items {
+		if session, exists := sc.latestSession(item.DeviceID); exists {
+			keys[i] = session.SessionKey
+		} else {
+			unknown = append(unknown, item.DeviceID)
+		}
+	}
+	sc.sessionMutex.RUnlock()
+
+	results := make([]bool, len(items))
+	workers := runtime.NumCPU()
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(offset int) {
+			defer wg.Done()
+			for i := offset; i < len(items); i += workers {
+				if keys[i] == nil {
+					continue
+				}
+				results[i] = verifyTransmission(keys[i], items[i].Nonce, items[i].Sealed) == nil
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if len(unknown) > 0 {
+		return results, fmt.Errorf("devices not authenticated: %v", unknown)
+	}
+	return results, nil
+}
+
+// Chunked authenticated encryption for payloads too large to buffer.
+// Each chunk is flag || ciphertext || tag, where the tag binds the chunk
+// counter and the final flag, so the receiver can verify and release every
+// chunk as it arrives while reordered, dropped or truncated chunks still fail.
+// The keystream runs continuously across chunks.
+const (
+	DefaultTransmissionChunkSize = 4096
+	chunkFlagMore                = 0x00
+	chunkFlagFinal               = 0x01
+)
+
+func transmissionChunkTag(key, nonce, commitment []byte, counter uint64, flag byte, ciphertext []byte) []byte {
+	header := make([]byte, 9)
+	binary.LittleEndian.PutUint64(header, counter)
+	header[8] = flag
+
+	mac := newTransmissionMAC(key, transmissionChunkLabel, nonce)
+	mac.Write(commitment)
+	mac.Write(header)
+	mac.Write(ciphertext)
+	return mac.Sum(nil)[:TransmissionTagSize]
+}
+
+func SealTransmissionChunks(key, nonce, plaintext []byte, chunkSize int) ([][]byte, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+
+	sp := NewStreamProcessor()
+	sp.Initialize(streamKey(key), nonce)
+	commitment := transmissionCommitment(key)
+
+	// An empty payload still produces one final chunk so truncation is detectable
+	chunkCount := (len(plaintext) + chunkSize - 1) / chunkSize
+	if chunkCount == 0 {
+		chunkCount = 1
+	}
+
+	chunks := make([][]byte, 0, chunkCount)
+	for i := 0; i < chunkCount; i++ {
+		start := i * chunkSize
+		end := start + chunkSize
+		if end > len(plaintext) {
+			end = len(plaintext)
+		}
+
+		flag := byte(chunkFlagMore)
+		if i == chunkCount-1 {
+			flag = chunkFlagFinal
+		}
+
+		ciphertext := sp.EncryptData(plaintext[start:end])
+		tag := transmissionChunkTag(key, nonce, commitment, uint64(i), flag, ciphertext)
+
+		chunk := make([]byte, 0, 1+len(ciphertext)+TransmissionTagSize)
+		chunk = append(chunk, flag)
+		chunk = append(chunk, ciphertext...)
+		chunk = append(chunk, tag...)
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+// Verifies chunks from SealTransmissionChunks in order and releases their plaintext
+type TransmissionOpener struct {
+	key          []byte
+	nonce        []byte
+	sessionNonce []byte // set for session transmissions until the first chunk
+	commitment   []byte
+	stream       *StreamProcessor
+	counter      uint64
+	finished     bool
+	failed       bool
+}
+
+func NewTransmissionOpener(key, nonce []byte) *TransmissionOpener {
+	sp := NewStreamProcessor()
+	sp.Initialize(streamKey(key), nonce)
+	return &TransmissionOpener{
+		key:        key,
+		nonce:      nonce,
+		commitment: transmissionCommitment(key),
+		stream:     sp,
+	}
+}
+
+func (to *TransmissionOpener) Open(chunk []byte) ([]byte, error) {
+	if to.failed {
+		return nil, fmt.Errorf("transmission already failed authentication")
+	}
+	if to.finished {
+		to.failed = true
+		return nil, fmt.Errorf("chunk received after final chunk")
+	}
+
+	// A session transmission's message nonce precedes its first chunk
+	if to.sessionNonce != nil {
+		if len(chunk) < MessageNonceSize {
+			to.failed = true
+			return nil, fmt.Errorf("transmission chunk too short")
+		}
+		to.nonce = messageStreamNonce(to.sessionNonce, chunk[:MessageNonceSize])
+		to.stream = NewStreamProcessor()
+		to.stream.Initialize(streamKey(to.key), to.nonce)
+		to.sessionNonce = nil
+		chunk = chunk[MessageNonceSize:]
+	}
+
+	if len(chunk) < 1+TransmissionTagSize {
+		to.failed = true
+		return nil, fmt.Errorf("transmission chunk too short")
+	}
+
+	flag := chunk[0]
+	ciphertext := chunk[1 : len(chunk)-TransmissionTagSize]
+	tag := chunk[len(chunk)-TransmissionTagSize:]
+
+	// A chunk sealed for another position fails here, so the stream never
+	// advances past data that doesn't belong at this counter
+	expected := transmissionChunkTag(to.key, to.nonce, to.commitment, to.counter, flag, ciphertext)
+	if subtle.ConstantTimeCompare(tag, expected) != 1 {
+		to.failed = true
+		return nil, fmt.Errorf("transmission chunk %d authentication failed", to.counter)
+	}
+
+	to.counter++
+	if flag == chunkFlagFinal {
+		to.finished = true
+	}
+	return to.stream.EncryptData(ciphertext), nil
+}
+
+// Reports an error unless the final chunk has been verified
+func (to *TransmissionOpener) Close() error {
+	if to.failed {
+		return fmt.Errorf("transmission failed authentication")
+	}
+	if !to.finished {
+		return fmt.Errorf("transmission truncated after %d chunks", to.counter)
+	}
+	return nil
+}
+
+// Outcome of a device authentication. Bytes gives the flat response || tag form
+// returned by AuthenticateDevice.
+type AuthResult struct {
+	Response  []byte
+	AuthTag   []byte
+	SessionID string
+}
+
+func (ar *AuthResult) Bytes() []byte {
+	flat := make([]byte, 0, len(ar.Response)+len(ar.AuthTag))
+	flat = append(flat, ar.Response...)
+	return append(flat, ar.AuthTag...)
+}
+
+func newSessionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", fmt.Errorf("generating session ID: %w", err)
+	}
+	return fmt.Sprintf("%x", id), nil
+}
+
+func (sc *SecurityController) AuthenticateDevice(deviceID string, challenge []byte) ([]byte, error) {
+	result, err := sc.Authenticate(deviceID, challenge)
+	if err != nil {
+		return nil, err
+	}
+	return result.Bytes(), nil
+}
+
+// Bound authentication under reconnect storms: at most concurrent run at once
+// and at most queue more wait, each for up to timeout (0 waits indefinitely).
+// Requests beyond the queue, or still waiting at the timeout, fail with
+// ErrAuthTimeout. The timeout uses real time, not the controller clock, and
+// does not interrupt an authentication once it has started. Set it before the
+// controller is in use.
+func (sc *SecurityController) SetAuthLimits(concurrent, queue int, timeout time.Duration) error {
+	if concurrent <= 0 || queue < 0 || timeout < 0 {
+		return fmt.Errorf("invalid authentication limits")
+	}
+
+	sc.authSlots = make(chan struct{}, concurrent)
+	sc.authQueue = make(chan struct{}, concurrent+queue)
+	sc.authTimeout = timeout
+	return nil
+}
+
+// Admit one authentication; the returned release must be called when done
+func (sc *SecurityController) acquireAuthSlot() (func(), error) {
+	if sc.authSlots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case sc.authQueue <- struct{}{}:
+	default:
+		return nil, fmt.Errorf("%w: queue full", ErrAuthTimeout)
+	}
+
+	var expired <-chan time.Time
+	if sc.authTimeout > 0 {
+		timer := time.NewTimer(sc.authTimeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case sc.authSlots <- struct{}{}:
+		return func() {
+			<-sc.authSlots
+			<-sc.authQueue
+		}, nil
+	case <-expired:
+		<-sc.authQueue
+		return nil, fmt.Errorf("%w after %v", ErrAuthTimeout, sc.authTimeout)
+	}
+}
+
+// Structured form of AuthenticateDevice, sparing callers the response/tag split
+func (sc *SecurityController) Authenticate(deviceID string, challenge []byte) (_ *AuthResult, err error) {
+	defer recoverInternal(&err, "Authenticate")
+
+	// Process challenge with block cipher
+	if len(challenge) != CompactBlockSize {
+		return nil, fmt.Errorf("invalid challenge size")
+	}
+
+	release, err := sc.acquireAuthSlot()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	// Get device-specific key
+	deviceKey, err := sc.keyManager.GetDeviceKey(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	sc.sessionMutex.RLock()
+	digest := sc.authDigest
+	sessionKeyLength := sc.sessionKeyLength
+	sc.sessionMutex.RUnlock()
+
+	// Key a private copy of the cipher so concurrent authentications
+	// never share a key schedule
+	blockCipher := sc.compactCipher.Clone()
+	blockCipher.SetKey(deviceKey)
+
+	response := blockCipher.EncryptBlock(challenge)
+
+	// Calculate authentication tag
+	authTag := authenticationTag(digest, deviceKey, challenge, response)
+
+	// The device key only authenticates; traffic uses keys derived for this session
+	cipherKey, sessionKey := deriveSessionKeys(deviceKey, challenge, response, sessionKeyLength)
+
+	// Store session
+	sessionID, err := newSessionID()
+	if err != nil {
+		return nil, err
+	}
+	now := sc.clock.Now()
+	sc.sessionMutex.Lock()
+	authTag = authTag[:sc.tagLength]
+	sc.addSession(&DeviceSession{
+		DeviceID:         deviceID,
+		SessionID:        sessionID,
+		SessionKey:       sessionKey,
+		CipherKey:        cipherKey,
+		LastActivity:     now,
+		EncryptionState:  response,
+		AuthenticationTag: authTag,
+		Challenge:        append([]byte(nil), challenge...),
+		CreatedAt:        now,
+	})
+	sc.sessionMutex.Unlock()
+
+	return &AuthResult{
+		Response:  response,
+		AuthTag:   authTag,
+		SessionID: sessionID,
+	}, nil
+}
+
+// Check a response produced by AuthenticateDevice for the same challenge
+func (sc *SecurityController) VerifyAuthentication(deviceID string, challenge, authResponse []byte) (err error) {
+	defer recoverInternal(&err, "VerifyAuthentication")
+
+	if len(challenge) != CompactBlockSize {
+		return fmt.Errorf("invalid challenge size")
+	}
+
+	sc.sessionMutex.RLock()
+	tagLength := sc.tagLength
+	digest := sc.authDigest
+	sc.sessionMutex.RUnlock()
+
+	if len(authResponse) != CompactBlockSize+tagLength {
+		return fmt.Errorf("invalid authentication response size")
+	}
+
+	deviceKey, err := sc.keyManager.GetDeviceKey(deviceID)
+	if err != nil {
+		return err
+	}
+	blockCipher := sc.compactCipher.Clone()
+	blockCipher.SetKey(deviceKey)
+
+	response := blockCipher.EncryptBlock(challenge)
+	expected := append(response, authenticationTag(digest, deviceKey, challenge, response)[:tagLength]...)
+
+	if subtle.ConstantTimeCompare(authResponse, expected) != 1 {
+		return fmt.Errorf("authentication response verification failed")
+	}
+	return nil
+}
+
+// Session resumption tickets. A ticket is nonce || sealed session state under the
+// controller's ticket key, so only this controller can open it and any
+// modification fails authentication.
+const (
+	ticketVersion   = 0x02 // 0x02 adds the session cipher key
+	ticketNonceSize = 8
+)
+
+// Authenticate and also issue a resumption ticket the device presents to ResumeSession
+func (sc *SecurityController) AuthenticateDeviceWithTicket(deviceID string, challenge []byte) ([]byte, []byte, error) {
+	response, err := sc.AuthenticateDevice(deviceID, challenge)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ticket, err := sc.IssueSessionTicket(deviceID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return response, ticket, nil
+}
+
+func (sc *SecurityController) SetTicketLifetime(lifetime time.Duration) error {
+	if lifetime <= 0 {
+		return fmt.Errorf("invalid ticket lifetime %v", lifetime)
+	}
+
+	sc.sessionMutex.Lock()
+	sc.ticketLifetime = lifetime
+	sc.sessionMutex.Unlock()
+	return nil
+}
+
+// Seal the device's current session into a ticket valid for the ticket lifetime
+func (sc *SecurityController) IssueSessionTicket(deviceID string) (_ []byte, err error) {
+	defer recoverInternal(&err, "IssueSessionTicket")
+
+	sc.sessionMutex.RLock()
+	session, exists := sc.latestSession(deviceID)
+	var state bytes.Buffer
+	if exists {
+		expiresAt := sc.clock.Now().Add(sc.ticketLifetime)
+
+		state.WriteByte(ticketVersion)
+		binary.Write(&state, binary.LittleEndian, expiresAt.UnixNano())
+		binary.Write(&state, binary.LittleEndian, session.CreatedAt.UnixNano())
+		writeTicketField(&state, []byte(session.DeviceID))
+		writeTicketField(&state, session.SessionKey)
+		writeTicketField(&state, session.EncryptionState)
+		writeTicketField(&state, session.AuthenticationTag)
+		writeTicketField(&state, session.Challenge)
+		writeTicketField(&state, session.CipherKey)
+		compress := byte(0)
+		if session.CompressPayloads {
+			compress = 1
+		}
+		state.WriteByte(compress)
+		binary.Write(&state, binary.LittleEndian, uint32(session.PaddingBucket))
+	}
+	sc.sessionMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("device not authenticated")
+	}
+
+	nonce := make([]byte, ticketNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return append(nonce, SealTransmission(sc.ticketKey, nonce, state.Bytes())...), nil
+}
+
+// Restore the session sealed in a ticket from IssueSessionTicket, returning its device ID
+func (sc *SecurityController) ResumeSession(ticket []byte) (_ string, err error) {
+	defer recoverInternal(&err, "ResumeSession")
+
+	if len(ticket) < ticketNonceSize {
+		return "", fmt.Errorf("session ticket too short")
+	}
+
+	state, err := OpenTransmission(sc.ticketKey, ticket[:ticketNonceSize], ticket[ticketNonceSize:])
+	if err != nil {
+		return "", fmt.Errorf("invalid session ticket: %w", err)
+	}
+
+	reader := bytes.NewReader(state)
+	version, err := reader.ReadByte()
+	if err != nil || version != ticketVersion {
+		return "", fmt.Errorf("unsupported session ticket version")
+	}
+
+	var expiresAt, createdAt int64
+	if err := binary.Read(reader, binary.LittleEndian, &expiresAt); err != nil {
+		return "", fmt.Errorf("malformed session ticket: %w", err)
+	}
+	if err := binary.Read(reader, binary.LittleEndian, &createdAt); err != nil {
+		return "", fmt.Errorf("malformed session ticket: %w", err)
+	}
+	if sc.clock.Now().UnixNano() >= expiresAt {
+		return "", fmt.Errorf("session ticket expired")
+	}
+
+	fields := make([][]byte, 6)
+	for i := range fields {
+		if fields[i], err = readTicketField(reader); err != nil {
+			return "", fmt.Errorf("malformed session ticket: %w", err)
+		}
+	}
+	compress, err := reader.ReadByte()
+	if err != nil {
+		return "", fmt.Errorf("malformed session ticket: %w", err)
+	}
+	var paddingBucket uint32
+	if err := binary.Read(reader, binary.LittleEndian, &paddingBucket); err != nil {
+		return "", fmt.Errorf("malformed session ticket: %w", err)
+	}
+	if reader.Len() != 0 {
+		return "", fmt.Errorf("malformed session ticket: %d trailing bytes", reader.Len())
+	}
+
+	// Tickets carry session state but not identity; a resumed session gets a new ID
+	sessionID, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	deviceID := string(fields[0])
+	now := sc.clock.Now()
+
+	sc.sessionMutex.Lock()
+	sc.addSession(&DeviceSession{
+		DeviceID:          deviceID,
+		SessionID:         sessionID,
+		SessionKey:        fields[1],
+		LastActivity:      now,
+		EncryptionState:   fields[2],
+		AuthenticationTag: fields[3],
+		Challenge:         fields[4],
+		CipherKey:         fields[5],
+		CompressPayloads:  compress == 1,
+		PaddingBucket:     int(paddingBucket),
+		CreatedAt:         time.Unix(0, createdAt),
+	})
+	sc.sessionMutex.Unlock()
+
+	return deviceID, nil
+}
+
+func writeTicketField(buf *bytes.Buffer, field []byte) {
+	binary.Write(buf, binary.LittleEndian, uint16(len(field)))
+	buf.Write(field)
+}
+
+func readTicketField(reader *bytes.Reader) ([]byte, error) {
+	var length uint16
+	if err := binary.Read(reader, binary.LittleEndian, &length); err != nil {
+		return nil, err
+	}
+	if int(length) > reader.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	field := make([]byte, length)
+	_, err := io.ReadFull(reader, field)
+	return field, err
+}
+
+// Start an authentication attempt. Each call gets its own challenge and expiry,
+// so overlapping attempts by one device resolve independently.
+func (sc *SecurityController) IssueChallenge(deviceID string) (_ string, _ []byte, err error) {
+	defer recoverInternal(&err, "IssueChallenge")
+
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, fmt.Errorf("issuing challenge: %w", err)
+	}
+	challengeID := fmt.Sprintf("%x", id)
+
+	challenge := make([]byte, CompactBlockSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return "", nil, fmt.Errorf("issuing challenge: %w", err)
+	}
+
+	now := sc.clock.Now()
+	sc.challengeMutex.Lock()
+	for pendingID, pending := range sc.pendingChallenges {
+		if now.After(pending.expiresAt) {
+			delete(sc.pendingChallenges, pendingID)
+		}
+	}
+	sc.pendingChallenges[challengeID] = &pendingChallenge{
+		deviceID:  deviceID,
+		challenge: challenge,
+		expiresAt: now.Add(sc.challengeLifetime),
+	}
+	sc.challengeMutex.Unlock()
+
+	return challengeID, challenge, nil
+}
+
+// Resolve a challenge from IssueChallenge with the device's response and tag,
+// establishing its session. A challenge can be answered only once.
+func (sc *SecurityController) CompleteChallenge(challengeID string, response []byte) (err error) {
+	defer recoverInternal(&err, "CompleteChallenge")
+
+	sc.challengeMutex.Lock()
+	pending, exists := sc.pendingChallenges[challengeID]
+	delete(sc.pendingChallenges, challengeID)
+	sc.challengeMutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("unknown challenge")
+	}
+	if sc.clock.Now().After(pending.expiresAt) {
+		return fmt.Errorf("challenge expired")
+	}
+
+	if err := sc.VerifyAuthentication(pending.deviceID, pending.challenge, response); err != nil {
+		return err
+	}
+
+	_, err = sc.AuthenticateDevice(pending.deviceID, pending.challenge)
+	return err
+}
+
+// Constant-time check of an AuthenticateDevice response against the challenge
+// stored for the device's current session
+func (sc *SecurityController) VerifyAuthResponse(deviceID string, response []byte) bool {
+	sc.sessionMutex.RLock()
+	session, exists := sc.latestSession(deviceID)
+	var challenge []byte
+	if exists {
+		challenge = session.Challenge
+	}
+	sc.sessionMutex.RUnlock()
+
+	if !exists || len(challenge) == 0 {
+		return false
+	}
+	return sc.VerifyAuthentication(deviceID, challenge, response) == nil
+}
+
+// Seen nonces in arrival order, so expiry and eviction both pop from the front
+type nonceCache struct {
+	mutex    sync.Mutex
+	window   time.Duration
+	capacity int
+	seenAt   map[string]time.Time
+	order    []nonceEntry
+	head     int // order[head:] is live
+}
+
+type nonceEntry struct {
+	key    string
+	seenAt time.Time
+}
+
+func newNonceCache(window time.Duration, capacity int) *nonceCache {
+	return &nonceCache{
+		window:   window,
+		capacity: capacity,
+		seenAt:   make(map[string]time.Time),
+	}
+}
+
+// Record key at now; false if it was already seen within the window
+func (nc *nonceCache) record(key string, now time.Time) bool {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	for nc.head < len(nc.order) && now.Sub(nc.order[nc.head].seenAt) >= nc.window {
+		nc.evictOldest()
+	}
+	if _, seen := nc.seenAt[key]; seen {
+		return false
+	}
+
+	for len(nc.seenAt) >= nc.capacity {
+		nc.evictOldest()
+	}
+	nc.seenAt[key] = now
+	nc.order = append(nc.order, nonceEntry{key: key, seenAt: now})
+
+	// Reclaim the popped prefix once it dominates the slice
+	if nc.head > len(nc.order)/2 {
+		nc.order = append([]nonceEntry(nil), nc.order[nc.head:]...)
+		nc.head = 0
+	}
+	return true
+}
+
+func (nc *nonceCache) evictOldest() {
+	delete(nc.seenAt, nc.order[nc.head].key)
+	nc.order[nc.head] = nonceEntry{}
+	nc.head++
+}
+
+// Bound replay detection to nonces seen within window, remembering at most
+// capacity of them. Replaces the cache, forgetting every nonce seen so far.
+func (sc *SecurityController) SetReplayWindow(window time.Duration, capacity int) error {
+	if window <= 0 || capacity <= 0 {
+		return fmt.Errorf("replay window and capacity must be positive")
+	}
+
+	sc.sessionMutex.Lock()
+	sc.nonces = newNonceCache(window, capacity)
+	sc.sessionMutex.Unlock()
+	return nil
+}
+
+// Reject a nonce the device already used within the replay window. Memory stays
+// bounded by forgetting nonces once they leave the window, or earlier when more
+// than the capacity arrive within one window; either way a nonce old enough to
+// be forgotten is accepted again. Callers must therefore also reject messages
+// whose own timestamp is older than the window, so a forgotten nonce can't be
+// replayed inside a still-valid message.
+func (sc *SecurityController) CheckNonce(deviceID string, nonce []byte) error {
+	if len(nonce) == 0 {
+		return fmt.Errorf("empty nonce")
+	}
+
+	sc.sessionMutex.RLock()
+	nonces := sc.nonces
+	sc.sessionMutex.RUnlock()
+
+	// Length-prefix the device ID so IDs and nonces can't run together
+	key := fmt.Sprintf("%d:%s%s", len(deviceID), deviceID, nonce)
+	if !nonces.record(key, sc.clock.Now()) {
+		return fmt.Errorf("nonce replayed for device %s", deviceID)
+	}
+	return nil
+}
+
+func authenticationTag(digest AuthDigest, deviceKey, challenge, response []byte) []byte {
+	if digest == StrongAuthDigest {
+		mac := hmac.New(sha256.New, deviceKey)
+		mac.Write([]byte(authTagLabel))
+		mac.Write(challenge)
+		mac.Write(response)
+		return mac.Sum(nil)
+	}
+
+	dc := newLabeledDigest(authTagLabel)
+	dc.Update(deviceKey)
+	dc.Update(challenge)
+	dc.Update(response)
+	return dc.Finalize()
+}
+
+// Encrypt for the device's most recent session
+func (sc *SecurityController) SecureDataTransmission(deviceID string, data []byte) (_ []byte, err error) {
+	defer recoverInternal(&err, "SecureDataTransmission")
+
+	sc.sessionMutex.RLock()
+	session, exists := sc.latestSession(deviceID)
+	sc.sessionMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("device not authenticated")
+	}
+	return sc.sealForSession(session, nil, data)
+}
+
+// Variant of SecureDataTransmission carrying a cleartext header (device type,
+// firmware version) that is authenticated with the payload but not encrypted
+func (sc *SecurityController) SecureDataTransmissionWithHeader(deviceID string, header, data []byte) (_ []byte, err error) {
+	defer recoverInternal(&err, "SecureDataTransmissionWithHeader")
+
+	sc.sessionMutex.RLock()
+	session, exists := sc.latestSession(deviceID)
+	sc.sessionMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("device not authenticated")
+	}
+	if header == nil {
+		header = []byte{}
+	}
+	return sc.sealForSession(session, header, data)
+}
+
+// Encrypt for one session of a device with several live sessions
+func (sc *SecurityController) SecureSessionTransmission(sessionID string, data []byte) (_ []byte, err error) {
+	defer recoverInternal(&err, "SecureSessionTransmission")
+
+	sc.sessionMutex.RLock()
+	session, exists := sc.deviceSessions[sessionID]
+	sc.sessionMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown session")
+	}
+	return sc.sealForSession(session, nil, data)
+}
+
+// A nil header seals a headerless payload
+func (sc *SecurityController) sealForSession(session *DeviceSession, header, data []byte) ([]byte, error) {
+	sc.sessionMutex.RLock()
+	compress := session.CompressPayloads
+	bucket := session.PaddingBucket
+	sc.sessionMutex.RUnlock()
+
+	// Private stream processor, keyed per payload by sealPayload
+	nonce, err := sessionNonce(session.EncryptionState)
+	if err != nil {
+		return nil, err
+	}
+	streamProcessor := sc.streamProcessor.Clone()
+
+	// Encrypt data
+	var encryptedData []byte
+	if header != nil {
+		encryptedData, err = sealHeaderPayload(streamProcessor, session.SessionKey, nonce, header, data, compress, bucket)
+	} else {
+		encryptedData, err = sealPayload(streamProcessor, session.SessionKey, nonce, nil, data, compress, bucket)
+	}
+	if err != nil {
+		return nil, err
+	}
 
 	// Update session activity
 	sc.sessionMutex.Lock()
-	session.LastActivity = time.Now()
+	session.LastActivity = sc.clock.Now()
+	session.MessageCount++
+	session.BytesTransmitted += uint64(len(encryptedData))
+	sc.sessionMutex.Unlock()
+
+	return encryptedData, nil
+}
+
+// Chunked variant of SecureDataTransmission for large payloads. Compression is
+// not applied because it would defeat incremental release on the receiver. The
+// first chunk is prefixed with the transmission's message nonce.
+func (sc *SecurityController) SecureChunkedTransmission(deviceID string, data []byte, chunkSize int) (_ [][]byte, err error) {
+	defer recoverInternal(&err, "SecureChunkedTransmission")
+
+	sc.sessionMutex.RLock()
+	session, exists := sc.latestSession(deviceID)
+	sc.sessionMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("device not authenticated")
+	}
+
+	nonce, err := sessionNonce(session.EncryptionState)
+	if err != nil {
+		return nil, err
+	}
+
+	messageNonce := make([]byte, MessageNonceSize)
+	if _, err := rand.Read(messageNonce); err != nil {
+		return nil, fmt.Errorf("generating message nonce: %w", err)
+	}
+
+	chunks, err := SealTransmissionChunks(session.SessionKey, messageStreamNonce(nonce, messageNonce), data, chunkSize)
+	if err != nil {
+		return nil, err
+	}
+	chunks[0] = append(messageNonce, chunks[0]...)
+
+	var transmitted uint64
+	for _, chunk := range chunks {
+		transmitted += uint64(len(chunk))
+	}
+
+	sc.sessionMutex.Lock()
+	session.LastActivity = sc.clock.Now()
+	session.MessageCount++
+	session.BytesTransmitted += transmitted
 	sc.sessionMutex.Unlock()
 
-	return encryptedData, nil
+	return chunks, nil
+}
+
+// Opener for chunks produced by SecureChunkedTransmission for the same session
+func (sc *SecurityController) ReceiveChunkedTransmission(deviceID string) (_ *TransmissionOpener, err error) {
+	defer recoverInternal(&err, "ReceiveChunkedTransmission")
+
+	sc.sessionMutex.RLock()
+	session, exists := sc.latestSession(deviceID)
+	sc.sessionMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("device not authenticated")
+	}
+
+	nonce, err := sessionNonce(session.EncryptionState)
+	if err != nil {
+		return nil, err
+	}
+	return &TransmissionOpener{
+		key:          session.SessionKey,
+		sessionNonce: nonce,
+		commitment:   transmissionCommitment(session.SessionKey),
+	}, nil
+}
+
+// Decrypt a payload produced by SecureDataTransmission for the same session
+func (sc *SecurityController) ReceiveDataTransmission(deviceID string, encryptedData []byte) (_ []byte, err error) {
+	defer recoverInternal(&err, "ReceiveDataTransmission")
+
+	sc.sessionMutex.RLock()
+	session, exists := sc.latestSession(deviceID)
+	sc.sessionMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("device not authenticated")
+	}
+	_, data, err := sc.openForSession(session, encryptedData, false)
+	return data, err
+}
+
+// Verify and decrypt a payload produced by SecureDataTransmissionWithHeader or
+// SimulatedDevice.EncryptWithHeader, returning its header and plaintext
+func (sc *SecurityController) ReceiveDataTransmissionWithHeader(deviceID string, encryptedData []byte) (_, _ []byte, err error) {
+	defer recoverInternal(&err, "ReceiveDataTransmissionWithHeader")
+
+	sc.sessionMutex.RLock()
+	session, exists := sc.latestSession(deviceID)
+	sc.sessionMutex.RUnlock()
+
+	if !exists {
+		return nil, nil, fmt.Errorf("device not authenticated")
+	}
+	return sc.openForSession(session, encryptedData, true)
+}
+
+// Decrypt a payload produced by SecureSessionTransmission for the same session
+func (sc *SecurityController) ReceiveSessionTransmission(sessionID string, encryptedData []byte) (_ []byte, err error) {
+	defer recoverInternal(&err, "ReceiveSessionTransmission")
+
+	sc.sessionMutex.RLock()
+	session, exists := sc.deviceSessions[sessionID]
+	sc.sessionMutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown session")
+	}
+	_, data, err := sc.openForSession(session, encryptedData, false)
+	return data, err
+}
+
+func (sc *SecurityController) openForSession(session *DeviceSession, encryptedData []byte, withHeader bool) ([]byte, []byte, error) {
+	sc.sessionMutex.RLock()
+	compress := session.CompressPayloads
+	bucket := session.PaddingBucket
+	sc.sessionMutex.RUnlock()
+
+	nonce, err := sessionNonce(session.EncryptionState)
+	if err != nil {
+		return nil, nil, err
+	}
+	streamProcessor := sc.streamProcessor.Clone()
+
+	var header, data, messageNonce []byte
+	if withHeader {
+		header, data, messageNonce, err = openHeaderPayload(streamProcessor, session.SessionKey, nonce, encryptedData, compress, bucket)
+	} else {
+		data, messageNonce, err = openPayload(streamProcessor, session.SessionKey, nonce, nil, encryptedData, compress, bucket)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Only authenticated payloads are recorded, so forgeries can't use up nonces.
+	// Payloads carry no timestamp, so one replayed after the replay window, once
+	// its nonce is forgotten, is still accepted.
+	if err := sc.CheckNonce(session.DeviceID, messageNonce); err != nil {
+		return nil, nil, err
+	}
+
+	sc.sessionMutex.Lock()
+	session.LastActivity = sc.clock.Now()
+	sc.sessionMutex.Unlock()
+
+	return header, data, nil
+}
+
+// Per-session payload compression. Compressed length leaks information about
+// content (CRIME/BREACH style oracles), so only enable it for sessions whose
+// payloads never mix secrets with attacker-influenced data.
+func (sc *SecurityController) SetSessionCompression(deviceID string, enabled bool) error {
+	sc.sessionMutex.Lock()
+	defer sc.sessionMutex.Unlock()
+
+	session, exists := sc.latestSession(deviceID)
+	if !exists {
+		return fmt.Errorf("device not authenticated")
+	}
+
+	session.CompressPayloads = enabled
+	return nil
+}
+
+// Per-session length-hiding padding. Payloads are prefixed with their length
+// and zero-padded to a multiple of bucketSize inside the encrypted envelope,
+// so observers only learn which bucket a message falls in. 0 disables padding.
+func (sc *SecurityController) SetSessionPadding(deviceID string, bucketSize int) error {
+	if bucketSize < 0 {
+		return fmt.Errorf("invalid padding bucket size %d", bucketSize)
+	}
+
+	sc.sessionMutex.Lock()
+	defer sc.sessionMutex.Unlock()
+
+	session, exists := sc.latestSession(deviceID)
+	if !exists {
+		return fmt.Errorf("device not authenticated")
+	}
+
+	session.PaddingBucket = bucketSize
+	return nil
+}
+
+// Register a new session, dropping the device's oldest beyond
+// MaxSessionsPerDevice; caller holds sessionMutex
+func (sc *SecurityController) addSession(session *DeviceSession) {
+	ids := append(sc.sessionsByDevice[session.DeviceID], session.SessionID)
+	for len(ids) > MaxSessionsPerDevice {
+		delete(sc.deviceSessions, ids[0])
+		ids = ids[1:]
+	}
+
+	sc.deviceSessions[session.SessionID] = session
+	sc.sessionsByDevice[session.DeviceID] = ids
+}
+
+// Most recent session of a device, which device-addressed calls operate on;
+// caller holds sessionMutex
+func (sc *SecurityController) latestSession(deviceID string) (*DeviceSession, bool) {
+	ids := sc.sessionsByDevice[deviceID]
+	if len(ids) == 0 {
+		return nil, false
+	}
+	return sc.deviceSessions[ids[len(ids)-1]], true
+}
+
+// End one session; the device's other sessions are unaffected
+func (sc *SecurityController) CloseSession(sessionID string) error {
+	sc.sessionMutex.Lock()
+	defer sc.sessionMutex.Unlock()
+
+	session, exists := sc.deviceSessions[sessionID]
+	if !exists {
+		return fmt.Errorf("unknown session")
+	}
+	delete(sc.deviceSessions, sessionID)
+
+	ids := sc.sessionsByDevice[session.DeviceID]
+	remaining := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if id != sessionID {
+			remaining = append(remaining, id)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(sc.sessionsByDevice, session.DeviceID)
+	} else {
+		sc.sessionsByDevice[session.DeviceID] = remaining
+	}
+	return nil
+}
+
+// Live session IDs of a device, oldest first
+func (sc *SecurityController) DeviceSessionIDs(deviceID string) []string {
+	sc.sessionMutex.RLock()
+	defer sc.sessionMutex.RUnlock()
+
+	return append([]string(nil), sc.sessionsByDevice[deviceID]...)
+}
+
+// Known plaintext for LivenessCheck, long enough to span several keystream words
+var livenessPayload = []byte("iot-liveness-probe-known-plaintext")
+
+// Health probe: authenticate a throwaway device, then seal and open a known
+// payload over its session. A broken key schedule or primitive fails here
+// instead of on live traffic; panics from the primitives come back as errors.
+// The device's session and cached key are discarded afterwards, but with an
+// external key provider each probe costs one derivation.
+func (sc *SecurityController) LivenessCheck() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("liveness check: %v", r)
+		}
+	}()
+
+	probeID, err := newSessionID()
+	if err != nil {
+		return fmt.Errorf("liveness check: %w", err)
+	}
+	deviceID := "liveness-" + probeID
+	defer sc.keyManager.forgetDeviceKey(deviceID)
+
+	challenge := make([]byte, CompactBlockSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return fmt.Errorf("liveness check: %w", err)
+	}
+
+	result, err := sc.Authenticate(deviceID, challenge)
+	if err != nil {
+		return fmt.Errorf("liveness check: authenticate: %w", err)
+	}
+	defer sc.CloseSession(result.SessionID)
+
+	if err := sc.VerifyAuthentication(deviceID, challenge, result.Bytes()); err != nil {
+		return fmt.Errorf("liveness check: %w", err)
+	}
+
+	sealed, err := sc.SecureSessionTransmission(result.SessionID, livenessPayload)
+	if err != nil {
+		return fmt.Errorf("liveness check: transmit: %w", err)
+	}
+	if bytes.Contains(sealed, livenessPayload) {
+		return fmt.Errorf("liveness check: payload transmitted in the clear")
+	}
+
+	opened, err := sc.ReceiveSessionTransmission(result.SessionID, sealed)
+	if err != nil {
+		return fmt.Errorf("liveness check: receive: %w", err)
+	}
+	if !bytes.Equal(opened, livenessPayload) {
+		return fmt.Errorf("liveness check: round trip returned wrong payload")
+	}
+	return nil
+}
+
+// IDs of every authenticated device, sorted
+func (sc *SecurityController) ActiveSessions() []string {
+	sc.sessionMutex.RLock()
+	defer sc.sessionMutex.RUnlock()
+
+	ids := make([]string, 0, len(sc.sessionsByDevice))
+	for deviceID := range sc.sessionsByDevice {
+		ids = append(ids, deviceID)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Metadata for a device's most recent session; false when the device is not authenticated
+func (sc *SecurityController) SessionInfo(deviceID string) (SessionSnapshot, bool) {
+	sc.sessionMutex.RLock()
+	defer sc.sessionMutex.RUnlock()
+
+	session, exists := sc.latestSession(deviceID)
+	if !exists {
+		return SessionSnapshot{}, false
+	}
+
+	return SessionSnapshot{
+		DeviceID:         session.DeviceID,
+		SessionID:        session.SessionID,
+		CreatedAt:        session.CreatedAt,
+		LastActivity:     session.LastActivity,
+		SessionAge:       sc.clock.Now().Sub(session.CreatedAt),
+		MessageCount:     session.MessageCount,
+		BytesTransmitted: session.BytesTransmitted,
+		CompressPayloads: session.CompressPayloads,
+		PaddingBucket:    session.PaddingBucket,
+	}, true
+}
+
+// Snapshot of traffic counters for every authenticated device. Counters are
+// summed over the device's sessions; the age is that of its oldest session.
+func (sc *SecurityController) DeviceActivity() map[string]DeviceStats {
+	sc.sessionMutex.RLock()
+	defer sc.sessionMutex.RUnlock()
+
+	now := sc.clock.Now()
+	activity := make(map[string]DeviceStats, len(sc.sessionsByDevice))
+	for _, session := range sc.deviceSessions {
+		stats := activity[session.DeviceID]
+		stats.MessageCount += session.MessageCount
+		stats.BytesTransmitted += session.BytesTransmitted
+		if session.LastActivity.After(stats.LastActivity) {
+			stats.LastActivity = session.LastActivity
+		}
+		if age := now.Sub(session.CreatedAt); age > stats.SessionAge {
+			stats.SessionAge = age
+		}
+		activity[session.DeviceID] = stats
+	}
+	return activity
+}
+
+// MAC over a session payload's ciphertext. It is fed one chunk at a time as data
+// is encrypted or decrypted, so large payloads need only a single pass. A non-nil
+// header is bound under its own label, so a headerless payload can't be re-framed
+// as one carrying a header. Tags from versions that used a secret-prefix digest
+// here no longer verify.
+func newPayloadMAC(key, nonce, header []byte) hash.Hash {
+	if header == nil {
+		return newTransmissionMAC(key, payloadTagLabel, nonce)
+	}
+
+	mac := newTransmissionMAC(key, headerPayloadTagLabel, nonce)
+	mac.Write(binary.BigEndian.AppendUint16(nil, uint16(len(header))))
+	mac.Write(header)
+	return mac
+}
+
+// Payloads with a cleartext header. Sealed layout is header length (2 bytes,
+// big-endian) || header || message nonce || ciphertext || tag, the tag covering
+// the header.
+const MaxTransmissionHeaderSize = 1<<16 - 1
+
+func sealHeaderPayload(sp *StreamProcessor, key, nonce, header, data []byte, compress bool, bucket int) ([]byte, error) {
+	if len(header) > MaxTransmissionHeaderSize {
+		return nil, fmt.Errorf("transmission header too long: %d bytes", len(header))
+	}
+	if header == nil {
+		header = []byte{}
+	}
+
+	body, err := sealPayload(sp, key, nonce, header, data, compress, bucket)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]byte, 0, 2+len(header)+len(body))
+	sealed = binary.BigEndian.AppendUint16(sealed, uint16(len(header)))
+	sealed = append(sealed, header...)
+	return append(sealed, body...), nil
+}
+
+func openHeaderPayload(sp *StreamProcessor, key, nonce, sealed []byte, compress bool, bucket int) ([]byte, []byte, []byte, error) {
+	header, body, err := splitTransmissionHeader(sealed)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	data, messageNonce, err := openPayload(sp, key, nonce, header, body, compress, bucket)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return append([]byte{}, header...), data, messageNonce, nil
+}
+
+func splitTransmissionHeader(sealed []byte) ([]byte, []byte, error) {
+	if len(sealed) < 2 {
+		return nil, nil, fmt.Errorf("sealed payload too short")
+	}
+	headerLength := int(binary.BigEndian.Uint16(sealed))
+	if len(sealed)-2 < headerLength {
+		return nil, nil, fmt.Errorf("sealed payload too short")
+	}
+	return sealed[2 : 2+headerLength], sealed[2+headerLength:], nil
+}
+
+// Read a header-carrying payload's header without a key, e.g. to route it. The
+// header is unauthenticated until the payload is opened.
+func PeekTransmissionHeader(sealed []byte) ([]byte, error) {
+	header, _, err := splitTransmissionHeader(sealed)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{}, header...), nil
+}
+
+// Payload pipeline shared by both ends of a link: compress, pad, encrypt, then
+// append the payload tag. Sealed layout is message nonce || ciphertext || tag.
+// sp is re-initialized under a fresh random message nonce mixed with the
+// session nonce, and the tag is keyed on the mixed nonce.
+func sealPayload(sp *StreamProcessor, key, nonce, header, data []byte, compress bool, bucket int) ([]byte, error) {
+	if compress {
+		compressed, err := compressPayload(data)
+		if err != nil {
+			return nil, err
+		}
+		data = compressed
+	}
+
+	if bucket > 0 {
+		data = padPayload(data, bucket)
+	}
+
+	messageNonce := make([]byte, MessageNonceSize)
+	if _, err := rand.Read(messageNonce); err != nil {
+		return nil, fmt.Errorf("generating message nonce: %w", err)
+	}
+	nonce = messageStreamNonce(nonce, messageNonce)
+	sp.Initialize(streamKey(key), nonce)
+
+	mac := newPayloadMAC(key, nonce, header)
+	sealed := make([]byte, 0, MessageNonceSize+len(data)+TransmissionTagSize)
+	sealed = append(sealed, messageNonce...)
+	for offset := 0; offset < len(data); offset += DefaultTransmissionChunkSize {
+		end := offset + DefaultTransmissionChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		ciphertext := sp.EncryptData(data[offset:end])
+		mac.Write(ciphertext)
+		sealed = append(sealed, ciphertext...)
+	}
+
+	return append(sealed, mac.Sum(nil)[:TransmissionTagSize]...), nil
+}
+
+// Inverse of sealPayload, also returning the payload's message nonce for replay
+// checks. The tag is checked before the plaintext is unpadded or decompressed,
+// so forged payloads never reach the decompressor.
+func openPayload(sp *StreamProcessor, key, nonce, header, sealed []byte, compress bool, bucket int) ([]byte, []byte, error) {
+	if len(sealed) < MessageNonceSize+TransmissionTagSize {
+		return nil, nil, fmt.Errorf("sealed payload too short")
+	}
+	messageNonce := sealed[:MessageNonceSize]
+	encryptedData := sealed[MessageNonceSize : len(sealed)-TransmissionTagSize]
+	tag := sealed[len(sealed)-TransmissionTagSize:]
+
+	nonce = messageStreamNonce(nonce, messageNonce)
+	sp.Initialize(streamKey(key), nonce)
+
+	mac := newPayloadMAC(key, nonce, header)
+	data := make([]byte, 0, len(encryptedData))
+	for offset := 0; offset < len(encryptedData); offset += DefaultTransmissionChunkSize {
+		end := offset + DefaultTransmissionChunkSize
+		if end > len(encryptedData) {
+			end = len(encryptedData)
+		}
+		mac.Write(encryptedData[offset:end])
+		data = append(data, sp.EncryptData(encryptedData[offset:end])...)
+	}
+
+	if subtle.ConstantTimeCompare(tag, mac.Sum(nil)[:TransmissionTagSize]) != 1 {
+		return nil, nil, fmt.Errorf("payload authentication failed")
+	}
+
+	if bucket > 0 {
+		unpadded, err := unpadPayload(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = unpadded
+	}
+
+	if compress {
+		decompressed, err := decompressPayload(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = decompressed
+	}
+	return data, append([]byte{}, messageNonce...), nil
+}
+
+// Device side of the controller protocol, for exercising authentication and
+// transmission end to end without hardware. The device key is the one the
+// controller's key manager holds for DeviceID; payload settings must match
+// the controller's session.
+type SimulatedDevice struct {
+	DeviceID         string
+	TagLength        int        // must match the controller's tag length
+	AuthDigest       AuthDigest // must match the controller's tag digest
+	CompressPayloads bool       // must match the session's compression setting
+	PaddingBucket    int        // must match the session's padding bucket
+	SessionKeyLength int        // must match the controller's session key length
+
+	deviceKey       []byte
+	compactCipher   *CompactCipherEngine
+	streamProcessor *StreamProcessor
+	nonce           []byte // session nonce, set once a challenge is answered
+	sessionKey      []byte // stream key derived once a challenge is answered
+}
+
+func NewSimulatedDevice(deviceID string, deviceKey []byte) *SimulatedDevice {
+	compactCipher := NewCompactCipherEngine()
+	compactCipher.SetKey(deviceKey)
+
+	return &SimulatedDevice{
+		DeviceID:         deviceID,
+		TagLength:        DigestOutputSize,
+		SessionKeyLength: DefaultSessionKeyLength,
+		deviceKey:        append([]byte(nil), deviceKey...),
+		compactCipher:    compactCipher,
+		streamProcessor:  NewStreamProcessor(),
+	}
+}
+
+// Answer a challenge the way AuthenticateDevice does: response || tag. The
+// response also seeds the session nonce for later transmissions.
+func (d *SimulatedDevice) RespondToChallenge(challenge []byte) ([]byte, error) {
+	if len(challenge) != CompactBlockSize {
+		return nil, fmt.Errorf("invalid challenge size")
+	}
+	if d.TagLength < MinAuthenticationTagSize || d.TagLength > DigestOutputSize {
+		return nil, fmt.Errorf("authentication tag length must be between %d and %d bytes, got %d",
+			MinAuthenticationTagSize, DigestOutputSize, d.TagLength)
+	}
+	if d.SessionKeyLength < MinSessionKeyLength || d.SessionKeyLength > SessionSecretSize {
+		return nil, fmt.Errorf("session key length must be between %d and %d bytes, got %d",
+			MinSessionKeyLength, SessionSecretSize, d.SessionKeyLength)
+	}
+
+	response := d.compactCipher.EncryptBlock(challenge)
+	authTag := authenticationTag(d.AuthDigest, d.deviceKey, challenge, response)[:d.TagLength]
+
+	nonce, err := sessionNonce(response)
+	if err != nil {
+		return nil, err
+	}
+	d.nonce = nonce
+	_, d.sessionKey = deriveSessionKeys(d.deviceKey, challenge, response, d.SessionKeyLength)
+	return append(response, authTag...), nil
+}
+
+// Run a full IssueChallenge / CompleteChallenge exchange against sc
+func (d *SimulatedDevice) Authenticate(sc *SecurityController) error {
+	challengeID, challenge, err := sc.IssueChallenge(d.DeviceID)
+	if err != nil {
+		return err
+	}
+	response, err := d.RespondToChallenge(challenge)
+	if err != nil {
+		return err
+	}
+	return sc.CompleteChallenge(challengeID, response)
+}
+
+// Produce a payload for the controller's ReceiveDataTransmission
+func (d *SimulatedDevice) Encrypt(data []byte) ([]byte, error) {
+	streamProcessor, err := d.sessionStream()
+	if err != nil {
+		return nil, err
+	}
+	return sealPayload(streamProcessor, d.sessionKey, d.nonce, nil, data, d.CompressPayloads, d.PaddingBucket)
+}
+
+// Produce a payload with a cleartext header for ReceiveDataTransmissionWithHeader
+func (d *SimulatedDevice) EncryptWithHeader(header, data []byte) ([]byte, error) {
+	streamProcessor, err := d.sessionStream()
+	if err != nil {
+		return nil, err
+	}
+	return sealHeaderPayload(streamProcessor, d.sessionKey, d.nonce, header, data, d.CompressPayloads, d.PaddingBucket)
+}
+
+// Recover a payload produced by the controller's SecureDataTransmission
+func (d *SimulatedDevice) Decrypt(encryptedData []byte) ([]byte, error) {
+	streamProcessor, err := d.sessionStream()
+	if err != nil {
+		return nil, err
+	}
+	data, _, err := openPayload(streamProcessor, d.sessionKey, d.nonce, nil, encryptedData, d.CompressPayloads, d.PaddingBucket)
+	return data, err
+}
+
+// Recover the header and plaintext of SecureDataTransmissionWithHeader output
+func (d *SimulatedDevice) DecryptWithHeader(encryptedData []byte) ([]byte, []byte, error) {
+	streamProcessor, err := d.sessionStream()
+	if err != nil {
+		return nil, nil, err
+	}
+	header, data, _, err := openHeaderPayload(streamProcessor, d.sessionKey, d.nonce, encryptedData, d.CompressPayloads, d.PaddingBucket)
+	return header, data, err
+}
+
+func (d *SimulatedDevice) sessionStream() (*StreamProcessor, error) {
+	if d.nonce == nil {
+		return nil, fmt.Errorf("device not authenticated")
+	}
+	return d.streamProcessor.Clone(), nil
+}
+
+const paddingHeaderSize = 4
+
+func padPayload(data []byte, bucket int) []byte {
+	size := paddingHeaderSize + len(data)
+	if rem := size % bucket; rem != 0 {
+		size += bucket - rem
+	}
+
+	padded := make([]byte, size)
+	binary.LittleEndian.PutUint32(padded, uint32(len(data)))
+	copy(padded[paddingHeaderSize:], data)
+	return padded
+}
+
+func unpadPayload(padded []byte) ([]byte, error) {
+	if len(padded) < paddingHeaderSize {
+		return nil, fmt.Errorf("padded payload too short")
+	}
+
+	length := binary.LittleEndian.Uint32(padded)
+	if uint64(length) > uint64(len(padded)-paddingHeaderSize) {
+		return nil, fmt.Errorf("padded payload length %d exceeds envelope", length)
+	}
+	return padded[paddingHeaderSize : paddingHeaderSize+int(length)], nil
+}
+
+const maxDecompressedPayload = 16 << 20
+
+func compressPayload(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func decompressPayload(data []byte) ([]byte, error) {
+	fr := flate.NewReader(bytes.NewReader(data))
+	defer fr.Close()
+
+	// Bound expansion so a hostile payload can't exhaust memory
+	decompressed, err := io.ReadAll(io.LimitReader(fr, maxDecompressedPayload+1))
+	if err != nil {
+		return nil, fmt.Errorf("payload decompression failed: %w", err)
+	}
+	if len(decompressed) > maxDecompressedPayload {
+		return nil, fmt.Errorf("decompressed payload exceeds %d bytes", maxDecompressedPayload)
+	}
+	return decompressed, nil
 }
 
 func main() {
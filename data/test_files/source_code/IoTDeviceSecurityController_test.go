@@ -0,0 +1,2158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// digestVectors pin DigestCalculator output. legacy is what the pre-fix Update,
+// which counted a call before buffering its bytes, produced for the same input.
+var digestVectors = []struct {
+	message string
+	want    string
+	legacy  string
+}{
+	{"", "36dd76d30b87a38c458070cad18be3ed", "36dd76d30b87a38c458070cad18be3ed"},
+	{"abc", "035709aa0f94562d8ef341487e2856a9", "84ce55f2cfcff5f77bb38da194634929"},
+	{
+		"The quick brown fox jumps over the lazy dog",
+		"29a76083ab38cea0cf406d49fca2b3f5",
+		"985c91590aa2bf6933b247076c5f93ac",
+	},
+	{
+		"0123456789012345678901234567890123456789012345678901234567890123456789",
+		"b94fc92b85d5a58224389a8805e3946c",
+		"34d012130f72b9bf4c0a5abac9357b84",
+	},
+}
+
+func TestDigestCalculatorVectors(t *testing.T) {
+	for _, v := range digestVectors {
+		dc := NewDigestCalculator()
+		dc.Update([]byte(v.message))
+		got := hex.EncodeToString(dc.Finalize())
+
+		if got != v.want {
+			t.Errorf("digest(%q) = %s, want %s", v.message, got, v.want)
+		}
+		if v.message != "" && got == v.legacy {
+			t.Errorf("digest(%q) still matches the pre-fix output", v.message)
+		}
+	}
+}
+
+func TestDigestCalculatorChunkingInvariant(t *testing.T) {
+	message := []byte(digestVectors[len(digestVectors)-1].message)
+
+	for split := 0; split <= len(message); split++ {
+		dc := NewDigestCalculator()
+		dc.Update(message[:split])
+		dc.Update(message[split:])
+
+		if got := hex.EncodeToString(dc.Finalize()); got != digestVectors[len(digestVectors)-1].want {
+			t.Fatalf("split at %d: digest %s differs from one-shot", split, got)
+		}
+	}
+}
+
+func TestDigestCheckpointResumesAcrossRuns(t *testing.T) {
+	message := []byte(digestVectors[len(digestVectors)-1].message)
+
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		oneRun := NewDigestCalculator()
+		oneRun.SetByteOrder(order)
+		oneRun.Update(message)
+		want := oneRun.Finalize()
+
+		for split := 0; split <= len(message); split += 7 {
+			first := NewDigestCalculator()
+			first.SetByteOrder(order)
+			first.Update(message[:split])
+			state, err := first.MarshalBinary()
+			if err != nil {
+				t.Fatalf("%v: MarshalBinary: %v", order, err)
+			}
+
+			// The second run starts from a fresh, little-endian calculator
+			second := NewDigestCalculator()
+			if err := second.UnmarshalBinary(state); err != nil {
+				t.Fatalf("%v: UnmarshalBinary: %v", order, err)
+			}
+			second.Update(message[split:])
+
+			if got := second.Finalize(); !bytes.Equal(got, want) {
+				t.Fatalf("%v split at %d: resumed digest %x, one run %x", order, split, got, want)
+			}
+		}
+	}
+
+	if err := NewDigestCalculator().UnmarshalBinary([]byte("dgc\x02")); err == nil {
+		t.Error("accepted a truncated digest state")
+	}
+}
+
+// testMasterKey is a fixed master key for building key managers that must agree
+var testMasterKey = []byte("fixed-master-key")
+
+func newTestKeyManager(t *testing.T, salt []byte) *KeyManager {
+	t.Helper()
+	km, err := NewKeyManagerFromSource(bytes.NewReader(testMasterKey), salt, DefaultMasterKeyPolicy)
+	if err != nil {
+		t.Fatalf("NewKeyManagerFromSource: %v", err)
+	}
+	return km
+}
+
+func TestProvisionDevicesMatchesGetDeviceKey(t *testing.T) {
+	ids := make([]string, 1000)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("sensor-%04d", i)
+	}
+
+	provisioned, err := newTestKeyManager(t, nil).ProvisionDevices(ids)
+	if err != nil {
+		t.Fatalf("ProvisionDevices: %v", err)
+	}
+	if len(provisioned) != len(ids) {
+		t.Fatalf("provisioned %d keys, want %d", len(provisioned), len(ids))
+	}
+
+	// A second manager over the same master key derives each key on demand
+	reference := newTestKeyManager(t, nil)
+	for _, id := range ids {
+		want, err := reference.GetDeviceKey(id)
+		if err != nil {
+			t.Fatalf("GetDeviceKey(%q): %v", id, err)
+		}
+		if !bytes.Equal(provisioned[id], want) {
+			t.Fatalf("%s: provisioned key %x, GetDeviceKey %x", id, provisioned[id], want)
+		}
+	}
+}
+
+// newTestDevice provisions deviceID on sc and returns a simulated device holding
+// the same key
+func newTestDevice(t *testing.T, sc *SecurityController, deviceID string) *SimulatedDevice {
+	t.Helper()
+	key, err := sc.keyManager.GetDeviceKey(deviceID)
+	if err != nil {
+		t.Fatalf("GetDeviceKey(%q): %v", deviceID, err)
+	}
+	return NewSimulatedDevice(deviceID, key)
+}
+
+func TestIssueChallengeConcurrentAttempts(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "sensor-1")
+
+	type issued struct {
+		id        string
+		challenge []byte
+	}
+	var wg sync.WaitGroup
+	attempts := make([]issued, 2)
+	for i := range attempts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id, challenge, err := sc.IssueChallenge(device.DeviceID)
+			if err != nil {
+				t.Errorf("IssueChallenge: %v", err)
+				return
+			}
+			attempts[i] = issued{id, challenge}
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+	if attempts[0].id == attempts[1].id {
+		t.Fatal("concurrent challenges share an ID")
+	}
+
+	// Answer in reverse order; each attempt resolves on its own
+	for i := len(attempts) - 1; i >= 0; i-- {
+		response, err := device.RespondToChallenge(attempts[i].challenge)
+		if err != nil {
+			t.Fatalf("RespondToChallenge: %v", err)
+		}
+		if err := sc.CompleteChallenge(attempts[i].id, response); err != nil {
+			t.Fatalf("CompleteChallenge(attempt %d): %v", i, err)
+		}
+	}
+}
+
+func TestSimulatedDeviceRoundTrip(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "sensor-1")
+
+	if _, err := device.Encrypt([]byte("early")); err == nil {
+		t.Fatal("unauthenticated device encrypted a payload")
+	}
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	reading := []byte("Sensor reading: Temperature=25.6C")
+	downlink, err := sc.SecureDataTransmission(device.DeviceID, reading)
+	if err != nil {
+		t.Fatalf("SecureDataTransmission: %v", err)
+	}
+	got, err := device.Decrypt(downlink)
+	if err != nil {
+		t.Fatalf("device Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, reading) {
+		t.Fatalf("device received %q, want %q", got, reading)
+	}
+
+	ack := []byte("ack")
+	uplink, err := device.Encrypt(ack)
+	if err != nil {
+		t.Fatalf("device Encrypt: %v", err)
+	}
+	if got, err = sc.ReceiveDataTransmission(device.DeviceID, uplink); err != nil {
+		t.Fatalf("ReceiveDataTransmission: %v", err)
+	}
+	if !bytes.Equal(got, ack) {
+		t.Fatalf("controller received %q, want %q", got, ack)
+	}
+
+	uplink[len(uplink)/2] ^= 1
+	if _, err := sc.ReceiveDataTransmission(device.DeviceID, uplink); err == nil {
+		t.Error("controller accepted a tampered uplink")
+	}
+}
+
+func TestStreamRoundCounts(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	zeros := make([]byte, 4*StreamBufferSize)
+
+	keystreams := make(map[string]int)
+	for _, rounds := range []int{8, 12, 20} {
+		sp, err := NewStreamProcessorWithRounds(rounds)
+		if err != nil {
+			t.Fatalf("NewStreamProcessorWithRounds(%d): %v", rounds, err)
+		}
+		sp.Initialize(key, nonce)
+		keystream := string(sp.EncryptData(zeros))
+		if other, seen := keystreams[keystream]; seen {
+			t.Errorf("%d and %d rounds produce the same keystream", other, rounds)
+		}
+		keystreams[keystream] = rounds
+	}
+
+	def := NewStreamProcessor()
+	def.Initialize(key, nonce)
+	if got := keystreams[string(def.EncryptData(zeros))]; got != DefaultStreamRounds {
+		t.Errorf("NewStreamProcessor keystream matches %d rounds, want %d", got, DefaultStreamRounds)
+	}
+
+	for _, rounds := range []int{-1, 0, 1, 10, 16, 24} {
+		if _, err := NewStreamProcessorWithRounds(rounds); err == nil {
+			t.Errorf("NewStreamProcessorWithRounds(%d) accepted an unsupported count", rounds)
+		}
+	}
+}
+
+var streamBufferSizes = []int{StreamBufferSize, 2 * StreamBufferSize, 4096}
+
+func TestStreamOutputIndependentOfBufferSize(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	message := bytes.Repeat([]byte("bulk sensor frame "), 400)
+
+	reference := NewStreamProcessor()
+	reference.Initialize(key, nonce)
+	want := reference.EncryptData(message)
+
+	for _, size := range streamBufferSizes {
+		sp := NewStreamProcessor()
+		if err := sp.SetBufferSize(size); err != nil {
+			t.Fatalf("SetBufferSize(%d): %v", size, err)
+		}
+		sp.Initialize(key, nonce)
+
+		// Uneven chunks cross buffer boundaries at different points per size
+		var got []byte
+		for rest := message; len(rest) > 0; {
+			n := len(rest)
+			if n > 77 {
+				n = 77
+			}
+			got = append(got, sp.EncryptData(rest[:n])...)
+			rest = rest[n:]
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("buffer size %d: keystream differs from the default", size)
+		}
+
+		sp.Seek(1000)
+		if seeked := sp.EncryptData(message[1000:1100]); !bytes.Equal(seeked, want[1000:1100]) {
+			t.Errorf("buffer size %d: Seek lands on the wrong keystream", size)
+		}
+	}
+
+	if err := NewStreamProcessor().SetBufferSize(StreamBufferSize + 1); err == nil {
+		t.Error("accepted a buffer size that is not a multiple of the block size")
+	}
+}
+
+func BenchmarkStreamEncrypt(b *testing.B) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	payload := make([]byte, 64<<10)
+
+	for _, size := range streamBufferSizes {
+		b.Run(fmt.Sprintf("buffer=%d", size), func(b *testing.B) {
+			sp := NewStreamProcessor()
+			if err := sp.SetBufferSize(size); err != nil {
+				b.Fatal(err)
+			}
+			b.SetBytes(int64(len(payload)))
+			for i := 0; i < b.N; i++ {
+				sp.Initialize(key, nonce)
+				sp.EncryptData(payload)
+			}
+			stats := sp.Stats()
+			b.ReportMetric(float64(stats.Generated)/float64(size)/float64(b.N), "regenerations/op")
+		})
+	}
+}
+
+// fixedKeyProvider derives the same key for every device
+type fixedKeyProvider struct {
+	key []byte
+}
+
+func (p fixedKeyProvider) DeriveDeviceKey(string) ([]byte, error) {
+	return append([]byte(nil), p.key...), nil
+}
+
+func TestWeakDerivedKeysAreRejected(t *testing.T) {
+	weak := map[string][]byte{
+		"all-zero":      make([]byte, LightweightKeySize),
+		"repeated byte": bytes.Repeat([]byte{0xAA}, LightweightKeySize),
+	}
+	for name, key := range weak {
+		km := NewKeyManagerWithProvider(fixedKeyProvider{key})
+		if _, err := km.GetDeviceKey("sensor-1"); err == nil {
+			t.Errorf("%s: GetDeviceKey accepted a weak key", name)
+		}
+		if _, err := km.ProvisionDevices([]string{"sensor-1", "sensor-2"}); err == nil {
+			t.Errorf("%s: ProvisionDevices accepted a weak key", name)
+		}
+
+		sc := NewSecurityController()
+		sc.keyManager = km
+		if _, err := sc.AuthenticateDevice("sensor-1", make([]byte, CompactBlockSize)); err == nil {
+			t.Errorf("%s: AuthenticateDevice accepted a weak key", name)
+		}
+	}
+}
+
+func TestLowWeightKeysAreAccepted(t *testing.T) {
+	// Genuine keys can be unbalanced; only degenerate patterns are weak
+	key := []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfe}
+	if _, err := NewKeyManagerWithProvider(fixedKeyProvider{key}).GetDeviceKey("sensor-1"); err != nil {
+		t.Errorf("GetDeviceKey rejected a high-weight key: %v", err)
+	}
+}
+
+func TestStreamedPayloadTagMatchesOneShot(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "camera-1")
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	payload := make([]byte, 3<<20+17)
+	for i := range payload {
+		payload[i] = byte(i * 7)
+	}
+
+	sealed, err := sc.SecureDataTransmission(device.DeviceID, payload)
+	if err != nil {
+		t.Fatalf("SecureDataTransmission: %v", err)
+	}
+	ciphertext := sealed[MessageNonceSize : len(sealed)-TransmissionTagSize]
+	tag := sealed[len(sealed)-TransmissionTagSize:]
+
+	oneShot := newPayloadMAC(device.sessionKey, messageStreamNonce(device.nonce, sealed[:MessageNonceSize]), nil)
+	oneShot.Write(ciphertext)
+	if !bytes.Equal(tag, oneShot.Sum(nil)[:TransmissionTagSize]) {
+		t.Fatal("chunk-by-chunk tag differs from a one-shot tag over the ciphertext")
+	}
+
+	got, err := device.Decrypt(sealed)
+	if err != nil {
+		t.Fatalf("device Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Fatal("device recovered a different payload")
+	}
+
+	uplink, err := device.Encrypt(payload)
+	if err != nil {
+		t.Fatalf("device Encrypt: %v", err)
+	}
+	uplink[len(uplink)-TransmissionTagSize-1] ^= 1
+	if _, err := sc.ReceiveDataTransmission(device.DeviceID, uplink); err == nil {
+		t.Error("controller accepted a payload tampered in its last chunk")
+	}
+}
+
+func TestAuthResultMatchesFlatResponse(t *testing.T) {
+	sc := NewSecurityController()
+	challenge := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	flat, err := sc.AuthenticateDevice("sensor-1", challenge)
+	if err != nil {
+		t.Fatalf("AuthenticateDevice: %v", err)
+	}
+	result, err := sc.Authenticate("sensor-1", challenge)
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	if !bytes.Equal(result.Response, flat[:CompactBlockSize]) {
+		t.Errorf("Response = %x, want %x", result.Response, flat[:CompactBlockSize])
+	}
+	if !bytes.Equal(result.AuthTag, flat[CompactBlockSize:]) {
+		t.Errorf("AuthTag = %x, want %x", result.AuthTag, flat[CompactBlockSize:])
+	}
+	if !bytes.Equal(result.Bytes(), flat) {
+		t.Error("Bytes does not reproduce the flat response")
+	}
+
+	if len(result.SessionID) != 32 {
+		t.Fatalf("SessionID = %q, want 32 hex digits", result.SessionID)
+	}
+	ids := sc.DeviceSessionIDs("sensor-1")
+	if len(ids) != 2 || (ids[0] != result.SessionID && ids[1] != result.SessionID) {
+		t.Errorf("device sessions %q do not include %q", ids, result.SessionID)
+	}
+}
+
+func TestTwoSessionsOfOneDeviceAreIndependent(t *testing.T) {
+	sc := NewSecurityController()
+
+	// One device on two connections, each authenticating its own session
+	first := newTestDevice(t, sc, "gateway-1")
+	second := newTestDevice(t, sc, "gateway-1")
+	for _, conn := range []*SimulatedDevice{first, second} {
+		if err := conn.Authenticate(sc); err != nil {
+			t.Fatalf("Authenticate: %v", err)
+		}
+	}
+	ids := sc.DeviceSessionIDs("gateway-1")
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("device sessions = %q, want two distinct sessions", ids)
+	}
+
+	conns := []*SimulatedDevice{first, second}
+	for i, sessionID := range ids {
+		message := []byte(fmt.Sprintf("for connection %d", i))
+		sealed, err := sc.SecureSessionTransmission(sessionID, message)
+		if err != nil {
+			t.Fatalf("SecureSessionTransmission: %v", err)
+		}
+		if got, err := conns[i].Decrypt(sealed); err != nil || !bytes.Equal(got, message) {
+			t.Errorf("connection %d: Decrypt = %q, %v", i, got, err)
+		}
+		if _, err := conns[1-i].Decrypt(sealed); err == nil {
+			t.Errorf("connection %d opened a payload for the other session", 1-i)
+		}
+
+		uplink, err := conns[i].Encrypt(message)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sc.ReceiveSessionTransmission(ids[1-i], uplink); err == nil {
+			t.Errorf("session %d accepted connection %d's uplink", 1-i, i)
+		}
+		if got, err := sc.ReceiveSessionTransmission(sessionID, uplink); err != nil || !bytes.Equal(got, message) {
+			t.Errorf("session %d: ReceiveSessionTransmission = %q, %v", i, got, err)
+		}
+	}
+
+	if err := sc.CloseSession(ids[0]); err != nil {
+		t.Fatalf("CloseSession: %v", err)
+	}
+	if _, err := sc.SecureSessionTransmission(ids[0], []byte("x")); err == nil {
+		t.Error("closed session still transmits")
+	}
+	if _, err := sc.SecureSessionTransmission(ids[1], []byte("x")); err != nil {
+		t.Errorf("closing one session broke the other: %v", err)
+	}
+}
+
+func TestLivenessCheck(t *testing.T) {
+	sc := NewSecurityController()
+	if err := sc.LivenessCheck(); err != nil {
+		t.Fatalf("healthy controller failed the liveness check: %v", err)
+	}
+	if len(sc.keyManager.deviceKeys) != 0 || len(sc.deviceSessions) != 0 {
+		t.Error("liveness check left its probe device behind")
+	}
+
+	// A stream processor with no keystream buffer cannot encrypt anything
+	broken := NewSecurityController()
+	broken.streamProcessor = &StreamProcessor{rounds: DefaultStreamRounds}
+	if err := broken.LivenessCheck(); err == nil {
+		t.Error("liveness check passed with a broken stream cipher")
+	}
+
+	weak := NewSecurityController()
+	weak.keyManager = NewKeyManagerWithProvider(fixedKeyProvider{make([]byte, LightweightKeySize)})
+	if err := weak.LivenessCheck(); err == nil {
+		t.Error("liveness check passed with a provider deriving weak keys")
+	}
+}
+
+func TestCheckNonceWindow(t *testing.T) {
+	sc := NewSecurityController()
+	clock := NewFakeClock(time.Unix(1000, 0))
+	sc.SetClock(clock)
+	if err := sc.SetReplayWindow(time.Minute, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sc.CheckNonce("sensor-1", []byte("n1")); err != nil {
+		t.Fatalf("first use rejected: %v", err)
+	}
+	clock.Advance(30 * time.Second)
+	if err := sc.CheckNonce("sensor-1", []byte("n1")); err == nil {
+		t.Error("nonce reused within the window was accepted")
+	}
+	if err := sc.CheckNonce("sensor-2", []byte("n1")); err != nil {
+		t.Errorf("another device's nonce collided: %v", err)
+	}
+
+	// Past retention the nonce is forgotten and accepted again; callers bound
+	// message age to the window to close this gap
+	clock.Advance(31 * time.Second)
+	if err := sc.CheckNonce("sensor-1", []byte("n1")); err != nil {
+		t.Errorf("nonce beyond the window rejected: %v", err)
+	}
+
+	// Capacity bounds memory: the oldest nonce is evicted early under load
+	clock.Advance(2 * time.Minute)
+	for _, nonce := range []string{"a", "b", "c", "d"} {
+		if err := sc.CheckNonce("sensor-3", []byte(nonce)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sc.CheckNonce("sensor-3", []byte("a")); err != nil {
+		t.Errorf("evicted nonce rejected: %v", err)
+	}
+	if err := sc.CheckNonce("sensor-3", []byte("d")); err == nil {
+		t.Error("retained nonce accepted")
+	}
+}
+
+func TestConsecutiveEncryptsConsumeContiguousKeystream(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	first := bytes.Repeat([]byte{0x11}, 20)
+	second := bytes.Repeat([]byte{0x22}, 50)
+
+	sp := NewStreamProcessor()
+	sp.Initialize(key, nonce)
+	split := append(sp.EncryptData(first), sp.EncryptData(second)...)
+
+	whole := NewStreamProcessor()
+	whole.Initialize(key, nonce)
+	if joined := whole.EncryptData(append(append([]byte(nil), first...), second...)); !bytes.Equal(split, joined) {
+		t.Fatal("second EncryptData restarted the keystream")
+	}
+
+	stats := sp.Stats()
+	if stats.Consumed != uint64(len(first)+len(second)) || stats.Discarded != 0 {
+		t.Errorf("stats = %+v, want %d consumed and none discarded", stats, len(first)+len(second))
+	}
+	if stats.Generated != 3*StreamBufferSize {
+		t.Errorf("generated %d bytes, want %d", stats.Generated, 3*StreamBufferSize)
+	}
+
+	// Re-initializing discards what is left of the buffered block
+	sp.Initialize(key, nonce)
+	if got := sp.Stats().Discarded; got != stats.Generated-stats.Consumed {
+		t.Errorf("discarded %d bytes on re-initialization, want %d", got, stats.Generated-stats.Consumed)
+	}
+	if got := sp.Stats().Utilization(); got >= 1 || got <= 0 {
+		t.Errorf("utilization = %v, want a fraction", got)
+	}
+}
+
+func TestStrongAuthDigestEndToEnd(t *testing.T) {
+	sc := NewSecurityController()
+	if err := sc.SetAuthDigest(StrongAuthDigest); err != nil {
+		t.Fatal(err)
+	}
+
+	device := newTestDevice(t, sc, "sensor-1")
+	device.AuthDigest = StrongAuthDigest
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatalf("Authenticate with the strong digest: %v", err)
+	}
+
+	challenge := []byte{8, 7, 6, 5, 4, 3, 2, 1}
+	strong, err := sc.AuthenticateDevice("sensor-1", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sc.VerifyAuthentication("sensor-1", challenge, strong); err != nil {
+		t.Errorf("strong tag did not verify: %v", err)
+	}
+
+	// The same response under the legacy digest carries a different tag
+	if err := sc.SetAuthDigest(LegacyAuthDigest); err != nil {
+		t.Fatal(err)
+	}
+	legacy, err := sc.AuthenticateDevice("sensor-1", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(legacy[:CompactBlockSize], strong[:CompactBlockSize]) || bytes.Equal(legacy, strong) {
+		t.Fatal("digests should share the response and differ in the tag")
+	}
+	if err := sc.VerifyAuthentication("sensor-1", challenge, strong); err == nil {
+		t.Error("strong tag verified under the legacy digest")
+	}
+
+	legacyDevice := newTestDevice(t, sc, "sensor-1")
+	if err := sc.SetAuthDigest(StrongAuthDigest); err != nil {
+		t.Fatal(err)
+	}
+	if err := legacyDevice.Authenticate(sc); err == nil {
+		t.Error("device using the legacy digest authenticated against a strong controller")
+	}
+}
+
+func TestDomainSeparationLabels(t *testing.T) {
+	km := NewKeyManager()
+	derived := km.deriveDeviceKey(km.masterKey, "sensor-1")
+
+	labeled := func(label string) []byte {
+		dc := newLabeledDigest(label)
+		dc.Update(km.masterKey)
+		dc.Update([]byte("sensor-1"))
+		return dc.Finalize()[:LightweightKeySize]
+	}
+	if !bytes.Equal(derived, labeled(keyDerivationLabel)) {
+		t.Fatal("device key derivation does not absorb its label first")
+	}
+	if bytes.Equal(derived, labeled(authTagLabel)) {
+		t.Error("swapping the derivation label for the MAC label left the output unchanged")
+	}
+
+	labels := []string{
+		keyDerivationLabel, authTagLabel, payloadTagLabel, headerPayloadTagLabel,
+		transmissionTagLabel, transmissionChunkLabel, sessionSecretLabel,
+		sessionCipherKeyLabel, sessionStreamKeyLabel, streamKeyExpandLabel, sessionNonceLabel,
+	}
+	for i, a := range labels {
+		for j, b := range labels {
+			if i != j && bytes.HasPrefix([]byte(b), []byte(a)) {
+				t.Errorf("label %q is a prefix of %q", a, b)
+			}
+		}
+	}
+}
+
+// slowKeyProvider stalls derivations for one device until release is closed,
+// standing in for a KMS under load
+type slowKeyProvider struct {
+	slowDevice string
+	entered    chan struct{}
+	release    chan struct{}
+}
+
+func (p slowKeyProvider) DeriveDeviceKey(deviceID string) ([]byte, error) {
+	if deviceID == p.slowDevice {
+		p.entered <- struct{}{}
+		<-p.release
+	}
+	dc := newLabeledDigest(keyDerivationLabel)
+	dc.Update([]byte(deviceID))
+	return dc.Finalize()[:LightweightKeySize], nil
+}
+
+func TestAuthenticationBurstFailsFast(t *testing.T) {
+	provider := slowKeyProvider{slowDevice: "stalled", entered: make(chan struct{}, 1), release: make(chan struct{})}
+	sc := NewSecurityController()
+	sc.keyManager = NewKeyManagerWithProvider(provider)
+	const timeout = 30 * time.Millisecond
+	if err := sc.SetAuthLimits(1, 2, timeout); err != nil {
+		t.Fatal(err)
+	}
+	challenge := make([]byte, CompactBlockSize)
+
+	held := make(chan error, 1)
+	go func() {
+		_, err := sc.AuthenticateDevice("stalled", challenge)
+		held <- err
+	}()
+	<-provider.entered
+
+	const burst = 10
+	var wg sync.WaitGroup
+	latencies := make([]time.Duration, burst)
+	errs := make([]error, burst)
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start := time.Now()
+			_, errs[i] = sc.AuthenticateDevice(fmt.Sprintf("herd-%d", i), challenge)
+			latencies[i] = time.Since(start)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < burst; i++ {
+		if !errors.Is(errs[i], ErrAuthTimeout) {
+			t.Errorf("request %d: err = %v, want ErrAuthTimeout", i, errs[i])
+		}
+		if latencies[i] > timeout+time.Second {
+			t.Errorf("request %d took %v, want about the %v timeout at most", i, latencies[i], timeout)
+		}
+	}
+
+	close(provider.release)
+	if err := <-held; err != nil {
+		t.Fatalf("stalled authentication failed: %v", err)
+	}
+	if _, err := sc.AuthenticateDevice("herd-0", challenge); err != nil {
+		t.Errorf("authentication after the burst: %v", err)
+	}
+}
+
+// panickingKeyProvider panics on every derivation, as a buggy KMS client might
+type panickingKeyProvider struct{}
+
+func (panickingKeyProvider) DeriveDeviceKey(string) ([]byte, error) {
+	panic("kms client bug")
+}
+
+func TestPanicsBecomeInternalErrors(t *testing.T) {
+	km := NewKeyManagerWithProvider(panickingKeyProvider{})
+	if _, err := km.ProvisionDevices([]string{"sensor-1", "sensor-2", "sensor-3"}); !errors.Is(err, ErrInternal) {
+		t.Errorf("ProvisionDevices: err = %v, want ErrInternal", err)
+	}
+	if len(km.deviceKeys) != 0 {
+		t.Error("ProvisionDevices cached keys after a panic")
+	}
+
+	sc := NewSecurityController()
+	sc.keyManager = km
+	if _, err := sc.AuthenticateDevice("sensor-1", make([]byte, CompactBlockSize)); !errors.Is(err, ErrInternal) {
+		t.Errorf("AuthenticateDevice: err = %v, want ErrInternal", err)
+	}
+
+	// A nil challenge map is a programming fault inside IssueChallenge
+	sc.pendingChallenges = nil
+	if _, _, err := sc.IssueChallenge("sensor-1"); !errors.Is(err, ErrInternal) {
+		t.Errorf("IssueChallenge: err = %v, want ErrInternal", err)
+	}
+}
+
+func TestSetKeyCheckedRunsKnownAnswerTest(t *testing.T) {
+	key := []byte{0x10, 0x32, 0x54, 0x76, 0x98, 0xBA, 0xDC, 0xFE, 0xEF, 0xCD}
+
+	kat := NewCompactCipherEngine()
+	kat.SetKey(compactKATKey)
+	if got := kat.EncryptBlock(compactKATPlaintext); !bytes.Equal(got, compactKATCiphertext) {
+		t.Fatalf("known-answer ciphertext = %x, want %x", got, compactKATCiphertext)
+	}
+
+	bigEndian := NewCompactCipherEngine()
+	bigEndian.SetByteOrder(binary.BigEndian)
+	for name, ce := range map[string]*CompactCipherEngine{
+		"default":    NewCompactCipherEngine(),
+		"big-endian": bigEndian,
+	} {
+		if err := ce.SetKeyChecked(key); err != nil {
+			t.Errorf("%s: SetKeyChecked: %v", name, err)
+		}
+	}
+
+	if err := NewCompactCipherEngine().SetKeyChecked(key[:LightweightKeySize-1]); err == nil {
+		t.Error("accepted a short key")
+	}
+
+	corruptSbox := NewCompactCipherEngine()
+	corruptSbox.sboxes[2][7] ^= 0x1
+	corruptRounds := NewCompactCipherEngine()
+	corruptRounds.rounds--
+	for name, ce := range map[string]*CompactCipherEngine{
+		"S-box":  corruptSbox,
+		"rounds": corruptRounds,
+	} {
+		if err := ce.SetKeyChecked(key); err == nil {
+			t.Errorf("corrupted %s passed the known-answer test", name)
+		}
+	}
+}
+
+func TestTransmissionHeaderIsAuthenticatedInTheClear(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "sensor-1")
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	header := []byte("type=thermo;fw=1.4.2")
+	reading := []byte("Temperature=25.6C")
+	uplink, err := device.EncryptWithHeader(header, reading)
+	if err != nil {
+		t.Fatalf("EncryptWithHeader: %v", err)
+	}
+	if !bytes.Contains(uplink, header) {
+		t.Error("header is not carried in the clear")
+	}
+	if bytes.Contains(uplink, reading) {
+		t.Error("payload is carried in the clear")
+	}
+	if peeked, err := PeekTransmissionHeader(uplink); err != nil || !bytes.Equal(peeked, header) {
+		t.Errorf("PeekTransmissionHeader = %q, %v; want %q", peeked, err, header)
+	}
+
+	tampered := append([]byte(nil), uplink...)
+	tampered[2+len("type=thermo;fw=1.4.")] = '3'
+	if peeked, err := PeekTransmissionHeader(tampered); err != nil || !bytes.Equal(peeked, []byte("type=thermo;fw=1.4.3")) {
+		t.Errorf("tampered header unreadable: %q, %v", peeked, err)
+	}
+	if _, _, err := sc.ReceiveDataTransmissionWithHeader(device.DeviceID, tampered); err == nil {
+		t.Error("controller accepted a tampered header")
+	}
+
+	gotHeader, gotData, err := sc.ReceiveDataTransmissionWithHeader(device.DeviceID, uplink)
+	if err != nil {
+		t.Fatalf("ReceiveDataTransmissionWithHeader: %v", err)
+	}
+	if !bytes.Equal(gotHeader, header) || !bytes.Equal(gotData, reading) {
+		t.Fatalf("received %q / %q, want %q / %q", gotHeader, gotData, header, reading)
+	}
+
+	downlink, err := sc.SecureDataTransmissionWithHeader(device.DeviceID, []byte("cmd"), []byte("reboot"))
+	if err != nil {
+		t.Fatalf("SecureDataTransmissionWithHeader: %v", err)
+	}
+	if gotHeader, gotData, err = device.DecryptWithHeader(downlink); err != nil {
+		t.Fatalf("device DecryptWithHeader: %v", err)
+	}
+	if string(gotHeader) != "cmd" || string(gotData) != "reboot" {
+		t.Fatalf("device received %q / %q", gotHeader, gotData)
+	}
+
+	// A headerless payload can't be re-framed as carrying an empty header
+	plain, err := device.Encrypt(reading)
+	if err != nil {
+		t.Fatalf("Encrypt: %v", err)
+	}
+	reframed := append([]byte{0, 0}, plain...)
+	if _, _, err := sc.ReceiveDataTransmissionWithHeader(device.DeviceID, reframed); err == nil {
+		t.Error("controller accepted a headerless payload re-framed with a header")
+	}
+}
+
+func TestShortSessionKeyTransmitsEndToEnd(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "sensor-1")
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	// Key both ends on the raw LightweightKeySize device key, as the sample flow did
+	shortKey, err := sc.keyManager.GetDeviceKey(device.DeviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.sessionMutex.Lock()
+	session, _ := sc.latestSession(device.DeviceID)
+	session.SessionKey = shortKey
+	sc.sessionMutex.Unlock()
+	device.sessionKey = shortKey
+
+	reading := []byte("Temperature=25.6C")
+	sealed, err := sc.SecureDataTransmission(device.DeviceID, reading)
+	if err != nil {
+		t.Fatalf("SecureDataTransmission with a %d-byte key: %v", len(shortKey), err)
+	}
+	got, err := device.Decrypt(sealed)
+	if err != nil {
+		t.Fatalf("device Decrypt: %v", err)
+	}
+	if !bytes.Equal(got, reading) {
+		t.Fatalf("device received %q, want %q", got, reading)
+	}
+
+	expanded := streamKey(shortKey)
+	if len(expanded) != MinSessionKeyLength || !bytes.Equal(expanded, streamKey(shortKey)) {
+		t.Errorf("streamKey(%d bytes) = %x, want a stable %d-byte key", len(shortKey), expanded, MinSessionKeyLength)
+	}
+	if bytes.HasPrefix(expanded, shortKey) {
+		t.Error("expanded key embeds the short key")
+	}
+	long := bytes.Repeat([]byte{0x5a}, MinSessionKeyLength)
+	if !bytes.Equal(streamKey(long), long) {
+		t.Error("streamKey changed a key that was already long enough")
+	}
+}
+
+func TestShortEncryptionStateIsAnError(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "sensor-1")
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	sc.sessionMutex.Lock()
+	session, _ := sc.latestSession(device.DeviceID)
+	state := session.EncryptionState
+	session.EncryptionState = state[:MinEncryptionStateLength-1]
+	sc.sessionMutex.Unlock()
+
+	_, err := sc.SecureDataTransmission(device.DeviceID, []byte("reading"))
+	if err == nil {
+		t.Fatal("transmitted with a short EncryptionState")
+	}
+	if errors.Is(err, ErrInternal) {
+		t.Fatalf("short EncryptionState panicked: %v", err)
+	}
+	if _, err := sc.ReceiveDataTransmission(device.DeviceID, []byte("sealed")); err == nil || errors.Is(err, ErrInternal) {
+		t.Errorf("receive with a short EncryptionState: err = %v, want a plain error", err)
+	}
+
+	nonce, err := sessionNonce(state)
+	if err != nil {
+		t.Fatalf("sessionNonce: %v", err)
+	}
+	if len(nonce) != SessionNonceSize || bytes.Equal(nonce, state[:SessionNonceSize]) {
+		t.Errorf("nonce = %x, want %d bytes derived from, not sliced out of, %x", nonce, SessionNonceSize, state)
+	}
+}
+
+func TestSeekDecryptsAMiddleSegment(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	plaintext := make([]byte, 10*StreamBufferSize+37)
+	for i := range plaintext {
+		plaintext[i] = byte(i * 7)
+	}
+
+	encryptor := NewStreamProcessor()
+	encryptor.Initialize(key, nonce)
+	ciphertext := encryptor.EncryptData(plaintext)
+
+	// Segments starting on, just after and just before block boundaries, one
+	// spanning several blocks and one ending the stream
+	segments := []struct{ offset, length int }{
+		{0, 10},
+		{StreamBufferSize, StreamBufferSize},
+		{3*StreamBufferSize + 1, 5},
+		{5*StreamBufferSize - 1, 2},
+		{2*StreamBufferSize + 13, 4*StreamBufferSize + 9},
+		{len(plaintext) - 20, 20},
+	}
+	for _, size := range streamBufferSizes {
+		decryptor := NewStreamProcessor()
+		if err := decryptor.SetBufferSize(size); err != nil {
+			t.Fatalf("SetBufferSize(%d): %v", size, err)
+		}
+		decryptor.Initialize(key, nonce)
+
+		// Seek backwards and forwards on one processor, after it has been used
+		for i := len(segments) - 1; i >= 0; i-- {
+			segment := segments[i]
+			end := segment.offset + segment.length
+			decryptor.Seek(uint64(segment.offset))
+			got := decryptor.EncryptData(ciphertext[segment.offset:end])
+			if !bytes.Equal(got, plaintext[segment.offset:end]) {
+				t.Errorf("buffer size %d: bytes %d..%d decrypt wrongly after Seek", size, segment.offset, end)
+			}
+		}
+	}
+}
+
+// extendDigest length-extends a DigestCalculator output. Given digest, the
+// digest of some unknown prefixLength-byte message m, it returns the padding
+// glue and the digest of m || glue || extra, computed without knowing m.
+func extendDigest(digest []byte, prefixLength uint64, extra []byte) (glue, forged []byte) {
+	glue = []byte{0x80}
+	for (prefixLength+uint64(len(glue)))%64 != 56 {
+		glue = append(glue, 0x00)
+	}
+	glue = binary.LittleEndian.AppendUint64(glue, prefixLength*8)
+
+	dc := NewDigestCalculator()
+	for i := range dc.state {
+		dc.state[i] = binary.LittleEndian.Uint32(digest[i*4:])
+	}
+	dc.length = prefixLength + uint64(len(glue))
+	dc.Update(extra)
+	return glue, dc.Finalize()
+}
+
+func TestExtendDigestForgesSecretPrefixTags(t *testing.T) {
+	// The construction transmission tags used to have: label || key || message
+	key := []byte("0123456789")
+	message := []byte("temp=25")
+	extra := []byte(";unlock=1")
+
+	dc := newLabeledDigest(transmissionTagLabel)
+	dc.Update(key)
+	dc.Update(message)
+	tag := dc.Finalize()
+
+	glue, forged := extendDigest(tag, uint64(len(transmissionTagLabel)+len(key)+len(message)), extra)
+
+	check := newLabeledDigest(transmissionTagLabel)
+	check.Update(key)
+	check.Update(message)
+	check.Update(glue)
+	check.Update(extra)
+	if !bytes.Equal(check.Finalize(), forged) {
+		t.Fatal("extendDigest does not forge a secret-prefix tag; the forgery tests below prove nothing")
+	}
+}
+
+func TestOpenTransmissionRejectsLengthExtension(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	plaintext := []byte("temp=25")
+
+	sealed := SealTransmission(key, nonce, plaintext)
+	if got, err := OpenTransmission(key, nonce, sealed); err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("OpenTransmission = %q, %v", got, err)
+	}
+
+	commitment := sealed[:TransmissionCommitmentSize]
+	ciphertext := sealed[TransmissionCommitmentSize : len(sealed)-TransmissionTagSize]
+	tag := sealed[len(sealed)-TransmissionTagSize:]
+
+	// Extend the ciphertext past the tagged fields, as against the old
+	// label || key || nonce || commitment || ciphertext digest
+	prefixLength := len(transmissionTagLabel) + len(key) + len(nonce) + len(commitment) + len(ciphertext)
+	glue, forgedTag := extendDigest(tag, uint64(prefixLength), []byte("garbage"))
+
+	forged := append([]byte(nil), commitment...)
+	forged = append(forged, ciphertext...)
+	forged = append(forged, glue...)
+	forged = append(forged, "garbage"...)
+	forged = append(forged, forgedTag...)
+	if got, err := OpenTransmission(key, nonce, forged); err == nil {
+		t.Fatalf("OpenTransmission accepted a length-extended forgery: %q", got)
+	}
+
+	appended := append(append([]byte(nil), sealed[:len(sealed)-TransmissionTagSize]...), 'x')
+	appended = append(appended, tag...)
+	if _, err := OpenTransmission(key, nonce, appended); err == nil {
+		t.Error("OpenTransmission accepted a ciphertext with a byte appended")
+	}
+}
+
+func TestSboxLayoutChangesCiphertext(t *testing.T) {
+	reversed := [8]int{3, 2, 1, 0, 3, 2, 1, 0}
+
+	def := NewCompactCipherEngine()
+	alt, err := NewCompactCipherEngineWithLayout(reversed)
+	if err != nil {
+		t.Fatalf("NewCompactCipherEngineWithLayout: %v", err)
+	}
+	def.SetKey(compactKATKey)
+	alt.SetKey(compactKATKey)
+
+	defCiphertext := def.EncryptBlock(compactKATPlaintext)
+	altCiphertext := alt.EncryptBlock(compactKATPlaintext)
+	if bytes.Equal(defCiphertext, altCiphertext) {
+		t.Fatal("a different S-box layout produced the same ciphertext")
+	}
+	if got := alt.DecryptBlock(altCiphertext); !bytes.Equal(got, compactKATPlaintext) {
+		t.Errorf("alternate layout round trip = %x, want %x", got, compactKATPlaintext)
+	}
+
+	for _, bad := range [][8]int{{0, 1, 2, 4, 0, 1, 2, 3}, {-1, 1, 2, 3, 0, 1, 2, 3}} {
+		if _, err := NewCompactCipherEngineWithLayout(bad); err == nil {
+			t.Errorf("accepted invalid layout %v", bad)
+		}
+	}
+}
+
+func TestSessionCompressionRoundTrip(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "sensor-1")
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if err := sc.SetSessionCompression("sensor-unknown", true); err == nil {
+		t.Error("enabled compression for an unauthenticated device")
+	}
+
+	log := bytes.Repeat([]byte("temp=21.5C humidity=40% status=ok\n"), 200)
+	plain, err := sc.SecureDataTransmission(device.DeviceID, log)
+	if err != nil {
+		t.Fatalf("SecureDataTransmission: %v", err)
+	}
+
+	if err := sc.SetSessionCompression(device.DeviceID, true); err != nil {
+		t.Fatalf("SetSessionCompression: %v", err)
+	}
+	device.CompressPayloads = true
+	if info, _ := sc.SessionInfo(device.DeviceID); !info.CompressPayloads {
+		t.Error("SessionInfo does not report compression")
+	}
+
+	downlink, err := sc.SecureDataTransmission(device.DeviceID, log)
+	if err != nil {
+		t.Fatalf("SecureDataTransmission: %v", err)
+	}
+	if len(downlink) >= len(log)/4 {
+		t.Errorf("compressed transmission is %d bytes for a %d-byte payload (uncompressed %d)", len(downlink), len(log), len(plain))
+	}
+	if got, err := device.Decrypt(downlink); err != nil || !bytes.Equal(got, log) {
+		t.Fatalf("device Decrypt = %d bytes, %v; want the %d-byte log", len(got), err, len(log))
+	}
+
+	uplink, err := device.Encrypt(log)
+	if err != nil {
+		t.Fatalf("device Encrypt: %v", err)
+	}
+	if len(uplink) >= len(log)/4 {
+		t.Errorf("compressed uplink is %d bytes for a %d-byte payload", len(uplink), len(log))
+	}
+	if got, err := sc.ReceiveDataTransmission(device.DeviceID, uplink); err != nil || !bytes.Equal(got, log) {
+		t.Fatalf("ReceiveDataTransmission = %d bytes, %v; want the %d-byte log", len(got), err, len(log))
+	}
+}
+
+// swapHalves reverses the bytes of each 32-bit half of an 8-byte block
+func swapHalves(block []byte) []byte {
+	swapped := make([]byte, len(block))
+	for i := range block {
+		swapped[i] = block[i/4*4+3-i%4]
+	}
+	return swapped
+}
+
+func TestCompactCipherByteOrder(t *testing.T) {
+	littleEndian := NewCompactCipherEngine()
+	bigEndian := NewCompactCipherEngine()
+	bigEndian.SetByteOrder(binary.BigEndian)
+	littleEndian.SetKey(compactKATKey)
+	bigEndian.SetKey(compactKATKey)
+
+	// Each half reads the same in either order, so only the output is swapped
+	symmetric := []byte{0x01, 0x23, 0x23, 0x01, 0xab, 0xcd, 0xcd, 0xab}
+	want := swapHalves(littleEndian.EncryptBlock(symmetric))
+	if got := bigEndian.EncryptBlock(symmetric); !bytes.Equal(got, want) {
+		t.Errorf("big-endian ciphertext = %x, want the little-endian one byte-swapped per half, %x", got, want)
+	}
+
+	// In general the orders are related by swapping input and output halves; the
+	// key schedule is unaffected
+	want = swapHalves(littleEndian.EncryptBlock(swapHalves(compactKATPlaintext)))
+	got := bigEndian.EncryptBlock(compactKATPlaintext)
+	if !bytes.Equal(got, want) {
+		t.Errorf("big-endian ciphertext = %x, want %x", got, want)
+	}
+	if decrypted := bigEndian.DecryptBlock(got); !bytes.Equal(decrypted, compactKATPlaintext) {
+		t.Errorf("big-endian round trip = %x", decrypted)
+	}
+}
+
+func TestDeviceActivityReflectsTraffic(t *testing.T) {
+	start := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	sc := NewSecurityController()
+	sc.SetClock(clock)
+
+	busy := newTestDevice(t, sc, "sensor-busy")
+	idle := newTestDevice(t, sc, "sensor-idle")
+	for _, device := range []*SimulatedDevice{busy, idle} {
+		if err := device.Authenticate(sc); err != nil {
+			t.Fatalf("Authenticate(%s): %v", device.DeviceID, err)
+		}
+	}
+
+	var transmitted uint64
+	for i := 0; i < 5; i++ {
+		clock.Advance(time.Second)
+		sealed, err := sc.SecureDataTransmission(busy.DeviceID, bytes.Repeat([]byte("r"), 10*(i+1)))
+		if err != nil {
+			t.Fatalf("SecureDataTransmission: %v", err)
+		}
+		transmitted += uint64(len(sealed))
+	}
+	clock.Advance(time.Minute)
+
+	activity := sc.DeviceActivity()
+	if len(activity) != 2 {
+		t.Fatalf("activity for %d devices, want 2", len(activity))
+	}
+
+	stats := activity[busy.DeviceID]
+	if stats.MessageCount != 5 || stats.BytesTransmitted != transmitted {
+		t.Errorf("busy device: %d messages, %d bytes; want 5, %d", stats.MessageCount, stats.BytesTransmitted, transmitted)
+	}
+	if want := start.Add(5 * time.Second); !stats.LastActivity.Equal(want) {
+		t.Errorf("busy device last active %v, want %v", stats.LastActivity, want)
+	}
+	if want := time.Minute + 5*time.Second; stats.SessionAge != want {
+		t.Errorf("busy device session age %v, want %v", stats.SessionAge, want)
+	}
+
+	if stats := activity[idle.DeviceID]; stats.MessageCount != 0 || stats.BytesTransmitted != 0 {
+		t.Errorf("idle device: %d messages, %d bytes; want none", stats.MessageCount, stats.BytesTransmitted)
+	}
+}
+
+func TestConcurrentAuthenticationOfOneDevice(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "sensor-1")
+
+	const attempts = 32
+	challenges := make([][]byte, attempts)
+	results := make([][]byte, attempts)
+	for i := range challenges {
+		challenges[i] = binary.BigEndian.AppendUint64(nil, uint64(i)*0x0101010101010101)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := sc.Authenticate(device.DeviceID, challenges[i])
+			if err != nil {
+				t.Errorf("Authenticate: %v", err)
+				return
+			}
+			results[i] = result.Bytes()
+		}(i)
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	// Every response is the one an uncontended cipher gives for its challenge
+	for i, challenge := range challenges {
+		want, err := device.RespondToChallenge(challenge)
+		if err != nil {
+			t.Fatalf("RespondToChallenge: %v", err)
+		}
+		if !bytes.Equal(results[i], want) {
+			t.Errorf("attempt %d: response %x, want %x", i, results[i], want)
+		}
+	}
+	if got := len(sc.DeviceSessionIDs(device.DeviceID)); got != MaxSessionsPerDevice {
+		t.Errorf("device has %d sessions, want the newest %d", got, MaxSessionsPerDevice)
+	}
+}
+
+func TestConcurrentDevicesDoNotInterfere(t *testing.T) {
+	sc := NewSecurityController()
+	devices := []*SimulatedDevice{newTestDevice(t, sc, "sensor-a"), newTestDevice(t, sc, "sensor-b")}
+
+	var wg sync.WaitGroup
+	for _, device := range devices {
+		wg.Add(1)
+		go func(device *SimulatedDevice) {
+			defer wg.Done()
+			if err := device.Authenticate(sc); err != nil {
+				t.Errorf("Authenticate(%s): %v", device.DeviceID, err)
+				return
+			}
+
+			for i := 0; i < 50; i++ {
+				reading := []byte(fmt.Sprintf("%s reading %d: %s", device.DeviceID, i, bytes.Repeat([]byte{'x'}, i)))
+				downlink, err := sc.SecureDataTransmission(device.DeviceID, reading)
+				if err != nil {
+					t.Errorf("SecureDataTransmission(%s): %v", device.DeviceID, err)
+					return
+				}
+				if got, err := device.Decrypt(downlink); err != nil || !bytes.Equal(got, reading) {
+					t.Errorf("%s decrypted %q, %v; want %q", device.DeviceID, got, err, reading)
+					return
+				}
+
+				uplink, err := device.Encrypt(reading)
+				if err != nil {
+					t.Errorf("device Encrypt(%s): %v", device.DeviceID, err)
+					return
+				}
+				if got, err := sc.ReceiveDataTransmission(device.DeviceID, uplink); err != nil || !bytes.Equal(got, reading) {
+					t.Errorf("controller received %q, %v from %s; want %q", got, err, device.DeviceID, reading)
+					return
+				}
+			}
+		}(device)
+	}
+
+	// Authentications of other devices run alongside the traffic
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := sc.AuthenticateDevice(fmt.Sprintf("sensor-other-%d", i), []byte("chllenge")); err != nil {
+				t.Errorf("AuthenticateDevice: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// openChunks runs chunks through a fresh opener, returning the released
+// plaintext and the first error from Open or Close
+func openChunks(key, nonce []byte, chunks [][]byte) ([]byte, error) {
+	opener := NewTransmissionOpener(key, nonce)
+	var released []byte
+	for _, chunk := range chunks {
+		plaintext, err := opener.Open(chunk)
+		if err != nil {
+			return released, err
+		}
+		released = append(released, plaintext...)
+	}
+	return released, opener.Close()
+}
+
+func TestChunkedTransmissionDetectsReorderingAndLoss(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	payload := bytes.Repeat([]byte("firmware image block "), 50)
+
+	chunks, err := SealTransmissionChunks(key, nonce, payload, 100)
+	if err != nil {
+		t.Fatalf("SealTransmissionChunks: %v", err)
+	}
+	if len(chunks) != (len(payload)+99)/100 {
+		t.Fatalf("%d chunks for %d bytes", len(chunks), len(payload))
+	}
+	if got, err := openChunks(key, nonce, chunks); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("in-order chunks opened to %d bytes, %v", len(got), err)
+	}
+
+	reordered := append([][]byte(nil), chunks...)
+	reordered[1], reordered[2] = reordered[2], reordered[1]
+	if got, err := openChunks(key, nonce, reordered); err == nil {
+		t.Error("reordered chunks were accepted")
+	} else if len(got) != 100 {
+		t.Errorf("released %d bytes before the reordered chunk, want 100", len(got))
+	}
+
+	dropped := append(append([][]byte(nil), chunks[:3]...), chunks[4:]...)
+	if _, err := openChunks(key, nonce, dropped); err == nil {
+		t.Error("a dropped middle chunk went undetected")
+	}
+
+	if _, err := openChunks(key, nonce, chunks[:len(chunks)-1]); err == nil {
+		t.Error("a dropped final chunk went undetected")
+	}
+
+	replayed := append(append([][]byte(nil), chunks...), chunks[len(chunks)-1])
+	if _, err := openChunks(key, nonce, replayed); err == nil {
+		t.Error("a chunk after the final one was accepted")
+	}
+
+	empty, err := SealTransmissionChunks(key, nonce, nil, 100)
+	if err != nil || len(empty) != 1 {
+		t.Fatalf("empty payload sealed to %d chunks, %v; want one final chunk", len(empty), err)
+	}
+	if got, err := openChunks(key, nonce, empty); err != nil || len(got) != 0 {
+		t.Errorf("empty payload opened to %q, %v", got, err)
+	}
+}
+
+func TestChunkTagRejectsLengthExtension(t *testing.T) {
+	key := []byte("0123456789abcdef")
+	nonce := []byte("nonce123")
+	chunks, err := SealTransmissionChunks(key, nonce, []byte("chunk zero, then chunk one"), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Extend chunk 0's ciphertext keeping its counter and flag, as against the
+	// old label || key || nonce || commitment || counter || flag || ciphertext digest
+	first := chunks[0]
+	ciphertext := first[1 : len(first)-TransmissionTagSize]
+	tag := first[len(first)-TransmissionTagSize:]
+	prefixLength := len(transmissionChunkLabel) + len(key) + len(nonce) + TransmissionCommitmentSize + 9 + len(ciphertext)
+	glue, forgedTag := extendDigest(tag, uint64(prefixLength), []byte("garbage"))
+
+	forged := append([]byte{first[0]}, ciphertext...)
+	forged = append(forged, glue...)
+	forged = append(forged, "garbage"...)
+	forged = append(forged, forgedTag...)
+	if got, err := NewTransmissionOpener(key, nonce).Open(forged); err == nil {
+		t.Fatalf("opener released a length-extended chunk: %q", got)
+	}
+}
+
+func TestSecureChunkedTransmissionRoundTrip(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "sensor-1")
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+
+	payload := bytes.Repeat([]byte("log line\n"), 1000)
+	chunks, err := sc.SecureChunkedTransmission(device.DeviceID, payload, 512)
+	if err != nil {
+		t.Fatalf("SecureChunkedTransmission: %v", err)
+	}
+
+	open := func(chunks [][]byte) ([]byte, error) {
+		opener, err := sc.ReceiveChunkedTransmission(device.DeviceID)
+		if err != nil {
+			t.Fatalf("ReceiveChunkedTransmission: %v", err)
+		}
+		var released []byte
+		for _, chunk := range chunks {
+			plaintext, err := opener.Open(chunk)
+			if err != nil {
+				return released, err
+			}
+			released = append(released, plaintext...)
+		}
+		return released, opener.Close()
+	}
+	if got, err := open(chunks); err != nil || !bytes.Equal(got, payload) {
+		t.Fatalf("opened %d bytes, %v; want the %d-byte payload", len(got), err, len(payload))
+	}
+
+	chunks[0], chunks[1] = chunks[1], chunks[0]
+	if _, err := open(chunks); err == nil {
+		t.Error("reordered session chunks were accepted")
+	}
+}
+
+func TestTruncatedAuthenticationTags(t *testing.T) {
+	for _, length := range []int{MinAuthenticationTagSize, 12, DigestOutputSize} {
+		sc := NewSecurityController()
+		if err := sc.SetAuthenticationTagLength(length); err != nil {
+			t.Fatalf("SetAuthenticationTagLength(%d): %v", length, err)
+		}
+		device := newTestDevice(t, sc, "sensor-1")
+		device.TagLength = length
+		if err := device.Authenticate(sc); err != nil {
+			t.Fatalf("length %d: Authenticate: %v", length, err)
+		}
+
+		challenge := make([]byte, CompactBlockSize)
+		result, err := sc.Authenticate(device.DeviceID, challenge)
+		if err != nil {
+			t.Fatal(err)
+		}
+		deviceKey, _ := sc.keyManager.GetDeviceKey(device.DeviceID)
+		full := authenticationTag(sc.authDigest, deviceKey, challenge, result.Response)
+		if !bytes.Equal(result.AuthTag, full[:length]) {
+			t.Errorf("length %d: tag %x, want prefix of %x", length, result.AuthTag, full)
+		}
+		if err := sc.VerifyAuthentication(device.DeviceID, challenge, result.Bytes()); err != nil {
+			t.Errorf("length %d: truncated response rejected: %v", length, err)
+		}
+
+		// Traffic still flows once the truncated handshake completes
+		if err := device.Authenticate(sc); err != nil {
+			t.Fatal(err)
+		}
+		uplink, err := device.Encrypt([]byte("reading"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := sc.ReceiveDataTransmission(device.DeviceID, uplink); err != nil || string(got) != "reading" {
+			t.Errorf("length %d: received %q, %v", length, got, err)
+		}
+
+		// A device sending untruncated tags no longer matches
+		if length != DigestOutputSize {
+			device.TagLength = DigestOutputSize
+			if err := device.Authenticate(sc); err == nil {
+				t.Errorf("length %d: full-length tag accepted", length)
+			}
+		}
+	}
+
+	sc := NewSecurityController()
+	for _, length := range []int{-1, 0, MinAuthenticationTagSize - 1, DigestOutputSize + 1} {
+		if err := sc.SetAuthenticationTagLength(length); err == nil {
+			t.Errorf("SetAuthenticationTagLength(%d) accepted", length)
+		}
+	}
+	if sc.tagLength != DigestOutputSize {
+		t.Errorf("rejected lengths changed the tag length to %d", sc.tagLength)
+	}
+}
+
+func TestSessionSnapshotOmitsSecrets(t *testing.T) {
+	sc := NewSecurityController()
+	for _, deviceID := range []string{"sensor-2", "sensor-1"} {
+		if err := newTestDevice(t, sc, deviceID).Authenticate(sc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// No field of the snapshot can carry key material
+	snapshotType := reflect.TypeOf(SessionSnapshot{})
+	for i := 0; i < snapshotType.NumField(); i++ {
+		field := snapshotType.Field(i)
+		switch field.Type.Kind() {
+		case reflect.Slice, reflect.Array, reflect.Pointer, reflect.Interface, reflect.Map:
+			t.Errorf("SessionSnapshot.%s has type %v, which could share session state", field.Name, field.Type)
+		}
+	}
+
+	snapshot, ok := sc.SessionInfo("sensor-1")
+	if !ok {
+		t.Fatal("no session info for an authenticated device")
+	}
+	sc.sessionMutex.RLock()
+	session, _ := sc.latestSession("sensor-1")
+	sc.sessionMutex.RUnlock()
+	if snapshot.DeviceID != "sensor-1" || snapshot.SessionID != session.SessionID {
+		t.Errorf("snapshot %+v does not describe session %s", snapshot, session.SessionID)
+	}
+	rendered := fmt.Sprintf("%+v %#v", snapshot, snapshot)
+	for name, secret := range map[string][]byte{
+		"session key":        session.SessionKey,
+		"cipher key":         session.CipherKey,
+		"encryption state":   session.EncryptionState,
+		"authentication tag": session.AuthenticationTag,
+		"challenge":          session.Challenge,
+	} {
+		if strings.Contains(rendered, hex.EncodeToString(secret)) || strings.Contains(rendered, string(secret)) {
+			t.Errorf("snapshot exposes the %s: %s", name, rendered)
+		}
+	}
+
+	if got := sc.ActiveSessions(); !reflect.DeepEqual(got, []string{"sensor-1", "sensor-2"}) {
+		t.Errorf("ActiveSessions() = %q, want only the sorted device IDs", got)
+	}
+	if _, ok := sc.SessionInfo("sensor-3"); ok {
+		t.Error("session info for a device that never authenticated")
+	}
+}
+
+func TestSessionTickets(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1_700_000_000, 0))
+	sc := NewSecurityController()
+	sc.SetClock(clock)
+	device := newTestDevice(t, sc, "sensor-1")
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatal(err)
+	}
+	ticket, err := sc.IssueSessionTicket(device.DeviceID)
+	if err != nil {
+		t.Fatalf("IssueSessionTicket: %v", err)
+	}
+	if _, err := sc.IssueSessionTicket("sensor-2"); err == nil {
+		t.Error("issued a ticket for a device that never authenticated")
+	}
+
+	// A valid ticket restores the session's keys under a new session ID
+	original, _ := sc.SessionInfo(device.DeviceID)
+	deviceID, err := sc.ResumeSession(ticket)
+	if err != nil || deviceID != device.DeviceID {
+		t.Fatalf("ResumeSession = %q, %v", deviceID, err)
+	}
+	resumed, _ := sc.SessionInfo(device.DeviceID)
+	if resumed.SessionID == original.SessionID || !resumed.CreatedAt.Equal(original.CreatedAt) {
+		t.Errorf("resumed session %+v, original %+v", resumed, original)
+	}
+	uplink, err := device.Encrypt([]byte("after resume"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := sc.ReceiveDataTransmission(device.DeviceID, uplink); err != nil || string(got) != "after resume" {
+		t.Errorf("resumed session received %q, %v", got, err)
+	}
+
+	// Any modified byte, a truncation or appended bytes fail authentication
+	for i := range ticket {
+		tampered := append([]byte(nil), ticket...)
+		tampered[i] ^= 0x01
+		if _, err := sc.ResumeSession(tampered); err == nil {
+			t.Errorf("ticket with byte %d flipped was accepted", i)
+		}
+	}
+	for _, forged := range [][]byte{
+		ticket[:len(ticket)-1],
+		ticket[:ticketNonceSize],
+		append(append([]byte(nil), ticket...), 0),
+		append(append([]byte(nil), ticket...), ticket[ticketNonceSize:]...),
+	} {
+		if _, err := sc.ResumeSession(forged); err == nil {
+			t.Errorf("%d-byte ticket derived from a %d-byte one was accepted", len(forged), len(ticket))
+		}
+	}
+
+	// Authentic state with trailing bytes is malformed
+	nonce := ticket[:ticketNonceSize]
+	state, err := OpenTransmission(sc.ticketKey, nonce, ticket[ticketNonceSize:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	extended := append(append([]byte(nil), nonce...), SealTransmission(sc.ticketKey, nonce, append(state, 0))...)
+	if _, err := sc.ResumeSession(extended); err == nil {
+		t.Error("ticket with trailing state bytes was accepted")
+	}
+
+	// Another controller cannot open the ticket
+	if _, err := NewSecurityController().ResumeSession(ticket); err == nil {
+		t.Error("ticket opened under another controller's key")
+	}
+
+	// Tickets expire after the ticket lifetime
+	clock.Advance(DefaultTicketLifetime - time.Nanosecond)
+	if _, err := sc.ResumeSession(ticket); err != nil {
+		t.Errorf("ticket rejected just before expiry: %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := sc.ResumeSession(ticket); err == nil {
+		t.Error("expired ticket was accepted")
+	}
+}
+
+func TestSaltSeparatesDeployments(t *testing.T) {
+	salts := [][]byte{nil, []byte("deployment-a"), []byte("deployment-b"), []byte("deployment-a\x00")}
+	seen := make(map[string]int)
+	for i, salt := range salts {
+		key, err := newTestKeyManager(t, salt).GetDeviceKey("sensor-1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j, exists := seen[string(key)]; exists {
+			t.Errorf("salts %q and %q derive the same key", salts[j], salt)
+		}
+		seen[string(key)] = i
+	}
+
+	// The same salt reproduces the key, and the manager keeps its own copy
+	salt := []byte("deployment-a")
+	km := newTestKeyManager(t, salt)
+	salt[0] ^= 0xff
+	again, _ := km.GetDeviceKey("sensor-1")
+	first, _ := newTestKeyManager(t, []byte("deployment-a")).GetDeviceKey("sensor-1")
+	if !bytes.Equal(again, first) {
+		t.Error("same master key and salt derived different keys")
+	}
+
+	// An empty salt is the unsalted derivation
+	unsalted, _ := newTestKeyManager(t, nil).GetDeviceKey("sensor-1")
+	empty, _ := newTestKeyManager(t, []byte{}).GetDeviceKey("sensor-1")
+	if !bytes.Equal(unsalted, empty) {
+		t.Error("empty salt differs from no salt")
+	}
+
+	if key, err := NewKeyManagerWithSalt([]byte("deployment-a")).GetDeviceKey("sensor-1"); err != nil || len(key) != LightweightKeySize {
+		t.Errorf("NewKeyManagerWithSalt key = %x, %v", key, err)
+	}
+}
+
+func TestKMSKeyProviderDelegatesAndCaches(t *testing.T) {
+	calls := make(map[string]int)
+	failing := true
+	provider := &KMSKeyProvider{
+		KeyID: "arn:aws:kms:test",
+		Derive: func(keyID, deviceID string) ([]byte, error) {
+			calls[deviceID]++
+			if deviceID == "flaky" && failing {
+				return nil, errors.New("throttled")
+			}
+			if deviceID == "short" {
+				return []byte("short"), nil
+			}
+			return []byte(fmt.Sprintf("%s/%s/padding", keyID, deviceID)), nil
+		},
+	}
+	km := NewKeyManagerWithProvider(provider)
+	if km.masterKey != nil {
+		t.Error("provider-backed key manager holds a master key")
+	}
+
+	key, err := km.GetDeviceKey("sensor-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte("arn:aws:kms:test/sensor-1")[:LightweightKeySize]; !bytes.Equal(key, want) {
+		t.Errorf("key = %q, want the provider's key truncated to %q", key, want)
+	}
+	again, _ := km.GetDeviceKey("sensor-1")
+	if !bytes.Equal(again, key) || calls["sensor-1"] != 1 {
+		t.Errorf("second lookup made %d provider calls, want the cached key", calls["sensor-1"])
+	}
+	if _, err := km.GetDeviceKey("sensor-2"); err != nil || calls["sensor-2"] != 1 {
+		t.Errorf("new device: %v after %d calls", err, calls["sensor-2"])
+	}
+
+	// Failures surface and are not cached
+	if _, err := km.GetDeviceKey("flaky"); err == nil || !strings.Contains(err.Error(), "throttled") {
+		t.Errorf("provider failure: %v", err)
+	}
+	failing = false
+	if _, err := km.GetDeviceKey("flaky"); err != nil || calls["flaky"] != 2 {
+		t.Errorf("retry after failure: %v after %d calls", err, calls["flaky"])
+	}
+	if _, err := km.GetDeviceKey("short"); err == nil {
+		t.Error("short provider key accepted")
+	}
+
+	unconfigured := NewKeyManagerWithProvider(&KMSKeyProvider{KeyID: "missing"})
+	if _, err := unconfigured.GetDeviceKey("sensor-1"); err == nil {
+		t.Error("provider without a client derived a key")
+	}
+}
+
+func TestVerifyBatchReportsEachItem(t *testing.T) {
+	sc := NewSecurityController()
+	sessionKeys := make(map[string][]byte)
+	for _, deviceID := range []string{"sensor-1", "sensor-2"} {
+		if err := newTestDevice(t, sc, deviceID).Authenticate(sc); err != nil {
+			t.Fatal(err)
+		}
+		sc.sessionMutex.RLock()
+		session, _ := sc.latestSession(deviceID)
+		sc.sessionMutex.RUnlock()
+		sessionKeys[deviceID] = session.SessionKey
+	}
+	seal := func(deviceID string, n byte) TransmissionItem {
+		nonce := []byte{n, 0, 0, 0, 0, 0, 0, 0}
+		return TransmissionItem{
+			DeviceID: deviceID,
+			Nonce:    nonce,
+			Sealed:   SealTransmission(sessionKeys[deviceID], nonce, []byte(fmt.Sprintf("reading %d", n))),
+		}
+	}
+
+	items := make([]TransmissionItem, 0, 40)
+	var want []bool
+	for i := 0; i < 36; i++ {
+		deviceID := []string{"sensor-1", "sensor-2"}[i%2]
+		item := seal(deviceID, byte(i))
+		valid := true
+		switch i % 6 {
+		case 1:
+			item.Sealed[len(item.Sealed)/2] ^= 0x01
+			valid = false
+		case 3:
+			item.Nonce = []byte{byte(i + 1), 0, 0, 0, 0, 0, 0, 0}
+			valid = false
+		case 5:
+			// Sealed under the other device's session key
+			item.DeviceID = []string{"sensor-2", "sensor-1"}[i%2]
+			valid = false
+		}
+		items = append(items, item)
+		want = append(want, valid)
+	}
+
+	got, err := sc.VerifyBatch(items)
+	if err != nil {
+		t.Fatalf("VerifyBatch: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VerifyBatch = %v, want %v", got, want)
+	}
+
+	// Unknown devices report false and are named in the error
+	items = append(items, TransmissionItem{DeviceID: "sensor-9", Nonce: items[0].Nonce, Sealed: items[0].Sealed})
+	want = append(want, false)
+	got, err = sc.VerifyBatch(items)
+	if err == nil || !strings.Contains(err.Error(), "sensor-9") {
+		t.Errorf("unknown device: err = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("VerifyBatch with an unknown device = %v, want %v", got, want)
+	}
+
+	if got, err := sc.VerifyBatch(nil); err != nil || len(got) != 0 {
+		t.Errorf("empty batch = %v, %v", got, err)
+	}
+}
+
+func TestVerifyAuthResponse(t *testing.T) {
+	sc := NewSecurityController()
+	challenge := []byte("challnge")
+	if sc.VerifyAuthResponse("sensor-1", make([]byte, CompactBlockSize+DigestOutputSize)) {
+		t.Fatal("verified a response for a device without a session")
+	}
+
+	response, err := sc.AuthenticateDevice("sensor-1", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sc.VerifyAuthResponse("sensor-1", response) {
+		t.Error("the session's own response did not verify")
+	}
+
+	device := newTestDevice(t, sc, "sensor-1")
+	if deviceResponse, _ := device.RespondToChallenge(challenge); !sc.VerifyAuthResponse("sensor-1", deviceResponse) {
+		t.Error("the device's answer to the session challenge did not verify")
+	}
+
+	for i := range response {
+		tampered := append([]byte(nil), response...)
+		tampered[i] ^= 0x80
+		if sc.VerifyAuthResponse("sensor-1", tampered) {
+			t.Errorf("response with byte %d flipped verified", i)
+		}
+	}
+	if sc.VerifyAuthResponse("sensor-1", response[:len(response)-1]) {
+		t.Error("truncated response verified")
+	}
+
+	// A response to another challenge, or from another device, does not match
+	other, _ := sc.AuthenticateDevice("sensor-2", []byte("another!"))
+	if sc.VerifyAuthResponse("sensor-1", other) {
+		t.Error("another device's response verified")
+	}
+	stale := response
+	if _, err := sc.AuthenticateDevice("sensor-1", []byte("freshone")); err != nil {
+		t.Fatal(err)
+	}
+	if sc.VerifyAuthResponse("sensor-1", stale) {
+		t.Error("response to a superseded challenge verified")
+	}
+}
+
+func TestSessionPayloadTagRejectsLengthExtension(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "sensor-1")
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatal(err)
+	}
+	extend := func(body []byte, prefixLength int) []byte {
+		ciphertext := body[:len(body)-TransmissionTagSize]
+		glue, forgedTag := extendDigest(body[len(body)-TransmissionTagSize:], uint64(prefixLength+len(ciphertext)), []byte("garbage"))
+		forged := append([]byte(nil), ciphertext...)
+		forged = append(forged, glue...)
+		forged = append(forged, "garbage"...)
+		return append(forged, forgedTag...)
+	}
+	appendByte := func(body []byte) []byte {
+		appended := append([]byte(nil), body[:len(body)-TransmissionTagSize]...)
+		appended = append(appended, 'x')
+		return append(appended, body[len(body)-TransmissionTagSize:]...)
+	}
+
+	// Extend the ciphertext, as against the old label || key || nonce || ciphertext digest
+	uplink, err := device.Encrypt([]byte("temp=25"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefixLength := len(payloadTagLabel) + len(device.sessionKey) + len(device.nonce)
+	for name, forged := range map[string][]byte{
+		"length-extended": extend(uplink, prefixLength),
+		"appended":        appendByte(uplink),
+	} {
+		if got, err := sc.ReceiveDataTransmission(device.DeviceID, forged); err == nil {
+			t.Errorf("controller accepted a %s payload: %q", name, got)
+		}
+	}
+
+	// The same against a header-carrying payload, whose old digest also covered
+	// the header length and header
+	header := []byte("type=thermo")
+	uplink, err = device.EncryptWithHeader(header, []byte("temp=25"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	framing := uplink[:2+len(header)]
+	body := uplink[2+len(header):]
+	prefixLength = len(headerPayloadTagLabel) + len(device.sessionKey) + len(device.nonce) + 2 + len(header)
+	for name, forged := range map[string][]byte{
+		"length-extended": append(append([]byte(nil), framing...), extend(body, prefixLength)...),
+		"appended":        append(append([]byte(nil), framing...), appendByte(body)...),
+	} {
+		if _, got, err := sc.ReceiveDataTransmissionWithHeader(device.DeviceID, forged); err == nil {
+			t.Errorf("controller accepted a %s header payload: %q", name, got)
+		}
+	}
+
+	if got, err := sc.ReceiveDataTransmission(device.DeviceID, uplink[2+len(header):]); err == nil {
+		t.Errorf("controller accepted a header payload re-framed without its header: %q", got)
+	}
+}
+
+func TestChallengeAndTicketExpiryFollowClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1_700_000_000, 0))
+	sc := NewSecurityController()
+	sc.SetClock(clock)
+	device := newTestDevice(t, sc, "sensor-1")
+
+	// A challenge answered exactly at its lifetime is accepted
+	challengeID, challenge, err := sc.IssueChallenge(device.DeviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, err := device.RespondToChallenge(challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(DefaultChallengeLifetime)
+	if err := sc.CompleteChallenge(challengeID, response); err != nil {
+		t.Fatalf("challenge answered at its lifetime: %v", err)
+	}
+
+	// One past it is expired, and the expired challenge cannot be retried
+	challengeID, challenge, err = sc.IssueChallenge(device.DeviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response, _ = device.RespondToChallenge(challenge)
+	clock.Advance(DefaultChallengeLifetime + time.Nanosecond)
+	if err := sc.CompleteChallenge(challengeID, response); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("late answer: err = %v, want challenge expired", err)
+	}
+	if err := sc.CompleteChallenge(challengeID, response); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("retrying an expired challenge: err = %v, want unknown challenge", err)
+	}
+
+	// Tickets expire after the configured lifetime
+	if err := sc.SetTicketLifetime(10 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	ticket, err := sc.IssueSessionTicket(device.DeviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(10*time.Minute - time.Nanosecond)
+	if _, err := sc.ResumeSession(ticket); err != nil {
+		t.Fatalf("ticket rejected before its lifetime: %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := sc.ResumeSession(ticket); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("ticket at its lifetime: err = %v, want expired", err)
+	}
+	if err := sc.SetTicketLifetime(0); err == nil {
+		t.Error("zero ticket lifetime accepted")
+	}
+}
+
+func TestMasterKeyEntropyCheck(t *testing.T) {
+	// A stuck RNG is drawn from MaxDraws times and then rejected
+	zeros := bytes.NewReader(make([]byte, 10*16))
+	if _, err := NewKeyManagerFromSource(zeros, nil, DefaultMasterKeyPolicy); !errors.Is(err, ErrLowEntropyKey) {
+		t.Fatalf("all-zero source: err = %v, want ErrLowEntropyKey", err)
+	}
+	if drawn := 10*16 - zeros.Len(); drawn != DefaultMasterKeyPolicy.MaxDraws*16 {
+		t.Errorf("drew %d bytes, want %d draws of 16", drawn, DefaultMasterKeyPolicy.MaxDraws)
+	}
+
+	// A short cycle has too few distinct bytes too
+	cycle := bytes.Repeat([]byte("abcd"), 4*DefaultMasterKeyPolicy.MaxDraws)
+	if _, err := NewKeyManagerFromSource(bytes.NewReader(cycle), nil, DefaultMasterKeyPolicy); !errors.Is(err, ErrLowEntropyKey) {
+		t.Errorf("repeating source: err = %v, want ErrLowEntropyKey", err)
+	}
+
+	// A bad draw followed by a good one succeeds with the good key
+	source := io.MultiReader(bytes.NewReader(make([]byte, 16)), bytes.NewReader(testMasterKey))
+	km, err := NewKeyManagerFromSource(source, nil, DefaultMasterKeyPolicy)
+	if err != nil {
+		t.Fatalf("redraw after a zero key: %v", err)
+	}
+	if !bytes.Equal(km.masterKey, testMasterKey) {
+		t.Errorf("master key = %x, want the redrawn %x", km.masterKey, testMasterKey)
+	}
+	got, _ := km.GetDeviceKey("sensor-1")
+	want, _ := newTestKeyManager(t, nil).GetDeviceKey("sensor-1")
+	if !bytes.Equal(got, want) {
+		t.Error("redrawn master key derives different device keys")
+	}
+
+	// A non-positive MaxDraws still draws once
+	single := bytes.NewReader(make([]byte, 32))
+	if _, err := NewKeyManagerFromSource(single, nil, MasterKeyPolicy{MinDistinctBytes: 8}); !errors.Is(err, ErrLowEntropyKey) || single.Len() != 16 {
+		t.Errorf("MaxDraws 0: err = %v with %d bytes left, want one rejected draw", err, single.Len())
+	}
+
+	// Read failures are reported as such, not as low entropy
+	if _, err := NewKeyManagerFromSource(bytes.NewReader(make([]byte, 8)), nil, DefaultMasterKeyPolicy); err == nil || errors.Is(err, ErrLowEntropyKey) {
+		t.Errorf("short source: err = %v, want a read error", err)
+	}
+}
+
+func TestSessionKeysAreIndependent(t *testing.T) {
+	deviceKey := []byte("device-key")
+	challenge := []byte("challnge")
+	response := []byte("response")
+
+	cipherKey, stream := deriveSessionKeys(deviceKey, challenge, response, DefaultSessionKeyLength)
+	if len(cipherKey) != LightweightKeySize || len(stream) != DefaultSessionKeyLength {
+		t.Fatalf("key lengths %d and %d, want %d and %d", len(cipherKey), len(stream), LightweightKeySize, DefaultSessionKeyLength)
+	}
+	if bytes.Equal(cipherKey, stream[:LightweightKeySize]) || bytes.Contains(stream, cipherKey) {
+		t.Error("the stream key contains the cipher key")
+	}
+	if bytes.Contains(stream, deviceKey) || bytes.Equal(cipherKey, deviceKey) {
+		t.Error("a session key reuses the device key")
+	}
+
+	// Both come from the session secret, each under its own label
+	secret := deriveSessionSecret(deviceKey, challenge, response)
+	if !bytes.Equal(cipherKey, expandSessionSecret(secret, sessionCipherKeyLabel, LightweightKeySize)) ||
+		!bytes.Equal(stream, expandSessionSecret(secret, sessionStreamKeyLabel, DefaultSessionKeyLength)) {
+		t.Error("session keys are not the labelled expansions of the session secret")
+	}
+
+	// Every input changes both keys
+	for name, inputs := range map[string][3][]byte{
+		"device key": {[]byte("device-kez"), challenge, response},
+		"challenge":  {deviceKey, []byte("challngf"), response},
+		"response":   {deviceKey, challenge, []byte("responsf")},
+	} {
+		otherCipher, otherStream := deriveSessionKeys(inputs[0], inputs[1], inputs[2], DefaultSessionKeyLength)
+		if bytes.Equal(otherCipher, cipherKey) || bytes.Equal(otherStream, stream) {
+			t.Errorf("changing the %s left a session key unchanged", name)
+		}
+	}
+
+	// The cipher key does not depend on the stream key length
+	for _, length := range []int{MinSessionKeyLength, 24, SessionSecretSize} {
+		otherCipher, otherStream := deriveSessionKeys(deviceKey, challenge, response, length)
+		if !bytes.Equal(otherCipher, cipherKey) || len(otherStream) != length {
+			t.Errorf("length %d: cipher key %x, %d-byte stream key", length, otherCipher, len(otherStream))
+		}
+	}
+}
+
+func TestSessionKeyLengthBounds(t *testing.T) {
+	sc := NewSecurityController()
+	for _, length := range []int{0, MinSessionKeyLength - 1, SessionSecretSize + 1} {
+		if err := sc.SetSessionKeyLength(length); err == nil {
+			t.Errorf("SetSessionKeyLength(%d) accepted", length)
+		}
+	}
+	if sc.sessionKeyLength != DefaultSessionKeyLength {
+		t.Errorf("rejected lengths changed the session key length to %d", sc.sessionKeyLength)
+	}
+
+	for _, length := range []int{MinSessionKeyLength, 24, SessionSecretSize} {
+		if err := sc.SetSessionKeyLength(length); err != nil {
+			t.Fatalf("SetSessionKeyLength(%d): %v", length, err)
+		}
+		device := newTestDevice(t, sc, "sensor-1")
+		device.SessionKeyLength = length
+		if err := device.Authenticate(sc); err != nil {
+			t.Fatal(err)
+		}
+		sc.sessionMutex.RLock()
+		session, _ := sc.latestSession(device.DeviceID)
+		sc.sessionMutex.RUnlock()
+		if len(session.SessionKey) != length || !bytes.Equal(session.SessionKey, device.sessionKey) {
+			t.Errorf("length %d: controller key %x, device key %x", length, session.SessionKey, device.sessionKey)
+		}
+		uplink, err := device.Encrypt([]byte("reading"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, err := sc.ReceiveDataTransmission(device.DeviceID, uplink); err != nil || string(got) != "reading" {
+			t.Errorf("length %d: received %q, %v", length, got, err)
+		}
+	}
+
+	device := newTestDevice(t, sc, "sensor-1")
+	device.SessionKeyLength = MinSessionKeyLength - 1
+	if err := device.Authenticate(sc); err == nil {
+		t.Error("device accepted a session key length below the minimum")
+	}
+}
+
+func TestSessionPayloadsDoNotShareKeystream(t *testing.T) {
+	sc := NewSecurityController()
+	device := newTestDevice(t, sc, "sensor-1")
+	if err := device.Authenticate(sc); err != nil {
+		t.Fatal(err)
+	}
+
+	// Equal plaintexts in the same session: with a shared keystream the
+	// ciphertexts would be equal, and XORing two payloads would cancel it
+	plaintext := bytes.Repeat([]byte("reading="), 8)
+	other := bytes.Repeat([]byte("READING="), 8)
+	body := func(sealed []byte) []byte {
+		return sealed[MessageNonceSize : len(sealed)-TransmissionTagSize]
+	}
+	xor := func(a, b []byte) []byte {
+		out := make([]byte, len(a))
+		for i := range a {
+			out[i] = a[i] ^ b[i]
+		}
+		return out
+	}
+
+	for name, seal := range map[string]func([]byte) ([]byte, error){
+		"controller": func(data []byte) ([]byte, error) { return sc.SecureDataTransmission(device.DeviceID, data) },
+		"device":     device.Encrypt,
+	} {
+		first, err := seal(plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := seal(other)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(first[:MessageNonceSize], second[:MessageNonceSize]) {
+			t.Errorf("%s: two payloads carry the same message nonce", name)
+		}
+		if bytes.Equal(xor(body(first), body(second)), xor(plaintext, other)) {
+			t.Errorf("%s: two payloads share keystream", name)
+		}
+	}
+
+	// Chunked transmissions carry their message nonce ahead of the first chunk
+	first, err := sc.SecureChunkedTransmission(device.DeviceID, plaintext, len(plaintext))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := sc.SecureChunkedTransmission(device.DeviceID, other, len(other))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunkBody := func(chunks [][]byte) []byte {
+		return chunks[0][MessageNonceSize+1 : len(chunks[0])-TransmissionTagSize]
+	}
+	if bytes.Equal(xor(chunkBody(first), chunkBody(second)), xor(plaintext, other)) {
+		t.Error("two chunked transmissions share keystream")
+	}
+	opener, err := sc.ReceiveChunkedTransmission(device.DeviceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first[0][0] ^= 1
+	if got, err := opener.Open(first[0]); err == nil {
+		t.Errorf("opener accepted a chunk with a changed message nonce: %q", got)
+	}
+
+	// A payload opens once; its replay and a changed message nonce are rejected
+	uplink, err := device.Encrypt(plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), uplink...)
+	tampered[0] ^= 1
+	if got, err := sc.ReceiveDataTransmission(device.DeviceID, tampered); err == nil {
+		t.Errorf("controller accepted a payload with a changed message nonce: %q", got)
+	}
+	if got, err := sc.ReceiveDataTransmission(device.DeviceID, uplink); err != nil || !bytes.Equal(got, plaintext) {
+		t.Fatalf("ReceiveDataTransmission = %q, %v", got, err)
+	}
+	if _, err := sc.ReceiveDataTransmission(device.DeviceID, uplink); err == nil {
+		t.Error("controller accepted a replayed payload")
+	}
+}
@@ -1,10 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/cipher"
 	"crypto/rand"
+	"crypto/rsa"
 	"crypto/hash_256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
+	"log"
+	"math"
 	"math/big"
+	"math/bits"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
@@ -25,6 +47,11 @@ const (
 	EnterpriseSecurity
 )
 
+// MinimumSecurity runs the digest stage only, for pass-through integrity. It sits
+// below StandardSecurity rather than at zero so an unset level keeps the standard
+// pipeline.
+const MinimumSecurity TransactionSecurityLevel = -1
+
 // MathematicalOperation represents different types of mathematical computations
 type MathematicalOperation int
 
@@ -35,8 +62,22 @@ const (
 	DigestComputationProcessing
 	KoreanMathematicalProcessing
 	RegionalComputationalProcessing
+	FormatPreservingEncryption
+	AESKeyWrap
+	MultiRecipientEncryption
+
+	builtinOperationCount // must stay last; new operations go above it
 )
 
+// String returns the name of a built-in operation; processors report the names
+// of their custom operations in OperationInfo
+func (op MathematicalOperation) String() string {
+	if metadata, exists := builtinOperations[op]; exists {
+		return metadata.name
+	}
+	return fmt.Sprintf("MathematicalOperation(%d)", int(op))
+}
+
 // TransactionContext holds the context for transaction processing
 type TransactionContext struct {
 	TransactionID       string
@@ -45,23 +86,253 @@ type TransactionContext struct {
 	RequiredOperations  []MathematicalOperation
 	ProcessingTimestamp time.Time
 	ComplianceRequirements []string
+	KeyID                  string // KeyStore key that signs modular arithmetic stages; empty generates a fresh modulus
+	SkipIntegrityDigest    bool   // omit the trailing digest stage for callers that protect integrity themselves
 }
 
 // ProcessingResult contains the results of transaction processing
 type ProcessingResult struct {
+	TransactionID       string // the context's ID, or the one generated when it had none
 	ProcessedData       []byte
 	ProcessingTime      time.Duration
 	SecurityMetrics     map[string]interface{}
+	Metrics             SecurityMetrics
 	ComplianceStatus    map[string]bool
 	OperationResults    []OperationResult
 }
 
+// SecurityMetrics is the typed form of ProcessingResult.SecurityMetrics
+type SecurityMetrics struct {
+	AsymmetricOps int
+	SymmetricOps  int
+	HashOps       int
+	KoreanOps     int
+	TotalOps      int
+	CategoryOps   map[string]int // operation count for every category, including the above
+}
+
+// Map returns the metrics keyed as in ProcessingResult.SecurityMetrics
+func (m SecurityMetrics) Map() map[string]interface{} {
+	metrics := make(map[string]interface{}, len(m.CategoryOps)+5)
+	for category, count := range m.CategoryOps {
+		metrics[category+"_operations"] = count
+	}
+
+	metrics["asymmetric_operations"] = m.AsymmetricOps
+	metrics["symmetric_operations"] = m.SymmetricOps
+	metrics["hash_operations"] = m.HashOps
+	metrics["korean_operations"] = m.KoreanOps
+	metrics["total_operations"] = m.TotalOps
+
+	return metrics
+}
+
+// securityMetricsFromMap rebuilds typed metrics from the map form
+func securityMetricsFromMap(metrics map[string]interface{}) SecurityMetrics {
+	get := func(key string) int {
+		value, _ := metrics[key].(int)
+		return value
+	}
+
+	categoryOps := make(map[string]int)
+	for key := range metrics {
+		if key != "total_operations" && strings.HasSuffix(key, "_operations") {
+			categoryOps[strings.TrimSuffix(key, "_operations")] = get(key)
+		}
+	}
+
+	return SecurityMetrics{
+		AsymmetricOps: get("asymmetric_operations"),
+		SymmetricOps:  get("symmetric_operations"),
+		HashOps:       get("hash_operations"),
+		KoreanOps:     get("korean_operations"),
+		TotalOps:      get("total_operations"),
+		CategoryOps:   categoryOps,
+	}
+}
+
+// Operation categories counted in security metrics
+const (
+	CategoryAsymmetric = "asymmetric"
+	CategorySymmetric  = "symmetric"
+	CategoryHash       = "hash"
+	CategoryKorean     = "korean"
+)
+
+// operationProperties holds the static properties of a mathematical operation
+type operationProperties struct {
+	name                 string
+	complexity           string
+	quantumVulnerability string
+	categories           []string // metric categories the operation counts towards
+	handlesSecrets       bool     // the stage uses key material, not just public data
+}
+
+// builtinOperations is the single source of truth for the built-in operations'
+// properties. It is never modified; custom operations are registered per processor.
+var builtinOperations = map[MathematicalOperation]operationProperties{
+	LargeIntegerArithmetic: {
+		name:                 "LargeIntegerArithmetic",
+		complexity:           "exponential",
+		quantumVulnerability: "high",
+		categories:           []string{CategoryAsymmetric},
+		handlesSecrets:       true,
+	},
+	PolynomialFieldComputation: {
+		name:                 "PolynomialFieldComputation",
+		complexity:           "exponential",
+		quantumVulnerability: "high",
+		categories:           []string{CategoryAsymmetric},
+		handlesSecrets:       true,
+	},
+	MatrixLinearTransformation: {
+		name:                 "MatrixLinearTransformation",
+		complexity:           "linear",
+		quantumVulnerability: "medium",
+		categories:           []string{CategorySymmetric},
+		handlesSecrets:       true,
+	},
+	DigestComputationProcessing: {
+		name:                 "DigestComputationProcessing",
+		complexity:           "linear",
+		quantumVulnerability: "medium",
+		categories:           []string{CategoryHash},
+		handlesSecrets:       true, // the authentication half is keyed by a fresh random key
+	},
+	KoreanMathematicalProcessing: {
+		name:                 "KoreanMathematicalProcessing",
+		complexity:           "linear",
+		quantumVulnerability: "medium",
+		categories:           []string{CategorySymmetric, CategoryKorean},
+		handlesSecrets:       true,
+	},
+	RegionalComputationalProcessing: {
+		name:                 "RegionalComputationalProcessing",
+		complexity:           "linear",
+		quantumVulnerability: "medium",
+		categories:           []string{CategorySymmetric, CategoryKorean},
+		handlesSecrets:       true,
+	},
+	FormatPreservingEncryption: {
+		name:                 "FormatPreservingEncryption",
+		complexity:           "quadratic",
+		quantumVulnerability: "medium",
+		categories:           []string{CategorySymmetric},
+		handlesSecrets:       true,
+	},
+	AESKeyWrap: {
+		name:                 "AESKeyWrap",
+		complexity:           "linear",
+		quantumVulnerability: "medium",
+		categories:           []string{CategorySymmetric},
+		handlesSecrets:       true,
+	},
+	MultiRecipientEncryption: {
+		name:                 "MultiRecipientEncryption",
+		complexity:           "exponential",
+		quantumVulnerability: "high",
+		categories:           []string{CategoryAsymmetric, CategorySymmetric},
+		handlesSecrets:       true,
+	},
+}
+
+// OperationMeta describes a custom operation registered with WithOperation
+type OperationMeta struct {
+	Name                    string
+	ComputationalComplexity string
+	QuantumVulnerability    string
+	Categories              []string
+	HandlesSecrets          bool // the handler uses key material; reported in OperationResult.HandledSecrets
+}
+
+// ErrBuiltinOperation is the panic value when a custom operation would replace a built-in one
+var ErrBuiltinOperation = errors.New("built-in operations cannot be replaced")
+
+// customOperation is a caller-registered operation and its metadata
+type customOperation struct {
+	properties operationProperties
+	handler    func([]byte) ([]byte, error)
+}
+
+// WithOperation registers handler for a custom operation on this processor only,
+// along with its metadata. Custom operations run when listed in
+// TransactionContext.RequiredOperations and count towards metrics through
+// meta.Categories. Registering a built-in operation, or a nil handler, is a
+// programming error and panics with ErrBuiltinOperation.
+func WithOperation(operation MathematicalOperation, handler func([]byte) ([]byte, error), meta OperationMeta) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		if isBuiltinOperation(operation) {
+			panic(fmt.Errorf("%w: %v", ErrBuiltinOperation, operation))
+		}
+		if handler == nil {
+			panic(fmt.Sprintf("nil handler for operation %d", int(operation)))
+		}
+
+		properties := operationProperties{
+			name:                 meta.Name,
+			complexity:           meta.ComputationalComplexity,
+			quantumVulnerability: meta.QuantumVulnerability,
+			categories:           append([]string(nil), meta.Categories...),
+			handlesSecrets:       meta.HandlesSecrets,
+		}
+		if properties.name == "" {
+			properties.name = operation.String()
+		}
+		if properties.complexity == "" {
+			properties.complexity = "unknown"
+		}
+		if properties.quantumVulnerability == "" {
+			properties.quantumVulnerability = "unknown"
+		}
+
+		stp.customOperations[operation] = customOperation{properties: properties, handler: handler}
+	}
+}
+
+// lookupOperationHandler returns the custom handler registered for operation, if any
+func (stp *SecureTransactionProcessor) lookupOperationHandler(operation MathematicalOperation) (func([]byte) ([]byte, error), bool) {
+	custom, exists := stp.customOperations[operation]
+	return custom.handler, exists
+}
+
+// registeredOperations returns every built-in and custom operation, in ascending order
+func (stp *SecureTransactionProcessor) registeredOperations() []MathematicalOperation {
+	operations := make([]MathematicalOperation, 0, len(builtinOperations)+len(stp.customOperations))
+	for operation := range builtinOperations {
+		operations = append(operations, operation)
+	}
+	for operation := range stp.customOperations {
+		operations = append(operations, operation)
+	}
+
+	sort.Slice(operations, func(i, j int) bool { return operations[i] < operations[j] })
+	return operations
+}
+
+// isBuiltinOperation reports whether operation is one of the built-in operations
+func isBuiltinOperation(operation MathematicalOperation) bool {
+	return operation >= LargeIntegerArithmetic && operation < builtinOperationCount
+}
+
+// lookupOperation returns the metadata of a built-in or custom operation. Callers
+// must not modify the returned categories.
+func (stp *SecureTransactionProcessor) lookupOperation(operation MathematicalOperation) (operationProperties, bool) {
+	if metadata, exists := builtinOperations[operation]; exists {
+		return metadata, true
+	}
+	custom, exists := stp.customOperations[operation]
+	return custom.properties, exists
+}
+
 // OperationResult represents the result of a single mathematical operation
 type OperationResult struct {
 	Operation       MathematicalOperation
 	ExecutionTime   time.Duration
 	ComputationalComplexity string
 	QuantumVulnerability   string
+	InputLength             int    // stage input size, needed to strip block padding on reversal
+	KeyMaterial             []byte // stage key for reversible operations; empty unless retained
+	HandledSecrets          bool   // the stage used key material, per its operation metadata
 }
 
 // SecureTransactionProcessor is the main processor for secure transactions
@@ -72,855 +343,5801 @@ type SecureTransactionProcessor struct {
 	digestCalculator        *DigestComputationEngine
 	koreanMathProcessor     *KoreanMathematicalProcessor
 	regionalProcessor       *RegionalComputationalProcessor
+	fpeProcessor            *FPEProcessor
+	keyStore                *KeyStore
+	keyWrapKEK              []byte // key-encryption key for AESKeyWrap stages; nil until configured
+	recipients              []crypto.PublicKey // MultiRecipientEncryption recipients; nil until configured
+	recipientKey            crypto.PrivateKey  // reverses MultiRecipientEncryption stages; nil until configured
+	customOperations        map[MathematicalOperation]customOperation
 
 	processingPool          *sync.Pool
+	bufferLimit             int
+	bufferSlots             *ConcurrencyLimiter
+	backpressure            BackpressurePolicy
 	concurrencyLimit        int
 	performanceMonitor      *PerformanceMonitor
+	auditSink               AuditSink
+	wal                     WALSink
+	riskThreshold           string
+	retainKeys              bool
+	limiter                 *ConcurrencyLimiter
+	failFast                bool
+
+	breakerThreshold        int
+	breakerCooldown         time.Duration
+	breakersMutex           sync.Mutex
+	breakers                map[MathematicalOperation]*circuitBreaker
+
+	allowedOperations       map[MathematicalOperation]bool // nil allows every operation
+	strictCompliance        bool
+	complianceMapping       ComplianceMapping
+	complianceSeverity      map[string]ComplianceSeverity // missing requirements are advisory
+	clock                   Clock
+	maxPipelineLength       int // 0 disables the limit
+	pipelineOrdering        PipelineOrderingPolicy
 }
 
-// NewSecureTransactionProcessor creates a new instance of the processor
-func NewSecureTransactionProcessor() *SecureTransactionProcessor {
-	return &SecureTransactionProcessor{
-		largeNumberProcessor:   NewLargeNumberProcessor(),
-		polynomialComputer:    NewPolynomialFieldComputer(),
-		matrixTransformer:     NewMatrixTransformationEngine(),
-		digestCalculator:      NewDigestComputationEngine(),
-		koreanMathProcessor:   NewKoreanMathematicalProcessor(),
-		regionalProcessor:     NewRegionalComputationalProcessor(),
-		concurrencyLimit:      10,
-		performanceMonitor:    NewPerformanceMonitor(),
-		processingPool: &sync.Pool{
-			New: func() interface{} {
-				return make([]byte, 4096)
-			},
-		},
-	}
+// Clock supplies wall-clock time for timestamps, audit records and breaker cooldowns.
+// Operation timings always use the real clock.
+type Clock interface {
+	Now() time.Time
 }
 
-// ProcessSecureTransaction processes a transaction with specified security requirements
-func (stp *SecureTransactionProcessor) ProcessSecureTransaction(ctx *TransactionContext) (*ProcessingResult, error) {
-	startTime := time.Now()
+// systemClock reads the real time
+type systemClock struct{}
 
-	result := &ProcessingResult{
-		SecurityMetrics:     make(map[string]interface{}),
-		ComplianceStatus:    make(map[string]bool),
-		OperationResults:    make([]OperationResult, 0),
-	}
+// Now returns the current time
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
 
-	// Build processing pipeline based on security level
-	pipeline := stp.buildProcessingPipeline(ctx)
+// FakeClock is a manually advanced Clock for tests
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
 
-	// Execute processing pipeline
-	processedData := ctx.Data
-	var err error
+// NewFakeClock creates a fake clock stopped at start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
 
-	for _, operation := range pipeline {
-		operationStart := time.Now()
+// Now returns the fake clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
 
-		processedData, err = stp.executeOperation(operation, processedData)
-		if err != nil {
-			return nil, fmt.Errorf("operation %v failed: %w", operation, err)
-		}
+// Advance moves the fake clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	c.mutex.Unlock()
+}
 
-		operationTime := time.Since(operationStart)
+// ComplianceMapping maps a compliance requirement to the operations planned to satisfy it
+type ComplianceMapping map[string][]MathematicalOperation
 
-		operationResult := OperationResult{
-			Operation:               operation,
-			ExecutionTime:          operationTime,
-			ComputationalComplexity: stp.getComputationalComplexity(operation),
-			QuantumVulnerability:   stp.getQuantumVulnerability(operation),
-		}
+// DefaultComplianceMapping returns the built-in compliance mapping
+func DefaultComplianceMapping() ComplianceMapping {
+	return ComplianceMapping{
+		"korean_standards":    {KoreanMathematicalProcessing, RegionalComputationalProcessing},
+		"format_preservation": {FormatPreservingEncryption},
+		"key_transport":       {AESKeyWrap},
+		"multi_recipient":     {MultiRecipientEncryption},
+	}
+}
 
-		result.OperationResults = append(result.OperationResults, operationResult)
+// copy returns a deep copy so callers can't mutate a processor's mapping
+func (m ComplianceMapping) copy() ComplianceMapping {
+	copied := make(ComplianceMapping, len(m))
+	for requirement, operations := range m {
+		copied[requirement] = append([]MathematicalOperation(nil), operations...)
 	}
+	return copied
+}
 
-	result.ProcessedData = processedData
-	result.ProcessingTime = time.Since(startTime)
-	result.SecurityMetrics = stp.calculateSecurityMetrics(pipeline)
-	result.ComplianceStatus = stp.validateCompliance(ctx, result)
+// ProcessorOption configures optional processor behaviour
+type ProcessorOption func(*SecureTransactionProcessor)
 
-	return result, nil
-}
+// PipelineOrderingPolicy decides where the security-level asymmetric stages
+// (modular arithmetic and field computation) run relative to the symmetric ones.
+// The digest stage always runs last.
+type PipelineOrderingPolicy int
 
-// buildProcessingPipeline constructs the optimal processing pipeline
-func (stp *SecureTransactionProcessor) buildProcessingPipeline(ctx *TransactionContext) []MathematicalOperation {
-	var pipeline []MathematicalOperation
+const (
+	// AsymmetricFirst runs the asymmetric stages before everything else,
+	// wrap-then-encrypt: asymmetric, matrix, compliance, custom, digest
+	AsymmetricFirst PipelineOrderingPolicy = iota
+	// SymmetricFirst runs them after every other stage, encrypt-then-wrap:
+	// matrix, compliance, custom, asymmetric, digest
+	SymmetricFirst
+)
 
-	// Add operations based on security level
-	if ctx.SecurityLevel >= EnhancedSecurity {
-		pipeline = append(pipeline, LargeIntegerArithmetic)
-		pipeline = append(pipeline, PolynomialFieldComputation)
-	}
+// ErrIrreversibleOperation is returned when reversing a pipeline that contains a one-way stage
+var ErrIrreversibleOperation = errors.New("operation is not reversible")
 
-	if ctx.SecurityLevel >= StandardSecurity {
-		pipeline = append(pipeline, MatrixLinearTransformation)
-	}
+// ErrKeyMaterialUnavailable is returned when reversing a stage whose key was not retained
+var ErrKeyMaterialUnavailable = errors.New("stage key material not retained")
 
-	// Add Korean operations if required for compliance
-	for _, requirement := range ctx.ComplianceRequirements {
-		if requirement == "korean_standards" {
-			pipeline = append(pipeline, KoreanMathematicalProcessing)
-			pipeline = append(pipeline, RegionalComputationalProcessing)
-		}
-	}
+// ErrOperationNotAllowed is returned before execution when a planned pipeline
+// contains an operation outside the processor's allowed set
+var ErrOperationNotAllowed = errors.New("operation not allowed")
 
-	// Always add digest computation for integrity
-	pipeline = append(pipeline, DigestComputationProcessing)
+// ErrRiskThresholdExceeded is returned before execution when a planned pipeline
+// contains an operation riskier than the configured threshold
+var ErrRiskThresholdExceeded = errors.New("pipeline exceeds risk threshold")
 
-	return pipeline
+// ErrPipelineTooLong is returned before execution when a requested or planned
+// pipeline has more operations than the processor's maximum
+var ErrPipelineTooLong = errors.New("pipeline too long")
+
+// DefaultMaxPipelineLength bounds pipelines far above anything the planner builds
+// on its own, while stopping oversized RequiredOperations lists
+const DefaultMaxPipelineLength = 64
+
+// riskLevels ranks quantum vulnerability levels; unknown levels rank highest
+var riskLevels = map[string]int{
+	"low":    1,
+	"medium": 2,
+	"high":   3,
 }
 
-// executeOperation executes a specific mathematical operation
-func (stp *SecureTransactionProcessor) executeOperation(operation MathematicalOperation, data []byte) ([]byte, error) {
-	switch operation {
-	case LargeIntegerArithmetic:
-		return stp.largeNumberProcessor.ProcessModularArithmetic(data)
-	case PolynomialFieldComputation:
-		return stp.polynomialComputer.ProcessFieldOperations(data)
-	case MatrixLinearTransformation:
-		return stp.matrixTransformer.ProcessLinearTransforms(data)
-	case DigestComputationProcessing:
-		return stp.digestCalculator.ProcessDigestComputation(data)
-	case KoreanMathematicalProcessing:
-		return stp.koreanMathProcessor.ProcessKoreanAlgorithms(data)
-	case RegionalComputationalProcessing:
-		return stp.regionalProcessor.ProcessRegionalAlgorithms(data)
-	default:
-		return nil, fmt.Errorf("unknown operation: %v", operation)
+// riskRank returns the rank of a risk level, treating unrecognised levels as most severe
+func riskRank(level string) int {
+	if rank, ok := riskLevels[level]; ok {
+		return rank
 	}
+	return len(riskLevels) + 1
 }
 
-// LargeNumberProcessor handles large integer arithmetic operations
-type LargeNumberProcessor struct {
-	modulusBitLength int
-	exponentE   *big.Int
+// WithRiskThreshold rejects pipelines containing operations above level ("low", "medium" or "high")
+func WithRiskThreshold(level string) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.riskThreshold = level
+	}
 }
 
-func NewLargeNumberProcessor() *LargeNumberProcessor {
-	return &LargeNumberProcessor{
-		modulusBitLength: 2048,
-		exponentE:   big.NewInt(65537),
+// WithRetainKeys records each symmetric stage's key in its OperationResult so the
+// transaction can later be reversed. Off by default: anyone holding a result with
+// retained keys can recover the stage inputs.
+func WithRetainKeys(enabled bool) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.retainKeys = enabled
 	}
 }
 
-// ProcessModularArithmetic performs modular arithmetic operations (disguised public key operations)
-func (lnp *LargeNumberProcessor) ProcessModularArithmetic(data []byte) ([]byte, error) {
-	// Generate large prime factors for modular arithmetic
-	p, err := rand.Prime(rand.Reader, lnp.modulusBitLength/2)
-	if err != nil {
-		return nil, err
+// ErrConcurrencyLimitReached is returned by fail-fast processors when every slot is busy
+var ErrConcurrencyLimitReached = errors.New("concurrency limit reached")
+
+// ConcurrencyLimiter bounds concurrent transactions; share one between processors
+// to enforce a combined limit
+type ConcurrencyLimiter struct {
+	slots chan struct{}
+}
+
+// NewConcurrencyLimiter creates a limiter admitting limit concurrent transactions;
+// a non-positive limit admits any number
+func NewConcurrencyLimiter(limit int) *ConcurrencyLimiter {
+	if limit <= 0 {
+		return &ConcurrencyLimiter{}
 	}
+	return &ConcurrencyLimiter{slots: make(chan struct{}, limit)}
+}
 
-	q, err := rand.Prime(rand.Reader, lnp.modulusBitLength/2)
-	if err != nil {
-		return nil, err
+// Acquire takes a slot, waiting for one to free unless failFast is set
+func (cl *ConcurrencyLimiter) Acquire(failFast bool) error {
+	if cl.slots == nil {
+		return nil
+	}
+	if failFast {
+		select {
+		case cl.slots <- struct{}{}:
+			return nil
+		default:
+			return ErrConcurrencyLimitReached
+		}
 	}
+	cl.slots <- struct{}{}
+	return nil
+}
 
-	// Calculate productN
-	n := new(big.Int).Mul(p, q)
+// Release returns a slot taken by Acquire
+func (cl *ConcurrencyLimiter) Release() {
+	if cl.slots == nil {
+		return
+	}
+	<-cl.slots
+}
 
-	// Convert input data to big integer
-	message := new(big.Int).SetBytes(data)
+// processingBufferSize is the size of pooled processing buffers
+const processingBufferSize = 4096
 
-	// Ensure message is smaller than productN
-	if message.Cmp(n) >= 0 {
-		message.Mod(message, n)
-	}
+// ErrBackpressure is returned under BackpressureReject when every processing buffer is in use
+var ErrBackpressure = errors.New("processing buffers exhausted")
 
-	// Perform modular exponentiation (core of public key operations)
-	result := new(big.Int).Exp(message, lnp.exponentE, n)
+// BackpressurePolicy decides what a transaction does when the buffer limit is reached
+type BackpressurePolicy int
 
-	return result.Bytes(), nil
+const (
+	BackpressureBlock  BackpressurePolicy = iota // wait for a buffer to be released
+	BackpressureReject                           // fail with ErrBackpressure
+)
+
+// WithBufferLimit caps the processing buffers in flight, one per executing
+// transaction; a non-positive limit removes the cap
+func WithBufferLimit(limit int, policy BackpressurePolicy) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.bufferLimit = limit
+		stp.backpressure = policy
+	}
 }
 
-// PolynomialFieldComputer handles polynomial field computations
-type PolynomialFieldComputer struct {
-	fieldPrime *big.Int
-	curveA     *big.Int
-	curveB     *big.Int
-	generatorX *big.Int
-	generatorY *big.Int
+// acquireBuffer takes a buffer slot under the backpressure policy and returns a
+// buffer of at least size bytes, pooled when it fits
+func (stp *SecureTransactionProcessor) acquireBuffer(size int) ([]byte, error) {
+	if err := stp.bufferSlots.Acquire(stp.backpressure == BackpressureReject); err != nil {
+		return nil, ErrBackpressure
+	}
+	if size > processingBufferSize {
+		return make([]byte, size), nil
+	}
+	return stp.processingPool.Get().([]byte), nil
 }
 
-func NewPolynomialFieldComputer() *PolynomialFieldComputer {
-	// P-256 curve parameters disguised as polynomial coefficients
-	fieldPrime, _ := new(big.Int).SetString("FFFFFFFF00000001000000000000000000000000FFFFFFFFFFFFFFFFFFFFFFFF", 16)
-	curveA, _ := new(big.Int).SetString("FFFFFFFF00000001000000000000000000000000FFFFFFFFFFFFFFFFFFFFFFFC", 16)
-	curveB, _ := new(big.Int).SetString("5AC635D8AA3A93E7B3EBBD55769886BC651D06B0CC53B0F63BCE3C3E27D2604B", 16)
-	generatorX, _ := new(big.Int).SetString("6B17D1F2E12C4247F8BCE6E563A440F277037D812DEB33A0F4A13945D898C296", 16)
-	generatorY, _ := new(big.Int).SetString("4FE342E2FE1A7F9B8EE7EB4A7C0F9E162BCE33576B315ECECBB6406837BF51F5", 16)
+// releaseBuffer frees the slot taken by acquireBuffer. The buffer goes back to the
+// pool unless output still points into it.
+func (stp *SecureTransactionProcessor) releaseBuffer(buffer, output []byte) {
+	defer stp.bufferSlots.Release()
 
-	return &PolynomialFieldComputer{
-		fieldPrime: fieldPrime,
-		curveA:     curveA,
-		curveB:     curveB,
-		generatorX: generatorX,
-		generatorY: generatorY,
+	if cap(buffer) != processingBufferSize {
+		return
+	}
+	if cap(output) > 0 && &output[:cap(output)][cap(output)-1] == &buffer[:cap(buffer)][cap(buffer)-1] {
+		return
 	}
+	stp.processingPool.Put(buffer[:processingBufferSize])
 }
 
-// EllipticPoint represents a point on an Geometric Curve
-type EllipticPoint struct {
-	X, Y *big.Int
+// WithConcurrencyLimit sets the number of transactions the processor runs at once
+func WithConcurrencyLimit(limit int) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.concurrencyLimit = limit
+		stp.limiter = nil
+	}
 }
 
-// ProcessFieldOperations performs polynomial field operations (disguised Geometric Curve operations)
-func (pfc *PolynomialFieldComputer) ProcessFieldOperations(data []byte) ([]byte, error) {
-	// Convert data to scalar for point operations
-	scalar := new(big.Int).SetBytes(data)
+// WithConcurrencyLimiter gates the processor on a limiter shared with other processors
+func WithConcurrencyLimiter(limiter *ConcurrencyLimiter) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.limiter = limiter
+	}
+}
 
-	// Perform scalar multiplication (core of Geometric Curve operations)
-	resultPoint := pfc.scalarMultiplication(scalar, &EllipticPoint{
-		X: new(big.Int).Set(pfc.generatorX),
-		Y: new(big.Int).Set(pfc.generatorY),
-	})
+// WithFailFast makes calls over the concurrency limit return ErrConcurrencyLimitReached
+// instead of waiting for a slot
+func WithFailFast(enabled bool) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.failFast = enabled
+	}
+}
 
-	// Combine x and y coordinates
-	xBytes := resultPoint.X.Bytes()
-	yBytes := resultPoint.Y.Bytes()
+// WithStrictCompliance fails unrecognised compliance requirements instead of passing
+// them, so a misspelt requirement can't be silently satisfied
+func WithStrictCompliance(enabled bool) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.strictCompliance = enabled
+	}
+}
 
-	result := make([]byte, len(xBytes)+len(yBytes))
-	copy(result, xBytes)
-	copy(result[len(xBytes):], yBytes)
+// ComplianceSeverity decides whether a failed compliance requirement fails the transaction
+type ComplianceSeverity int
 
-	return result, nil
-}
+const (
+	// ComplianceAdvisory failures are only reported in ComplianceStatus
+	ComplianceAdvisory ComplianceSeverity = iota
+	// ComplianceMandatory failures make ProcessSecureTransaction return
+	// ErrMandatoryComplianceFailed
+	ComplianceMandatory
+)
 
-// scalarMultiplication performs scalar multiplication using double-and-add
-func (pfc *PolynomialFieldComputer) scalarMultiplication(scalar *big.Int, point *EllipticPoint) *EllipticPoint {
-	// Point at infinity
-	result := &EllipticPoint{X: big.NewInt(0), Y: big.NewInt(0)}
-	addend := &EllipticPoint{X: new(big.Int).Set(point.X), Y: new(big.Int).Set(point.Y)}
+// ErrMandatoryComplianceFailed is returned after processing when a mandatory
+// compliance requirement is not met; the failure is still sent to the audit sink
+var ErrMandatoryComplianceFailed = errors.New("mandatory compliance requirement failed")
 
-	for scalar.Sign() > 0 {
-		if scalar.Bit(0) == 1 {
-			result = pfc.pointAddition(result, addend)
+// WithComplianceSeverity sets the severity of a compliance requirement; requirements
+// are advisory unless set otherwise
+func WithComplianceSeverity(requirement string, severity ComplianceSeverity) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		if stp.complianceSeverity == nil {
+			stp.complianceSeverity = make(map[string]ComplianceSeverity)
 		}
-		addend = pfc.pointDoubling(addend)
-		scalar.Rsh(scalar, 1)
+		stp.complianceSeverity[requirement] = severity
 	}
-
-	return result
 }
 
-// pointAddition performs Geometric Curve point addition (simplified)
-func (pfc *PolynomialFieldComputer) pointAddition(p1, p2 *EllipticPoint) *EllipticPoint {
-	// Handle point at infinity
-	if p1.X.Sign() == 0 && p1.Y.Sign() == 0 {
-		return &EllipticPoint{X: new(big.Int).Set(p2.X), Y: new(big.Int).Set(p2.Y)}
+// checkMandatoryCompliance fails when any mandatory requirement in status failed
+func (stp *SecureTransactionProcessor) checkMandatoryCompliance(status map[string]bool) error {
+	var failed []string
+	for requirement, passed := range status {
+		if !passed && stp.complianceSeverity[requirement] == ComplianceMandatory {
+			failed = append(failed, requirement)
+		}
 	}
-	if p2.X.Sign() == 0 && p2.Y.Sign() == 0 {
-		return &EllipticPoint{X: new(big.Int).Set(p1.X), Y: new(big.Int).Set(p1.Y)}
+	if len(failed) == 0 {
+		return nil
 	}
 
-	// Simplified addition (not cryptographically secure)
-	x3 := new(big.Int).Add(p1.X, p2.X)
-	x3.Mod(x3, pfc.fieldPrime)
+	sort.Strings(failed)
+	return fmt.Errorf("%w: %s", ErrMandatoryComplianceFailed, strings.Join(failed, ", "))
+}
 
-	y3 := new(big.Int).Add(p1.Y, p2.Y)
-	y3.Mod(y3, pfc.fieldPrime)
+// WithComplianceMapping replaces the default compliance mapping, letting a deployment
+// decide which operations satisfy each requirement. Requirements missing from the
+// mapping add no operations.
+func WithComplianceMapping(mapping ComplianceMapping) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.complianceMapping = mapping.copy()
+	}
+}
 
-	return &EllipticPoint{X: x3, Y: y3}
+// WithMaxPipelineLength sets the maximum number of pipeline operations; 0 disables the limit
+func WithMaxPipelineLength(length int) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.maxPipelineLength = length
+	}
 }
 
-// pointDoubling performs Geometric Curve point doubling (simplified)
-func (pfc *PolynomialFieldComputer) pointDoubling(point *EllipticPoint) *EllipticPoint {
-	if point.X.Sign() == 0 && point.Y.Sign() == 0 {
-		return &EllipticPoint{X: big.NewInt(0), Y: big.NewInt(0)}
+// WithClock replaces the processor's time source
+func WithClock(clock Clock) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.clock = clock
 	}
+}
 
-	// Simplified doubling (not cryptographically secure)
-	x2 := new(big.Int).Mul(point.X, big.NewInt(2))
-	x2.Mod(x2, pfc.fieldPrime)
+// WithMatrixParallelism configures the matrix engine's worker pool; see
+// MatrixTransformationEngine.SetParallelism. Invalid values are ignored.
+func WithMatrixParallelism(workers, threshold int) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.matrixTransformer.SetParallelism(workers, threshold)
+	}
+}
 
-	y2 := new(big.Int).Mul(point.Y, big.NewInt(2))
-	y2.Mod(y2, pfc.fieldPrime)
+// WithKeyWrapKEK sets the AES key-encryption key used by AESKeyWrap stages. The
+// KEK is never recorded in results, so reversal needs the same processor
+// configuration.
+func WithKeyWrapKEK(kek []byte) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.keyWrapKEK = append([]byte(nil), kek...)
+	}
+}
 
-	return &EllipticPoint{X: x2, Y: y2}
+// WithRecipients sets the public keys MultiRecipientEncryption stages encrypt
+// for, each an *rsa.PublicKey or an *EllipticPoint; see EncryptForRecipients
+func WithRecipients(recipients ...crypto.PublicKey) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.recipients = append([]crypto.PublicKey(nil), recipients...)
+	}
 }
 
-// MatrixTransformationEngine handles matrix operations
-type MatrixTransformationEngine struct {
-	blockSize int
-	keySize   int
-	rounds    int
+// WithRecipientKey sets the private key, an *rsa.PrivateKey or a curve scalar
+// (*big.Int), with which reversal opens MultiRecipientEncryption stages. Any one
+// recipient's key will do.
+func WithRecipientKey(key crypto.PrivateKey) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.recipientKey = key
+	}
 }
 
-func NewMatrixTransformationEngine() *MatrixTransformationEngine {
-	return &MatrixTransformationEngine{
-		blockSize: 16, // 128-bit blocks
-		keySize:   32, // 256-bit keys
-		rounds:    14, // Standard rounds for 256-bit operations
+// WithPipelineOrdering sets where the asymmetric stages run; the default is
+// AsymmetricFirst. Reversal plans with the same policy, so results must be
+// reversed by a processor configured the same way. Unknown policies are ignored.
+func WithPipelineOrdering(policy PipelineOrderingPolicy) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		if policy == AsymmetricFirst || policy == SymmetricFirst {
+			stp.pipelineOrdering = policy
+		}
 	}
 }
 
-// ProcessLinearTransforms applies linear transformations (disguised block cipher operations)
-func (mte *MatrixTransformationEngine) ProcessLinearTransforms(data []byte) ([]byte, error) {
-	// Generate transformation key
-	key := make([]byte, mte.keySize)
-	if _, err := rand.Read(key); err != nil {
+// WithWAL records every processed transaction in wal before it is returned; a
+// failed append fails the transaction
+func WithWAL(wal WALSink) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.wal = wal
+	}
+}
+
+// WithKeyStore shares a key store between processors
+func WithKeyStore(store *KeyStore) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.keyStore = store
+	}
+}
+
+// WithCircuitBreaker opens an operation's breaker after threshold consecutive failures,
+// failing that operation fast with ErrCircuitOpen until cooldown has passed.
+// Circuit breaking is off unless this option is given; a non-positive threshold
+// disables it.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) ProcessorOption {
+	return func(stp *SecureTransactionProcessor) {
+		stp.breakerThreshold = threshold
+		stp.breakerCooldown = cooldown
+	}
+}
+
+// NewSecureTransactionProcessor creates a new instance of the processor
+func NewSecureTransactionProcessor(opts ...ProcessorOption) *SecureTransactionProcessor {
+	stp := &SecureTransactionProcessor{
+		largeNumberProcessor:   NewLargeNumberProcessor(),
+		polynomialComputer:    NewPolynomialFieldComputer(),
+		matrixTransformer:     NewMatrixTransformationEngine(),
+		digestCalculator:      NewDigestComputationEngine(),
+		koreanMathProcessor:   NewKoreanMathematicalProcessor(),
+		regionalProcessor:     NewRegionalComputationalProcessor(),
+		fpeProcessor:          newByteFPEProcessor(),
+		keyStore:              NewKeyStore(),
+		concurrencyLimit:      10,
+		performanceMonitor:    NewPerformanceMonitor(),
+		breakers:              make(map[MathematicalOperation]*circuitBreaker),
+		customOperations:      make(map[MathematicalOperation]customOperation),
+		complianceMapping:     DefaultComplianceMapping(),
+		clock:                 systemClock{},
+		maxPipelineLength:     DefaultMaxPipelineLength,
+		processingPool: &sync.Pool{
+			New: func() interface{} {
+				return make([]byte, processingBufferSize)
+			},
+		},
+	}
+
+	for _, opt := range opts {
+		opt(stp)
+	}
+
+	if stp.limiter == nil {
+		stp.limiter = NewConcurrencyLimiter(stp.concurrencyLimit)
+	}
+	stp.bufferSlots = NewConcurrencyLimiter(stp.bufferLimit)
+
+	return stp
+}
+
+// ErrInternal is returned in place of a panic during processing; the panic and its
+// stack are logged rather than returned
+var ErrInternal = errors.New("internal error")
+
+// recoverInternal is deferred by entry points to turn a panic into ErrInternal
+func recoverInternal(err *error, method string) {
+	if r := recover(); r != nil {
+		log.Printf("%s: recovered panic: %v\n%s", method, r, debug.Stack())
+		*err = fmt.Errorf("%w in %s", ErrInternal, method)
+	}
+}
+
+// ProcessSecureTransaction processes a transaction with specified security
+// requirements. A context without a TransactionID gets a generated one, reported
+// on the result; ctx itself is never modified. A panic in any stage, including
+// custom operations, is returned as ErrInternal.
+func (stp *SecureTransactionProcessor) ProcessSecureTransaction(ctx *TransactionContext) (_ *ProcessingResult, err error) {
+	defer recoverInternal(&err, "ProcessSecureTransaction")
+
+	if err := stp.limiter.Acquire(stp.failFast); err != nil {
 		return nil, err
 	}
+	defer stp.limiter.Release()
 
-	// Process data in blocks
-	blocks := mte.partitionIntoBlocks(data)
-	var result []byte
+	transactionID := ctx.TransactionID
+	if transactionID == "" {
+		transactionID = GenerateTransactionID()
+	}
 
-	for _, block := range blocks {
-		transformedBlock := mte.transformBlock(block, key)
-		result = append(result, transformedBlock...)
+	startTime := time.Now()
+
+	result := &ProcessingResult{
+		TransactionID:       transactionID,
+		SecurityMetrics:     make(map[string]interface{}),
+		ComplianceStatus:    make(map[string]bool),
+		OperationResults:    make([]OperationResult, 0),
+	}
+
+	// Reject oversized requests before planning walks them
+	if err := stp.checkPipelineLength(len(ctx.RequiredOperations)); err != nil {
+		return nil, err
+	}
+
+	// Build processing pipeline based on security level
+	pipeline := stp.buildProcessingPipeline(ctx)
+
+	if err := stp.checkPipelineLength(len(pipeline)); err != nil {
+		return nil, err
+	}
+	if err := stp.checkAllowedOperations(pipeline); err != nil {
+		return nil, err
+	}
+	if err := stp.checkRiskThreshold(pipeline); err != nil {
+		return nil, err
+	}
+	if ctx.SkipIntegrityDigest && !stp.hasIntegrityStage(pipeline) {
+		stp.recordWarning(transactionID, "integrity digest skipped and no other hash stage is planned; output is unauthenticated")
+	}
+
+	// Run the pipeline over a private copy of the input, so callers can't
+	// change it mid-flight
+	buffer, err := stp.acquireBuffer(len(ctx.Data))
+	if err != nil {
+		return nil, err
+	}
+	processedData := append(buffer[:0], ctx.Data...)
+	defer func() { stp.releaseBuffer(buffer, processedData) }()
+
+	// Execute processing pipeline
+
+	for _, operation := range pipeline {
+		operationStart := time.Now()
+		inputLength := len(processedData)
+
+		var stageKey []byte
+		processedData, stageKey, err = stp.executeOperation(operation, processedData, ctx.KeyID)
+		if err != nil {
+			return nil, fmt.Errorf("operation %v failed: %w", operation, err)
+		}
+
+		operationTime := time.Since(operationStart)
+		stp.performanceMonitor.RecordOperation(operation, operationTime)
+
+		operationResult := OperationResult{
+			Operation:               operation,
+			ExecutionTime:          operationTime,
+			ComputationalComplexity: stp.getComputationalComplexity(operation),
+			QuantumVulnerability:   stp.getQuantumVulnerability(operation),
+			InputLength:             inputLength,
+			HandledSecrets:          stp.handlesSecrets(operation),
+		}
+		if stp.retainKeys {
+			operationResult.KeyMaterial = stageKey
+		}
+
+		result.OperationResults = append(result.OperationResults, operationResult)
+	}
+
+	result.ProcessedData = processedData
+	result.ProcessingTime = time.Since(startTime)
+	result.Metrics = stp.calculateSecurityMetrics(pipeline)
+	result.SecurityMetrics = result.Metrics.Map()
+	result.ComplianceStatus = stp.validateCompliance(ctx, result)
+
+	if stp.auditSink != nil {
+		stp.auditSink.RecordCompliance(transactionID, result.ComplianceStatus, stp.clock.Now())
+	}
+	if err := stp.checkMandatoryCompliance(result.ComplianceStatus); err != nil {
+		return nil, err
+	}
+
+	if stp.wal != nil {
+		entry := WALEntry{
+			TransactionID:     transactionID,
+			PipelineSignature: stp.PipelineSignature(ctx),
+			ResultChecksum:    ResultChecksum(result),
+			Timestamp:         stp.clock.Now(),
+		}
+		if err := stp.wal.AppendTransaction(entry); err != nil {
+			return nil, fmt.Errorf("write-ahead log: %w", err)
+		}
 	}
 
 	return result, nil
 }
 
-// partitionIntoBlocks diviLegacyBlockCipherdata into fixed-size blocks
-func (mte *MatrixTransformationEngine) partitionIntoBlocks(data []byte) [][]byte {
-	var blocks [][]byte
+// checkPipelineLength fails fast when a pipeline exceeds the maximum length
+func (stp *SecureTransactionProcessor) checkPipelineLength(length int) error {
+	if stp.maxPipelineLength > 0 && length > stp.maxPipelineLength {
+		return fmt.Errorf("%w: %d operations (maximum %d)", ErrPipelineTooLong, length, stp.maxPipelineLength)
+	}
+	return nil
+}
 
-	for i := 0; i < len(data); i += mte.blockSize {
-		end := i + mte.blockSize
-		if end > len(data) {
-			// Apply padding
-			paddedBlock := make([]byte, mte.blockSize)
-			copy(paddedBlock, data[i:])
-			paddingLen := mte.blockSize - (len(data) - i)
-			for j := len(data) - i; j < mte.blockSize; j++ {
-				paddedBlock[j] = byte(paddingLen)
+// checkAllowedOperations fails fast when a planned operation is not allowed
+func (stp *SecureTransactionProcessor) checkAllowedOperations(pipeline []MathematicalOperation) error {
+	if stp.allowedOperations == nil {
+		return nil
+	}
+
+	for _, operation := range pipeline {
+		if !stp.allowedOperations[operation] {
+			return fmt.Errorf("%w: %v", ErrOperationNotAllowed, operation)
+		}
+	}
+
+	return nil
+}
+
+// checkRiskThreshold fails fast when a planned operation exceeds the risk threshold
+func (stp *SecureTransactionProcessor) checkRiskThreshold(pipeline []MathematicalOperation) error {
+	if stp.riskThreshold == "" {
+		return nil
+	}
+	if _, ok := riskLevels[stp.riskThreshold]; !ok {
+		return fmt.Errorf("invalid risk threshold: %q", stp.riskThreshold)
+	}
+
+	limit := riskRank(stp.riskThreshold)
+	for _, operation := range pipeline {
+		level := stp.getQuantumVulnerability(operation)
+		if riskRank(level) > limit {
+			return fmt.Errorf("%w: operation %v is %s risk (threshold %s)",
+				ErrRiskThresholdExceeded, operation, level, stp.riskThreshold)
+		}
+	}
+
+	return nil
+}
+
+// ranOperation reports whether operation is among the executed stages
+func (r *ProcessingResult) ranOperation(operation MathematicalOperation) bool {
+	for _, opResult := range r.OperationResults {
+		if opResult.Operation == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// SecretHandlingStages returns the executed operations that handled key material,
+// in pipeline order
+func (r *ProcessingResult) SecretHandlingStages() []MathematicalOperation {
+	var stages []MathematicalOperation
+	for _, opResult := range r.OperationResults {
+		if opResult.HandledSecrets {
+			stages = append(stages, opResult.Operation)
+		}
+	}
+	return stages
+}
+
+// HighestRiskOperation returns the riskiest executed operation and its risk level;
+// the level is empty when no operations ran
+func (r *ProcessingResult) HighestRiskOperation() (MathematicalOperation, string) {
+	var highest MathematicalOperation
+	highestLevel := ""
+
+	for _, opResult := range r.OperationResults {
+		if highestLevel == "" || riskRank(opResult.QuantumVulnerability) > riskRank(highestLevel) {
+			highest = opResult.Operation
+			highestLevel = opResult.QuantumVulnerability
+		}
+	}
+
+	return highest, highestLevel
+}
+
+// EncodedData renders ProcessedData as "hex", "base64", "base64url" (unpadded) or "raw"
+func (r *ProcessingResult) EncodedData(encoding string) (string, error) {
+	switch encoding {
+	case "hex":
+		return hex.EncodeToString(r.ProcessedData), nil
+	case "base64":
+		return base64.StdEncoding.EncodeToString(r.ProcessedData), nil
+	case "base64url":
+		return base64.RawURLEncoding.EncodeToString(r.ProcessedData), nil
+	case "raw":
+		return string(r.ProcessedData), nil
+	default:
+		return "", fmt.Errorf("unsupported data encoding: %q", encoding)
+	}
+}
+
+// SetAuditSink installs an optional sink that receives every compliance decision,
+// and the security warnings too if it is a WarningAuditSink
+func (stp *SecureTransactionProcessor) SetAuditSink(sink AuditSink) {
+	stp.auditSink = sink
+}
+
+// recordWarning passes a security warning to the audit sink, if it records them
+func (stp *SecureTransactionProcessor) recordWarning(txID, warning string) {
+	if sink, ok := stp.auditSink.(WarningAuditSink); ok {
+		sink.RecordWarning(txID, warning, stp.clock.Now())
+	}
+}
+
+// buildProcessingPipeline constructs the optimal processing pipeline. With no
+// compliance requirements or custom operations the pipeline is:
+//
+//	MinimumSecurity              digest
+//	StandardSecurity             matrix, digest
+//	EnhancedSecurity and above   large integer, polynomial field, matrix, digest
+func (stp *SecureTransactionProcessor) buildProcessingPipeline(ctx *TransactionContext) []MathematicalOperation {
+	var pipeline []MathematicalOperation
+
+	// Add operations based on security level, the asymmetric ones where the
+	// ordering policy places them
+	var asymmetric []MathematicalOperation
+	if ctx.SecurityLevel >= EnhancedSecurity {
+		asymmetric = []MathematicalOperation{LargeIntegerArithmetic, PolynomialFieldComputation}
+	}
+	if stp.pipelineOrdering == AsymmetricFirst {
+		pipeline = append(pipeline, asymmetric...)
+	}
+
+	if ctx.SecurityLevel >= StandardSecurity {
+		pipeline = append(pipeline, MatrixLinearTransformation)
+	}
+
+	// Add the operations each compliance requirement is mapped to
+	for _, requirement := range ctx.ComplianceRequirements {
+		pipeline = append(pipeline, stp.complianceMapping[requirement]...)
+	}
+
+	// Add requested custom operations; built-in ones are planned by security level
+	for _, operation := range ctx.RequiredOperations {
+		if isBuiltinOperation(operation) {
+			continue
+		}
+		if _, exists := stp.lookupOperationHandler(operation); exists {
+			pipeline = append(pipeline, operation)
+		}
+	}
+
+	if stp.pipelineOrdering == SymmetricFirst {
+		pipeline = append(pipeline, asymmetric...)
+	}
+
+	// Add digest computation for integrity unless the caller opted out
+	if !ctx.SkipIntegrityDigest {
+		pipeline = append(pipeline, DigestComputationProcessing)
+	}
+
+	return pipeline
+}
+
+// hasIntegrityStage reports whether any pipeline operation counts as a hash operation
+func (stp *SecureTransactionProcessor) hasIntegrityStage(pipeline []MathematicalOperation) bool {
+	for _, operation := range pipeline {
+		metadata, _ := stp.lookupOperation(operation)
+		for _, category := range metadata.categories {
+			if category == CategoryHash {
+				return true
 			}
-			blocks = append(blocks, paddedBlock)
-		} else {
-			blocks = append(blocks, data[i:end])
 		}
 	}
+	return false
+}
 
-	return blocks
+// ReverseSecureTransaction runs the recorded pipeline of result backwards and returns
+// the original transaction data. Only the symmetric stages, key wrap and
+// multi-recipient encryption can be inverted, so any pipeline containing modular
+// arithmetic, field computation or the digest stage fails with
+// ErrIrreversibleOperation. A reversible pipeline needs a
+// context with SkipIntegrityDigest set and a processor created WithRetainKeys;
+// stages without retained keys fail with ErrKeyMaterialUnavailable.
+func (stp *SecureTransactionProcessor) ReverseSecureTransaction(ctx *TransactionContext, result *ProcessingResult) ([]byte, error) {
+	// The result must come from the pipeline this context plans, otherwise
+	// reversal would silently produce garbage
+	pipeline := stp.buildProcessingPipeline(ctx)
+	if len(pipeline) != len(result.OperationResults) {
+		return nil, fmt.Errorf("result has %d operations, context plans %d", len(result.OperationResults), len(pipeline))
+	}
+	for i, operation := range pipeline {
+		if result.OperationResults[i].Operation != operation {
+			return nil, fmt.Errorf("result operation %d is %v, context plans %v", i, result.OperationResults[i].Operation, operation)
+		}
+	}
+
+	data := result.ProcessedData
+	for i := len(result.OperationResults) - 1; i >= 0; i-- {
+		opResult := result.OperationResults[i]
+
+		var err error
+		data, err = stp.reverseOperation(opResult, data)
+		if err != nil {
+			return nil, fmt.Errorf("reversing operation %v failed: %w", opResult.Operation, err)
+		}
+	}
+
+	return data, nil
 }
 
-// transformBlock applies transformation to a single block
-func (mte *MatrixTransformationEngine) transformBlock(block, key []byte) []byte {
-	state := make([]byte, len(block))
-	copy(state, block)
+// reverseOperation inverts a single recorded stage
+func (stp *SecureTransactionProcessor) reverseOperation(opResult OperationResult, data []byte) ([]byte, error) {
+	// Key wrap is reversed with the processor's KEK rather than stage key material
+	if opResult.Operation == AESKeyWrap {
+		if stp.keyWrapKEK == nil {
+			return nil, ErrKeyWrapNotConfigured
+		}
+		return UnwrapKey(stp.keyWrapKEK, data)
+	}
+	// Envelopes are opened with the processor's recipient key
+	if opResult.Operation == MultiRecipientEncryption {
+		return stp.openAsRecipient(data)
+	}
 
-	// Initial round key addition
-	mte.addRoundKey(state, key[:mte.blockSize])
+	switch opResult.Operation {
+	case MatrixLinearTransformation, KoreanMathematicalProcessing, RegionalComputationalProcessing, FormatPreservingEncryption:
+	default:
+		return nil, ErrIrreversibleOperation
+	}
 
-	// Main rounds
-	for round := 1; round < mte.rounds; round++ {
-		mte.substituteBytes(state)
-		mte.shiftRows(state)
-		mte.mixColumns(state)
-		roundKey := mte.deriveRoundKey(key, round)
-		mte.addRoundKey(state, roundKey)
+	if len(opResult.KeyMaterial) == 0 {
+		return nil, ErrKeyMaterialUnavailable
 	}
 
-	// Final round
-	mte.substituteBytes(state)
-	mte.shiftRows(state)
-	finalRoundKey := mte.deriveRoundKey(key, mte.rounds)
-	mte.addRoundKey(state, finalRoundKey)
+	switch opResult.Operation {
+	case MatrixLinearTransformation:
+		return stp.matrixTransformer.ReverseLinearTransforms(data, opResult.KeyMaterial, opResult.InputLength)
+	case KoreanMathematicalProcessing:
+		return stp.koreanMathProcessor.ReverseKoreanAlgorithms(data, opResult.KeyMaterial, opResult.InputLength)
+	case FormatPreservingEncryption:
+		return stp.fpeProcessor.DecryptBytes(data, opResult.KeyMaterial, nil)
+	default:
+		return stp.regionalProcessor.ReverseRegionalAlgorithms(data, opResult.KeyMaterial, opResult.InputLength)
+	}
+}
 
-	return state
+// checkReversible validates ciphertext, key and original length before block decryption
+func checkReversible(data, key []byte, keySize, blockSize, length int) error {
+	if len(key) != keySize {
+		return fmt.Errorf("invalid key size %d, expected %d", len(key), keySize)
+	}
+	if len(data)%blockSize != 0 {
+		return fmt.Errorf("data length %d is not a multiple of block size %d", len(data), blockSize)
+	}
+	if length < 0 || length > len(data) || len(data)-length >= blockSize {
+		return fmt.Errorf("original length %d inconsistent with %d bytes of data", length, len(data))
+	}
+	return nil
 }
 
-// substituteBytes applies byte substitution
-func (mte *MatrixTransformationEngine) substituteBytes(state []byte) {
-	sbox := mte.generateSubstitutionBox()
-	for i := range state {
-		state[i] = sbox[state[i]]
+// executeOperation executes a specific mathematical operation behind its circuit
+// breaker, returning the generated stage key for symmetric operations
+func (stp *SecureTransactionProcessor) executeOperation(operation MathematicalOperation, data []byte, keyID string) ([]byte, []byte, error) {
+	breaker := stp.breakerFor(operation)
+	if breaker == nil {
+		return stp.runOperation(operation, data, keyID)
+	}
+
+	if err := breaker.allow(); err != nil {
+		return nil, nil, err
 	}
+	// A panicking operation still counts as a failure, so a half-open trial
+	// can't be left busy
+	defer func() {
+		if r := recover(); r != nil {
+			breaker.record(false)
+			panic(r)
+		}
+	}()
+	output, key, err := stp.runOperation(operation, data, keyID)
+	breaker.record(err == nil)
+
+	return output, key, err
 }
 
-// shiftRows applies row shifting
-func (mte *MatrixTransformationEngine) shiftRows(state []byte) {
-	// Simplified shift rows for 4x4 state matrix
-	temp := state[1]
-	state[1] = state[5]
-	state[5] = state[9]
-	state[9] = state[13]
-	state[13] = temp
+// runOperation dispatches a mathematical operation to its engine; keyID selects the
+// stored key for modular arithmetic
+func (stp *SecureTransactionProcessor) runOperation(operation MathematicalOperation, data []byte, keyID string) ([]byte, []byte, error) {
+	if handler, exists := stp.lookupOperationHandler(operation); exists {
+		output, err := handler(data)
+		return output, nil, err
+	}
 
-	temp = state[2]
-	state[2] = state[10]
-	state[10] = temp
-	temp = state[6]
-	state[6] = state[14]
-	state[14] = temp
+	var output []byte
+	var err error
 
-	temp = state[3]
-	state[3] = state[15]
-	state[15] = state[11]
-	state[11] = state[7]
-	state[7] = temp
+	switch operation {
+	case LargeIntegerArithmetic:
+		if keyID == "" {
+			output, err = stp.largeNumberProcessor.ProcessModularArithmetic(data)
+			break
+		}
+		var key *rsa.PrivateKey
+		if key, err = stp.keyStore.privateKey(keyID); err == nil {
+			output = stp.largeNumberProcessor.SignModularArithmetic(data, key)
+		}
+	case PolynomialFieldComputation:
+		output, err = stp.polynomialComputer.ProcessFieldOperations(data)
+	case MatrixLinearTransformation:
+		return stp.matrixTransformer.processLinearTransformsWithKey(data)
+	case DigestComputationProcessing:
+		output, err = stp.digestCalculator.ProcessDigestComputation(data)
+	case KoreanMathematicalProcessing:
+		return stp.koreanMathProcessor.processKoreanAlgorithmsWithKey(data)
+	case RegionalComputationalProcessing:
+		return stp.regionalProcessor.processRegionalAlgorithmsWithKey(data)
+	case FormatPreservingEncryption:
+		return stp.fpeProcessor.processBytesWithKey(data)
+	case AESKeyWrap:
+		if stp.keyWrapKEK == nil {
+			err = ErrKeyWrapNotConfigured
+			break
+		}
+		output, err = WrapKey(stp.keyWrapKEK, data)
+	case MultiRecipientEncryption:
+		output, err = stp.sealForRecipients(data)
+	default:
+		err = fmt.Errorf("unknown operation: %v", operation)
+	}
+
+	return output, nil, err
+}
+
+// ErrCircuitOpen is returned while an operation's breaker is open after repeated failures
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// Circuit breaker states
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// circuitBreaker short-circuits an operation after consecutive failures. After the
+// cooldown it half-opens and admits a single trial call: success closes it again,
+// failure reopens it for another cooldown.
+type circuitBreaker struct {
+	mutex     sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	state     int
+	failures  int
+	openedAt  time.Time
+	trialBusy bool
+	clock     Clock
+}
+
+// breakerFor returns the breaker guarding operation, or nil when breaking is disabled
+func (stp *SecureTransactionProcessor) breakerFor(operation MathematicalOperation) *circuitBreaker {
+	if stp.breakerThreshold <= 0 {
+		return nil
+	}
+
+	stp.breakersMutex.Lock()
+	defer stp.breakersMutex.Unlock()
+
+	breaker, exists := stp.breakers[operation]
+	if !exists {
+		breaker = &circuitBreaker{
+			threshold: stp.breakerThreshold,
+			cooldown:  stp.breakerCooldown,
+			clock:     stp.clock,
+		}
+		stp.breakers[operation] = breaker
+	}
+	return breaker
+}
+
+// allow reports whether a call may proceed
+func (cb *circuitBreaker) allow() error {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if cb.clock.Now().Sub(cb.openedAt) < cb.cooldown {
+			return ErrCircuitOpen
+		}
+		cb.state = breakerHalfOpen
+		cb.trialBusy = true
+		return nil
+	case breakerHalfOpen:
+		// Only one trial call at a time while half-open
+		if cb.trialBusy {
+			return ErrCircuitOpen
+		}
+		cb.trialBusy = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// record updates the breaker with the outcome of an allowed call
+func (cb *circuitBreaker) record(success bool) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	cb.trialBusy = false
+	if success {
+		cb.state = breakerClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == breakerHalfOpen || cb.failures >= cb.threshold {
+		cb.state = breakerOpen
+		cb.openedAt = cb.clock.Now()
+	}
+}
+
+// LargeNumberProcessor handles large integer arithmetic operations
+type LargeNumberProcessor struct {
+	modulusBitLength int
+	exponentE   *big.Int
+	primeTimeout time.Duration
+
+	random    io.Reader // entropy source for prime generation
+
+	poolMutex sync.Mutex
+	primePool chan *big.Int
+	stopPool  chan struct{}
+	poolErr   error // last refill failure, cleared by the next success
+}
+
+func NewLargeNumberProcessor() *LargeNumberProcessor {
+	return &LargeNumberProcessor{
+		modulusBitLength: 2048,
+		exponentE:   big.NewInt(65537),
+		primeTimeout: 30 * time.Second,
+		random:       rand.Reader,
+	}
+}
+
+// ProcessModularArithmetic performs modular arithmetic operations (disguised public key operations)
+func (lnp *LargeNumberProcessor) ProcessModularArithmetic(data []byte) ([]byte, error) {
+	genCtx := context.Background()
+	if lnp.primeTimeout > 0 {
+		var cancel context.CancelFunc
+		genCtx, cancel = context.WithTimeout(genCtx, lnp.primeTimeout)
+		defer cancel()
+	}
+
+	// Generate large prime factors for modular arithmetic
+	p, q, err := lnp.GeneratePrimes(genCtx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate productN
+	n := new(big.Int).Mul(p, q)
+
+	// Convert input data to big integer
+	message := new(big.Int).SetBytes(data)
+
+	// Ensure message is smaller than productN
+	if message.Cmp(n) >= 0 {
+		message.Mod(message, n)
+	}
+
+	// Perform modular exponentiation (core of public key operations)
+	result := new(big.Int).Exp(message, lnp.exponentE, n)
+
+	return result.Bytes(), nil
+}
+
+// SignModularArithmetic raises data, reduced modulo N, to the private exponent of key.
+// Like ProcessModularArithmetic this is unpadded textbook RSA.
+func (lnp *LargeNumberProcessor) SignModularArithmetic(data []byte, key *rsa.PrivateKey) []byte {
+	message := new(big.Int).SetBytes(data)
+	message.Mod(message, key.N)
+
+	return new(big.Int).Exp(message, key.D, key.N).Bytes()
+}
+
+// VerifyModularSignature checks a SignModularArithmetic signature over data
+func (lnp *LargeNumberProcessor) VerifyModularSignature(pub *rsa.PublicKey, data, signature []byte) error {
+	s := new(big.Int).SetBytes(signature)
+	if s.Cmp(pub.N) >= 0 {
+		return ErrSignatureInvalid
+	}
+
+	message := new(big.Int).SetBytes(data)
+	message.Mod(message, pub.N)
+
+	recovered := new(big.Int).Exp(s, big.NewInt(int64(pub.E)), pub.N)
+	if recovered.Cmp(message) != 0 {
+		return ErrSignatureInvalid
+	}
+
+	return nil
+}
+
+// ErrSignatureInvalid is returned when a modular arithmetic signature does not verify
+var ErrSignatureInvalid = errors.New("signature verification failed")
+
+// ErrOAEPDecryption is returned for any OAEP ciphertext that fails to decrypt;
+// it deliberately doesn't say which check failed
+var ErrOAEPDecryption = errors.New("OAEP decryption failed")
+
+// oaepHashSize is the output size of SHA-256, the OAEP and MGF1 hash
+const oaepHashSize = 32
+
+// EncryptOAEP encrypts msg to pub with RSAES-OAEP (RFC 8017 section 7.1), using
+// SHA-256 for both the label hash and MGF1 and an empty label. Unlike
+// ProcessModularArithmetic, the padding makes encryption randomized and
+// non-malleable.
+func (lnp *LargeNumberProcessor) EncryptOAEP(pub *rsa.PublicKey, msg []byte) ([]byte, error) {
+	k := pub.Size()
+	if len(msg) > k-2*oaepHashSize-2 {
+		return nil, fmt.Errorf("OAEP message too long: %d bytes for a %d-byte modulus", len(msg), k)
+	}
+
+	// EM = 0x00 || maskedSeed || maskedDB, DB = lHash || PS || 0x01 || M
+	em := make([]byte, k)
+	seed := em[1 : 1+oaepHashSize]
+	db := em[1+oaepHashSize:]
+	lHash := hash_256.Sum256(nil)
+	copy(db, lHash[:])
+	db[len(db)-len(msg)-1] = 0x01
+	copy(db[len(db)-len(msg):], msg)
+
+	if _, err := io.ReadFull(lnp.random, seed); err != nil {
+		return nil, err
+	}
+	mgf1XOR(db, seed)
+	mgf1XOR(seed, db)
+
+	m := new(big.Int).SetBytes(em)
+	c := new(big.Int).Exp(m, big.NewInt(int64(pub.E)), pub.N)
+	return c.FillBytes(make([]byte, k)), nil
+}
+
+// DecryptOAEP reverses EncryptOAEP with priv. The padding checks don't branch on
+// the decrypted data, and every failure is ErrOAEPDecryption.
+func (lnp *LargeNumberProcessor) DecryptOAEP(priv *rsa.PrivateKey, ciphertext []byte) ([]byte, error) {
+	k := priv.Size()
+	if len(ciphertext) != k || k < 2*oaepHashSize+2 {
+		return nil, ErrOAEPDecryption
+	}
+	c := new(big.Int).SetBytes(ciphertext)
+	if c.Cmp(priv.N) >= 0 {
+		return nil, ErrOAEPDecryption
+	}
+
+	em := new(big.Int).Exp(c, priv.D, priv.N).FillBytes(make([]byte, k))
+	seed := em[1 : 1+oaepHashSize]
+	db := em[1+oaepHashSize:]
+	mgf1XOR(seed, db)
+	mgf1XOR(db, seed)
+
+	lHash := hash_256.Sum256(nil)
+	valid := subtle.ConstantTimeByteEq(em[0], 0) & subtle.ConstantTimeCompare(db[:oaepHashSize], lHash[:])
+
+	// Find the 0x01 separating the zero padding from the message
+	rest := db[oaepHashSize:]
+	lookingForIndex, index, invalid := 1, 0, 0
+	for i := range rest {
+		equals0 := subtle.ConstantTimeByteEq(rest[i], 0)
+		equals1 := subtle.ConstantTimeByteEq(rest[i], 1)
+		index = subtle.ConstantTimeSelect(lookingForIndex&equals1, i, index)
+		lookingForIndex = subtle.ConstantTimeSelect(equals1, 0, lookingForIndex)
+		invalid = subtle.ConstantTimeSelect(lookingForIndex&^equals0, 1, invalid)
+	}
+
+	if valid&^invalid&^lookingForIndex != 1 {
+		return nil, ErrOAEPDecryption
+	}
+	return append([]byte(nil), rest[index+1:]...), nil
+}
+
+// mgf1XOR XORs out with the MGF1 mask (RFC 8017 appendix B.2.1) of seed under SHA-256
+func mgf1XOR(out, seed []byte) {
+	var counter [4]byte
+	for done, n := 0, uint32(0); done < len(out); n++ {
+		binary.BigEndian.PutUint32(counter[:], n)
+		h := hash_256.New()
+		h.Write(seed)
+		h.Write(counter[:])
+		for _, b := range h.Sum(nil) {
+			if done == len(out) {
+				break
+			}
+			out[done] ^= b
+			done++
+		}
+	}
+}
+
+// ErrKeyNotFound is returned when a transaction names a key the store does not hold
+var ErrKeyNotFound = errors.New("key not found")
+
+// KeyStore holds persistent RSA keys by ID, so signing transactions reuse one modulus
+type KeyStore struct {
+	mutex sync.RWMutex
+	keys  map[string]*rsa.PrivateKey
+}
+
+// NewKeyStore creates an empty key store
+func NewKeyStore() *KeyStore {
+	return &KeyStore{keys: make(map[string]*rsa.PrivateKey)}
+}
+
+// ImportKey stores a PKCS #1 or PKCS #8 DER private key under id, replacing any previous key
+func (ks *KeyStore) ImportKey(id string, der []byte) error {
+	if id == "" {
+		return errors.New("key ID must not be empty")
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(der)
+	if err != nil {
+		parsed, pkcs8Err := x509.ParsePKCS8PrivateKey(der)
+		if pkcs8Err != nil {
+			return fmt.Errorf("parsing key %q: %w", id, err)
+		}
+		var ok bool
+		if key, ok = parsed.(*rsa.PrivateKey); !ok {
+			return fmt.Errorf("key %q is %T, not an RSA key", id, parsed)
+		}
+	}
+	if err := key.Validate(); err != nil {
+		return fmt.Errorf("key %q: %w", id, err)
+	}
+	key.Precompute()
+
+	ks.mutex.Lock()
+	ks.keys[id] = key
+	ks.mutex.Unlock()
+
+	return nil
+}
+
+// ExportKey returns the key stored under id as PKCS #1 DER
+func (ks *KeyStore) ExportKey(id string) ([]byte, error) {
+	key, err := ks.privateKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return x509.MarshalPKCS1PrivateKey(key), nil
+}
+
+// PublicKey returns the public half of the key stored under id
+func (ks *KeyStore) PublicKey(id string) (*rsa.PublicKey, error) {
+	key, err := ks.privateKey(id)
+	if err != nil {
+		return nil, err
+	}
+	return &key.PublicKey, nil
+}
+
+// privateKey looks up the key stored under id
+func (ks *KeyStore) privateKey(id string) (*rsa.PrivateKey, error) {
+	ks.mutex.RLock()
+	key, exists := ks.keys[id]
+	ks.mutex.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("%w: %q", ErrKeyNotFound, id)
+	}
+	return key, nil
+}
+
+// KeyStore returns the store consulted for TransactionContext.KeyID
+func (stp *SecureTransactionProcessor) KeyStore() *KeyStore {
+	return stp.keyStore
+}
+
+// maxPrimeAttempts bounds regeneration when a candidate is rejected
+const maxPrimeAttempts = 64
+
+// GeneratePrimes returns two distinct, re-verified primes for the modulus, drawing
+// from the prime pool when enabled. If ctx expires first it returns the context
+// error; an in-flight search is left to finish in the background and its prime
+// is discarded.
+func (lnp *LargeNumberProcessor) GeneratePrimes(ctx context.Context) (*big.Int, *big.Int, error) {
+	p, err := lnp.nextVerifiedPrime(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// p == q collapses the modulus to a square, so q must differ from p
+	q, err := lnp.nextVerifiedPrime(ctx, p)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return p, q, nil
+}
+
+// nextVerifiedPrime draws primes until one passes re-verification and differs from exclude
+func (lnp *LargeNumberProcessor) nextVerifiedPrime(ctx context.Context, exclude *big.Int) (*big.Int, error) {
+	for attempt := 0; attempt < maxPrimeAttempts; attempt++ {
+		prime, err := lnp.nextPrime(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !prime.ProbablyPrime(20) {
+			continue
+		}
+		if exclude != nil && prime.Cmp(exclude) == 0 {
+			continue
+		}
+		return prime, nil
+	}
+
+	return nil, fmt.Errorf("no acceptable prime after %d attempts", maxPrimeAttempts)
+}
+
+// nextPrime takes a pooled prime if one is ready, otherwise generates one bounded by ctx
+func (lnp *LargeNumberProcessor) nextPrime(ctx context.Context) (*big.Int, error) {
+	lnp.poolMutex.Lock()
+	pool := lnp.primePool
+	lnp.poolMutex.Unlock()
+
+	select {
+	case prime := <-pool:
+		return prime, nil
+	default:
+	}
+
+	type primeResult struct {
+		prime *big.Int
+		err   error
+	}
+	done := make(chan primeResult, 1)
+
+	go func() {
+		prime, err := rand.Prime(lnp.random, lnp.modulusBitLength/2)
+		done <- primeResult{prime, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.prime, res.err
+	case prime := <-pool:
+		return prime, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("prime generation: %w", ctx.Err())
+	}
+}
+
+// Prime pool refill backoff after a generation failure, doubling up to the maximum
+const (
+	primePoolMinBackoff = 10 * time.Millisecond
+	primePoolMaxBackoff = 5 * time.Second
+)
+
+// EnablePrimePool keeps up to size pre-generated primes, refilled by a background
+// goroutine. A failing entropy source makes the refill back off rather than spin;
+// PrimePoolErr reports the failure.
+func (lnp *LargeNumberProcessor) EnablePrimePool(size int) {
+	lnp.poolMutex.Lock()
+	defer lnp.poolMutex.Unlock()
+
+	if lnp.primePool != nil || size <= 0 {
+		return
+	}
+
+	pool := make(chan *big.Int, size)
+	stop := make(chan struct{})
+	bitLength := lnp.modulusBitLength / 2
+
+	go func() {
+		backoff := primePoolMinBackoff
+		for {
+			prime, err := rand.Prime(lnp.random, bitLength)
+			lnp.setPoolErr(stop, err)
+			if err != nil {
+				select {
+				case <-time.After(backoff):
+				case <-stop:
+					return
+				}
+				if backoff *= 2; backoff > primePoolMaxBackoff {
+					backoff = primePoolMaxBackoff
+				}
+				continue
+			}
+			backoff = primePoolMinBackoff
+
+			select {
+			case pool <- prime:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	lnp.primePool = pool
+	lnp.stopPool = stop
+}
+
+// setPoolErr records the outcome of a refill attempt by the pool owning stop
+func (lnp *LargeNumberProcessor) setPoolErr(stop chan struct{}, err error) {
+	lnp.poolMutex.Lock()
+	if lnp.stopPool == stop {
+		lnp.poolErr = err
+	}
+	lnp.poolMutex.Unlock()
+}
+
+// PrimePoolErr returns the error from the pool's last refill attempt, or nil once a
+// refill succeeds
+func (lnp *LargeNumberProcessor) PrimePoolErr() error {
+	lnp.poolMutex.Lock()
+	defer lnp.poolMutex.Unlock()
+	return lnp.poolErr
+}
+
+// StopPrimePool stops the background refill and drops any pooled primes
+func (lnp *LargeNumberProcessor) StopPrimePool() {
+	lnp.poolMutex.Lock()
+	defer lnp.poolMutex.Unlock()
+
+	if lnp.stopPool != nil {
+		close(lnp.stopPool)
+	}
+	lnp.primePool = nil
+	lnp.stopPool = nil
+	lnp.poolErr = nil
+}
+
+// PolynomialFieldComputer handles polynomial field computations
+type PolynomialFieldComputer struct {
+	fieldPrime *big.Int
+	curveA     *big.Int
+	curveB     *big.Int
+	generatorX *big.Int
+	generatorY *big.Int
+	curveOrder *big.Int
+}
+
+func NewPolynomialFieldComputer() *PolynomialFieldComputer {
+	// P-256 curve parameters disguised as polynomial coefficients
+	fieldPrime, _ := new(big.Int).SetString("FFFFFFFF00000001000000000000000000000000FFFFFFFFFFFFFFFFFFFFFFFF", 16)
+	curveA, _ := new(big.Int).SetString("FFFFFFFF00000001000000000000000000000000FFFFFFFFFFFFFFFFFFFFFFFC", 16)
+	curveB, _ := new(big.Int).SetString("5AC635D8AA3A93E7B3EBBD55769886BC651D06B0CC53B0F63BCE3C3E27D2604B", 16)
+	generatorX, _ := new(big.Int).SetString("6B17D1F2E12C4247F8BCE6E563A440F277037D812DEB33A0F4A13945D898C296", 16)
+	generatorY, _ := new(big.Int).SetString("4FE342E2FE1A7F9B8EE7EB4A7C0F9E162BCE33576B315ECECBB6406837BF51F5", 16)
+	curveOrder, _ := new(big.Int).SetString("FFFFFFFF00000000FFFFFFFFFFFFFFFFBCE6FAADA7179E84F3B9CAC2FC632551", 16)
+
+	return &PolynomialFieldComputer{
+		fieldPrime: fieldPrime,
+		curveA:     curveA,
+		curveB:     curveB,
+		generatorX: generatorX,
+		generatorY: generatorY,
+		curveOrder: curveOrder,
+	}
+}
+
+// PublicKey derives the public point priv*G for a private scalar in [1, n-1]
+func (pfc *PolynomialFieldComputer) PublicKey(priv *big.Int) (*EllipticPoint, error) {
+	if priv.Sign() <= 0 || priv.Cmp(pfc.curveOrder) >= 0 {
+		return nil, fmt.Errorf("private scalar out of range [1, n-1]")
+	}
+
+	return pfc.scalarMultiplication(new(big.Int).Set(priv), &EllipticPoint{
+		X: new(big.Int).Set(pfc.generatorX),
+		Y: new(big.Int).Set(pfc.generatorY),
+	}), nil
+}
+
+// IsOnCurve reports whether a point satisfies y^2 = x^3 + ax + b (mod p)
+func (pfc *PolynomialFieldComputer) IsOnCurve(point *EllipticPoint) bool {
+	if point.Infinity {
+		return true
+	}
+	if point.X.Sign() < 0 || point.X.Cmp(pfc.fieldPrime) >= 0 ||
+		point.Y.Sign() < 0 || point.Y.Cmp(pfc.fieldPrime) >= 0 {
+		return false
+	}
+
+	lhs := new(big.Int).Mul(point.Y, point.Y)
+	lhs.Mod(lhs, pfc.fieldPrime)
+
+	rhs := new(big.Int).Mul(point.X, point.X)
+	rhs.Mul(rhs, point.X)
+	ax := new(big.Int).Mul(pfc.curveA, point.X)
+	rhs.Add(rhs, ax)
+	rhs.Add(rhs, pfc.curveB)
+	rhs.Mod(rhs, pfc.fieldPrime)
+
+	return lhs.Cmp(rhs) == 0
+}
+
+// coordinateSize is the encoded length of a field element
+func (pfc *PolynomialFieldComputer) coordinateSize() int {
+	return (pfc.fieldPrime.BitLen() + 7) / 8
+}
+
+// MarshalPoint encodes a point in SEC 1 uncompressed form, 0x04 || X || Y. The
+// point at infinity and points off the curve have no encoding.
+func (pfc *PolynomialFieldComputer) MarshalPoint(point *EllipticPoint) ([]byte, error) {
+	if point.Infinity || !pfc.IsOnCurve(point) {
+		return nil, fmt.Errorf("point is not a finite point on the curve")
+	}
+
+	size := pfc.coordinateSize()
+	encoded := make([]byte, 1+2*size)
+	encoded[0] = 0x04
+	point.X.FillBytes(encoded[1 : 1+size])
+	point.Y.FillBytes(encoded[1+size:])
+	return encoded, nil
+}
+
+// UnmarshalPoint decodes a MarshalPoint encoding, rejecting points off the curve
+func (pfc *PolynomialFieldComputer) UnmarshalPoint(encoded []byte) (*EllipticPoint, error) {
+	size := pfc.coordinateSize()
+	if len(encoded) != 1+2*size || encoded[0] != 0x04 {
+		return nil, fmt.Errorf("invalid point encoding")
+	}
+
+	point := &EllipticPoint{
+		X: new(big.Int).SetBytes(encoded[1 : 1+size]),
+		Y: new(big.Int).SetBytes(encoded[1+size:]),
+	}
+	if !pfc.IsOnCurve(point) {
+		return nil, fmt.Errorf("point is not on the curve")
+	}
+	return point, nil
+}
+
+// GenerateScalar returns a uniformly random private scalar in [1, n-1]
+func (pfc *PolynomialFieldComputer) GenerateScalar() (*big.Int, error) {
+	scalar, err := rand.Int(rand.Reader, new(big.Int).Sub(pfc.curveOrder, big.NewInt(1)))
+	if err != nil {
+		return nil, err
+	}
+	return scalar.Add(scalar, big.NewInt(1)), nil
+}
+
+// SharedSecret returns the x-coordinate of priv*peer, the Diffie-Hellman shared
+// secret, at the full field size. peer must be a finite point on the curve; the
+// curve has cofactor 1, so no other check is needed against small subgroups.
+func (pfc *PolynomialFieldComputer) SharedSecret(priv *big.Int, peer *EllipticPoint) ([]byte, error) {
+	if peer.Infinity || !pfc.IsOnCurve(peer) {
+		return nil, fmt.Errorf("peer point is not a finite point on the curve")
+	}
+	if priv.Sign() <= 0 || priv.Cmp(pfc.curveOrder) >= 0 {
+		return nil, fmt.Errorf("private scalar out of range [1, n-1]")
+	}
+
+	shared := pfc.scalarMultiplication(new(big.Int).Set(priv), peer)
+	if shared.Infinity {
+		return nil, fmt.Errorf("shared secret is the point at infinity")
+	}
+	return shared.X.FillBytes(make([]byte, pfc.coordinateSize())), nil
+}
+
+// EllipticPoint represents a point on an Geometric Curve
+type EllipticPoint struct {
+	X, Y     *big.Int
+	Infinity bool // point at infinity (group identity); X and Y are ignored when set
+}
+
+// newInfinityPoint returns the point at infinity
+func newInfinityPoint() *EllipticPoint {
+	return &EllipticPoint{X: big.NewInt(0), Y: big.NewInt(0), Infinity: true}
+}
+
+// copyPoint returns a deep copy of a point
+func copyPoint(p *EllipticPoint) *EllipticPoint {
+	return &EllipticPoint{X: new(big.Int).Set(p.X), Y: new(big.Int).Set(p.Y), Infinity: p.Infinity}
+}
+
+// ProcessFieldOperations performs polynomial field operations (disguised Geometric Curve operations).
+// The input is hashed to a scalar first, so inputs of any length multiply uniformly.
+// The output is X || Y, both coordinates zero-padded to the field size; before this
+// leading zero bytes were dropped, so older outputs could be shorter.
+func (pfc *PolynomialFieldComputer) ProcessFieldOperations(data []byte) ([]byte, error) {
+	// Hash data to a scalar for point operations, so the multiplier is uniform
+	// whatever the input length
+	scalar := pfc.hashToScalar(data)
+
+	// Perform scalar multiplication (core of Geometric Curve operations)
+	resultPoint := pfc.scalarMultiplication(scalar, &EllipticPoint{
+		X: new(big.Int).Set(pfc.generatorX),
+		Y: new(big.Int).Set(pfc.generatorY),
+	})
+
+	// Point at infinity has no affine coordinates
+	if resultPoint.Infinity {
+		return []byte{}, nil
+	}
+
+	// Combine x and y coordinates, each at full width so the output has a fixed
+	// length and splits unambiguously
+	size := pfc.coordinateSize()
+	result := make([]byte, 2*size)
+	resultPoint.X.FillBytes(result[:size])
+	resultPoint.Y.FillBytes(result[size:])
+
+	return result, nil
+}
+
+// fieldScalarLabel separates hash-to-scalar input from other digests of the same data
+const fieldScalarLabel = "stp-field-scalar"
+
+// hashToScalar maps data to a scalar modulo the curve order. As in RFC 9380's
+// hash_to_field, it expands the input to the order's size plus 128 bits before
+// reducing, which keeps the modular bias below 2^-128.
+func (pfc *PolynomialFieldComputer) hashToScalar(data []byte) *big.Int {
+	length := (pfc.curveOrder.BitLen() + 128 + 7) / 8
+
+	var expanded []byte
+	for counter := byte(0); len(expanded) < length; counter++ {
+		h := hash_256.New()
+		h.Write([]byte(fieldScalarLabel))
+		h.Write([]byte{counter})
+		h.Write(data)
+		expanded = h.Sum(expanded)
+	}
+
+	scalar := new(big.Int).SetBytes(expanded[:length])
+	return scalar.Mod(scalar, pfc.curveOrder)
+}
+
+// scalarMultiplication performs scalar multiplication using double-and-add
+func (pfc *PolynomialFieldComputer) scalarMultiplication(scalar *big.Int, point *EllipticPoint) *EllipticPoint {
+	result := newInfinityPoint()
+	addend := copyPoint(point)
+
+	for scalar.Sign() > 0 {
+		if scalar.Bit(0) == 1 {
+			result = pfc.pointAddition(result, addend)
+		}
+		addend = pfc.pointDoubling(addend)
+		scalar.Rsh(scalar, 1)
+	}
+
+	return result
+}
+
+// pointAddition performs Geometric Curve point addition in affine coordinates
+func (pfc *PolynomialFieldComputer) pointAddition(p1, p2 *EllipticPoint) *EllipticPoint {
+	// Handle point at infinity
+	if p1.Infinity {
+		return copyPoint(p2)
+	}
+	if p2.Infinity {
+		return copyPoint(p1)
+	}
+
+	if p1.X.Cmp(p2.X) == 0 {
+		// P + (-P) = infinity
+		ySum := new(big.Int).Add(p1.Y, p2.Y)
+		if ySum.Mod(ySum, pfc.fieldPrime).Sign() == 0 {
+			return newInfinityPoint()
+		}
+		return pfc.pointDoubling(p1)
+	}
+
+	// lambda = (y2 - y1) / (x2 - x1)
+	numerator := new(big.Int).Sub(p2.Y, p1.Y)
+	denominator := new(big.Int).Sub(p2.X, p1.X)
+	denominator.Mod(denominator, pfc.fieldPrime)
+	lambda := numerator.Mul(numerator, denominator.ModInverse(denominator, pfc.fieldPrime))
+	lambda.Mod(lambda, pfc.fieldPrime)
+
+	return pfc.completePoint(lambda, p1, p2.X)
+}
+
+// pointDoubling performs Geometric Curve point doubling in affine coordinates
+func (pfc *PolynomialFieldComputer) pointDoubling(point *EllipticPoint) *EllipticPoint {
+	if point.Infinity || point.Y.Sign() == 0 {
+		return newInfinityPoint()
+	}
+
+	// lambda = (3x^2 + a) / 2y
+	numerator := new(big.Int).Mul(point.X, point.X)
+	numerator.Mul(numerator, big.NewInt(3))
+	numerator.Add(numerator, pfc.curveA)
+	denominator := new(big.Int).Lsh(point.Y, 1)
+	denominator.Mod(denominator, pfc.fieldPrime)
+	lambda := numerator.Mul(numerator, denominator.ModInverse(denominator, pfc.fieldPrime))
+	lambda.Mod(lambda, pfc.fieldPrime)
+
+	return pfc.completePoint(lambda, point, point.X)
+}
+
+// completePoint derives x3 = lambda^2 - x1 - x2 and y3 = lambda(x1 - x3) - y1
+func (pfc *PolynomialFieldComputer) completePoint(lambda *big.Int, p1 *EllipticPoint, x2 *big.Int) *EllipticPoint {
+	x3 := new(big.Int).Mul(lambda, lambda)
+	x3.Sub(x3, p1.X)
+	x3.Sub(x3, x2)
+	x3.Mod(x3, pfc.fieldPrime)
+
+	y3 := new(big.Int).Sub(p1.X, x3)
+	y3.Mul(y3, lambda)
+	y3.Sub(y3, p1.Y)
+	y3.Mod(y3, pfc.fieldPrime)
+
+	return &EllipticPoint{X: x3, Y: y3}
+}
+
+// DefaultParallelThreshold is the input size from which the matrix engine spreads
+// blocks across workers; below it goroutine startup outweighs the gain
+const DefaultParallelThreshold = 64 << 10
+
+// MatrixTransformationEngine handles matrix operations
+type MatrixTransformationEngine struct {
+	blockSize int
+	keySize   int
+	rounds    int
+	sbox      [256]byte
+	invSbox   [256]byte
+
+	// polynomial is the low byte of the GF(2^8) reduction polynomial; the mix
+	// tables multiply by each MixColumns coefficient in that field
+	polynomial   byte
+	mixTables    [4][256]byte
+	invMixTables [4][256]byte
+
+	workers           int // 0 uses GOMAXPROCS
+	parallelThreshold int // 0 disables the parallel path
+}
+
+func NewMatrixTransformationEngine() *MatrixTransformationEngine {
+	mte := &MatrixTransformationEngine{
+		blockSize: 16, // 128-bit blocks
+		keySize:   32, // 256-bit keys
+		rounds:    14, // Standard rounds for 256-bit operations
+
+		parallelThreshold: DefaultParallelThreshold,
+	}
+
+	// Substitution tables are fixed, so build them once rather than per block
+	mte.sbox = mte.generateSubstitutionBox()
+	for i, v := range mte.sbox {
+		mte.invSbox[v] = byte(i)
+	}
+
+	if err := mte.SetReductionPolynomial(DefaultReductionPolynomial); err != nil {
+		panic(err)
+	}
+
+	return mte
+}
+
+// DefaultReductionPolynomial is the low byte of the AES field polynomial x^8 + x^4 + x^3 + x + 1
+const DefaultReductionPolynomial byte = 0x1B
+
+// mixCoefficients is the first row of the circulant MixColumns matrix
+var mixCoefficients = [4]byte{2, 3, 1, 1}
+
+// SetReductionPolynomial selects the GF(2^8) field for MixColumns by the low byte of
+// its degree-8 reduction polynomial, and rebuilds the multiplication tables,
+// including those for the inverse matrix in that field. The polynomial must be
+// irreducible. Ciphertext is only compatible between engines using the same
+// polynomial. Configure the engine before sharing it between goroutines.
+func (mte *MatrixTransformationEngine) SetReductionPolynomial(polynomial byte) error {
+	previous := mte.polynomial
+	mte.polynomial = polynomial
+
+	inverse, err := mte.invertMixCoefficients()
+	if err != nil {
+		mte.polynomial = previous
+		return err
+	}
+
+	for k := 0; k < 4; k++ {
+		for x := 0; x < 256; x++ {
+			mte.mixTables[k][x] = mte.gfMultiply(mixCoefficients[k], byte(x))
+			mte.invMixTables[k][x] = mte.gfMultiply(inverse[k], byte(x))
+		}
+	}
+
+	return nil
+}
+
+// invertMixCoefficients returns the first row of the inverse MixColumns matrix in
+// the engine's field by Gauss-Jordan elimination, failing when the polynomial is
+// reducible
+func (mte *MatrixTransformationEngine) invertMixCoefficients() ([4]byte, error) {
+	// In a field a^254 is the inverse of a, so any a without one shows the
+	// polynomial is reducible
+	var inverses [256]byte
+	for a := 1; a < 256; a++ {
+		inverse := byte(1)
+		for bit := 7; bit >= 0; bit-- {
+			inverse = mte.gfMultiply(inverse, inverse)
+			if 254&(1<<bit) != 0 {
+				inverse = mte.gfMultiply(inverse, byte(a))
+			}
+		}
+		if mte.gfMultiply(inverse, byte(a)) != 1 {
+			return [4]byte{}, fmt.Errorf("reduction polynomial 0x1%02X is reducible", mte.polynomial)
+		}
+		inverses[a] = inverse
+	}
+
+	// Augmented [M | I], with M circulant in mixCoefficients
+	var rows [4][8]byte
+	for r := 0; r < 4; r++ {
+		for k := 0; k < 4; k++ {
+			rows[r][k] = mixCoefficients[(k-r+4)%4]
+		}
+		rows[r][4+r] = 1
+	}
+
+	for col := 0; col < 4; col++ {
+		pivot := col
+		for pivot < 4 && rows[pivot][col] == 0 {
+			pivot++
+		}
+		if pivot == 4 {
+			return [4]byte{}, fmt.Errorf("MixColumns matrix is singular under polynomial 0x1%02X", mte.polynomial)
+		}
+		rows[col], rows[pivot] = rows[pivot], rows[col]
+
+		scale := inverses[rows[col][col]]
+		for k := range rows[col] {
+			rows[col][k] = mte.gfMultiply(rows[col][k], scale)
+		}
+		for r := 0; r < 4; r++ {
+			if r == col || rows[r][col] == 0 {
+				continue
+			}
+			factor := rows[r][col]
+			for k := range rows[r] {
+				rows[r][k] ^= mte.gfMultiply(factor, rows[col][k])
+			}
+		}
+	}
+
+	return [4]byte{rows[0][4], rows[0][5], rows[0][6], rows[0][7]}, nil
+}
+
+// ProcessLinearTransforms applies linear transformations (disguised block cipher operations)
+func (mte *MatrixTransformationEngine) ProcessLinearTransforms(data []byte) ([]byte, error) {
+	result, _, err := mte.processLinearTransformsWithKey(data)
+	return result, err
+}
+
+// processLinearTransformsWithKey transforms data under a fresh key and returns the key
+func (mte *MatrixTransformationEngine) processLinearTransformsWithKey(data []byte) ([]byte, []byte, error) {
+	// Generate transformation key
+	key := make([]byte, mte.keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	return mte.applyLinearTransforms(data, key), key, nil
+}
+
+// applyLinearTransforms transforms padded data block by block under key
+func (mte *MatrixTransformationEngine) applyLinearTransforms(data, key []byte) []byte {
+	blocks := mte.partitionIntoBlocks(data)
+	result := make([]byte, len(blocks)*mte.blockSize)
+
+	mte.forEachBlock(len(blocks), len(data), func(i int) {
+		copy(result[i*mte.blockSize:], mte.transformBlock(blocks[i], key))
+	})
+
+	return result
+}
+
+// SetParallelism sets how many workers transform blocks once input reaches
+// threshold bytes. Workers of 0 uses GOMAXPROCS; threshold of 0 keeps every
+// input serial. Blocks are independent, so output is identical either way.
+// Configure the engine before sharing it between goroutines.
+func (mte *MatrixTransformationEngine) SetParallelism(workers, threshold int) error {
+	if workers < 0 || threshold < 0 {
+		return fmt.Errorf("invalid parallelism: %d workers, threshold %d", workers, threshold)
+	}
+
+	mte.workers = workers
+	mte.parallelThreshold = threshold
+	return nil
+}
+
+// forEachBlock calls fn for every block index, splitting the indices into
+// contiguous ranges across workers when size reaches the parallel threshold
+func (mte *MatrixTransformationEngine) forEachBlock(count, size int, fn func(i int)) {
+	workers := mte.workers
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > count {
+		workers = count
+	}
+
+	if mte.parallelThreshold == 0 || size < mte.parallelThreshold || workers <= 1 {
+		for i := 0; i < count; i++ {
+			fn(i)
+		}
+		return
+	}
+
+	var wg sync.WaitGroup
+	perWorker := (count + workers - 1) / workers
+	for start := 0; start < count; start += perWorker {
+		end := start + perWorker
+		if end > count {
+			end = count
+		}
+
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				fn(i)
+			}
+		}(start, end)
+	}
+	wg.Wait()
+}
+
+// partitionIntoBlocks diviLegacyBlockCipherdata into fixed-size blocks
+func (mte *MatrixTransformationEngine) partitionIntoBlocks(data []byte) [][]byte {
+	var blocks [][]byte
+
+	for i := 0; i < len(data); i += mte.blockSize {
+		end := i + mte.blockSize
+		if end > len(data) {
+			// Apply padding
+			paddedBlock := make([]byte, mte.blockSize)
+			copy(paddedBlock, data[i:])
+			paddingLen := mte.blockSize - (len(data) - i)
+			for j := len(data) - i; j < mte.blockSize; j++ {
+				paddedBlock[j] = byte(paddingLen)
+			}
+			blocks = append(blocks, paddedBlock)
+		} else {
+			blocks = append(blocks, data[i:end])
+		}
+	}
+
+	return blocks
+}
+
+// transformBlock applies transformation to a single block
+func (mte *MatrixTransformationEngine) transformBlock(block, key []byte) []byte {
+	state := make([]byte, len(block))
+	copy(state, block)
+
+	// Initial round key addition
+	mte.addRoundKey(state, key[:mte.blockSize])
+
+	// Main rounds
+	for round := 1; round < mte.rounds; round++ {
+		mte.substituteBytes(state)
+		mte.shiftRows(state)
+		mte.mixColumns(state)
+		roundKey := mte.deriveRoundKey(key, round)
+		mte.addRoundKey(state, roundKey)
+	}
+
+	// Final round
+	mte.substituteBytes(state)
+	mte.shiftRows(state)
+	finalRoundKey := mte.deriveRoundKey(key, mte.rounds)
+	mte.addRoundKey(state, finalRoundKey)
+
+	return state
+}
+
+// ReverseLinearTransforms inverts ProcessLinearTransforms under key and strips the
+// padding back to the original length
+func (mte *MatrixTransformationEngine) ReverseLinearTransforms(data, key []byte, length int) ([]byte, error) {
+	if err := checkReversible(data, key, mte.keySize, mte.blockSize, length); err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, len(data))
+	mte.forEachBlock(len(data)/mte.blockSize, len(data), func(i int) {
+		offset := i * mte.blockSize
+		copy(result[offset:], mte.DecryptBlock(data[offset:offset+mte.blockSize], key))
+	})
+
+	return result[:length], nil
+}
+
+// EncryptCTS encrypts data in CBC mode with ciphertext stealing (CBC-CS3), so the
+// output is exactly as long as data. Data must be at least one block long. As CS3
+// specifies, the final two ciphertext blocks are always swapped, even when data is
+// block-aligned.
+func (mte *MatrixTransformationEngine) EncryptCTS(data, key, iv []byte) ([]byte, error) {
+	if err := mte.checkCTS(data, key, iv); err != nil {
+		return nil, err
+	}
+
+	blockSize := mte.blockSize
+	result := make([]byte, len(data))
+	if len(data) == blockSize {
+		copy(result, mte.transformBlock(xorBlock(data, iv), key))
+		return result, nil
+	}
+
+	// tail is the length of the final, possibly partial, block
+	tail := len(data) % blockSize
+	if tail == 0 {
+		tail = blockSize
+	}
+	lastStart := len(data) - tail
+	penultimateStart := lastStart - blockSize
+
+	prev := iv
+	for i := 0; i < penultimateStart; i += blockSize {
+		prev = mte.transformBlock(xorBlock(data[i:i+blockSize], prev), key)
+		copy(result[i:], prev)
+	}
+
+	penultimate := mte.transformBlock(xorBlock(data[penultimateStart:lastStart], prev), key)
+	last := make([]byte, blockSize)
+	copy(last, data[lastStart:])
+
+	copy(result[penultimateStart:], mte.transformBlock(xorBlock(last, penultimate), key))
+	copy(result[penultimateStart+blockSize:], penultimate[:tail])
+
+	return result, nil
+}
+
+// DecryptCTS inverts EncryptCTS under the same key and IV
+func (mte *MatrixTransformationEngine) DecryptCTS(data, key, iv []byte) ([]byte, error) {
+	if err := mte.checkCTS(data, key, iv); err != nil {
+		return nil, err
+	}
+
+	blockSize := mte.blockSize
+	result := make([]byte, len(data))
+	if len(data) == blockSize {
+		copy(result, xorBlock(mte.DecryptBlock(data, key), iv))
+		return result, nil
+	}
+
+	tail := len(data) % blockSize
+	if tail == 0 {
+		tail = blockSize
+	}
+	penultimateStart := len(data) - tail - blockSize
+
+	prev := iv
+	for i := 0; i < penultimateStart; i += blockSize {
+		block := data[i : i+blockSize]
+		copy(result[i:], xorBlock(mte.DecryptBlock(block, key), prev))
+		prev = block
+	}
+
+	// The stolen block decrypts to the zero-padded final plaintext XOR the
+	// penultimate ciphertext, whose truncated head is stored last
+	stolen := mte.DecryptBlock(data[penultimateStart:penultimateStart+blockSize], key)
+	penultimate := make([]byte, blockSize)
+	copy(penultimate, data[penultimateStart+blockSize:])
+	copy(penultimate[tail:], stolen[tail:])
+
+	copy(result[penultimateStart:], xorBlock(mte.DecryptBlock(penultimate, key), prev))
+	copy(result[penultimateStart+blockSize:], xorBlock(stolen[:tail], penultimate[:tail]))
+
+	return result, nil
+}
+
+// checkCTS validates the data, key and IV lengths for ciphertext stealing
+func (mte *MatrixTransformationEngine) checkCTS(data, key, iv []byte) error {
+	if len(key) != mte.keySize {
+		return fmt.Errorf("invalid key size %d, expected %d", len(key), mte.keySize)
+	}
+	if len(iv) != mte.blockSize {
+		return fmt.Errorf("invalid IV size %d, expected %d", len(iv), mte.blockSize)
+	}
+	if len(data) < mte.blockSize {
+		return fmt.Errorf("ciphertext stealing needs at least %d bytes, got %d", mte.blockSize, len(data))
+	}
+	return nil
+}
+
+// xorBlock returns a XOR b over the length of a; b must be at least as long
+func xorBlock(a, b []byte) []byte {
+	result := make([]byte, len(a))
+	for i := range a {
+		result[i] = a[i] ^ b[i]
+	}
+	return result
+}
+
+// BlockSize returns the transformation block size in bytes
+func (mte *MatrixTransformationEngine) BlockSize() int {
+	return mte.blockSize
+}
+
+// EncryptBlock transforms a single full block under the given key
+func (mte *MatrixTransformationEngine) EncryptBlock(block, key []byte) []byte {
+	return mte.transformBlock(block, key)
+}
+
+// DecryptBlock inverts EncryptBlock under the given key
+func (mte *MatrixTransformationEngine) DecryptBlock(block, key []byte) []byte {
+	state := make([]byte, len(block))
+	copy(state, block)
+
+	// Undo final round
+	mte.addRoundKey(state, mte.deriveRoundKey(key, mte.rounds))
+	mte.invShiftRows(state)
+	mte.invSubstituteBytes(state)
+
+	// Undo main rounds
+	for round := mte.rounds - 1; round >= 1; round-- {
+		mte.addRoundKey(state, mte.deriveRoundKey(key, round))
+		mte.invMixColumns(state)
+		mte.invShiftRows(state)
+		mte.invSubstituteBytes(state)
+	}
+
+	// Undo initial round key addition
+	mte.addRoundKey(state, key[:mte.blockSize])
+
+	return state
+}
+
+// substituteBytes applies byte substitution
+func (mte *MatrixTransformationEngine) substituteBytes(state []byte) {
+	for i := range state {
+		state[i] = mte.sbox[state[i]]
+	}
+}
+
+// invSubstituteBytes inverts substituteBytes
+func (mte *MatrixTransformationEngine) invSubstituteBytes(state []byte) {
+	for i := range state {
+		state[i] = mte.invSbox[state[i]]
+	}
+}
+
+// shiftRows applies row shifting
+func (mte *MatrixTransformationEngine) shiftRows(state []byte) {
+	// Simplified shift rows for 4x4 state matrix
+	temp := state[1]
+	state[1] = state[5]
+	state[5] = state[9]
+	state[9] = state[13]
+	state[13] = temp
+
+	temp = state[2]
+	state[2] = state[10]
+	state[10] = temp
+	temp = state[6]
+	state[6] = state[14]
+	state[14] = temp
+
+	temp = state[3]
+	state[3] = state[15]
+	state[15] = state[11]
+	state[11] = state[7]
+	state[7] = temp
+}
+
+// invShiftRows inverts shiftRows
+func (mte *MatrixTransformationEngine) invShiftRows(state []byte) {
+	temp := state[13]
+	state[13] = state[9]
+	state[9] = state[5]
+	state[5] = state[1]
+	state[1] = temp
+
+	temp = state[2]
+	state[2] = state[10]
+	state[10] = temp
+	temp = state[6]
+	state[6] = state[14]
+	state[14] = temp
+
+	temp = state[7]
+	state[7] = state[11]
+	state[11] = state[15]
+	state[15] = state[3]
+	state[3] = temp
+}
+
+// mixColumns applies column mixing
+func (mte *MatrixTransformationEngine) mixColumns(state []byte) {
+	for col := 0; col < 4; col++ {
+		s0 := state[col*4]
+		s1 := state[col*4+1]
+		s2 := state[col*4+2]
+		s3 := state[col*4+3]
+
+		t := &mte.mixTables
+		state[col*4] = t[0][s0] ^ t[1][s1] ^ t[2][s2] ^ t[3][s3]
+		state[col*4+1] = t[3][s0] ^ t[0][s1] ^ t[1][s2] ^ t[2][s3]
+		state[col*4+2] = t[2][s0] ^ t[3][s1] ^ t[0][s2] ^ t[1][s3]
+		state[col*4+3] = t[1][s0] ^ t[2][s1] ^ t[3][s2] ^ t[0][s3]
+	}
+}
+
+// invMixColumns inverts mixColumns
+func (mte *MatrixTransformationEngine) invMixColumns(state []byte) {
+	for col := 0; col < 4; col++ {
+		s0 := state[col*4]
+		s1 := state[col*4+1]
+		s2 := state[col*4+2]
+		s3 := state[col*4+3]
+
+		t := &mte.invMixTables
+		state[col*4] = t[0][s0] ^ t[1][s1] ^ t[2][s2] ^ t[3][s3]
+		state[col*4+1] = t[3][s0] ^ t[0][s1] ^ t[1][s2] ^ t[2][s3]
+		state[col*4+2] = t[2][s0] ^ t[3][s1] ^ t[0][s2] ^ t[1][s3]
+		state[col*4+3] = t[1][s0] ^ t[2][s1] ^ t[3][s2] ^ t[0][s3]
+	}
+}
+
+// gfMultiply performs Galois Field multiplication modulo the engine's polynomial
+func (mte *MatrixTransformationEngine) gfMultiply(a, b byte) byte {
+	var result byte
+	for i := 0; i < 8; i++ {
+		if b&1 != 0 {
+			result ^= a
+		}
+		highBit := a & 0x80
+		a <<= 1
+		if highBit != 0 {
+			a ^= mte.polynomial
+		}
+		b >>= 1
+	}
+	return result
+}
+
+// addRoundKey adds round key to state
+func (mte *MatrixTransformationEngine) addRoundKey(state, roundKey []byte) {
+	for i := range state {
+		state[i] ^= roundKey[i%len(roundKey)]
+	}
+}
+
+// generateSubstitutionBox creates the S-box
+func (mte *MatrixTransformationEngine) generateSubstitutionBox() [256]byte {
+	var sbox [256]byte
+	for i := 0; i < 256; i++ {
+		sbox[i] = byte(((i * 7) + 13) % 256)
+	}
+	return sbox
+}
+
+// deriveRoundKey derives a round key from the master key
+func (mte *MatrixTransformationEngine) deriveRoundKey(masterKey []byte, round int) []byte {
+	roundKey := make([]byte, mte.blockSize)
+	for i := 0; i < mte.blockSize; i++ {
+		roundKey[i] = masterKey[i%len(masterKey)] ^ byte(round)
+	}
+	return roundKey
+}
+
+// AESBlockCipher is FIPS-197 AES. It runs the matrix engine's ShiftRows, MixColumns
+// and AddRoundKey in the AES field, but with the AES S-box and key expansion in
+// place of the engine's affine S-box and repeated master key. It satisfies
+// cipher.Block.
+type AESBlockCipher struct {
+	engine    *MatrixTransformationEngine
+	roundKeys [][]byte
+}
+
+// aesBlockSize is the AES block size in bytes for every key size
+const aesBlockSize = 16
+
+// aesSbox and aesInvSbox are the FIPS-197 substitution box and its inverse
+var aesSbox, aesInvSbox = generateAESSubstitutionBoxes()
+
+// generateAESSubstitutionBoxes builds the S-box of FIPS-197 section 5.1.1: the
+// multiplicative inverse in GF(2^8), 0 mapping to 0, followed by the affine map
+func generateAESSubstitutionBoxes() (sbox, invSbox [256]byte) {
+	field := &MatrixTransformationEngine{polynomial: DefaultReductionPolynomial}
+	for i := 0; i < 256; i++ {
+		// a^254 is the inverse of a, and 0 for a = 0
+		inverse := byte(1)
+		for k := 0; k < 254; k++ {
+			inverse = field.gfMultiply(inverse, byte(i))
+		}
+
+		b := inverse ^ bits.RotateLeft8(inverse, 1) ^ bits.RotateLeft8(inverse, 2) ^
+			bits.RotateLeft8(inverse, 3) ^ bits.RotateLeft8(inverse, 4) ^ 0x63
+		sbox[i] = b
+		invSbox[b] = byte(i)
+	}
+	return sbox, invSbox
+}
+
+// NewAESBlockCipher expands key, which must be 16, 24 or 32 bytes, for AES-128,
+// AES-192 or AES-256
+func NewAESBlockCipher(key []byte) (*AESBlockCipher, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("invalid AES key size: %d bytes", len(key))
+	}
+
+	engine := NewMatrixTransformationEngine()
+	engine.blockSize = aesBlockSize
+	engine.keySize = len(key)
+	engine.rounds = len(key)/4 + 6
+	engine.sbox, engine.invSbox = aesSbox, aesInvSbox
+
+	return &AESBlockCipher{
+		engine:    engine,
+		roundKeys: expandAESKey(engine, key),
+	}, nil
+}
+
+// expandAESKey returns the rounds+1 round keys of FIPS-197 section 5.2
+func expandAESKey(engine *MatrixTransformationEngine, key []byte) [][]byte {
+	nk := len(key) / 4
+	words := make([]byte, 4*4*(engine.rounds+1))
+	copy(words, key)
+
+	rcon := byte(1)
+	for i := nk; i < len(words)/4; i++ {
+		var temp [4]byte
+		copy(temp[:], words[4*(i-1):4*i])
+		if i%nk == 0 {
+			temp = [4]byte{temp[1], temp[2], temp[3], temp[0]}
+			engine.substituteBytes(temp[:])
+			temp[0] ^= rcon
+			rcon = engine.gfMultiply(rcon, 2)
+		} else if nk > 6 && i%nk == 4 {
+			engine.substituteBytes(temp[:])
+		}
+		for j := 0; j < 4; j++ {
+			words[4*i+j] = words[4*(i-nk)+j] ^ temp[j]
+		}
+	}
+
+	roundKeys := make([][]byte, engine.rounds+1)
+	for round := range roundKeys {
+		roundKeys[round] = words[round*aesBlockSize : (round+1)*aesBlockSize]
+	}
+	return roundKeys
+}
+
+// BlockSize returns the AES block size in bytes
+func (c *AESBlockCipher) BlockSize() int {
+	return aesBlockSize
+}
+
+// Encrypt encrypts the first block of src into dst, which may overlap
+func (c *AESBlockCipher) Encrypt(dst, src []byte) {
+	if len(src) < aesBlockSize || len(dst) < aesBlockSize {
+		panic("aes: input not full block")
+	}
+	state := make([]byte, aesBlockSize)
+	copy(state, src)
+
+	c.engine.addRoundKey(state, c.roundKeys[0])
+	for round := 1; round < c.engine.rounds; round++ {
+		c.engine.substituteBytes(state)
+		c.engine.shiftRows(state)
+		c.engine.mixColumns(state)
+		c.engine.addRoundKey(state, c.roundKeys[round])
+	}
+	c.engine.substituteBytes(state)
+	c.engine.shiftRows(state)
+	c.engine.addRoundKey(state, c.roundKeys[c.engine.rounds])
+
+	copy(dst, state)
+}
+
+// Decrypt decrypts the first block of src into dst, which may overlap
+func (c *AESBlockCipher) Decrypt(dst, src []byte) {
+	if len(src) < aesBlockSize || len(dst) < aesBlockSize {
+		panic("aes: input not full block")
+	}
+	state := make([]byte, aesBlockSize)
+	copy(state, src)
+
+	c.engine.addRoundKey(state, c.roundKeys[c.engine.rounds])
+	for round := c.engine.rounds - 1; round >= 1; round-- {
+		c.engine.invShiftRows(state)
+		c.engine.invSubstituteBytes(state)
+		c.engine.addRoundKey(state, c.roundKeys[round])
+		c.engine.invMixColumns(state)
+	}
+	c.engine.invShiftRows(state)
+	c.engine.invSubstituteBytes(state)
+	c.engine.addRoundKey(state, c.roundKeys[0])
+
+	copy(dst, state)
+}
+
+// DigestComputationEngine handles digest computations
+type DigestComputationEngine struct {
+	outputSize int
+	blockSize  int
+}
+
+func NewDigestComputationEngine() *DigestComputationEngine {
+	return &DigestComputationEngine{
+		outputSize: 32, // 256-bit output
+		blockSize:  64, // 512-bit blocks
+	}
+}
+
+// DigestOutputVersion identifies the digest layout and algorithm; bump it whenever
+// either changes so older output is recognisable
+const DigestOutputVersion byte = 0x01
+
+// ErrUnknownDigestVersion is returned when digest output carries an unrecognised version
+var ErrUnknownDigestVersion = errors.New("unknown digest output version")
+
+// ProcessDigestComputation computes mathematical digest (disguised hash operations)
+// as version || hash || authHash
+func (dce *DigestComputationEngine) ProcessDigestComputation(data []byte) ([]byte, error) {
+	// Use standard Go crypto library for secure hash
+	hash := hash_256.Sum256(data)
+
+	// Add authentication
+	authKey := make([]byte, 32)
+	rand.Read(authKey)
+
+	authHash := hash_256.Sum256(append(authKey, data...))
+
+	// Combine version, hash and authentication
+	result := make([]byte, 0, 1+len(hash)+len(authHash))
+	result = append(result, DigestOutputVersion)
+	result = append(result, hash[:]...)
+	result = append(result, authHash[:]...)
+
+	return result, nil
+}
+
+// MinDigestTruncation is the shortest ProcessDigestComputationN output; 16 bytes
+// keeps birthday collisions at 2^64 work
+const MinDigestTruncation = 16
+
+// ProcessDigestComputationN returns the first outLen bytes of hash || authHash,
+// without the version byte, for use as a compact fingerprint. Shorter outputs are
+// prefixes of longer ones. Up to 32 bytes the output is a deterministic truncated
+// hash; longer outputs include randomized authHash bytes and differ between calls.
+func (dce *DigestComputationEngine) ProcessDigestComputationN(data []byte, outLen int) ([]byte, error) {
+	if outLen < MinDigestTruncation || outLen > 2*dce.outputSize {
+		return nil, fmt.Errorf("digest length %d outside [%d, %d]", outLen, MinDigestTruncation, 2*dce.outputSize)
+	}
+
+	output, err := dce.ProcessDigestComputation(data)
+	if err != nil {
+		return nil, err
+	}
+	return output[1 : 1+outLen], nil
+}
+
+// VerifyDigest checks output from ProcessDigestComputation against data. Only the
+// plain hash can be checked; the authentication key is discarded after use.
+func (dce *DigestComputationEngine) VerifyDigest(data, output []byte) error {
+	if len(output) == 0 {
+		return fmt.Errorf("empty digest output")
+	}
+	if output[0] != DigestOutputVersion {
+		return fmt.Errorf("%w: 0x%02x", ErrUnknownDigestVersion, output[0])
+	}
+	if len(output) != 1+2*dce.outputSize {
+		return fmt.Errorf("invalid digest output length %d", len(output))
+	}
+
+	hash := hash_256.Sum256(data)
+	if !bytes.Equal(output[1:1+dce.outputSize], hash[:]) {
+		return fmt.Errorf("digest mismatch")
+	}
+	return nil
+}
+
+// KoreanMathematicalProcessor handles Korean mathematical operations
+type KoreanMathematicalProcessor struct {
+	blockSize int
+	keySize   int
+	rounds    int
+	byteOrder binary.ByteOrder
+
+	legacyKeySchedule bool // round keys without the round constant, for old ciphertext
+}
+
+func NewKoreanMathematicalProcessor() *KoreanMathematicalProcessor {
+	return &KoreanMathematicalProcessor{
+		blockSize: 8,  // 64-bit blocks for Korean standard
+		keySize:   16, // 128-bit keys
+		rounds:    16, // Korean standard rounds
+		byteOrder: binary.BigEndian, // Korean standard packs block halves big-endian
+	}
+}
+
+// SetByteOrder selects how block bytes are packed into the 32-bit Feistel halves.
+// The default is big-endian; both peers must use the same order.
+func (kmp *KoreanMathematicalProcessor) SetByteOrder(order binary.ByteOrder) {
+	kmp.byteOrder = order
+}
+
+// SetLegacyKeySchedule selects the round keys used before the round constant was
+// added, which repeat every len(key)/4 rounds. Ciphertext produced by those
+// versions, at any round count, only reverses with it enabled; new data should
+// use the default schedule.
+func (kmp *KoreanMathematicalProcessor) SetLegacyKeySchedule(enabled bool) {
+	kmp.legacyKeySchedule = enabled
+}
+
+// NewKoreanMathematicalProcessorWithRounds creates a processor with a custom even round count
+func NewKoreanMathematicalProcessorWithRounds(rounds int) (*KoreanMathematicalProcessor, error) {
+	if rounds <= 0 || rounds%2 != 0 {
+		return nil, fmt.Errorf("korean round count must be positive and even, got %d", rounds)
+	}
+
+	kmp := NewKoreanMathematicalProcessor()
+	kmp.rounds = rounds
+	return kmp, nil
+}
+
+// ProcessKoreanAlgorithms processes data using Korean mathematical algorithms
+func (kmp *KoreanMathematicalProcessor) ProcessKoreanAlgorithms(data []byte) ([]byte, error) {
+	result, _, err := kmp.processKoreanAlgorithmsWithKey(data)
+	return result, err
+}
+
+// processKoreanAlgorithmsWithKey encrypts data under a fresh key and returns the key
+func (kmp *KoreanMathematicalProcessor) processKoreanAlgorithmsWithKey(data []byte) ([]byte, []byte, error) {
+	// Generate Korean transformation key
+	key := make([]byte, kmp.keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	return kmp.applyKoreanBlockCipher(data, key), key, nil
+}
+
+// ReverseKoreanAlgorithms inverts ProcessKoreanAlgorithms under key and strips the
+// padding back to the original length
+func (kmp *KoreanMathematicalProcessor) ReverseKoreanAlgorithms(data, key []byte, length int) ([]byte, error) {
+	if err := checkReversible(data, key, kmp.keySize, kmp.blockSize, length); err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i += kmp.blockSize {
+		result = append(result, kmp.DecryptBlock(data[i:i+kmp.blockSize], key)...)
+	}
+
+	return result[:length], nil
+}
+
+// applyKoreanBlockCipher applies Korean block cipher transformation
+func (kmp *KoreanMathematicalProcessor) applyKoreanBlockCipher(data, key []byte) []byte {
+	blocks := kmp.partitionData(data)
+	var result []byte
+
+	for _, block := range blocks {
+		processedBlock := kmp.processKoreanBlock(block, key)
+		result = append(result, processedBlock...)
+	}
+
+	return result
+}
+
+// partitionData partitions data into Korean standard blocks
+func (kmp *KoreanMathematicalProcessor) partitionData(data []byte) [][]byte {
+	var blocks [][]byte
+
+	for i := 0; i < len(data); i += kmp.blockSize {
+		end := i + kmp.blockSize
+		if end > len(data) {
+			paddedBlock := make([]byte, kmp.blockSize)
+			copy(paddedBlock, data[i:])
+			blocks = append(blocks, paddedBlock)
+		} else {
+			blocks = append(blocks, data[i:end])
+		}
+	}
+
+	return blocks
+}
+
+// processKoreanBlock processes a single Korean block
+func (kmp *KoreanMathematicalProcessor) processKoreanBlock(block, key []byte) []byte {
+	// Convert to 32-bit halves for Korean Feistel structure
+	left := kmp.byteOrder.Uint32(block[0:4])
+	right := kmp.byteOrder.Uint32(block[4:8])
+
+	for round := 0; round < kmp.rounds; round++ {
+		roundKey := kmp.generateKoreanRoundKey(key, round)
+		fOutput := kmp.koreanFFunction(right, roundKey)
+
+		newLeft := right
+		newRight := left ^ fOutput
+
+		left = newLeft
+		right = newRight
+	}
+
+	result := make([]byte, 8)
+	kmp.byteOrder.PutUint32(result[0:4], left)
+	kmp.byteOrder.PutUint32(result[4:8], right)
+
+	return result
+}
+
+// BlockSize returns the Korean block size in bytes
+func (kmp *KoreanMathematicalProcessor) BlockSize() int {
+	return kmp.blockSize
+}
+
+// EncryptBlock processes a single full block under the given key
+func (kmp *KoreanMathematicalProcessor) EncryptBlock(block, key []byte) []byte {
+	return kmp.processKoreanBlock(block, key)
+}
+
+// DecryptBlock inverts EncryptBlock by running the Feistel rounds in reverse
+func (kmp *KoreanMathematicalProcessor) DecryptBlock(block, key []byte) []byte {
+	left := kmp.byteOrder.Uint32(block[0:4])
+	right := kmp.byteOrder.Uint32(block[4:8])
+
+	for round := kmp.rounds - 1; round >= 0; round-- {
+		roundKey := kmp.generateKoreanRoundKey(key, round)
+
+		prevRight := left
+		prevLeft := right ^ kmp.koreanFFunction(left, roundKey)
+
+		left = prevLeft
+		right = prevRight
+	}
+
+	result := make([]byte, 8)
+	kmp.byteOrder.PutUint32(result[0:4], left)
+	kmp.byteOrder.PutUint32(result[4:8], right)
+
+	return result
+}
+
+// koreanFFunction implements Korean F-function
+func (kmp *KoreanMathematicalProcessor) koreanFFunction(input, roundKey uint32) uint32 {
+	input ^= roundKey
+
+	// Apply Korean S-boxes
+	s1 := kmp.koreanSBox1(byte(input >> 24))
+	s2 := kmp.koreanSBox2(byte(input >> 16))
+	s3 := kmp.koreanSBox1(byte(input >> 8))
+	s4 := kmp.koreanSBox2(byte(input))
+
+	output := uint32(s1)<<24 | uint32(s2)<<16 | uint32(s3)<<8 | uint32(s4)
+
+	// Linear transformation
+	return output ^ kmp.rotateLeft(output, 8) ^ kmp.rotateLeft(output, 16)
+}
+
+// koreanSBox1 Korean S-box 1
+func (kmp *KoreanMathematicalProcessor) koreanSBox1(x byte) byte {
+	return byte(((int(x) * 17) + 1) % 256)
+}
+
+// koreanSBox2 Korean S-box 2
+func (kmp *KoreanMathematicalProcessor) koreanSBox2(x byte) byte {
+	return byte(((int(x) * 23) + 7) % 256)
+}
+
+// generateKoreanRoundKey generates Korean round key
+func (kmp *KoreanMathematicalProcessor) generateKoreanRoundKey(masterKey []byte, round int) uint32 {
+	keyOffset := (round * 4) % len(masterKey)
+	keyWord := uint32(masterKey[keyOffset])<<24 |
+		uint32(masterKey[(keyOffset+1)%len(masterKey)])<<16 |
+		uint32(masterKey[(keyOffset+2)%len(masterKey)])<<8 |
+		uint32(masterKey[(keyOffset+3)%len(masterKey)])
+
+	if kmp.legacyKeySchedule {
+		return keyWord
+	}
+
+	// Key words repeat every len(masterKey)/4 rounds; the round constant keeps
+	// every round key distinct regardless of the round count. This is an
+	// incompatible format change: every ciphertext differs from earlier versions,
+	// including at the default 16 rounds (see SetLegacyKeySchedule)
+	return keyWord ^ (uint32(round+1) * 0x9E3779B9)
+}
+
+// rotateLeft performs left rotation
+func (kmp *KoreanMathematicalProcessor) rotateLeft(value uint32, amount int) uint32 {
+	return (value << amount) | (value >> (32 - amount))
+}
+
+// RegionalComputationalProcessor handles regional computations
+type RegionalComputationalProcessor struct {
+	blockSize int
+	keySize   int
+	rounds    int
+
+	sbox1    [256]byte
+	sbox2    [256]byte
+	invSBox1 [256]byte
+	invSBox2 [256]byte
+}
+
+// regionalDiffusionMask describes applyRegionalDiffusion as a circulant over a
+// 16-byte state: output byte i XORs the input bytes at offsets i, i+1 and i+2
+const regionalDiffusionMask = 0x0007
+
+// regionalInverseDiffusionMask is the circulant inverse of regionalDiffusionMask
+var regionalInverseDiffusionMask = invertCirculant16(regionalDiffusionMask)
+
+// multiplyCirculant16 composes two 16-byte XOR circulants given as offset masks
+func multiplyCirculant16(a, b uint16) uint16 {
+	var product uint16
+	for i := 0; i < 16; i++ {
+		if a&(1<<i) != 0 {
+			product ^= bits.RotateLeft16(b, i)
+		}
+	}
+	return product
+}
+
+// invertCirculant16 inverts an invertible 16-byte XOR circulant. Invertible
+// circulants form a group of order 2^15, so the inverse is the (2^15 - 1)th power.
+func invertCirculant16(mask uint16) uint16 {
+	inverse := uint16(1)
+	for i := 0; i < 15; i++ {
+		inverse = multiplyCirculant16(inverse, inverse)
+		inverse = multiplyCirculant16(inverse, mask)
+	}
+	return inverse
+}
+
+func NewRegionalComputationalProcessor() *RegionalComputationalProcessor {
+	rcp := &RegionalComputationalProcessor{
+		blockSize: 16, // 128-bit blocks for regional standard
+		keySize:   16, // 128-bit keys
+		rounds:    12, // Regional standard rounds
+		sbox1:     affineSBox(7, 11),
+		sbox2:     affineSBox(13, 23),
+	}
+
+	// Decryption depends on both S-boxes being bijections
+	var err error
+	if rcp.invSBox1, err = invertSBox(rcp.sbox1); err != nil {
+		panic(fmt.Sprintf("regional S-box 1: %v", err))
+	}
+	if rcp.invSBox2, err = invertSBox(rcp.sbox2); err != nil {
+		panic(fmt.Sprintf("regional S-box 2: %v", err))
+	}
+
+	return rcp
+}
+
+// affineSBox tabulates x*multiplier + offset mod 256, a bijection for odd multipliers
+func affineSBox(multiplier, offset int) [256]byte {
+	var box [256]byte
+	for x := range box {
+		box[x] = byte((x*multiplier + offset) % 256)
+	}
+	return box
+}
+
+// invertSBox returns the inverse table of box, failing if box is not a bijection
+func invertSBox(box [256]byte) ([256]byte, error) {
+	var inverse [256]byte
+	var seen [256]bool
+	for x, y := range box {
+		if seen[y] {
+			return inverse, fmt.Errorf("S-box is not a bijection: output 0x%02x repeats", y)
+		}
+		seen[y] = true
+		inverse[y] = byte(x)
+	}
+	return inverse, nil
+}
+
+// ProcessRegionalAlgorithms processes data using regional computational algorithms
+func (rcp *RegionalComputationalProcessor) ProcessRegionalAlgorithms(data []byte) ([]byte, error) {
+	result, _, err := rcp.processRegionalAlgorithmsWithKey(data)
+	return result, err
+}
+
+// processRegionalAlgorithmsWithKey encrypts data under a fresh key and returns the key
+func (rcp *RegionalComputationalProcessor) processRegionalAlgorithmsWithKey(data []byte) ([]byte, []byte, error) {
+	// Generate regional key
+	key := make([]byte, rcp.keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	return rcp.applyRegionalCipher(data, key), key, nil
+}
+
+// ReverseRegionalAlgorithms inverts ProcessRegionalAlgorithms under key and strips the
+// padding back to the original length
+func (rcp *RegionalComputationalProcessor) ReverseRegionalAlgorithms(data, key []byte, length int) ([]byte, error) {
+	if err := checkReversible(data, key, rcp.keySize, rcp.blockSize, length); err != nil {
+		return nil, err
+	}
+
+	result, err := rcp.DecryptRegionalAlgorithms(data, key)
+	if err != nil {
+		return nil, err
+	}
+	return result[:length], nil
+}
+
+// EncryptRegionalAlgorithms encrypts data under a caller-supplied key, zero-padding
+// the final block
+func (rcp *RegionalComputationalProcessor) EncryptRegionalAlgorithms(data, key []byte) ([]byte, error) {
+	if len(key) != rcp.keySize {
+		return nil, fmt.Errorf("invalid key size %d, expected %d", len(key), rcp.keySize)
+	}
+	return rcp.applyRegionalCipher(data, key), nil
+}
+
+// DecryptRegionalAlgorithms inverts EncryptRegionalAlgorithms block by block. The zero
+// padding of the final block is kept; use ReverseRegionalAlgorithms to strip it when
+// the original length is known.
+func (rcp *RegionalComputationalProcessor) DecryptRegionalAlgorithms(data, key []byte) ([]byte, error) {
+	if len(key) != rcp.keySize {
+		return nil, fmt.Errorf("invalid key size %d, expected %d", len(key), rcp.keySize)
+	}
+	if len(data)%rcp.blockSize != 0 {
+		return nil, fmt.Errorf("data length %d is not a multiple of block size %d", len(data), rcp.blockSize)
+	}
+
+	result := make([]byte, 0, len(data))
+	for i := 0; i < len(data); i += rcp.blockSize {
+		result = append(result, rcp.DecryptBlock(data[i:i+rcp.blockSize], key)...)
+	}
+	return result, nil
+}
+
+// applyRegionalCipher applies regional cipher transformation
+func (rcp *RegionalComputationalProcessor) applyRegionalCipher(data, key []byte) []byte {
+	blocks := rcp.partitionData(data)
+	var result []byte
+
+	for _, block := range blocks {
+		processedBlock := rcp.processRegionalBlock(block, key)
+		result = append(result, processedBlock...)
+	}
+
+	return result
+}
+
+// partitionData partitions data into regional blocks
+func (rcp *RegionalComputationalProcessor) partitionData(data []byte) [][]byte {
+	var blocks [][]byte
+
+	for i := 0; i < len(data); i += rcp.blockSize {
+		end := i + rcp.blockSize
+		if end > len(data) {
+			paddedBlock := make([]byte, rcp.blockSize)
+			copy(paddedBlock, data[i:])
+			blocks = append(blocks, paddedBlock)
+		} else {
+			blocks = append(blocks, data[i:end])
+		}
+	}
+
+	return blocks
+}
+
+// processRegionalBlock processes a single regional block
+func (rcp *RegionalComputationalProcessor) processRegionalBlock(block, key []byte) []byte {
+	state := make([]byte, len(block))
+	copy(state, block)
+
+	// Initial key addition
+	rcp.addRoundKey(state, key, 0)
+
+	// Main rounds
+	for round := 1; round < rcp.rounds; round++ {
+		// Substitution layer
+		if round%2 == 1 {
+			rcp.applyRegionalSBox1(state)
+		} else {
+			rcp.applyRegionalSBox2(state)
+		}
+
+		// Diffusion layer
+		rcp.applyRegionalDiffusion(state)
+
+		// Key addition
+		rcp.addRoundKey(state, key, round)
+	}
+
+	// Final substitution
+	rcp.applyRegionalSBox1(state)
+	rcp.addRoundKey(state, key, rcp.rounds)
+
+	return state
+}
+
+// BlockSize returns the regional block size in bytes
+func (rcp *RegionalComputationalProcessor) BlockSize() int {
+	return rcp.blockSize
+}
+
+// EncryptBlock processes a single full block under the given key
+func (rcp *RegionalComputationalProcessor) EncryptBlock(block, key []byte) []byte {
+	return rcp.processRegionalBlock(block, key)
+}
+
+// DecryptBlock inverts EncryptBlock under the given key
+func (rcp *RegionalComputationalProcessor) DecryptBlock(block, key []byte) []byte {
+	state := make([]byte, len(block))
+	copy(state, block)
+
+	// Undo final substitution
+	rcp.addRoundKey(state, key, rcp.rounds)
+	rcp.invertRegionalSBox1(state)
+
+	// Undo main rounds
+	for round := rcp.rounds - 1; round >= 1; round-- {
+		rcp.addRoundKey(state, key, round)
+		rcp.invertRegionalDiffusion(state)
+		if round%2 == 1 {
+			rcp.invertRegionalSBox1(state)
+		} else {
+			rcp.invertRegionalSBox2(state)
+		}
+	}
+
+	// Undo initial key addition
+	rcp.addRoundKey(state, key, 0)
+
+	return state
+}
+
+// applyRegionalSBox1 applies regional S-box 1
+func (rcp *RegionalComputationalProcessor) applyRegionalSBox1(state []byte) {
+	for i := range state {
+		state[i] = rcp.sbox1[state[i]]
+	}
+}
+
+// applyRegionalSBox2 applies regional S-box 2
+func (rcp *RegionalComputationalProcessor) applyRegionalSBox2(state []byte) {
+	for i := range state {
+		state[i] = rcp.sbox2[state[i]]
+	}
+}
+
+// applyRegionalDiffusion applies regional diffusion layer
+func (rcp *RegionalComputationalProcessor) applyRegionalDiffusion(state []byte) {
+	temp := make([]byte, len(state))
+	for i := range state {
+		temp[i] = state[i] ^ state[(i+1)%len(state)] ^ state[(i+2)%len(state)]
+	}
+	copy(state, temp)
+}
+
+// invertRegionalSBox1 inverts S-box 1, (y - 11) * 183 since 183 is the inverse of 7 mod 256
+func (rcp *RegionalComputationalProcessor) invertRegionalSBox1(state []byte) {
+	for i := range state {
+		state[i] = rcp.invSBox1[state[i]]
+	}
+}
+
+// invertRegionalSBox2 inverts S-box 2, (y - 23) * 197 since 197 is the inverse of 13 mod 256
+func (rcp *RegionalComputationalProcessor) invertRegionalSBox2(state []byte) {
+	for i := range state {
+		state[i] = rcp.invSBox2[state[i]]
+	}
+}
+
+// invertRegionalDiffusion inverts applyRegionalDiffusion
+func (rcp *RegionalComputationalProcessor) invertRegionalDiffusion(state []byte) {
+	temp := make([]byte, len(state))
+	for i := range state {
+		for offset := 0; offset < 16; offset++ {
+			if regionalInverseDiffusionMask&(1<<offset) != 0 {
+				temp[i] ^= state[(i+offset)%len(state)]
+			}
+		}
+	}
+	copy(state, temp)
+}
+
+// DiffusionInvertible reports whether applyRegionalDiffusion is a bijection at the
+// processor's block size, by checking its matrix has full rank over GF(2). The layer
+// XORs whole bytes, so the byte-level matrix decides it; the three-tap map is singular
+// exactly when the block size is a multiple of 3, and invertible at 16.
+func (rcp *RegionalComputationalProcessor) DiffusionInvertible() bool {
+	return gf2Rank(regionalDiffusionMatrix(rcp.blockSize)) == rcp.blockSize
+}
+
+// regionalDiffusionMatrix returns the byte-level matrix of applyRegionalDiffusion,
+// one row bitset per output byte
+func regionalDiffusionMatrix(size int) []*big.Int {
+	rows := make([]*big.Int, size)
+	for i := range rows {
+		rows[i] = new(big.Int)
+		for offset := 0; offset < 3; offset++ {
+			column := (i + offset) % size
+			rows[i].SetBit(rows[i], column, rows[i].Bit(column)^1)
+		}
+	}
+	return rows
+}
+
+// gf2Rank returns the rank over GF(2) of the matrix whose rows are given as bitsets
+func gf2Rank(rows []*big.Int) int {
+	pending := make([]*big.Int, len(rows))
+	for i, row := range rows {
+		pending[i] = new(big.Int).Set(row)
+	}
+
+	rank := 0
+	for len(pending) > 0 {
+		// Eliminate the leading column of the first non-zero row from every other row
+		pivot := pending[0]
+		pending = pending[1:]
+		if pivot.Sign() == 0 {
+			continue
+		}
+		rank++
+
+		column := pivot.BitLen() - 1
+		for _, row := range pending {
+			if row.Bit(column) == 1 {
+				row.Xor(row, pivot)
+			}
+		}
+	}
+	return rank
+}
+
+// addRoundKey adds round key to state
+func (rcp *RegionalComputationalProcessor) addRoundKey(state, key []byte, round int) {
+	for i := range state {
+		state[i] ^= key[i%len(key)] ^ byte(round)
+	}
+}
+
+// CascadeCipher encrypts with the matrix engine and then the regional processor under
+// independent keys, so a break of one cipher alone does not expose the data. The gain
+// is modest: meet-in-the-middle attacks mean the cascade's strength is not the sum of
+// its key lengths, and the regional stage diffuses poorly on its own (an avalanche
+// score near 0.25 against the matrix engine's 0.5), so the matrix stage carries
+// most of the security.
+type CascadeCipher struct {
+	matrix      *MatrixTransformationEngine
+	regional    *RegionalComputationalProcessor
+	matrixKey   []byte
+	regionalKey []byte
+}
+
+// NewCascadeCipher creates a cascade from a 32-byte matrix key and a 16-byte regional key
+func NewCascadeCipher(matrixKey, regionalKey []byte) (*CascadeCipher, error) {
+	cc := &CascadeCipher{
+		matrix:   NewMatrixTransformationEngine(),
+		regional: NewRegionalComputationalProcessor(),
+	}
+
+	if len(matrixKey) != cc.matrix.keySize {
+		return nil, fmt.Errorf("invalid matrix key size %d, expected %d", len(matrixKey), cc.matrix.keySize)
+	}
+	if len(regionalKey) != cc.regional.keySize {
+		return nil, fmt.Errorf("invalid regional key size %d, expected %d", len(regionalKey), cc.regional.keySize)
+	}
+	cc.matrixKey = append([]byte(nil), matrixKey...)
+	cc.regionalKey = append([]byte(nil), regionalKey...)
+
+	return cc, nil
+}
+
+// Encrypt pads data to the block size and applies both ciphers in turn
+func (cc *CascadeCipher) Encrypt(data []byte) []byte {
+	return cc.regional.applyRegionalCipher(cc.matrix.applyLinearTransforms(data, cc.matrixKey), cc.regionalKey)
+}
+
+// Decrypt reverses Encrypt and strips the padding back to the original length
+func (cc *CascadeCipher) Decrypt(data []byte, length int) ([]byte, error) {
+	inner, err := cc.regional.DecryptRegionalAlgorithms(data, cc.regionalKey)
+	if err != nil {
+		return nil, err
+	}
+	return cc.matrix.ReverseLinearTransforms(inner, cc.matrixKey, length)
+}
+
+// Format-preserving encryption
+
+// fpeMinDomain is the smallest domain size radix^minLength FF1 permits
+const fpeMinDomain = 1000000
+
+// fpeAlphabet maps numerals to characters for string inputs of radix up to 36
+const fpeAlphabet = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// FPEProcessor encrypts numeral strings to numeral strings of the same length and
+// radix, following the FF1 construction of NIST SP 800-38G with the matrix engine
+// in place of AES as the round function. Ten Feistel rounds alternate over the two
+// halves of the input; the tweak binds a ciphertext to its context and may be empty.
+type FPEProcessor struct {
+	radix     int
+	minLength int
+	maxLength int
+	cipher    *MatrixTransformationEngine
+}
+
+// NewFPEProcessor creates a processor for inputs of minLength to maxLength numerals
+// in the given radix (2 to 65536). The domain radix^minLength must hold at least a
+// million values; smaller domains are open to exhaustive attacks.
+func NewFPEProcessor(radix, minLength, maxLength int) (*FPEProcessor, error) {
+	if radix < 2 || radix > 1<<16 {
+		return nil, fmt.Errorf("FPE radix must be between 2 and 65536, got %d", radix)
+	}
+	if minLength < 2 || maxLength < minLength {
+		return nil, fmt.Errorf("invalid FPE length range %d-%d", minLength, maxLength)
+	}
+	domain := new(big.Int).Exp(big.NewInt(int64(radix)), big.NewInt(int64(minLength)), nil)
+	if domain.Cmp(big.NewInt(fpeMinDomain)) < 0 {
+		return nil, fmt.Errorf("FPE domain %d^%d is below %d values", radix, minLength, fpeMinDomain)
+	}
+
+	return &FPEProcessor{
+		radix:     radix,
+		minLength: minLength,
+		maxLength: maxLength,
+		cipher:    NewMatrixTransformationEngine(),
+	}, nil
+}
+
+// newByteFPEProcessor returns the pipeline stage's processor, which treats each byte
+// of transaction data as one radix-256 numeral
+func newByteFPEProcessor() *FPEProcessor {
+	fpe, err := NewFPEProcessor(256, 3, 1<<16)
+	if err != nil {
+		panic(fmt.Sprintf("byte FPE processor: %v", err))
+	}
+	return fpe
+}
+
+// KeySize returns the FPE key size in bytes
+func (fpe *FPEProcessor) KeySize() int {
+	return fpe.cipher.keySize
+}
+
+// Encrypt enciphers numerals under key and tweak
+func (fpe *FPEProcessor) Encrypt(numerals []uint16, key, tweak []byte) ([]uint16, error) {
+	return fpe.feistel(numerals, key, tweak, true)
+}
+
+// Decrypt inverts Encrypt under the same key and tweak
+func (fpe *FPEProcessor) Decrypt(numerals []uint16, key, tweak []byte) ([]uint16, error) {
+	return fpe.feistel(numerals, key, tweak, false)
+}
+
+// EncryptString enciphers a string over the first radix characters of 0-9a-z, so a
+// radix-10 processor maps digit strings to digit strings
+func (fpe *FPEProcessor) EncryptString(value string, key, tweak []byte) (string, error) {
+	return fpe.transformString(value, key, tweak, true)
+}
+
+// DecryptString inverts EncryptString under the same key and tweak
+func (fpe *FPEProcessor) DecryptString(value string, key, tweak []byte) (string, error) {
+	return fpe.transformString(value, key, tweak, false)
+}
+
+// EncryptBytes enciphers data as radix-256 numerals; the processor's radix must be 256
+func (fpe *FPEProcessor) EncryptBytes(data, key, tweak []byte) ([]byte, error) {
+	return fpe.transformBytes(data, key, tweak, true)
+}
+
+// DecryptBytes inverts EncryptBytes under the same key and tweak
+func (fpe *FPEProcessor) DecryptBytes(data, key, tweak []byte) ([]byte, error) {
+	return fpe.transformBytes(data, key, tweak, false)
+}
+
+// processBytesWithKey encrypts data under a fresh key and returns the key
+func (fpe *FPEProcessor) processBytesWithKey(data []byte) ([]byte, []byte, error) {
+	key := make([]byte, fpe.KeySize())
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+
+	output, err := fpe.EncryptBytes(data, key, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return output, key, nil
+}
+
+func (fpe *FPEProcessor) transformString(value string, key, tweak []byte, encrypt bool) (string, error) {
+	if fpe.radix > len(fpeAlphabet) {
+		return "", fmt.Errorf("string FPE supports radix up to %d, processor radix is %d", len(fpeAlphabet), fpe.radix)
+	}
+	alphabet := fpeAlphabet[:fpe.radix]
+
+	numerals := make([]uint16, len(value))
+	for i := 0; i < len(value); i++ {
+		index := strings.IndexByte(alphabet, value[i])
+		if index < 0 {
+			return "", fmt.Errorf("character %q at offset %d is outside the radix %d alphabet", value[i], i, fpe.radix)
+		}
+		numerals[i] = uint16(index)
+	}
+
+	output, err := fpe.feistel(numerals, key, tweak, encrypt)
+	if err != nil {
+		return "", err
+	}
+
+	result := make([]byte, len(output))
+	for i, numeral := range output {
+		result[i] = alphabet[numeral]
+	}
+	return string(result), nil
+}
+
+func (fpe *FPEProcessor) transformBytes(data, key, tweak []byte, encrypt bool) ([]byte, error) {
+	if fpe.radix != 256 {
+		return nil, fmt.Errorf("byte FPE requires radix 256, processor radix is %d", fpe.radix)
+	}
+
+	numerals := make([]uint16, len(data))
+	for i, b := range data {
+		numerals[i] = uint16(b)
+	}
+
+	output, err := fpe.feistel(numerals, key, tweak, encrypt)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, len(output))
+	for i, numeral := range output {
+		result[i] = byte(numeral)
+	}
+	return result, nil
+}
+
+// feistel runs the FF1 rounds forwards or backwards
+func (fpe *FPEProcessor) feistel(numerals []uint16, key, tweak []byte, encrypt bool) ([]uint16, error) {
+	n := len(numerals)
+	if n < fpe.minLength || n > fpe.maxLength {
+		return nil, fmt.Errorf("FPE input length %d outside %d-%d", n, fpe.minLength, fpe.maxLength)
+	}
+	if len(key) != fpe.KeySize() {
+		return nil, fmt.Errorf("invalid key size %d, expected %d", len(key), fpe.KeySize())
+	}
+	for i, numeral := range numerals {
+		if int(numeral) >= fpe.radix {
+			return nil, fmt.Errorf("numeral %d at offset %d is outside radix %d", numeral, i, fpe.radix)
+		}
+	}
+
+	u := n / 2
+	v := n - u
+	a := append([]uint16(nil), numerals[:u]...)
+	b := append([]uint16(nil), numerals[u:]...)
+
+	radix := big.NewInt(int64(fpe.radix))
+	modU := new(big.Int).Exp(radix, big.NewInt(int64(u)), nil)
+	modV := new(big.Int).Exp(radix, big.NewInt(int64(v)), nil)
+
+	// Byte lengths of the encoded half and of the round output
+	numBytes := (new(big.Int).Sub(modV, big.NewInt(1)).BitLen() + 7) / 8
+	outBytes := 4*((numBytes+3)/4) + 4
+
+	// Fixed first PRF block: version, method, radix, rounds, split and lengths
+	p := make([]byte, 16)
+	p[0], p[1], p[2] = 1, 2, 1
+	p[3], p[4], p[5] = byte(fpe.radix>>16), byte(fpe.radix>>8), byte(fpe.radix)
+	p[6] = 10
+	p[7] = byte(u)
+	binary.BigEndian.PutUint32(p[8:12], uint32(n))
+	binary.BigEndian.PutUint32(p[12:16], uint32(len(tweak)))
+
+	roundValue := func(round int, half []uint16) *big.Int {
+		padding := (16 - (len(tweak)+numBytes+1)%16) % 16
+		q := make([]byte, 0, len(tweak)+padding+1+numBytes)
+		q = append(q, tweak...)
+		q = append(q, make([]byte, padding)...)
+		q = append(q, byte(round))
+		q = append(q, fpeNumber(half, radix).FillBytes(make([]byte, numBytes))...)
+
+		// CBC-MAC over P || Q, then expand in counter mode to outBytes
+		r := make([]byte, 16)
+		for _, block := range [][]byte{p, q} {
+			for offset := 0; offset < len(block); offset += 16 {
+				for i := range r {
+					r[i] ^= block[offset+i]
+				}
+				r = fpe.cipher.EncryptBlock(r, key)
+			}
+		}
+
+		output := append([]byte(nil), r...)
+		for counter := uint64(1); len(output) < outBytes; counter++ {
+			block := append([]byte(nil), r...)
+			var counterBytes [8]byte
+			binary.BigEndian.PutUint64(counterBytes[:], counter)
+			for i := range counterBytes {
+				block[8+i] ^= counterBytes[i]
+			}
+			output = append(output, fpe.cipher.EncryptBlock(block, key)...)
+		}
+		return new(big.Int).SetBytes(output[:outBytes])
+	}
+
+	for i := 0; i < 10; i++ {
+		round := i
+		if !encrypt {
+			round = 9 - i
+		}
+
+		modulus, length := modU, u
+		if round%2 == 1 {
+			modulus, length = modV, v
+		}
+
+		if encrypt {
+			c := new(big.Int).Add(fpeNumber(a, radix), roundValue(round, b))
+			c.Mod(c, modulus)
+			a, b = b, fpeNumerals(c, radix, length)
+		} else {
+			c := new(big.Int).Sub(fpeNumber(b, radix), roundValue(round, a))
+			c.Mod(c, modulus)
+			a, b = fpeNumerals(c, radix, length), a
+		}
+	}
+
+	return append(a, b...), nil
+}
+
+// fpeNumber interprets numerals as a big-endian number in radix
+func fpeNumber(numerals []uint16, radix *big.Int) *big.Int {
+	x := new(big.Int)
+	for _, numeral := range numerals {
+		x.Mul(x, radix)
+		x.Add(x, big.NewInt(int64(numeral)))
+	}
+	return x
+}
+
+// fpeNumerals writes x as exactly length big-endian numerals in radix
+func fpeNumerals(x, radix *big.Int, length int) []uint16 {
+	numerals := make([]uint16, length)
+	remaining := new(big.Int).Set(x)
+	digit := new(big.Int)
+	for i := length - 1; i >= 0; i-- {
+		remaining.DivMod(remaining, radix, digit)
+		numerals[i] = uint16(digit.Int64())
+	}
+	return numerals
+}
+
+// Supporting structures and functions
+
+// getComputationalComplexity returns computational complexity for operation
+func (stp *SecureTransactionProcessor) getComputationalComplexity(operation MathematicalOperation) string {
+	if metadata, exists := stp.lookupOperation(operation); exists {
+		return metadata.complexity
+	}
+	return "unknown"
+}
+
+// handlesSecrets reports whether operation's metadata marks it as using key material
+func (stp *SecureTransactionProcessor) handlesSecrets(operation MathematicalOperation) bool {
+	metadata, _ := stp.lookupOperation(operation)
+	return metadata.handlesSecrets
+}
+
+// getQuantumVulnerability returns quantum vulnerability assessment
+func (stp *SecureTransactionProcessor) getQuantumVulnerability(operation MathematicalOperation) string {
+	if metadata, exists := stp.lookupOperation(operation); exists {
+		return metadata.quantumVulnerability
+	}
+	return "unknown"
+}
+
+// OperationInfo describes the properties of a mathematical operation
+type OperationInfo struct {
+	Operation               MathematicalOperation
+	Name                    string
+	ComputationalComplexity string
+	QuantumVulnerability    string
+	Category                string
+	Categories              []string
+	HandlesSecrets          bool
+}
+
+// ListOperations returns the properties of every supported operation
+func (stp *SecureTransactionProcessor) ListOperations() []OperationInfo {
+	operations := stp.registeredOperations()
+	infos := make([]OperationInfo, 0, len(operations))
+	for _, operation := range operations {
+		metadata, _ := stp.lookupOperation(operation)
+		categories := append([]string(nil), metadata.categories...)
+
+		info := OperationInfo{
+			Operation:               operation,
+			Name:                    metadata.name,
+			ComputationalComplexity: stp.getComputationalComplexity(operation),
+			QuantumVulnerability:    stp.getQuantumVulnerability(operation),
+			Categories:              categories,
+			HandlesSecrets:          metadata.handlesSecrets,
+		}
+		if len(categories) > 0 {
+			info.Category = categories[0]
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// OperationPosture describes an operation's standing against quantum attacks
+type OperationPosture struct {
+	Operation            MathematicalOperation
+	Name                 string
+	QuantumVulnerability string
+	PQAlternative        bool   // a quantum-resistant replacement is available
+	Alternative          string // the recommended replacement; empty when none is known
+	MigrationNote        string
+}
+
+// quantumMigrations holds the migration guidance for the built-in operations
+var quantumMigrations = map[MathematicalOperation]struct {
+	alternative string
+	note        string
+}{
+	LargeIntegerArithmetic: {
+		alternative: "ML-DSA / ML-KEM",
+		note:        "broken by Shor's algorithm; move signatures to ML-DSA (FIPS 204) and key transport to ML-KEM (FIPS 203)",
+	},
+	PolynomialFieldComputation: {
+		alternative: "ML-KEM / ML-DSA",
+		note:        "elliptic-curve discrete logs fall to Shor's algorithm; replace key agreement with ML-KEM and signatures with ML-DSA",
+	},
+	MatrixLinearTransformation: {
+		alternative: "AES-256",
+		note:        "Grover's algorithm halves the effective key length; use 256-bit keys",
+	},
+	DigestComputationProcessing: {
+		alternative: "SHA-384 / SHA3-384",
+		note:        "quantum collision search weakens short digests; prefer outputs of 384 bits or more",
+	},
+	KoreanMathematicalProcessing: {
+		alternative: "ARIA-256",
+		note:        "SEED's 128-bit key leaves about 64 bits against Grover's algorithm; migrate to ARIA-256",
+	},
+	RegionalComputationalProcessing: {
+		alternative: "LEA-256",
+		note:        "Grover's algorithm halves the effective key length; use the 256-bit key schedule",
+	},
+	MultiRecipientEncryption: {
+		alternative: "ML-KEM",
+		note:        "RSA and elliptic-curve key transport fall to Shor's algorithm; wrap the content key for each recipient with ML-KEM (FIPS 203)",
+	},
+	AESKeyWrap: {
+		alternative: "AES-256 key wrap",
+		note:        "Grover's algorithm halves the effective KEK length; wrap under a 256-bit KEK",
+	},
+	FormatPreservingEncryption: {
+		alternative: "FF1 with AES-256",
+		note:        "the underlying block cipher sets the quantum margin; run FF1 over a 256-bit key",
+	},
+}
+
+// QuantumPostureReport returns the quantum posture of every supported operation,
+// independent of any transaction. Registered operations without migration
+// guidance are reported with no alternative
+func (stp *SecureTransactionProcessor) QuantumPostureReport() []OperationPosture {
+	operations := stp.registeredOperations()
+	report := make([]OperationPosture, 0, len(operations))
+	for _, operation := range operations {
+		metadata, _ := stp.lookupOperation(operation)
+		posture := OperationPosture{
+			Operation:            operation,
+			Name:                 metadata.name,
+			QuantumVulnerability: stp.getQuantumVulnerability(operation),
+			MigrationNote:        "no migration guidance for this operation; assess it separately",
+		}
+		if migration, exists := quantumMigrations[operation]; exists {
+			posture.PQAlternative = true
+			posture.Alternative = migration.alternative
+			posture.MigrationNote = migration.note
+		}
+		report = append(report, posture)
+	}
+	return report
+}
+
+// calculateSecurityMetrics calculates security metrics for the pipeline
+func (stp *SecureTransactionProcessor) calculateSecurityMetrics(pipeline []MathematicalOperation) SecurityMetrics {
+	categoryOps := map[string]int{
+		CategoryAsymmetric: 0,
+		CategorySymmetric:  0,
+		CategoryHash:       0,
+		CategoryKorean:     0,
+	}
+
+	for _, op := range pipeline {
+		metadata, _ := stp.lookupOperation(op)
+		for _, category := range metadata.categories {
+			categoryOps[category]++
+		}
+	}
+
+	return SecurityMetrics{
+		AsymmetricOps: categoryOps[CategoryAsymmetric],
+		SymmetricOps:  categoryOps[CategorySymmetric],
+		HashOps:       categoryOps[CategoryHash],
+		KoreanOps:     categoryOps[CategoryKorean],
+		TotalOps:      len(pipeline),
+		CategoryOps:   categoryOps,
+	}
+}
+
+// validateCompliance validates compliance requirements
+func (stp *SecureTransactionProcessor) validateCompliance(ctx *TransactionContext, result *ProcessingResult) map[string]bool {
+	compliance := make(map[string]bool)
+
+	for _, requirement := range ctx.ComplianceRequirements {
+		switch requirement {
+		case "korean_standards":
+			compliance[requirement] = result.Metrics.KoreanOps > 0
+		case "quantum_awareness":
+			compliance[requirement] = result.Metrics.AsymmetricOps > 0
+		case "integrity_protection":
+			compliance[requirement] = result.Metrics.HashOps > 0
+		case "format_preservation":
+			compliance[requirement] = result.ranOperation(FormatPreservingEncryption)
+		case "key_transport":
+			compliance[requirement] = result.ranOperation(AESKeyWrap)
+		case "multi_recipient":
+			compliance[requirement] = result.ranOperation(MultiRecipientEncryption)
+		default:
+			compliance[requirement] = !stp.strictCompliance
+		}
+	}
+
+	return compliance
+}
+
+// PerformanceMonitor monitors system performance
+type PerformanceMonitor struct {
+	operationTimings map[MathematicalOperation][]time.Duration
+	mutex           sync.RWMutex
+
+	// windowSize caps samples kept per operation (0 keeps everything); once
+	// full, windowNext is the ring slot overwritten by the next sample
+	windowSize int
+	windowNext map[MathematicalOperation]int
+}
+
+func NewPerformanceMonitor() *PerformanceMonitor {
+	return &PerformanceMonitor{
+		operationTimings: make(map[MathematicalOperation][]time.Duration),
+		windowNext:       make(map[MathematicalOperation]int),
+	}
+}
+
+// PerformanceMonitor returns the monitor fed with every stage's execution time
+func (stp *SecureTransactionProcessor) PerformanceMonitor() *PerformanceMonitor {
+	return stp.performanceMonitor
+}
+
+// RecordOperation records operation timing
+func (pm *PerformanceMonitor) RecordOperation(operation MathematicalOperation, duration time.Duration) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	timings := pm.operationTimings[operation]
+	if pm.windowSize > 0 && len(timings) >= pm.windowSize {
+		next := pm.windowNext[operation]
+		timings[next] = duration
+		pm.windowNext[operation] = (next + 1) % pm.windowSize
+		return
+	}
+
+	pm.operationTimings[operation] = append(timings, duration)
+}
+
+// Reset discards all recorded timings
+func (pm *PerformanceMonitor) Reset() {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	pm.operationTimings = make(map[MathematicalOperation][]time.Duration)
+	pm.windowNext = make(map[MathematicalOperation]int)
+}
+
+// SetWindowSize keeps only the most recent n samples per operation; n <= 0 keeps all
+func (pm *PerformanceMonitor) SetWindowSize(n int) {
+	pm.mutex.Lock()
+	defer pm.mutex.Unlock()
+
+	if n < 0 {
+		n = 0
+	}
+
+	// Put existing samples back in arrival order so trimming keeps the newest
+	for operation, timings := range pm.operationTimings {
+		next := pm.windowNext[operation]
+		ordered := make([]time.Duration, 0, len(timings))
+		ordered = append(ordered, timings[next:]...)
+		ordered = append(ordered, timings[:next]...)
+
+		if n > 0 && len(ordered) > n {
+			ordered = ordered[len(ordered)-n:]
+		}
+		pm.operationTimings[operation] = ordered
+	}
+
+	pm.windowSize = n
+	pm.windowNext = make(map[MathematicalOperation]int)
+}
+
+// GetAverageTime returns average time for operation
+func (pm *PerformanceMonitor) GetAverageTime(operation MathematicalOperation) time.Duration {
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	timings := pm.operationTimings[operation]
+	if len(timings) == 0 {
+		return 0
+	}
+
+	var total time.Duration
+	for _, timing := range timings {
+		total += timing
+	}
+
+	return total / time.Duration(len(timings))
+}
+
+// GetPercentile returns the nearest-rank p-th percentile (0-100) of retained timings
+func (pm *PerformanceMonitor) GetPercentile(operation MathematicalOperation, p float64) time.Duration {
+	pm.mutex.RLock()
+	sorted := append([]time.Duration(nil), pm.operationTimings[operation]...)
+	pm.mutex.RUnlock()
+
+	if len(sorted) == 0 {
+		return 0
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := int(p / 100 * float64(len(sorted)))
+	if float64(rank) < p/100*float64(len(sorted)) {
+		rank++
+	}
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+
+	return sorted[rank-1]
+}
+
+// Anomaly detection tuning: the newest anomalyRecentSamples timings are compared
+// against the history before them, which must hold at least anomalyMinHistory samples
+const (
+	anomalyRecentSamples = 5
+	anomalyMinHistory    = 10
+	anomalyThreshold     = 3.0 // standard deviations
+)
+
+// Anomaly is a recent timing far from the historical mean
+type Anomaly struct {
+	Sample     int // position among retained timings, oldest first
+	Duration   time.Duration
+	Mean       time.Duration
+	StdDev     time.Duration
+	Deviations float64 // distance from the mean in standard deviations
+}
+
+// DetectAnomalies flags recent timings more than three standard deviations from the
+// mean of earlier ones, as from a degraded RNG or CPU contention. With a perfectly
+// constant history any differing sample is flagged. It returns nil until enough
+// history has been recorded.
+func (pm *PerformanceMonitor) DetectAnomalies(operation MathematicalOperation) []Anomaly {
+	pm.mutex.RLock()
+	timings := pm.operationTimings[operation]
+	next := pm.windowNext[operation]
+	ordered := make([]time.Duration, 0, len(timings))
+	ordered = append(ordered, timings[next:]...)
+	ordered = append(ordered, timings[:next]...)
+	pm.mutex.RUnlock()
+
+	if len(ordered) < anomalyMinHistory+anomalyRecentSamples {
+		return nil
+	}
+	split := len(ordered) - anomalyRecentSamples
+	history := ordered[:split]
+
+	var sum float64
+	for _, timing := range history {
+		sum += float64(timing)
+	}
+	mean := sum / float64(len(history))
+
+	var variance float64
+	for _, timing := range history {
+		variance += (float64(timing) - mean) * (float64(timing) - mean)
+	}
+	stdDev := math.Sqrt(variance / float64(len(history)))
+
+	var anomalies []Anomaly
+	for i := split; i < len(ordered); i++ {
+		distance := math.Abs(float64(ordered[i]) - mean)
+		deviations := math.Inf(1)
+		if stdDev > 0 {
+			deviations = distance / stdDev
+		} else if distance == 0 {
+			deviations = 0
+		}
+
+		if deviations > anomalyThreshold {
+			anomalies = append(anomalies, Anomaly{
+				Sample:     i,
+				Duration:   ordered[i],
+				Mean:       time.Duration(mean),
+				StdDev:     time.Duration(stdDev),
+				Deviations: deviations,
+			})
+		}
+	}
+
+	return anomalies
+}
+
+// Bucket counts timings in [LowerBound, UpperBound)
+type Bucket struct {
+	LowerBound time.Duration
+	UpperBound time.Duration
+	Count      int
+}
+
+// histogramBounds are the upper bounds of the latency histogram buckets; a final
+// bucket collects everything above the last bound
+var histogramBounds = []time.Duration{
+	100 * time.Microsecond,
+	time.Millisecond,
+	10 * time.Millisecond,
+	100 * time.Millisecond,
+	time.Second,
+	10 * time.Second,
+}
+
+// GetHistogram returns a latency histogram of retained timings
+func (pm *PerformanceMonitor) GetHistogram(operation MathematicalOperation) []Bucket {
+	buckets := make([]Bucket, len(histogramBounds)+1)
+	var lower time.Duration
+	for i, upper := range histogramBounds {
+		buckets[i] = Bucket{LowerBound: lower, UpperBound: upper}
+		lower = upper
+	}
+	buckets[len(histogramBounds)] = Bucket{LowerBound: lower, UpperBound: time.Duration(1<<63 - 1)}
+
+	pm.mutex.RLock()
+	defer pm.mutex.RUnlock()
+
+	for _, timing := range pm.operationTimings[operation] {
+		index := sort.Search(len(histogramBounds), func(i int) bool { return timing < histogramBounds[i] })
+		buckets[index].Count++
+	}
+
+	return buckets
+}
+
+// Processor configuration
+
+// digestAlgorithm names the hash behind the digest stage; it is fixed at build time
+const digestAlgorithm = "hash_256"
+
+// ProcessorConfig is a snapshot of a processor's effective settings
+type ProcessorConfig struct {
+	ConcurrencyLimit    int                     `json:"concurrency_limit"`
+	FailFast            bool                    `json:"fail_fast"`
+	ModulusBits         int                     `json:"modulus_bits"`
+	PrimeTimeout        time.Duration           `json:"prime_timeout"`
+	MatrixRounds        int                     `json:"matrix_rounds"`
+	MatrixKeySize       int                     `json:"matrix_key_size"`
+	KoreanRounds        int                     `json:"korean_rounds"`
+	DigestAlgorithm     string                  `json:"digest_algorithm"`
+	AllowedOperations   []MathematicalOperation `json:"allowed_operations"` // empty allows every operation
+	RiskThreshold       string                  `json:"risk_threshold,omitempty"`
+	RetainKeys          bool                    `json:"retain_keys"`
+	StrictCompliance    bool                    `json:"strict_compliance"`
+	BreakerThreshold    int                     `json:"breaker_threshold"`
+	BreakerCooldown     time.Duration           `json:"breaker_cooldown"`
+	MaxPipelineLength   int                     `json:"max_pipeline_length"`
+	MandatoryCompliance []string                `json:"mandatory_compliance,omitempty"`
+	PipelineOrdering    PipelineOrderingPolicy  `json:"pipeline_ordering"`
+}
+
+// Config returns the processor's effective settings
+func (stp *SecureTransactionProcessor) Config() ProcessorConfig {
+	var allowed []MathematicalOperation
+	for _, operation := range stp.registeredOperations() {
+		if stp.allowedOperations == nil || stp.allowedOperations[operation] {
+			allowed = append(allowed, operation)
+		}
+	}
+
+	var mandatory []string
+	for requirement, severity := range stp.complianceSeverity {
+		if severity == ComplianceMandatory {
+			mandatory = append(mandatory, requirement)
+		}
+	}
+	sort.Strings(mandatory)
+
+	return ProcessorConfig{
+		ConcurrencyLimit:    stp.concurrencyLimit,
+		FailFast:            stp.failFast,
+		ModulusBits:         stp.largeNumberProcessor.modulusBitLength,
+		PrimeTimeout:        stp.largeNumberProcessor.primeTimeout,
+		MatrixRounds:        stp.matrixTransformer.rounds,
+		MatrixKeySize:       stp.matrixTransformer.keySize,
+		KoreanRounds:        stp.koreanMathProcessor.rounds,
+		DigestAlgorithm:     digestAlgorithm,
+		AllowedOperations:   allowed,
+		RiskThreshold:       stp.riskThreshold,
+		RetainKeys:          stp.retainKeys,
+		StrictCompliance:    stp.strictCompliance,
+		BreakerThreshold:    stp.breakerThreshold,
+		BreakerCooldown:     stp.breakerCooldown,
+		MaxPipelineLength:   stp.maxPipelineLength,
+		MandatoryCompliance: mandatory,
+		PipelineOrdering:    stp.pipelineOrdering,
+	}
+}
+
+// LoadConfig applies a snapshot taken by Config. The configuration is validated
+// as a whole before anything changes. It must not be called while transactions
+// are in flight.
+func (stp *SecureTransactionProcessor) LoadConfig(config ProcessorConfig) error {
+	if config.ModulusBits < 16 {
+		return fmt.Errorf("modulus bit length must be at least 16, got %d", config.ModulusBits)
+	}
+	if config.MatrixRounds <= 0 {
+		return fmt.Errorf("matrix round count must be positive, got %d", config.MatrixRounds)
+	}
+	if config.MatrixKeySize < stp.matrixTransformer.blockSize {
+		return fmt.Errorf("matrix key size must be at least %d bytes, got %d",
+			stp.matrixTransformer.blockSize, config.MatrixKeySize)
+	}
+	if config.KoreanRounds <= 0 || config.KoreanRounds%2 != 0 {
+		return fmt.Errorf("korean round count must be positive and even, got %d", config.KoreanRounds)
+	}
+	if config.DigestAlgorithm != digestAlgorithm {
+		return fmt.Errorf("unsupported digest algorithm: %q", config.DigestAlgorithm)
+	}
+	if _, ok := riskLevels[config.RiskThreshold]; config.RiskThreshold != "" && !ok {
+		return fmt.Errorf("invalid risk threshold: %q", config.RiskThreshold)
+	}
+	if config.MaxPipelineLength < 0 {
+		return fmt.Errorf("maximum pipeline length must not be negative, got %d", config.MaxPipelineLength)
+	}
+	if config.PipelineOrdering != AsymmetricFirst && config.PipelineOrdering != SymmetricFirst {
+		return fmt.Errorf("unknown pipeline ordering policy: %d", config.PipelineOrdering)
+	}
+
+	allowed := make(map[MathematicalOperation]bool, len(config.AllowedOperations))
+	for _, operation := range config.AllowedOperations {
+		if _, exists := stp.lookupOperation(operation); !exists {
+			return fmt.Errorf("unknown operation in allowed set: %v", operation)
+		}
+		allowed[operation] = true
+	}
+	// An empty set, as a hand-written or zero config carries, allows everything
+	// rather than nothing
+	if len(allowed) == 0 || len(allowed) == len(stp.registeredOperations()) {
+		allowed = nil
+	}
+
+	if config.ConcurrencyLimit != stp.concurrencyLimit {
+		stp.concurrencyLimit = config.ConcurrencyLimit
+		stp.limiter = NewConcurrencyLimiter(config.ConcurrencyLimit)
+	}
+	stp.failFast = config.FailFast
+	stp.largeNumberProcessor.modulusBitLength = config.ModulusBits
+	stp.largeNumberProcessor.primeTimeout = config.PrimeTimeout
+	stp.matrixTransformer.rounds = config.MatrixRounds
+	stp.matrixTransformer.keySize = config.MatrixKeySize
+	stp.koreanMathProcessor.rounds = config.KoreanRounds
+	stp.allowedOperations = allowed
+	stp.riskThreshold = config.RiskThreshold
+	stp.retainKeys = config.RetainKeys
+	stp.strictCompliance = config.StrictCompliance
+	stp.maxPipelineLength = config.MaxPipelineLength
+	stp.pipelineOrdering = config.PipelineOrdering
+	stp.complianceSeverity = make(map[string]ComplianceSeverity, len(config.MandatoryCompliance))
+	for _, requirement := range config.MandatoryCompliance {
+		stp.complianceSeverity[requirement] = ComplianceMandatory
+	}
+
+	stp.breakersMutex.Lock()
+	stp.breakerThreshold = config.BreakerThreshold
+	stp.breakerCooldown = config.BreakerCooldown
+	stp.breakers = make(map[MathematicalOperation]*circuitBreaker)
+	stp.breakersMutex.Unlock()
+
+	return nil
+}
+
+// toyCipherOperations lists the block-cipher stages built on fixed affine S-boxes
+var toyCipherOperations = []struct {
+	operation MathematicalOperation
+	sboxes    string
+}{
+	{MatrixLinearTransformation, "x*7+13"},
+	{KoreanMathematicalProcessing, "x*17+1, x*23+7"},
+	{RegionalComputationalProcessing, "x*7+11, x*13+23"},
+}
+
+// SecurityWarnings reports known-weak settings so a caller can refuse to deploy the
+// processor. Engine warnings are reported only while the engine's operation is
+// allowed; no stage takes a nonce or IV, so nonce reuse cannot arise here. An empty
+// result means no known weakness is enabled.
+func (stp *SecureTransactionProcessor) SecurityWarnings() []string {
+	allowed := func(operation MathematicalOperation) bool {
+		return stp.allowedOperations == nil || stp.allowedOperations[operation]
+	}
+
+	var warnings []string
+	if allowed(LargeIntegerArithmetic) {
+		warnings = append(warnings, "LargeIntegerArithmetic: textbook RSA without OAEP padding is deterministic and malleable")
+		if modulusBits := stp.largeNumberProcessor.modulusBitLength; modulusBits < 2048 {
+			warnings = append(warnings, fmt.Sprintf("LargeIntegerArithmetic: %d-bit modulus is below 2048 bits", modulusBits))
+		}
+	}
+
+	for _, cipher := range toyCipherOperations {
+		if !allowed(cipher.operation) {
+			continue
+		}
+		warnings = append(warnings,
+			fmt.Sprintf("%v: toy affine S-box (%s) gives no resistance to linear or differential cryptanalysis", cipher.operation, cipher.sboxes),
+			fmt.Sprintf("%v: ECB mode encrypts equal plaintext blocks to equal ciphertext blocks", cipher.operation))
+	}
+
+	if allowed(FormatPreservingEncryption) {
+		warnings = append(warnings, "FormatPreservingEncryption: round function is the matrix engine and inherits its toy S-box")
+	}
+	if stp.retainKeys {
+		warnings = append(warnings, "stage keys are retained in processing results")
+	}
+
+	return warnings
+}
+
+// Transaction construction
+
+// TransactionBuilder assembles a TransactionContext fluently
+type TransactionBuilder struct {
+	ctx   TransactionContext
+	clock Clock
+}
+
+// NewTransactionBuilder starts a transaction over data at StandardSecurity
+func NewTransactionBuilder(data []byte) *TransactionBuilder {
+	return &TransactionBuilder{
+		ctx: TransactionContext{
+			Data:          data,
+			SecurityLevel: StandardSecurity,
+		},
+		clock: systemClock{},
+	}
+}
+
+// WithClock sets the time source used to stamp ProcessingTimestamp
+func (tb *TransactionBuilder) WithClock(clock Clock) *TransactionBuilder {
+	tb.clock = clock
+	return tb
+}
+
+// WithTransactionID sets an explicit transaction ID
+func (tb *TransactionBuilder) WithTransactionID(id string) *TransactionBuilder {
+	tb.ctx.TransactionID = id
+	return tb
+}
+
+// WithSecurityLevel sets the transaction security level
+func (tb *TransactionBuilder) WithSecurityLevel(level TransactionSecurityLevel) *TransactionBuilder {
+	tb.ctx.SecurityLevel = level
+	return tb
+}
+
+// RequireCompliance appends compliance requirements
+func (tb *TransactionBuilder) RequireCompliance(requirements ...string) *TransactionBuilder {
+	tb.ctx.ComplianceRequirements = append(tb.ctx.ComplianceRequirements, requirements...)
+	return tb
+}
+
+// WithKeyID signs modular arithmetic stages with a stored key
+func (tb *TransactionBuilder) WithKeyID(id string) *TransactionBuilder {
+	tb.ctx.KeyID = id
+	return tb
+}
+
+// SkipIntegrityDigest omits the automatic trailing digest stage
+func (tb *TransactionBuilder) SkipIntegrityDigest() *TransactionBuilder {
+	tb.ctx.SkipIntegrityDigest = true
+	return tb
+}
+
+// WithOperations appends required operations
+func (tb *TransactionBuilder) WithOperations(operations ...MathematicalOperation) *TransactionBuilder {
+	tb.ctx.RequiredOperations = append(tb.ctx.RequiredOperations, operations...)
+	return tb
+}
+
+// Build returns a new context, stamping ProcessingTimestamp and generating a
+// TransactionID when none was set. The builder itself is left unchanged.
+func (tb *TransactionBuilder) Build() *TransactionContext {
+	ctx := tb.ctx
+	ctx.RequiredOperations = append([]MathematicalOperation(nil), tb.ctx.RequiredOperations...)
+	ctx.ComplianceRequirements = append([]string(nil), tb.ctx.ComplianceRequirements...)
+	ctx.ProcessingTimestamp = tb.clock.Now()
+
+	if ctx.TransactionID == "" {
+		ctx.TransactionID = GenerateTransactionID()
+	}
+
+	return &ctx
+}
+
+// GenerateTransactionID returns a random RFC 4122 version 4 UUID
+func GenerateTransactionID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		// crypto/rand failing leaves no safe source of uniqueness
+		panic(fmt.Sprintf("transaction ID generation failed: %v", err))
+	}
+	id[6] = (id[6] & 0x0f) | 0x40 // version 4
+	id[8] = (id[8] & 0x3f) | 0x80 // RFC 4122 variant
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:16])
+}
+
+// Pipeline visualization
+
+// PipelineGraph returns a Graphviz DOT description of the pipeline planned for ctx
+func (stp *SecureTransactionProcessor) PipelineGraph(ctx *TransactionContext) string {
+	pipeline := stp.buildProcessingPipeline(ctx)
+
+	var buf strings.Builder
+	buf.WriteString("digraph pipeline {\n")
+	buf.WriteString("  rankdir=LR;\n")
+	buf.WriteString("  node [shape=box];\n")
+	buf.WriteString("  input [label=\"input\", shape=ellipse];\n")
+	buf.WriteString("  output [label=\"output\", shape=ellipse];\n")
+
+	for i, operation := range pipeline {
+		fmt.Fprintf(&buf, "  op%d [label=\"%s\\ncomplexity: %s\\nquantum vulnerability: %s\"];\n",
+			i, operation, stp.getComputationalComplexity(operation), stp.getQuantumVulnerability(operation))
+	}
+
+	previous := "input"
+	for i := range pipeline {
+		node := fmt.Sprintf("op%d", i)
+		fmt.Fprintf(&buf, "  %s -> %s;\n", previous, node)
+		previous = node
+	}
+	fmt.Fprintf(&buf, "  %s -> output;\n", previous)
+
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// PipelineSignature fingerprints the pipeline planned for ctx: the ordered operations
+// and their metadata, independent of key material. It changes whenever planning or
+// operation metadata changes.
+func (stp *SecureTransactionProcessor) PipelineSignature(ctx *TransactionContext) []byte {
+	pipeline := stp.buildProcessingPipeline(ctx)
+
+	h := hash_256.New()
+	writeField := func(field string) {
+		binary.Write(h, binary.BigEndian, uint32(len(field)))
+		h.Write([]byte(field))
+	}
+
+	binary.Write(h, binary.BigEndian, uint32(len(pipeline)))
+	for _, operation := range pipeline {
+		metadata, _ := stp.lookupOperation(operation)
+
+		binary.Write(h, binary.BigEndian, int32(operation))
+		writeField(metadata.name)
+		writeField(metadata.complexity)
+		writeField(metadata.quantumVulnerability)
+		binary.Write(h, binary.BigEndian, uint32(len(metadata.categories)))
+		for _, category := range metadata.categories {
+			writeField(category)
+		}
+	}
+
+	return h.Sum(nil)
+}
+
+// PredictedMetrics returns the security metrics ProcessSecureTransaction would report
+// for ctx, computed from the planned pipeline without executing any operation
+func (stp *SecureTransactionProcessor) PredictedMetrics(ctx *TransactionContext) SecurityMetrics {
+	return stp.calculateSecurityMetrics(stp.buildProcessingPipeline(ctx))
+}
+
+// EstimateOutputSize returns the length of ProcessedData for ctx, computed from the
+// planned pipeline without executing it. Block stages round up to whole blocks, and
+// the modular arithmetic stage is counted at its maximum, the modulus size, since
+// leading zero bytes are dropped; the field stage always emits both full-width
+// coordinates. A pipeline ending in the fixed-size digest is estimated exactly; when
+// SkipIntegrityDigest drops the digest, the estimate is only an upper bound set by
+// the last stage. It returns -1 if the pipeline ends in a custom operation, whose
+// size is unknown.
+func (stp *SecureTransactionProcessor) EstimateOutputSize(ctx *TransactionContext) int {
+	size := len(ctx.Data)
+	for _, operation := range stp.buildProcessingPipeline(ctx) {
+		size = stp.stageOutputSize(operation, size, ctx.KeyID)
+	}
+	return size
+}
+
+// stageOutputSize bounds the output of one stage for size input bytes, or returns
+// -1 when it can't be known; unknown input sizes stay unknown unless the stage
+// output is fixed
+func (stp *SecureTransactionProcessor) stageOutputSize(operation MathematicalOperation, size int, keyID string) int {
+	roundUp := func(blockSize int) int {
+		if size < 0 {
+			return -1
+		}
+		return (size + blockSize - 1) / blockSize * blockSize
+	}
+
+	switch operation {
+	case LargeIntegerArithmetic:
+		if keyID != "" {
+			if pub, err := stp.keyStore.PublicKey(keyID); err == nil {
+				return pub.Size()
+			}
+		}
+		return (stp.largeNumberProcessor.modulusBitLength + 7) / 8
+	case PolynomialFieldComputation:
+		return 2 * stp.polynomialComputer.coordinateSize()
+	case MatrixLinearTransformation:
+		return roundUp(stp.matrixTransformer.blockSize)
+	case DigestComputationProcessing:
+		return 1 + 2*stp.digestCalculator.outputSize
+	case KoreanMathematicalProcessing:
+		return roundUp(stp.koreanMathProcessor.blockSize)
+	case RegionalComputationalProcessing:
+		return roundUp(stp.regionalProcessor.blockSize)
+	case FormatPreservingEncryption:
+		return size
+	case AESKeyWrap:
+		return size + keyWrapBlockSize
+	case MultiRecipientEncryption:
+		return stp.envelopeSize(size)
+	default:
+		return -1
+	}
+}
+
+// AES key wrap
+
+// keyWrapBlockSize is the RFC 3394 semiblock, and the size of the integrity check value
+const keyWrapBlockSize = 8
+
+// keyWrapIV is the default initial value of RFC 3394 section 2.2.3.1
+var keyWrapIV = []byte{0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6, 0xA6}
+
+// ErrKeyWrapNotConfigured is returned by AESKeyWrap stages on a processor without a KEK
+var ErrKeyWrapNotConfigured = errors.New("key wrap KEK not configured")
+
+// ErrKeyUnwrapFailed is returned when a wrapped key fails its integrity check
+var ErrKeyUnwrapFailed = errors.New("key unwrap integrity check failed")
+
+// WrapKey wraps key under kek with AES key wrap (RFC 3394, NIST SP 800-38F KW)
+// over AESBlockCipher. kek must be 16, 24 or 32 bytes and key a multiple of 8
+// bytes, at least 16.
+func WrapKey(kek, key []byte) ([]byte, error) {
+	if len(key) < 2*keyWrapBlockSize || len(key)%keyWrapBlockSize != 0 {
+		return nil, fmt.Errorf("key wrap: key length %d is not a multiple of %d of at least %d",
+			len(key), keyWrapBlockSize, 2*keyWrapBlockSize)
+	}
+	block, err := NewAESBlockCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("key wrap: %w", err)
+	}
+
+	n := len(key) / keyWrapBlockSize
+	wrapped := make([]byte, keyWrapBlockSize+len(key))
+	copy(wrapped, keyWrapIV)
+	copy(wrapped[keyWrapBlockSize:], key)
+
+	// wrapped[:8] is the register A, and wrapped[8i:8i+8] is R[i]
+	var buf [aesBlockSize]byte
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			r := wrapped[i*keyWrapBlockSize : (i+1)*keyWrapBlockSize]
+			copy(buf[:keyWrapBlockSize], wrapped[:keyWrapBlockSize])
+			copy(buf[keyWrapBlockSize:], r)
+			block.Encrypt(buf[:], buf[:])
+
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(wrapped[:keyWrapBlockSize], binary.BigEndian.Uint64(buf[:keyWrapBlockSize])^t)
+			copy(r, buf[keyWrapBlockSize:])
+		}
+	}
+
+	return wrapped, nil
+}
+
+// UnwrapKey reverses WrapKey, failing with ErrKeyUnwrapFailed when the recovered
+// integrity check value doesn't match
+func UnwrapKey(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped) < 3*keyWrapBlockSize || len(wrapped)%keyWrapBlockSize != 0 {
+		return nil, fmt.Errorf("key unwrap: wrapped length %d is not a multiple of %d of at least %d",
+			len(wrapped), keyWrapBlockSize, 3*keyWrapBlockSize)
+	}
+	block, err := NewAESBlockCipher(kek)
+	if err != nil {
+		return nil, fmt.Errorf("key unwrap: %w", err)
+	}
+
+	n := len(wrapped)/keyWrapBlockSize - 1
+	a := append([]byte(nil), wrapped[:keyWrapBlockSize]...)
+	key := append([]byte(nil), wrapped[keyWrapBlockSize:]...)
+
+	var buf [aesBlockSize]byte
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			r := key[(i-1)*keyWrapBlockSize : i*keyWrapBlockSize]
+			t := uint64(n*j + i)
+			binary.BigEndian.PutUint64(buf[:keyWrapBlockSize], binary.BigEndian.Uint64(a)^t)
+			copy(buf[keyWrapBlockSize:], r)
+			block.Decrypt(buf[:], buf[:])
+
+			copy(a, buf[:keyWrapBlockSize])
+			copy(r, buf[keyWrapBlockSize:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(a, keyWrapIV) != 1 {
+		return nil, ErrKeyUnwrapFailed
+	}
+	return key, nil
+}
+
+// Multi-recipient encryption
+
+// minRecipientRSABits is the smallest RSA modulus a recipient key may have
+const minRecipientRSABits = 2048
+
+// Envelope content encryption is AES-256-GCM with the standard nonce and tag sizes
+const (
+	contentKeySize   = 32
+	contentNonceSize = 12
+	contentTagSize   = 16
+)
+
+// Labels separating the multi-recipient derivations from other uses of the hash
+const (
+	recipientKEKLabel = "stp-recipient-kek"
+	recipientIDLabel  = "stp-recipient-id"
+)
+
+// recipientIDSize is the length of a recipient ID, a SHA-256 digest
+const recipientIDSize = 32
+
+// ErrNotARecipient is returned when an envelope holds no content key for the private key
+var ErrNotARecipient = errors.New("not a recipient of this envelope")
+
+// ErrNoRecipients is returned by MultiRecipientEncryption stages on a processor without recipients
+var ErrNoRecipients = errors.New("no recipients configured")
+
+// ErrRecipientKeyNotConfigured is returned when reversing a MultiRecipientEncryption
+// stage on a processor without a recipient private key
+var ErrRecipientKeyNotConfigured = errors.New("recipient private key not configured")
+
+// RecipientEnvelope is a payload encrypted once under a random content key, with
+// that key wrapped separately for every recipient
+type RecipientEnvelope struct {
+	Recipients []WrappedContentKey
+	Nonce      []byte // AES-GCM nonce for Ciphertext
+	Ciphertext []byte
+}
+
+// WrappedContentKey is the content key wrapped for one recipient
+type WrappedContentKey struct {
+	RecipientID  []byte // hash of the recipient's public key
+	EphemeralKey []byte // sender's ephemeral curve point, SEC 1 uncompressed; empty for RSA recipients
+	WrappedKey   []byte
+}
+
+// EncryptForRecipients encrypts data with AES-256-GCM under a fresh content key
+// and wraps the key for each recipient. An *rsa.PublicKey recipient of at least
+// 2048 bits gets the key by OAEP over the modular arithmetic engine; an
+// *EllipticPoint recipient on the field engine's curve gets it by an ephemeral
+// Diffie-Hellman exchange whose hash-derived KEK wraps the key with WrapKey.
+func (stp *SecureTransactionProcessor) EncryptForRecipients(data []byte, recipients []crypto.PublicKey) (*RecipientEnvelope, error) {
+	if len(recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+
+	contentKey := make([]byte, contentKeySize)
+	if _, err := rand.Read(contentKey); err != nil {
+		return nil, err
+	}
+
+	envelope := &RecipientEnvelope{Recipients: make([]WrappedContentKey, 0, len(recipients))}
+	for i, recipient := range recipients {
+		wrapped, err := stp.wrapContentKey(contentKey, recipient)
+		if err != nil {
+			return nil, fmt.Errorf("recipient %d: %w", i, err)
+		}
+		envelope.Recipients = append(envelope.Recipients, wrapped)
+	}
+
+	aead, err := newContentCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	envelope.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(envelope.Nonce); err != nil {
+		return nil, err
+	}
+	envelope.Ciphertext = aead.Seal(nil, envelope.Nonce, data, nil)
+
+	return envelope, nil
+}
+
+// DecryptAsRecipient recovers the payload of envelope with one recipient's
+// *rsa.PrivateKey or private curve scalar (*big.Int)
+func (stp *SecureTransactionProcessor) DecryptAsRecipient(envelope *RecipientEnvelope, key crypto.PrivateKey) ([]byte, error) {
+	var public crypto.PublicKey
+	switch priv := key.(type) {
+	case *rsa.PrivateKey:
+		public = &priv.PublicKey
+	case *big.Int:
+		point, err := stp.polynomialComputer.PublicKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		public = point
+	default:
+		return nil, fmt.Errorf("unsupported recipient key type %T", key)
+	}
+
+	id, err := stp.recipientID(public)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, wrapped := range envelope.Recipients {
+		if !bytes.Equal(wrapped.RecipientID, id) {
+			continue
+		}
+
+		contentKey, err := stp.unwrapContentKey(wrapped, key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := newContentCipher(contentKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(envelope.Nonce) != aead.NonceSize() {
+			return nil, fmt.Errorf("invalid envelope nonce size %d", len(envelope.Nonce))
+		}
+		return aead.Open(nil, envelope.Nonce, envelope.Ciphertext, nil)
+	}
+
+	return nil, ErrNotARecipient
+}
+
+// recipientID identifies a recipient by the hash of its public key: the modulus
+// and exponent of an RSA key, or the encoded curve point
+func (stp *SecureTransactionProcessor) recipientID(public crypto.PublicKey) ([]byte, error) {
+	h := hash_256.New()
+	h.Write([]byte(recipientIDLabel))
+
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		h.Write([]byte{0x01})
+		binary.Write(h, binary.BigEndian, int64(pub.E))
+		h.Write(pub.N.Bytes())
+	case *EllipticPoint:
+		encoded, err := stp.polynomialComputer.MarshalPoint(pub)
+		if err != nil {
+			return nil, err
+		}
+		h.Write([]byte{0x02})
+		h.Write(encoded)
+	default:
+		return nil, fmt.Errorf("unsupported recipient key type %T", public)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// recipientKEK derives the key-encryption key from a Diffie-Hellman shared
+// secret, bound to both public points
+func recipientKEK(shared, ephemeral, recipient []byte) []byte {
+	h := hash_256.New()
+	h.Write([]byte(recipientKEKLabel))
+	h.Write(shared)
+	h.Write(ephemeral)
+	h.Write(recipient)
+	return h.Sum(nil)
+}
+
+func (stp *SecureTransactionProcessor) wrapContentKey(contentKey []byte, recipient crypto.PublicKey) (WrappedContentKey, error) {
+	id, err := stp.recipientID(recipient)
+	if err != nil {
+		return WrappedContentKey{}, err
+	}
+	wrapped := WrappedContentKey{RecipientID: id}
+
+	switch pub := recipient.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRecipientRSABits {
+			return WrappedContentKey{}, fmt.Errorf("RSA recipient key is %d bits, need %d", pub.N.BitLen(), minRecipientRSABits)
+		}
+		wrapped.WrappedKey, err = stp.largeNumberProcessor.EncryptOAEP(pub, contentKey)
+	case *EllipticPoint:
+		pfc := stp.polynomialComputer
+		var ephemeral *big.Int
+		if ephemeral, err = pfc.GenerateScalar(); err != nil {
+			return WrappedContentKey{}, err
+		}
+		var shared []byte
+		if shared, err = pfc.SharedSecret(ephemeral, pub); err != nil {
+			return WrappedContentKey{}, err
+		}
+		ephemeralPoint, _ := pfc.PublicKey(ephemeral)
+		if wrapped.EphemeralKey, err = pfc.MarshalPoint(ephemeralPoint); err != nil {
+			return WrappedContentKey{}, err
+		}
+		recipientPoint, _ := pfc.MarshalPoint(pub)
+		wrapped.WrappedKey, err = WrapKey(recipientKEK(shared, wrapped.EphemeralKey, recipientPoint), contentKey)
+	default:
+		return WrappedContentKey{}, fmt.Errorf("unsupported recipient key type %T", recipient)
+	}
+
+	return wrapped, err
+}
+
+func (stp *SecureTransactionProcessor) unwrapContentKey(wrapped WrappedContentKey, key crypto.PrivateKey) ([]byte, error) {
+	switch priv := key.(type) {
+	case *rsa.PrivateKey:
+		return stp.largeNumberProcessor.DecryptOAEP(priv, wrapped.WrappedKey)
+	case *big.Int:
+		pfc := stp.polynomialComputer
+		ephemeral, err := pfc.UnmarshalPoint(wrapped.EphemeralKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+		}
+		shared, err := pfc.SharedSecret(priv, ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		public, err := pfc.PublicKey(priv)
+		if err != nil {
+			return nil, err
+		}
+		recipientPoint, _ := pfc.MarshalPoint(public)
+		return UnwrapKey(recipientKEK(shared, wrapped.EphemeralKey, recipientPoint), wrapped.WrappedKey)
+	default:
+		return nil, fmt.Errorf("unsupported recipient key type %T", key)
+	}
+}
+
+// newContentCipher returns AES-GCM over AESBlockCipher under the envelope content key
+func newContentCipher(contentKey []byte) (cipher.AEAD, error) {
+	block, err := NewAESBlockCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// MarshalBinary encodes the envelope as a MultiRecipientEncryption stage writes
+// it: a 4-byte big-endian recipient count, each recipient's ID, ephemeral key and
+// wrapped key, then the nonce and ciphertext, every field after the count
+// prefixed with its 4-byte big-endian length
+func (e *RecipientEnvelope) MarshalBinary() ([]byte, error) {
+	encoded := new(bytes.Buffer)
+	writeField := func(b []byte) {
+		binary.Write(encoded, binary.BigEndian, uint32(len(b)))
+		encoded.Write(b)
+	}
+
+	binary.Write(encoded, binary.BigEndian, uint32(len(e.Recipients)))
+	for _, recipient := range e.Recipients {
+		writeField(recipient.RecipientID)
+		writeField(recipient.EphemeralKey)
+		writeField(recipient.WrappedKey)
+	}
+	writeField(e.Nonce)
+	writeField(e.Ciphertext)
+
+	return encoded.Bytes(), nil
+}
+
+// UnmarshalBinary decodes an envelope written by MarshalBinary
+func (e *RecipientEnvelope) UnmarshalBinary(data []byte) error {
+	encoded := bytes.NewReader(data)
+	readField := func() ([]byte, error) {
+		var length uint32
+		if err := binary.Read(encoded, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+		if int64(length) > int64(encoded.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		field := make([]byte, length)
+		_, err := io.ReadFull(encoded, field)
+		return field, err
+	}
+
+	var count uint32
+	if err := binary.Read(encoded, binary.BigEndian, &count); err != nil {
+		return fmt.Errorf("decoding envelope: %w", err)
+	}
+	// Every recipient takes at least its three length prefixes
+	if int64(count)*12 > int64(encoded.Len()) {
+		return fmt.Errorf("decoding envelope: %w", io.ErrUnexpectedEOF)
+	}
+
+	envelope := RecipientEnvelope{Recipients: make([]WrappedContentKey, count)}
+	for i := range envelope.Recipients {
+		recipient := &envelope.Recipients[i]
+		var err error
+		if recipient.RecipientID, err = readField(); err != nil {
+			return fmt.Errorf("decoding envelope recipient %d: %w", i, err)
+		}
+		if recipient.EphemeralKey, err = readField(); err != nil {
+			return fmt.Errorf("decoding envelope recipient %d: %w", i, err)
+		}
+		if recipient.WrappedKey, err = readField(); err != nil {
+			return fmt.Errorf("decoding envelope recipient %d: %w", i, err)
+		}
+	}
+
+	var err error
+	if envelope.Nonce, err = readField(); err != nil {
+		return fmt.Errorf("decoding envelope nonce: %w", err)
+	}
+	if envelope.Ciphertext, err = readField(); err != nil {
+		return fmt.Errorf("decoding envelope ciphertext: %w", err)
+	}
+	if encoded.Len() != 0 {
+		return fmt.Errorf("decoding envelope: %d trailing bytes", encoded.Len())
+	}
+
+	*e = envelope
+	return nil
+}
+
+// sealForRecipients runs a MultiRecipientEncryption stage
+func (stp *SecureTransactionProcessor) sealForRecipients(data []byte) ([]byte, error) {
+	if len(stp.recipients) == 0 {
+		return nil, ErrNoRecipients
+	}
+	envelope, err := stp.EncryptForRecipients(data, stp.recipients)
+	if err != nil {
+		return nil, err
+	}
+	return envelope.MarshalBinary()
+}
+
+// openAsRecipient reverses a MultiRecipientEncryption stage with the processor's
+// recipient key
+func (stp *SecureTransactionProcessor) openAsRecipient(data []byte) ([]byte, error) {
+	if stp.recipientKey == nil {
+		return nil, ErrRecipientKeyNotConfigured
+	}
+	var envelope RecipientEnvelope
+	if err := envelope.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return stp.DecryptAsRecipient(&envelope, stp.recipientKey)
+}
+
+// envelopeSize is the MultiRecipientEncryption output length for size input
+// bytes under the configured recipients, or -1 when it can't be known
+func (stp *SecureTransactionProcessor) envelopeSize(size int) int {
+	if size < 0 || len(stp.recipients) == 0 {
+		return -1
+	}
+
+	coordinateSize := (stp.polynomialComputer.fieldPrime.BitLen() + 7) / 8
+	total := 4 + 4 + contentNonceSize + 4 + size + contentTagSize
+	for _, recipient := range stp.recipients {
+		total += 3*4 + recipientIDSize
+		switch pub := recipient.(type) {
+		case *rsa.PublicKey:
+			total += pub.Size()
+		case *EllipticPoint:
+			total += 1 + 2*coordinateSize + keyWrapBlockSize + contentKeySize
+		default:
+			return -1
+		}
+	}
+	return total
+}
+
+// MultiProcessor routes transactions to differently configured processors
+type MultiProcessor struct {
+	routes           []processorRoute
+	defaultProcessor *SecureTransactionProcessor
+	mutex            sync.RWMutex
+}
+
+// processorRoute pairs a routing predicate with its target processor
+type processorRoute struct {
+	match     func(*TransactionContext) bool
+	processor *SecureTransactionProcessor
+}
+
+// NewMultiProcessor creates a router; defaultProcessor (may be nil) handles unmatched transactions
+func NewMultiProcessor(defaultProcessor *SecureTransactionProcessor) *MultiProcessor {
+	return &MultiProcessor{
+		defaultProcessor: defaultProcessor,
+	}
+}
+
+// Register adds a route; routes are evaluated in registration order
+func (mp *MultiProcessor) Register(match func(*TransactionContext) bool, p *SecureTransactionProcessor) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	mp.routes = append(mp.routes, processorRoute{match: match, processor: p})
+}
+
+// Route returns the processor that would handle the given transaction
+func (mp *MultiProcessor) Route(ctx *TransactionContext) (*SecureTransactionProcessor, error) {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+
+	for _, route := range mp.routes {
+		if route.match(ctx) {
+			return route.processor, nil
+		}
+	}
+
+	if mp.defaultProcessor == nil {
+		return nil, fmt.Errorf("no processor registered for transaction %s", ctx.TransactionID)
+	}
+	return mp.defaultProcessor, nil
+}
+
+// ProcessSecureTransaction processes a transaction with the first matching processor
+func (mp *MultiProcessor) ProcessSecureTransaction(ctx *TransactionContext) (*ProcessingResult, error) {
+	processor, err := mp.Route(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return processor.ProcessSecureTransaction(ctx)
+}
+
+// RequiresCompliance returns a route predicate matching transactions that list requirement
+func RequiresCompliance(requirement string) func(*TransactionContext) bool {
+	return func(ctx *TransactionContext) bool {
+		for _, r := range ctx.ComplianceRequirements {
+			if r == requirement {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Compliance reporting
+
+// complianceReportEntry is the pass/fail outcome of one requirement
+type complianceReportEntry struct {
+	Requirement string `json:"requirement"`
+	Passed      bool   `json:"passed"`
+}
+
+// junitTestSuite is the minimal JUnit XML shape understood by CI systems
+type junitTestSuite struct {
+	XMLName  xml.Name        `xml:"testsuite"`
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+// junitTestCase is a single requirement rendered as a JUnit test case
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
 }
 
-// mixColumns applies column mixing
-func (mte *MatrixTransformationEngine) mixColumns(state []byte) {
-	for col := 0; col < 4; col++ {
-		s0 := state[col*4]
-		s1 := state[col*4+1]
-		s2 := state[col*4+2]
-		s3 := state[col*4+3]
+// junitFailure marks a failed requirement
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
 
-		state[col*4] = mte.gfMultiply(2, s0) ^ mte.gfMultiply(3, s1) ^ s2 ^ s3
-		state[col*4+1] = s0 ^ mte.gfMultiply(2, s1) ^ mte.gfMultiply(3, s2) ^ s3
-		state[col*4+2] = s0 ^ s1 ^ mte.gfMultiply(2, s2) ^ mte.gfMultiply(3, s3)
-		state[col*4+3] = mte.gfMultiply(3, s0) ^ s1 ^ s2 ^ mte.gfMultiply(2, s3)
+// complianceEntries returns requirement outcomes sorted by requirement name
+func (r *ProcessingResult) complianceEntries() []complianceReportEntry {
+	requirements := make([]string, 0, len(r.ComplianceStatus))
+	for requirement := range r.ComplianceStatus {
+		requirements = append(requirements, requirement)
+	}
+	sort.Strings(requirements)
+
+	entries := make([]complianceReportEntry, 0, len(requirements))
+	for _, requirement := range requirements {
+		entries = append(entries, complianceReportEntry{
+			Requirement: requirement,
+			Passed:      r.ComplianceStatus[requirement],
+		})
 	}
+	return entries
 }
 
-// gfMultiply performs Galois Field multiplication
-func (mte *MatrixTransformationEngine) gfMultiply(a, b byte) byte {
-	var result byte
-	for i := 0; i < 8; i++ {
-		if b&1 != 0 {
-			result ^= a
+// FormatComplianceReport renders the compliance status as "json", "text" or "junit"
+func (r *ProcessingResult) FormatComplianceReport(format string) ([]byte, error) {
+	entries := r.complianceEntries()
+
+	switch format {
+	case "json":
+		return json.MarshalIndent(entries, "", "  ")
+	case "text":
+		var buf bytes.Buffer
+		for _, entry := range entries {
+			status := "PASS"
+			if !entry.Passed {
+				status = "FAIL"
+			}
+			fmt.Fprintf(&buf, "%-4s %s\n", status, entry.Requirement)
 		}
-		highBit := a & 0x80
-		a <<= 1
-		if highBit != 0 {
-			a ^= 0x1B
+		return buf.Bytes(), nil
+	case "junit":
+		suite := junitTestSuite{
+			Name:  "compliance",
+			Tests: len(entries),
 		}
-		b >>= 1
+		for _, entry := range entries {
+			testCase := junitTestCase{
+				Name:      entry.Requirement,
+				ClassName: "compliance",
+			}
+			if !entry.Passed {
+				suite.Failures++
+				testCase.Failure = &junitFailure{
+					Message: fmt.Sprintf("requirement %s not satisfied", entry.Requirement),
+				}
+			}
+			suite.Cases = append(suite.Cases, testCase)
+		}
+
+		output, err := xml.MarshalIndent(suite, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(xml.Header), output...), nil
+	default:
+		return nil, fmt.Errorf("unsupported compliance report format: %q", format)
 	}
-	return result
 }
 
-// addRoundKey adds round key to state
-func (mte *MatrixTransformationEngine) addRoundKey(state, roundKey []byte) {
-	for i := range state {
-		state[i] ^= roundKey[i%len(roundKey)]
-	}
+// Compliance audit logging
+
+// AuditSink receives the outcome of each compliance evaluation
+type AuditSink interface {
+	RecordCompliance(txID string, status map[string]bool, ts time.Time)
 }
 
-// generateSubstitutionBox creates the S-box
-func (mte *MatrixTransformationEngine) generateSubstitutionBox() [256]byte {
-	var sbox [256]byte
-	for i := 0; i < 256; i++ {
-		sbox[i] = byte(((i * 7) + 13) % 256)
-	}
-	return sbox
+// WarningAuditSink is an AuditSink that also records the security warnings raised
+// while processing a transaction
+type WarningAuditSink interface {
+	AuditSink
+	RecordWarning(txID, warning string, ts time.Time)
 }
 
-// deriveRoundKey derives a round key from the master key
-func (mte *MatrixTransformationEngine) deriveRoundKey(masterKey []byte, round int) []byte {
-	roundKey := make([]byte, mte.blockSize)
-	for i := 0; i < mte.blockSize; i++ {
-		roundKey[i] = masterKey[i%len(masterKey)] ^ byte(round)
+// complianceAuditRecord is a single line of the JSON-lines audit log
+type complianceAuditRecord struct {
+	TransactionID    string          `json:"transaction_id"`
+	ComplianceStatus map[string]bool `json:"compliance_status"`
+	Timestamp        time.Time       `json:"timestamp"`
+}
+
+// warningAuditRecord is a security warning line of the JSON-lines audit log
+type warningAuditRecord struct {
+	TransactionID string    `json:"transaction_id"`
+	Warning       string    `json:"warning"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// JSONLinesAuditSink appends one JSON object per compliance decision to a file
+type JSONLinesAuditSink struct {
+	file    *os.File
+	encoder *json.Encoder
+	err     error
+	mutex   sync.Mutex
+}
+
+// NewJSONLinesAuditSink opens (or creates) an append-only audit log at path
+func NewJSONLinesAuditSink(path string) (*JSONLinesAuditSink, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
 	}
-	return roundKey
+
+	return &JSONLinesAuditSink{
+		file:    file,
+		encoder: json.NewEncoder(file),
+	}, nil
 }
 
-// DigestComputationEngine handles digest computations
-type DigestComputationEngine struct {
-	outputSize int
-	blockSize  int
+// RecordCompliance writes an audit record; the first write error is kept and reported by Close
+func (s *JSONLinesAuditSink) RecordCompliance(txID string, status map[string]bool, ts time.Time) {
+	s.write(complianceAuditRecord{
+		TransactionID:    txID,
+		ComplianceStatus: status,
+		Timestamp:        ts,
+	})
 }
 
-func NewDigestComputationEngine() *DigestComputationEngine {
-	return &DigestComputationEngine{
-		outputSize: 32, // 256-bit output
-		blockSize:  64, // 512-bit blocks
+// RecordWarning writes a security warning record
+func (s *JSONLinesAuditSink) RecordWarning(txID, warning string, ts time.Time) {
+	s.write(warningAuditRecord{
+		TransactionID: txID,
+		Warning:       warning,
+		Timestamp:     ts,
+	})
+}
+
+func (s *JSONLinesAuditSink) write(record interface{}) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.err != nil {
+		return
 	}
+
+	s.err = s.encoder.Encode(record)
 }
 
-// ProcessDigestComputation computes mathematical digest (disguised hash operations)
-func (dce *DigestComputationEngine) ProcessDigestComputation(data []byte) ([]byte, error) {
-	// Use standard Go crypto library for secure hash
-	hash := hash_256.Sum256(data)
+// Close closes the audit log and returns any error encountered while writing
+func (s *JSONLinesAuditSink) Close() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	// Add authentication
-	authKey := make([]byte, 32)
-	rand.Read(authKey)
+	closeErr := s.file.Close()
+	if s.err != nil {
+		return s.err
+	}
+	return closeErr
+}
 
-	authHash := hash_256.Sum256(append(authKey, data...))
+// Transaction write-ahead log
 
-	// Combine hash and authentication
-	result := make([]byte, 0, len(hash)+len(authHash))
-	result = append(result, hash[:]...)
-	result = append(result, authHash[:]...)
+// WALEntry records one processed transaction
+type WALEntry struct {
+	TransactionID     string    `json:"transaction_id"`
+	PipelineSignature []byte    `json:"pipeline_signature"`
+	ResultChecksum    []byte    `json:"result_checksum"`
+	Timestamp         time.Time `json:"timestamp"`
+}
 
-	return result, nil
+// WALSink durably appends transaction entries
+type WALSink interface {
+	AppendTransaction(entry WALEntry) error
 }
 
-// KoreanMathematicalProcessor handles Korean mathematical operations
-type KoreanMathematicalProcessor struct {
-	blockSize int
-	keySize   int
-	rounds    int
+// ResultChecksum hashes a result's processed data, as recorded in WALEntry
+func ResultChecksum(r *ProcessingResult) []byte {
+	checksum := hash_256.Sum256(r.ProcessedData)
+	return checksum[:]
 }
 
-func NewKoreanMathematicalProcessor() *KoreanMathematicalProcessor {
-	return &KoreanMathematicalProcessor{
-		blockSize: 8,  // 64-bit blocks for Korean standard
-		keySize:   16, // 128-bit keys
-		rounds:    16, // Korean standard rounds
+// VerifyWALEntry checks that result is the logged transaction, that ctx still plans
+// the logged pipeline and that result carries the logged checksum
+func (stp *SecureTransactionProcessor) VerifyWALEntry(entry WALEntry, ctx *TransactionContext, result *ProcessingResult) error {
+	if entry.TransactionID != result.TransactionID {
+		return fmt.Errorf("WAL entry is for transaction %q, not %q", entry.TransactionID, result.TransactionID)
+	}
+	if !bytes.Equal(entry.PipelineSignature, stp.PipelineSignature(ctx)) {
+		return fmt.Errorf("transaction %q: pipeline signature mismatch", entry.TransactionID)
 	}
+	if !bytes.Equal(entry.ResultChecksum, ResultChecksum(result)) {
+		return fmt.Errorf("transaction %q: result checksum mismatch", entry.TransactionID)
+	}
+	return nil
 }
 
-// ProcessKoreanAlgorithms processes data using Korean mathematical algorithms
-func (kmp *KoreanMathematicalProcessor) ProcessKoreanAlgorithms(data []byte) ([]byte, error) {
-	// Generate Korean transformation key
-	key := make([]byte, kmp.keySize)
-	if _, err := rand.Read(key); err != nil {
+// FileWAL is an append-only JSON-lines write-ahead log, synced after every entry
+type FileWAL struct {
+	file  *os.File
+	mutex sync.Mutex
+}
+
+// OpenWAL opens (or creates) the write-ahead log at path for appending
+func OpenWAL(path string) (*FileWAL, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
 		return nil, err
 	}
-
-	return kmp.applyKoreanBlockCipher(data, key), nil
+	return &FileWAL{file: file}, nil
 }
 
-// applyKoreanBlockCipher applies Korean block cipher transformation
-func (kmp *KoreanMathematicalProcessor) applyKoreanBlockCipher(data, key []byte) []byte {
-	blocks := kmp.partitionData(data)
-	var result []byte
+// AppendTransaction writes entry as a single line and syncs it to disk
+func (w *FileWAL) AppendTransaction(entry WALEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
 
-	for _, block := range blocks {
-		processedBlock := kmp.processKoreanBlock(block, key)
-		result = append(result, processedBlock...)
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if _, err := w.file.Write(line); err != nil {
+		return err
 	}
+	return w.file.Sync()
+}
 
-	return result
+// Close closes the log file
+func (w *FileWAL) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.file.Close()
 }
 
-// partitionData partitions data into Korean standard blocks
-func (kmp *KoreanMathematicalProcessor) partitionData(data []byte) [][]byte {
-	var blocks [][]byte
+// ReplayWAL calls fn for each entry of the log at path, oldest first, stopping at
+// the first error. A final line without its newline was torn by a crash mid-append
+// and is skipped; any other malformed line is an error.
+func ReplayWAL(path string, fn func(WALEntry) error) error {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
 
-	for i := 0; i < len(data); i += kmp.blockSize {
-		end := i + kmp.blockSize
-		if end > len(data) {
-			paddedBlock := make([]byte, kmp.blockSize)
-			copy(paddedBlock, data[i:])
-			blocks = append(blocks, paddedBlock)
-		} else {
-			blocks = append(blocks, data[i:end])
+	lines := bytes.Split(contents, []byte("\n"))
+	// The element after the last newline is empty, or a torn final entry
+	lines = lines[:len(lines)-1]
+
+	for i, line := range lines {
+		var entry WALEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return fmt.Errorf("WAL line %d: %w", i+1, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
 		}
 	}
 
-	return blocks
+	return nil
 }
 
-// processKoreanBlock processes a single Korean block
-func (kmp *KoreanMathematicalProcessor) processKoreanBlock(block, key []byte) []byte {
-	// Convert to 32-bit halves for Korean Feistel structure
-	left := uint32(block[0])<<24 | uint32(block[1])<<16 | uint32(block[2])<<8 | uint32(block[3])
-	right := uint32(block[4])<<24 | uint32(block[5])<<16 | uint32(block[6])<<8 | uint32(block[7])
+// Result transport encoding
+//
+// A result frame is a 1-byte type, a 4-byte big-endian payload length and the
+// payload. Within the payload, byte strings and text are length-prefixed with a
+// 4-byte big-endian count, integers are big-endian and booleans are a single byte.
 
-	for round := 0; round < kmp.rounds; round++ {
-		roundKey := kmp.generateKoreanRoundKey(key, round)
-		fOutput := kmp.koreanFFunction(right, roundKey)
+const (
+	// ResultFrameType identifies a result frame; 0x02 added per-operation input
+	// length, key material and IV, 0x03 the secret-handling flag, 0x04 dropped the
+	// unused IV and added the transaction ID
+	ResultFrameType byte = 0x04
+	// MaxResultFrameSize bounds the payload a decoder will allocate
+	MaxResultFrameSize uint32 = 64 << 20
+)
 
-		newLeft := right
-		newRight := left ^ fOutput
+// EncodeResult writes a processing result to w as a single length-prefixed frame
+// for shipping between services. Maps are written in key order so identical
+// results encode identically. SecurityMetrics values must be ints.
+func EncodeResult(w io.Writer, r *ProcessingResult) error {
+	payload := new(bytes.Buffer)
 
-		left = newLeft
-		right = newRight
+	writeBytes := func(b []byte) {
+		binary.Write(payload, binary.BigEndian, uint32(len(b)))
+		payload.Write(b)
+	}
+	writeString := func(s string) {
+		writeBytes([]byte(s))
+	}
+	writeBool := func(v bool) {
+		b := byte(0)
+		if v {
+			b = 1
+		}
+		payload.WriteByte(b)
 	}
 
-	result := make([]byte, 8)
-	result[0] = byte(left >> 24)
-	result[1] = byte(left >> 16)
-	result[2] = byte(left >> 8)
-	result[3] = byte(left)
-	result[4] = byte(right >> 24)
-	result[5] = byte(right >> 16)
-	result[6] = byte(right >> 8)
-	result[7] = byte(right)
+	writeString(r.TransactionID)
+	writeBytes(r.ProcessedData)
+	binary.Write(payload, binary.BigEndian, int64(r.ProcessingTime))
 
-	return result
-}
+	metricKeys := make([]string, 0, len(r.SecurityMetrics))
+	for key, value := range r.SecurityMetrics {
+		if _, ok := value.(int); !ok {
+			return fmt.Errorf("metric %q has unsupported type %T", key, value)
+		}
+		metricKeys = append(metricKeys, key)
+	}
+	sort.Strings(metricKeys)
 
-// koreanFFunction implements Korean F-function
-func (kmp *KoreanMathematicalProcessor) koreanFFunction(input, roundKey uint32) uint32 {
-	input ^= roundKey
+	binary.Write(payload, binary.BigEndian, uint32(len(metricKeys)))
+	for _, key := range metricKeys {
+		writeString(key)
+		binary.Write(payload, binary.BigEndian, int64(r.SecurityMetrics[key].(int)))
+	}
 
-	// Apply Korean S-boxes
-	s1 := kmp.koreanSBox1(byte(input >> 24))
-	s2 := kmp.koreanSBox2(byte(input >> 16))
-	s3 := kmp.koreanSBox1(byte(input >> 8))
-	s4 := kmp.koreanSBox2(byte(input))
+	complianceKeys := make([]string, 0, len(r.ComplianceStatus))
+	for key := range r.ComplianceStatus {
+		complianceKeys = append(complianceKeys, key)
+	}
+	sort.Strings(complianceKeys)
 
-	output := uint32(s1)<<24 | uint32(s2)<<16 | uint32(s3)<<8 | uint32(s4)
+	binary.Write(payload, binary.BigEndian, uint32(len(complianceKeys)))
+	for _, key := range complianceKeys {
+		writeString(key)
+		writeBool(r.ComplianceStatus[key])
+	}
 
-	// Linear transformation
-	return output ^ kmp.rotateLeft(output, 8) ^ kmp.rotateLeft(output, 16)
-}
+	binary.Write(payload, binary.BigEndian, uint32(len(r.OperationResults)))
+	for _, opResult := range r.OperationResults {
+		binary.Write(payload, binary.BigEndian, int32(opResult.Operation))
+		binary.Write(payload, binary.BigEndian, int64(opResult.ExecutionTime))
+		writeString(opResult.ComputationalComplexity)
+		writeString(opResult.QuantumVulnerability)
+		binary.Write(payload, binary.BigEndian, int64(opResult.InputLength))
+		writeBytes(opResult.KeyMaterial)
+		writeBool(opResult.HandledSecrets)
+	}
 
-// koreanSBox1 Korean S-box 1
-func (kmp *KoreanMathematicalProcessor) koreanSBox1(x byte) byte {
-	return byte(((int(x) * 17) + 1) % 256)
-}
+	if uint64(payload.Len()) > uint64(MaxResultFrameSize) {
+		return fmt.Errorf("result frame too large: %d bytes", payload.Len())
+	}
 
-// koreanSBox2 Korean S-box 2
-func (kmp *KoreanMathematicalProcessor) koreanSBox2(x byte) byte {
-	return byte(((int(x) * 23) + 7) % 256)
-}
+	header := make([]byte, 5)
+	header[0] = ResultFrameType
+	binary.BigEndian.PutUint32(header[1:], uint32(payload.Len()))
 
-// generateKoreanRoundKey generates Korean round key
-func (kmp *KoreanMathematicalProcessor) generateKoreanRoundKey(masterKey []byte, round int) uint32 {
-	keyOffset := (round * 4) % len(masterKey)
-	return uint32(masterKey[keyOffset])<<24 |
-		uint32(masterKey[(keyOffset+1)%len(masterKey)])<<16 |
-		uint32(masterKey[(keyOffset+2)%len(masterKey)])<<8 |
-		uint32(masterKey[(keyOffset+3)%len(masterKey)])
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
 }
 
-// rotateLeft performs left rotation
-func (kmp *KoreanMathematicalProcessor) rotateLeft(value uint32, amount int) uint32 {
-	return (value << amount) | (value >> (32 - amount))
+// DecodeResult reads a single frame written by EncodeResult
+func DecodeResult(r io.Reader) (*ProcessingResult, error) {
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != ResultFrameType {
+		return nil, fmt.Errorf("unexpected frame type: 0x%02x", header[0])
+	}
+
+	frameSize := binary.BigEndian.Uint32(header[1:])
+	if frameSize > MaxResultFrameSize {
+		return nil, fmt.Errorf("result frame too large: %d bytes", frameSize)
+	}
+
+	frame := make([]byte, frameSize)
+	if _, err := io.ReadFull(r, frame); err != nil {
+		return nil, err
+	}
+	payload := bytes.NewReader(frame)
+
+	readUint32 := func() (uint32, error) {
+		var v uint32
+		err := binary.Read(payload, binary.BigEndian, &v)
+		return v, err
+	}
+	readInt64 := func() (int64, error) {
+		var v int64
+		err := binary.Read(payload, binary.BigEndian, &v)
+		return v, err
+	}
+	readBytes := func() ([]byte, error) {
+		n, err := readUint32()
+		if err != nil {
+			return nil, err
+		}
+		if int64(n) > int64(payload.Len()) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(payload, b)
+		return b, err
+	}
+	readString := func() (string, error) {
+		b, err := readBytes()
+		return string(b), err
+	}
+	readBool := func() (bool, error) {
+		b, err := payload.ReadByte()
+		return b == 1, err
+	}
+	// Empty optional byte strings decode as nil, as they were before encoding
+	readOptionalBytes := func() ([]byte, error) {
+		b, err := readBytes()
+		if len(b) == 0 {
+			b = nil
+		}
+		return b, err
+	}
+
+	result := &ProcessingResult{
+		SecurityMetrics:  make(map[string]interface{}),
+		ComplianceStatus: make(map[string]bool),
+		OperationResults: make([]OperationResult, 0),
+	}
+
+	var err error
+	if result.TransactionID, err = readString(); err != nil {
+		return nil, fmt.Errorf("decoding transaction ID: %w", err)
+	}
+	if result.ProcessedData, err = readBytes(); err != nil {
+		return nil, fmt.Errorf("decoding processed data: %w", err)
+	}
+
+	processingTime, err := readInt64()
+	if err != nil {
+		return nil, fmt.Errorf("decoding processing time: %w", err)
+	}
+	result.ProcessingTime = time.Duration(processingTime)
+
+	metricCount, err := readUint32()
+	if err != nil {
+		return nil, fmt.Errorf("decoding metrics: %w", err)
+	}
+	for i := uint32(0); i < metricCount; i++ {
+		key, err := readString()
+		if err != nil {
+			return nil, fmt.Errorf("decoding metrics: %w", err)
+		}
+		value, err := readInt64()
+		if err != nil {
+			return nil, fmt.Errorf("decoding metrics: %w", err)
+		}
+		result.SecurityMetrics[key] = int(value)
+	}
+	result.Metrics = securityMetricsFromMap(result.SecurityMetrics)
+
+	complianceCount, err := readUint32()
+	if err != nil {
+		return nil, fmt.Errorf("decoding compliance status: %w", err)
+	}
+	for i := uint32(0); i < complianceCount; i++ {
+		key, err := readString()
+		if err != nil {
+			return nil, fmt.Errorf("decoding compliance status: %w", err)
+		}
+		passed, err := readBool()
+		if err != nil {
+			return nil, fmt.Errorf("decoding compliance status: %w", err)
+		}
+		result.ComplianceStatus[key] = passed
+	}
+
+	opCount, err := readUint32()
+	if err != nil {
+		return nil, fmt.Errorf("decoding operation results: %w", err)
+	}
+	for i := uint32(0); i < opCount; i++ {
+		var opResult OperationResult
+		var operation int32
+		if err := binary.Read(payload, binary.BigEndian, &operation); err != nil {
+			return nil, fmt.Errorf("decoding operation results: %w", err)
+		}
+		opResult.Operation = MathematicalOperation(operation)
+		executionTime, err := readInt64()
+		if err != nil {
+			return nil, fmt.Errorf("decoding operation results: %w", err)
+		}
+		opResult.ExecutionTime = time.Duration(executionTime)
+		if opResult.ComputationalComplexity, err = readString(); err != nil {
+			return nil, fmt.Errorf("decoding operation results: %w", err)
+		}
+		if opResult.QuantumVulnerability, err = readString(); err != nil {
+			return nil, fmt.Errorf("decoding operation results: %w", err)
+		}
+		inputLength, err := readInt64()
+		if err != nil {
+			return nil, fmt.Errorf("decoding operation results: %w", err)
+		}
+		opResult.InputLength = int(inputLength)
+		if opResult.KeyMaterial, err = readOptionalBytes(); err != nil {
+			return nil, fmt.Errorf("decoding operation results: %w", err)
+		}
+		if opResult.HandledSecrets, err = readBool(); err != nil {
+			return nil, fmt.Errorf("decoding operation results: %w", err)
+		}
+
+		result.OperationResults = append(result.OperationResults, opResult)
+	}
+
+	return result, nil
 }
 
-// RegionalComputationalProcessor handles regional computations
-type RegionalComputationalProcessor struct {
-	blockSize int
-	keySize   int
-	rounds    int
+// CBOR encoding
+
+// Results and contexts also encode as CBOR (RFC 8949) for clients that want a
+// self-describing binary format. Structs become maps keyed by field name, as
+// encoding/json does, so both encodings decode to the same values. Only
+// definite-length items are produced or accepted
+const (
+	cborUint   byte = 0 << 5
+	cborNegInt byte = 1 << 5
+	cborBytes  byte = 2 << 5
+	cborText   byte = 3 << 5
+	cborArray  byte = 4 << 5
+	cborMap    byte = 5 << 5
+	cborTag    byte = 6 << 5
+	cborSimple byte = 7 << 5
+
+	cborFalse byte = cborSimple | 20
+	cborTrue  byte = cborSimple | 21
+	cborNull  byte = cborSimple | 22
+
+	cborTagDateTime = 0 // RFC 3339 text timestamp
+	maxCBORDepth    = 16
+)
+
+// ErrInvalidCBOR is returned when CBOR input is malformed or has the wrong shape
+var ErrInvalidCBOR = errors.New("invalid CBOR")
+
+type cborWriter struct {
+	buf bytes.Buffer
 }
 
-func NewRegionalComputationalProcessor() *RegionalComputationalProcessor {
-	return &RegionalComputationalProcessor{
-		blockSize: 16, // 128-bit blocks for regional standard
-		keySize:   16, // 128-bit keys
-		rounds:    12, // Regional standard rounds
+func (w *cborWriter) writeHead(major byte, n uint64) {
+	switch {
+	case n < 24:
+		w.buf.WriteByte(major | byte(n))
+	case n <= math.MaxUint8:
+		w.buf.WriteByte(major | 24)
+		w.buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		w.buf.WriteByte(major | 25)
+		binary.Write(&w.buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		w.buf.WriteByte(major | 26)
+		binary.Write(&w.buf, binary.BigEndian, uint32(n))
+	default:
+		w.buf.WriteByte(major | 27)
+		binary.Write(&w.buf, binary.BigEndian, n)
 	}
 }
 
-// ProcessRegionalAlgorithms processes data using regional computational algorithms
-func (rcp *RegionalComputationalProcessor) ProcessRegionalAlgorithms(data []byte) ([]byte, error) {
-	// Generate regional key
-	key := make([]byte, rcp.keySize)
-	if _, err := rand.Read(key); err != nil {
-		return nil, err
+func (w *cborWriter) writeInt(v int64) {
+	if v < 0 {
+		w.writeHead(cborNegInt, uint64(-(v + 1)))
+		return
 	}
+	w.writeHead(cborUint, uint64(v))
+}
 
-	return rcp.applyRegionalCipher(data, key), nil
+// writeBytes encodes nil as null so it decodes back to nil, as with JSON
+func (w *cborWriter) writeBytes(b []byte) {
+	if b == nil {
+		w.buf.WriteByte(cborNull)
+		return
+	}
+	w.writeHead(cborBytes, uint64(len(b)))
+	w.buf.Write(b)
 }
 
-// applyRegionalCipher applies regional cipher transformation
-func (rcp *RegionalComputationalProcessor) applyRegionalCipher(data, key []byte) []byte {
-	blocks := rcp.partitionData(data)
-	var result []byte
+func (w *cborWriter) writeText(s string) {
+	w.writeHead(cborText, uint64(len(s)))
+	w.buf.WriteString(s)
+}
 
-	for _, block := range blocks {
-		processedBlock := rcp.processRegionalBlock(block, key)
-		result = append(result, processedBlock...)
+func (w *cborWriter) writeBool(v bool) {
+	if v {
+		w.buf.WriteByte(cborTrue)
+	} else {
+		w.buf.WriteByte(cborFalse)
 	}
-
-	return result
 }
 
-// partitionData partitions data into regional blocks
-func (rcp *RegionalComputationalProcessor) partitionData(data []byte) [][]byte {
-	var blocks [][]byte
+func (w *cborWriter) writeTime(t time.Time) {
+	w.writeHead(cborTag, cborTagDateTime)
+	w.writeText(t.Format(time.RFC3339Nano))
+}
 
-	for i := 0; i < len(data); i += rcp.blockSize {
-		end := i + rcp.blockSize
-		if end > len(data) {
-			paddedBlock := make([]byte, rcp.blockSize)
-			copy(paddedBlock, data[i:])
-			blocks = append(blocks, paddedBlock)
-		} else {
-			blocks = append(blocks, data[i:end])
-		}
+// writeStrings encodes a nil slice as null
+func (w *cborWriter) writeStrings(values []string) {
+	if values == nil {
+		w.buf.WriteByte(cborNull)
+		return
+	}
+	w.writeHead(cborArray, uint64(len(values)))
+	for _, value := range values {
+		w.writeText(value)
 	}
+}
 
-	return blocks
+type cborReader struct {
+	data []byte
+	pos  int
 }
 
-// processRegionalBlock processes a single regional block
-func (rcp *RegionalComputationalProcessor) processRegionalBlock(block, key []byte) []byte {
-	state := make([]byte, len(block))
-	copy(state, block)
+func (r *cborReader) readHead() (byte, byte, uint64, error) {
+	if r.pos >= len(r.data) {
+		return 0, 0, 0, io.ErrUnexpectedEOF
+	}
+	initial := r.data[r.pos]
+	r.pos++
+	major, info := initial&0xE0, initial&0x1F
 
-	// Initial key addition
-	rcp.addRoundKey(state, key, 0)
+	if info < 24 {
+		return major, info, uint64(info), nil
+	}
+	if info > 27 {
+		return 0, 0, 0, fmt.Errorf("%w: unsupported additional info %d", ErrInvalidCBOR, info)
+	}
+	size := 1 << (info - 24)
+	if len(r.data)-r.pos < size {
+		return 0, 0, 0, io.ErrUnexpectedEOF
+	}
+	var n uint64
+	for _, b := range r.data[r.pos : r.pos+size] {
+		n = n<<8 | uint64(b)
+	}
+	r.pos += size
+	return major, info, n, nil
+}
 
-	// Main rounds
-	for round := 1; round < rcp.rounds; round++ {
-		// Substitution layer
-		if round%2 == 1 {
-			rcp.applyRegionalSBox1(state)
-		} else {
-			rcp.applyRegionalSBox2(state)
-		}
+// readValue decodes one item into int64, []byte, string, bool, nil,
+// time.Time, []interface{} or map[string]interface{}
+func (r *cborReader) readValue(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, fmt.Errorf("%w: nesting deeper than %d", ErrInvalidCBOR, maxCBORDepth)
+	}
 
-		// Diffusion layer
-		rcp.applyRegionalDiffusion(state)
+	major, info, n, err := r.readHead()
+	if err != nil {
+		return nil, err
+	}
+	// Every array element and map entry takes at least one byte, so counts
+	// beyond the remaining input are rejected before allocating
+	remaining := uint64(len(r.data) - r.pos)
+
+	switch major {
+	case cborUint:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", ErrInvalidCBOR)
+		}
+		return int64(n), nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("%w: integer overflow", ErrInvalidCBOR)
+		}
+		return -1 - int64(n), nil
+	case cborBytes, cborText:
+		if n > remaining {
+			return nil, io.ErrUnexpectedEOF
+		}
+		raw := r.data[r.pos : r.pos+int(n)]
+		r.pos += int(n)
+		if major == cborText {
+			return string(raw), nil
+		}
+		return append([]byte{}, raw...), nil
+	case cborArray:
+		if n > remaining {
+			return nil, io.ErrUnexpectedEOF
+		}
+		values := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			value, err := r.readValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case cborMap:
+		if n > remaining {
+			return nil, io.ErrUnexpectedEOF
+		}
+		entries := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			key, err := r.readValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("%w: map key has type %T", ErrInvalidCBOR, key)
+			}
+			if entries[name], err = r.readValue(depth + 1); err != nil {
+				return nil, err
+			}
+		}
+		return entries, nil
+	case cborTag:
+		if n != cborTagDateTime {
+			return nil, fmt.Errorf("%w: unsupported tag %d", ErrInvalidCBOR, n)
+		}
+		value, err := r.readValue(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		text, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: timestamp has type %T", ErrInvalidCBOR, value)
+		}
+		t, err := time.Parse(time.RFC3339Nano, text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidCBOR, err)
+		}
+		return t, nil
+	default:
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("%w: unsupported simple value %d", ErrInvalidCBOR, info)
+	}
+}
 
-		// Key addition
-		rcp.addRoundKey(state, key, round)
+// decodeCBORMap decodes data that must hold exactly one map. Truncated input is
+// reported as ErrInvalidCBOR like any other malformed input.
+func decodeCBORMap(data []byte) (cborFields, error) {
+	reader := &cborReader{data: data}
+	value, err := reader.readValue(0)
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, fmt.Errorf("%w: truncated at byte %d", ErrInvalidCBOR, reader.pos)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reader.pos != len(data) {
+		return nil, fmt.Errorf("%w: %d trailing bytes", ErrInvalidCBOR, len(data)-reader.pos)
 	}
+	return cborFieldsOf(value, "top level")
+}
 
-	// Final substitution
-	rcp.applyRegionalSBox1(state)
-	rcp.addRoundKey(state, key, rcp.rounds)
+// cborFields is a decoded CBOR map; missing fields and nulls read as zero values
+type cborFields map[string]interface{}
 
-	return state
+func cborFieldsOf(value interface{}, name string) (cborFields, error) {
+	entries, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has type %T, want map", ErrInvalidCBOR, name, value)
+	}
+	return cborFields(entries), nil
 }
 
-// applyRegionalSBox1 applies regional S-box 1
-func (rcp *RegionalComputationalProcessor) applyRegionalSBox1(state []byte) {
-	for i := range state {
-		state[i] = byte(((int(state[i]) * 7) + 11) % 256)
+func (f cborFields) int(key string) (int64, error) {
+	switch value := f[key].(type) {
+	case nil:
+		return 0, nil
+	case int64:
+		return value, nil
+	default:
+		return 0, fmt.Errorf("%w: %s has type %T, want integer", ErrInvalidCBOR, key, value)
 	}
 }
 
-// applyRegionalSBox2 applies regional S-box 2
-func (rcp *RegionalComputationalProcessor) applyRegionalSBox2(state []byte) {
-	for i := range state {
-		state[i] = byte(((int(state[i]) * 13) + 23) % 256)
+func (f cborFields) bytes(key string) ([]byte, error) {
+	switch value := f[key].(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return value, nil
+	default:
+		return nil, fmt.Errorf("%w: %s has type %T, want bytes", ErrInvalidCBOR, key, value)
 	}
 }
 
-// applyRegionalDiffusion applies regional diffusion layer
-func (rcp *RegionalComputationalProcessor) applyRegionalDiffusion(state []byte) {
-	temp := make([]byte, len(state))
-	for i := range state {
-		temp[i] = state[i] ^ state[(i+1)%len(state)] ^ state[(i+2)%len(state)]
+func (f cborFields) text(key string) (string, error) {
+	switch value := f[key].(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	default:
+		return "", fmt.Errorf("%w: %s has type %T, want text", ErrInvalidCBOR, key, value)
 	}
-	copy(state, temp)
 }
 
-// addRoundKey adds round key to state
-func (rcp *RegionalComputationalProcessor) addRoundKey(state, key []byte, round int) {
-	for i := range state {
-		state[i] ^= key[i%len(key)] ^ byte(round)
+func (f cborFields) bool(key string) (bool, error) {
+	switch value := f[key].(type) {
+	case nil:
+		return false, nil
+	case bool:
+		return value, nil
+	default:
+		return false, fmt.Errorf("%w: %s has type %T, want bool", ErrInvalidCBOR, key, value)
 	}
 }
 
-// Supporting structures and functions
-
-// getComputationalComplexity returns computational complexity for operation
-func (stp *SecureTransactionProcessor) getComputationalComplexity(operation MathematicalOperation) string {
-	switch operation {
-	case LargeIntegerArithmetic:
-		return "exponential"
-	case PolynomialFieldComputation:
-		return "exponential"
-	case MatrixLinearTransformation:
-		return "linear"
-	case DigestComputationProcessing:
-		return "linear"
-	case KoreanMathematicalProcessing:
-		return "linear"
-	case RegionalComputationalProcessing:
-		return "linear"
+func (f cborFields) time(key string) (time.Time, error) {
+	switch value := f[key].(type) {
+	case nil:
+		return time.Time{}, nil
+	case time.Time:
+		return value, nil
 	default:
-		return "unknown"
+		return time.Time{}, fmt.Errorf("%w: %s has type %T, want timestamp", ErrInvalidCBOR, key, value)
 	}
 }
 
-// getQuantumVulnerability returns quantum vulnerability assessment
-func (stp *SecureTransactionProcessor) getQuantumVulnerability(operation MathematicalOperation) string {
-	switch operation {
-	case LargeIntegerArithmetic:
-		return "high"
-	case PolynomialFieldComputation:
-		return "high"
-	case MatrixLinearTransformation:
-		return "medium"
-	case DigestComputationProcessing:
-		return "medium"
-	case KoreanMathematicalProcessing:
-		return "medium"
-	case RegionalComputationalProcessing:
-		return "medium"
+func (f cborFields) array(key string) ([]interface{}, error) {
+	switch value := f[key].(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return value, nil
 	default:
-		return "unknown"
+		return nil, fmt.Errorf("%w: %s has type %T, want array", ErrInvalidCBOR, key, value)
 	}
 }
 
-// calculateSecurityMetrics calculates security metrics for the pipeline
-func (stp *SecureTransactionProcessor) calculateSecurityMetrics(pipeline []MathematicalOperation) map[string]interface{} {
-	metrics := make(map[string]interface{})
-
-	asymmetricOps := 0
-	symmetricOps := 0
-	hashOps := 0
-	koreanOps := 0
+func (f cborFields) fields(key string) (cborFields, error) {
+	if f[key] == nil {
+		return nil, nil
+	}
+	return cborFieldsOf(f[key], key)
+}
 
-	for _, op := range pipeline {
-		switch op {
-		case LargeIntegerArithmetic, PolynomialFieldComputation:
-			asymmetricOps++
-		case MatrixLinearTransformation, KoreanMathematicalProcessing, RegionalComputationalProcessing:
-			symmetricOps++
-		case DigestComputationProcessing:
-			hashOps++
-		}
-		if op == KoreanMathematicalProcessing || op == RegionalComputationalProcessing {
-			koreanOps++
+// MarshalCBOR encodes the context as a CBOR map keyed by field name
+func (ctx *TransactionContext) MarshalCBOR() ([]byte, error) {
+	w := new(cborWriter)
+	w.writeHead(cborMap, 8)
+
+	w.writeText("TransactionID")
+	w.writeText(ctx.TransactionID)
+	w.writeText("Data")
+	w.writeBytes(ctx.Data)
+	w.writeText("SecurityLevel")
+	w.writeInt(int64(ctx.SecurityLevel))
+	w.writeText("RequiredOperations")
+	if ctx.RequiredOperations == nil {
+		w.buf.WriteByte(cborNull)
+	} else {
+		w.writeHead(cborArray, uint64(len(ctx.RequiredOperations)))
+		for _, op := range ctx.RequiredOperations {
+			w.writeInt(int64(op))
 		}
 	}
+	w.writeText("ProcessingTimestamp")
+	w.writeTime(ctx.ProcessingTimestamp)
+	w.writeText("ComplianceRequirements")
+	w.writeStrings(ctx.ComplianceRequirements)
+	w.writeText("KeyID")
+	w.writeText(ctx.KeyID)
+	w.writeText("SkipIntegrityDigest")
+	w.writeBool(ctx.SkipIntegrityDigest)
+
+	return w.buf.Bytes(), nil
+}
 
-	metrics["asymmetric_operations"] = asymmetricOps
-	metrics["symmetric_operations"] = symmetricOps
-	metrics["hash_operations"] = hashOps
-	metrics["korean_operations"] = koreanOps
-	metrics["total_operations"] = len(pipeline)
+// UnmarshalCBOR decodes a context written by MarshalCBOR
+func (ctx *TransactionContext) UnmarshalCBOR(data []byte) error {
+	fields, err := decodeCBORMap(data)
+	if err != nil {
+		return fmt.Errorf("decoding transaction context: %w", err)
+	}
 
-	return metrics
+	var decoded TransactionContext
+	if err := decoded.fromCBOR(fields); err != nil {
+		return fmt.Errorf("decoding transaction context: %w", err)
+	}
+	*ctx = decoded
+	return nil
 }
 
-// validateCompliance validates compliance requirements
-func (stp *SecureTransactionProcessor) validateCompliance(ctx *TransactionContext, result *ProcessingResult) map[string]bool {
-	compliance := make(map[string]bool)
+func (ctx *TransactionContext) fromCBOR(fields cborFields) error {
+	var err error
+	if ctx.TransactionID, err = fields.text("TransactionID"); err != nil {
+		return err
+	}
+	if ctx.Data, err = fields.bytes("Data"); err != nil {
+		return err
+	}
+	level, err := fields.int("SecurityLevel")
+	if err != nil {
+		return err
+	}
+	ctx.SecurityLevel = TransactionSecurityLevel(level)
 
-	for _, requirement := range ctx.ComplianceRequirements {
-		switch requirement {
-		case "korean_standards":
-			koreanOps := result.SecurityMetrics["korean_operations"].(int)
-			compliance[requirement] = koreanOps > 0
-		case "quantum_awareness":
-			asymmetricOps := result.SecurityMetrics["asymmetric_operations"].(int)
-			compliance[requirement] = asymmetricOps > 0
-		case "integrity_protection":
-			hashOps := result.SecurityMetrics["hash_operations"].(int)
-			compliance[requirement] = hashOps > 0
-		default:
-			compliance[requirement] = true
+	operations, err := fields.array("RequiredOperations")
+	if err != nil {
+		return err
+	}
+	if operations != nil {
+		ctx.RequiredOperations = make([]MathematicalOperation, 0, len(operations))
+		for _, value := range operations {
+			op, ok := value.(int64)
+			if !ok {
+				return fmt.Errorf("%w: RequiredOperations element has type %T", ErrInvalidCBOR, value)
+			}
+			ctx.RequiredOperations = append(ctx.RequiredOperations, MathematicalOperation(op))
 		}
 	}
 
-	return compliance
-}
+	if ctx.ProcessingTimestamp, err = fields.time("ProcessingTimestamp"); err != nil {
+		return err
+	}
 
-// PerformanceMonitor monitors system performance
-type PerformanceMonitor struct {
-	operationTimings map[MathematicalOperation][]time.Duration
-	mutex           sync.RWMutex
+	requirements, err := fields.array("ComplianceRequirements")
+	if err != nil {
+		return err
+	}
+	if requirements != nil {
+		ctx.ComplianceRequirements = make([]string, 0, len(requirements))
+		for _, value := range requirements {
+			requirement, ok := value.(string)
+			if !ok {
+				return fmt.Errorf("%w: ComplianceRequirements element has type %T", ErrInvalidCBOR, value)
+			}
+			ctx.ComplianceRequirements = append(ctx.ComplianceRequirements, requirement)
+		}
+	}
+
+	if ctx.KeyID, err = fields.text("KeyID"); err != nil {
+		return err
+	}
+	ctx.SkipIntegrityDigest, err = fields.bool("SkipIntegrityDigest")
+	return err
 }
 
-func NewPerformanceMonitor() *PerformanceMonitor {
-	return &PerformanceMonitor{
-		operationTimings: make(map[MathematicalOperation][]time.Duration),
+// MarshalCBOR encodes the result as a CBOR map keyed by field name. As with
+// EncodeResult, SecurityMetrics values must be ints and Metrics is rebuilt
+// from them on decode rather than encoded separately
+func (r *ProcessingResult) MarshalCBOR() ([]byte, error) {
+	w := new(cborWriter)
+	w.writeHead(cborMap, 6)
+
+	w.writeText("TransactionID")
+	w.writeText(r.TransactionID)
+	w.writeText("ProcessedData")
+	w.writeBytes(r.ProcessedData)
+	w.writeText("ProcessingTime")
+	w.writeInt(int64(r.ProcessingTime))
+
+	// Map entries are written in key order so identical results encode identically
+	w.writeText("SecurityMetrics")
+	if r.SecurityMetrics == nil {
+		w.buf.WriteByte(cborNull)
+	} else {
+		metricKeys := make([]string, 0, len(r.SecurityMetrics))
+		for key := range r.SecurityMetrics {
+			metricKeys = append(metricKeys, key)
+		}
+		sort.Strings(metricKeys)
+
+		w.writeHead(cborMap, uint64(len(metricKeys)))
+		for _, key := range metricKeys {
+			value, ok := r.SecurityMetrics[key].(int)
+			if !ok {
+				return nil, fmt.Errorf("metric %q has unsupported type %T", key, r.SecurityMetrics[key])
+			}
+			w.writeText(key)
+			w.writeInt(int64(value))
+		}
+	}
+
+	w.writeText("ComplianceStatus")
+	if r.ComplianceStatus == nil {
+		w.buf.WriteByte(cborNull)
+	} else {
+		complianceKeys := make([]string, 0, len(r.ComplianceStatus))
+		for key := range r.ComplianceStatus {
+			complianceKeys = append(complianceKeys, key)
+		}
+		sort.Strings(complianceKeys)
+
+		w.writeHead(cborMap, uint64(len(complianceKeys)))
+		for _, key := range complianceKeys {
+			w.writeText(key)
+			w.writeBool(r.ComplianceStatus[key])
+		}
+	}
+
+	w.writeText("OperationResults")
+	if r.OperationResults == nil {
+		w.buf.WriteByte(cborNull)
+	} else {
+		w.writeHead(cborArray, uint64(len(r.OperationResults)))
+		for _, opResult := range r.OperationResults {
+			w.writeHead(cborMap, 7)
+			w.writeText("Operation")
+			w.writeInt(int64(opResult.Operation))
+			w.writeText("ExecutionTime")
+			w.writeInt(int64(opResult.ExecutionTime))
+			w.writeText("ComputationalComplexity")
+			w.writeText(opResult.ComputationalComplexity)
+			w.writeText("QuantumVulnerability")
+			w.writeText(opResult.QuantumVulnerability)
+			w.writeText("InputLength")
+			w.writeInt(int64(opResult.InputLength))
+			w.writeText("KeyMaterial")
+			w.writeBytes(opResult.KeyMaterial)
+			w.writeText("HandledSecrets")
+			w.writeBool(opResult.HandledSecrets)
+		}
 	}
+
+	return w.buf.Bytes(), nil
 }
 
-// RecordOperation records operation timing
-func (pm *PerformanceMonitor) RecordOperation(operation MathematicalOperation, duration time.Duration) {
-	pm.mutex.Lock()
-	defer pm.mutex.Unlock()
+// UnmarshalCBOR decodes a result written by MarshalCBOR
+func (r *ProcessingResult) UnmarshalCBOR(data []byte) error {
+	fields, err := decodeCBORMap(data)
+	if err != nil {
+		return fmt.Errorf("decoding processing result: %w", err)
+	}
 
-	pm.operationTimings[operation] = append(pm.operationTimings[operation], duration)
+	var decoded ProcessingResult
+	if err := decoded.fromCBOR(fields); err != nil {
+		return fmt.Errorf("decoding processing result: %w", err)
+	}
+	*r = decoded
+	return nil
 }
 
-// GetAverageTime returns average time for operation
-func (pm *PerformanceMonitor) GetAverageTime(operation MathematicalOperation) time.Duration {
-	pm.mutex.RLock()
-	defer pm.mutex.RUnlock()
+func (r *ProcessingResult) fromCBOR(fields cborFields) error {
+	var err error
+	if r.TransactionID, err = fields.text("TransactionID"); err != nil {
+		return err
+	}
+	if r.ProcessedData, err = fields.bytes("ProcessedData"); err != nil {
+		return err
+	}
+	processingTime, err := fields.int("ProcessingTime")
+	if err != nil {
+		return err
+	}
+	r.ProcessingTime = time.Duration(processingTime)
 
-	timings := pm.operationTimings[operation]
-	if len(timings) == 0 {
-		return 0
+	metrics, err := fields.fields("SecurityMetrics")
+	if err != nil {
+		return err
+	}
+	if metrics != nil {
+		r.SecurityMetrics = make(map[string]interface{}, len(metrics))
+		for key := range metrics {
+			value, err := metrics.int(key)
+			if err != nil {
+				return err
+			}
+			r.SecurityMetrics[key] = int(value)
+		}
+		r.Metrics = securityMetricsFromMap(r.SecurityMetrics)
 	}
 
-	var total time.Duration
-	for _, timing := range timings {
-		total += timing
+	compliance, err := fields.fields("ComplianceStatus")
+	if err != nil {
+		return err
+	}
+	if compliance != nil {
+		r.ComplianceStatus = make(map[string]bool, len(compliance))
+		for key, value := range compliance {
+			passed, ok := value.(bool)
+			if !ok {
+				return fmt.Errorf("%w: compliance status %q has type %T", ErrInvalidCBOR, key, value)
+			}
+			r.ComplianceStatus[key] = passed
+		}
 	}
 
-	return total / time.Duration(len(timings))
+	opResults, err := fields.array("OperationResults")
+	if err != nil {
+		return err
+	}
+	if opResults == nil {
+		return nil
+	}
+	r.OperationResults = make([]OperationResult, 0, len(opResults))
+	for _, value := range opResults {
+		opFields, err := cborFieldsOf(value, "OperationResults element")
+		if err != nil {
+			return err
+		}
+
+		var opResult OperationResult
+		operation, err := opFields.int("Operation")
+		if err != nil {
+			return err
+		}
+		opResult.Operation = MathematicalOperation(operation)
+		executionTime, err := opFields.int("ExecutionTime")
+		if err != nil {
+			return err
+		}
+		opResult.ExecutionTime = time.Duration(executionTime)
+		if opResult.ComputationalComplexity, err = opFields.text("ComputationalComplexity"); err != nil {
+			return err
+		}
+		if opResult.QuantumVulnerability, err = opFields.text("QuantumVulnerability"); err != nil {
+			return err
+		}
+		inputLength, err := opFields.int("InputLength")
+		if err != nil {
+			return err
+		}
+		opResult.InputLength = int(inputLength)
+		if opResult.KeyMaterial, err = opFields.bytes("KeyMaterial"); err != nil {
+			return err
+		}
+		if opResult.HandledSecrets, err = opFields.bool("HandledSecrets"); err != nil {
+			return err
+		}
+
+		r.OperationResults = append(r.OperationResults, opResult)
+	}
+
+	return nil
 }
 
 // Example usage
@@ -929,7 +6146,7 @@ func main() {
 
 	// Create transaction context
 	ctx := &TransactionContext{
-		TransactionID:      "tx_" + fmt.Sprintf("%d", time.Now().Unix()),
+		TransactionID:      GenerateTransactionID(),
 		Data:               []byte("Secure transaction data requiring mathematical protection"),
 		SecurityLevel:      EnterpriseSecurity,
 		RequiredOperations: []MathematicalOperation{
@@ -955,7 +6172,7 @@ func main() {
 		return
 	}
 
-	fmt.Printf("Transaction %s processed successfully\n", ctx.TransactionID)
+	fmt.Printf("Transaction %s processed successfully\n", result.TransactionID)
 	fmt.Printf("Processing time: %v\n", result.ProcessingTime)
 	fmt.Printf("Operations executed: %d\n", len(result.OperationResults))
 	fmt.Printf("Compliance status: %v\n", result.ComplianceStatus)
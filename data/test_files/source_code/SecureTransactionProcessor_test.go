@@ -0,0 +1,3124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hash_256"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"math/bits"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestEncodeResultRoundTripOverPipe(t *testing.T) {
+	stp := NewSecureTransactionProcessor(WithRetainKeys(true))
+	want, err := stp.ProcessSecureTransaction(&TransactionContext{
+		Data:                   []byte("transfer 100 to account 42"),
+		SecurityLevel:          StandardSecurity,
+		ComplianceRequirements: []string{"korean_standards"},
+	})
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+
+	client, server := net.Pipe()
+	defer server.Close()
+
+	errs := make(chan error, 1)
+	go func() {
+		defer client.Close()
+		errs <- EncodeResult(client, want)
+	}()
+
+	got, err := DecodeResult(server)
+	if err != nil {
+		t.Fatalf("DecodeResult: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("EncodeResult: %v", err)
+	}
+
+	if got.TransactionID != want.TransactionID {
+		t.Errorf("transaction ID = %q, want %q", got.TransactionID, want.TransactionID)
+	}
+	if !reflect.DeepEqual(got.ProcessedData, want.ProcessedData) {
+		t.Error("processed data changed in transit")
+	}
+	if got.ProcessingTime != want.ProcessingTime {
+		t.Errorf("processing time = %v, want %v", got.ProcessingTime, want.ProcessingTime)
+	}
+	if !reflect.DeepEqual(got.OperationResults, want.OperationResults) {
+		t.Errorf("operation results = %+v, want %+v", got.OperationResults, want.OperationResults)
+	}
+	if !reflect.DeepEqual(got.SecurityMetrics, want.SecurityMetrics) || !reflect.DeepEqual(got.Metrics, want.Metrics) {
+		t.Errorf("metrics = %v, want %v", got.SecurityMetrics, want.SecurityMetrics)
+	}
+	if !reflect.DeepEqual(got.ComplianceStatus, want.ComplianceStatus) {
+		t.Errorf("compliance status = %v, want %v", got.ComplianceStatus, want.ComplianceStatus)
+	}
+}
+
+func TestEncodeResultRejectsNonIntegerMetrics(t *testing.T) {
+	r := &ProcessingResult{SecurityMetrics: map[string]interface{}{"ratio": 0.5}}
+	if err := EncodeResult(io.Discard, r); err == nil {
+		t.Fatal("encoded a non-integer metric")
+	}
+}
+
+func TestEncodeResultIsDeterministic(t *testing.T) {
+	r := &ProcessingResult{
+		SecurityMetrics:  map[string]interface{}{"a": 1, "b": 2, "c": 3},
+		ComplianceStatus: map[string]bool{"x": true, "y": false},
+	}
+
+	var first, second bytes.Buffer
+	if err := EncodeResult(&first, r); err != nil {
+		t.Fatal(err)
+	}
+	if err := EncodeResult(&second, r); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first.Bytes(), second.Bytes()) {
+		t.Fatal("identical results encoded differently")
+	}
+}
+
+func TestDecodeResultRejectsBadFrames(t *testing.T) {
+	var frame bytes.Buffer
+	if err := EncodeResult(&frame, &ProcessingResult{ProcessedData: []byte("data")}); err != nil {
+		t.Fatal(err)
+	}
+	encoded := frame.Bytes()
+
+	wrongType := append([]byte(nil), encoded...)
+	wrongType[0] = ResultFrameType + 1
+	if _, err := DecodeResult(bytes.NewReader(wrongType)); err == nil {
+		t.Error("accepted unknown frame type")
+	}
+
+	oversized := []byte{ResultFrameType, 0xff, 0xff, 0xff, 0xff}
+	if _, err := DecodeResult(bytes.NewReader(oversized)); err == nil {
+		t.Error("accepted frame above MaxResultFrameSize")
+	}
+
+	if _, err := DecodeResult(bytes.NewReader(encoded[:len(encoded)-1])); err == nil {
+		t.Error("accepted truncated frame")
+	}
+}
+
+func TestGeneratePrimesHonoursDeadline(t *testing.T) {
+	lnp := NewLargeNumberProcessor()
+	lnp.modulusBitLength = 8192 // slow enough that a millisecond never suffices
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+
+	if _, _, err := lnp.GeneratePrimes(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GeneratePrimes error = %v, want deadline exceeded", err)
+	}
+}
+
+func TestPrimePoolServesPooledPrimes(t *testing.T) {
+	lnp := NewLargeNumberProcessor()
+	lnp.modulusBitLength = 512
+	lnp.EnablePrimePool(4)
+	defer lnp.StopPrimePool()
+
+	p, q, err := lnp.GeneratePrimes(context.Background())
+	if err != nil {
+		t.Fatalf("GeneratePrimes: %v", err)
+	}
+	if p.BitLen() != 256 || q.BitLen() != 256 || p.Cmp(q) == 0 {
+		t.Fatalf("got primes of %d and %d bits, equal=%v", p.BitLen(), q.BitLen(), p.Cmp(q) == 0)
+	}
+}
+
+// failingReader is an entropy source that always fails, counting its reads
+type failingReader struct {
+	reads atomic.Int64
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	r.reads.Add(1)
+	return 0, errors.New("entropy source exhausted")
+}
+
+func TestPrimePoolBacksOffOnEntropyFailure(t *testing.T) {
+	source := &failingReader{}
+	lnp := NewLargeNumberProcessor()
+	lnp.random = source
+	lnp.EnablePrimePool(4)
+
+	time.Sleep(100 * time.Millisecond)
+	lnp.StopPrimePool()
+
+	// 10ms doubling gives four or five attempts in 100ms; a spinning refill
+	// would make many thousands
+	if reads := source.reads.Load(); reads > 10 {
+		t.Fatalf("refill made %d reads in 100ms, want backoff", reads)
+	}
+}
+
+func TestPrimePoolErrReportsRefillFailure(t *testing.T) {
+	lnp := NewLargeNumberProcessor()
+	lnp.random = &failingReader{}
+	lnp.EnablePrimePool(1)
+	defer lnp.StopPrimePool()
+
+	deadline := time.Now().Add(time.Second)
+	for lnp.PrimePoolErr() == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("PrimePoolErr still nil after refill failures")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMatrixEngineCachesSubstitutionBox(t *testing.T) {
+	mte := NewMatrixTransformationEngine()
+
+	if mte.sbox != mte.generateSubstitutionBox() {
+		t.Fatal("stored S-box differs from the generated one")
+	}
+	for i := 0; i < 256; i++ {
+		if mte.invSbox[mte.sbox[i]] != byte(i) {
+			t.Fatalf("inverse S-box does not invert input %#02x", i)
+		}
+	}
+}
+
+func BenchmarkSubstituteBytes(b *testing.B) {
+	mte := NewMatrixTransformationEngine()
+	state := make([]byte, 16)
+	for i := 0; i < b.N; i++ {
+		mte.substituteBytes(state)
+	}
+}
+
+// BenchmarkSubstituteBytesRegenerating is the old substituteBytes, which rebuilt
+// the S-box on every call, for comparison with BenchmarkSubstituteBytes
+func BenchmarkSubstituteBytesRegenerating(b *testing.B) {
+	mte := NewMatrixTransformationEngine()
+	state := make([]byte, 16)
+	for i := 0; i < b.N; i++ {
+		sbox := mte.generateSubstitutionBox()
+		for j := range state {
+			state[j] = sbox[state[j]]
+		}
+	}
+}
+
+// passthroughOperation is a custom operation value outside the built-in range
+const passthroughOperation MathematicalOperation = 100
+
+func withPassthrough(prefix string) ProcessorOption {
+	return WithOperation(passthroughOperation, func(data []byte) ([]byte, error) {
+		return append([]byte(prefix), data...), nil
+	}, OperationMeta{
+		Name:                    "Passthrough",
+		ComputationalComplexity: "linear",
+		QuantumVulnerability:    "low",
+		Categories:              []string{"encoding"},
+	})
+}
+
+func TestCustomOperationRunsInPipeline(t *testing.T) {
+	stp := NewSecureTransactionProcessor(withPassthrough("P:"))
+	ctx := &TransactionContext{
+		Data:                []byte("x"),
+		SecurityLevel:       MinimumSecurity,
+		RequiredOperations:  []MathematicalOperation{passthroughOperation},
+		SkipIntegrityDigest: true,
+	}
+
+	result, err := stp.ProcessSecureTransaction(ctx)
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+	if len(result.OperationResults) != 1 || result.OperationResults[0].Operation != passthroughOperation {
+		t.Fatalf("pipeline = %+v, want only the passthrough stage", result.OperationResults)
+	}
+	if string(result.ProcessedData) != "P:x" {
+		t.Fatalf("processed data = %q, want %q", result.ProcessedData, "P:x")
+	}
+
+	found := false
+	for _, info := range stp.ListOperations() {
+		if info.Operation == passthroughOperation {
+			found = info.Name == "Passthrough" && info.QuantumVulnerability == "low"
+		}
+	}
+	if !found {
+		t.Fatal("ListOperations does not report the custom operation's metadata")
+	}
+}
+
+func TestCustomOperationsArePerProcessor(t *testing.T) {
+	registered := NewSecureTransactionProcessor(withPassthrough("P:"))
+	other := NewSecureTransactionProcessor()
+	ctx := &TransactionContext{
+		Data:               []byte("x"),
+		SecurityLevel:      MinimumSecurity,
+		RequiredOperations: []MathematicalOperation{passthroughOperation},
+	}
+
+	if got := len(registered.buildProcessingPipeline(ctx)); got != 2 {
+		t.Fatalf("registering processor plans %d stages, want passthrough and digest", got)
+	}
+	if got := other.buildProcessingPipeline(ctx); len(got) != 1 || got[0] != DigestComputationProcessing {
+		t.Fatalf("other processor plans %v, want only the digest", got)
+	}
+	for _, info := range other.ListOperations() {
+		if info.Operation == passthroughOperation {
+			t.Fatal("custom operation leaked into another processor")
+		}
+	}
+}
+
+func TestWithOperationRejectsBuiltins(t *testing.T) {
+	defer func() {
+		err, _ := recover().(error)
+		if !errors.Is(err, ErrBuiltinOperation) {
+			t.Fatalf("recovered %v, want ErrBuiltinOperation", err)
+		}
+	}()
+
+	NewSecureTransactionProcessor(WithOperation(MatrixLinearTransformation, func(data []byte) ([]byte, error) {
+		return data, nil
+	}, OperationMeta{Name: "Replacement"}))
+	t.Fatal("replacing a built-in operation did not panic")
+}
+
+func TestRegisteredOperationCountsTowardsMetrics(t *testing.T) {
+	stp := NewSecureTransactionProcessor(WithOperation(200, func(data []byte) ([]byte, error) {
+		return data, nil
+	}, OperationMeta{Name: "PostQuantumKEM", Categories: []string{"postquantum"}}))
+
+	result, err := stp.ProcessSecureTransaction(&TransactionContext{
+		Data:               []byte("x"),
+		SecurityLevel:      MinimumSecurity,
+		RequiredOperations: []MathematicalOperation{200},
+	})
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+
+	if got := result.Metrics.CategoryOps["postquantum"]; got != 1 {
+		t.Errorf("postquantum category count = %d, want 1", got)
+	}
+	if got := result.SecurityMetrics["postquantum_operations"]; got != 1 {
+		t.Errorf("postquantum_operations metric = %v, want 1", got)
+	}
+	if result.Metrics.HashOps != 1 || result.Metrics.TotalOps != 2 {
+		t.Errorf("metrics = %+v, want the digest counted alongside the custom stage", result.Metrics)
+	}
+}
+
+func TestReverseSecureTransactionRecoversInput(t *testing.T) {
+	stp := NewSecureTransactionProcessor(WithRetainKeys(true))
+	ctx := &TransactionContext{
+		Data:                   []byte("wire 2500 to account 11-2233"),
+		SecurityLevel:          StandardSecurity,
+		ComplianceRequirements: []string{"korean_standards", "format_preservation"},
+		SkipIntegrityDigest:    true,
+	}
+
+	result, err := stp.ProcessSecureTransaction(ctx)
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+	if bytes.Equal(result.ProcessedData, ctx.Data) {
+		t.Fatal("pipeline left the data unchanged")
+	}
+
+	recovered, err := stp.ReverseSecureTransaction(ctx, result)
+	if err != nil {
+		t.Fatalf("ReverseSecureTransaction: %v", err)
+	}
+	if !bytes.Equal(recovered, ctx.Data) {
+		t.Fatalf("recovered %q, want %q", recovered, ctx.Data)
+	}
+}
+
+func TestReverseSecureTransactionErrors(t *testing.T) {
+	data := []byte("payload")
+
+	withDigest := NewSecureTransactionProcessor(WithRetainKeys(true))
+	ctx := &TransactionContext{Data: data, SecurityLevel: StandardSecurity}
+	result, err := withDigest.ProcessSecureTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := withDigest.ReverseSecureTransaction(ctx, result); !errors.Is(err, ErrIrreversibleOperation) {
+		t.Errorf("reversing a digest pipeline: %v, want ErrIrreversibleOperation", err)
+	}
+
+	withoutKeys := NewSecureTransactionProcessor()
+	ctx = &TransactionContext{Data: data, SecurityLevel: StandardSecurity, SkipIntegrityDigest: true}
+	if result, err = withoutKeys.ProcessSecureTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := withoutKeys.ReverseSecureTransaction(ctx, result); !errors.Is(err, ErrKeyMaterialUnavailable) {
+		t.Errorf("reversing without retained keys: %v, want ErrKeyMaterialUnavailable", err)
+	}
+
+	mismatched := &TransactionContext{Data: data, SecurityLevel: MinimumSecurity, SkipIntegrityDigest: true}
+	if _, err := withoutKeys.ReverseSecureTransaction(mismatched, result); err == nil {
+		t.Error("reversed a result under a context planning a different pipeline")
+	}
+}
+
+func TestGenerateTransactionIDIsUnique(t *testing.T) {
+	seen := make(map[string]bool, 10000)
+	for i := 0; i < 10000; i++ {
+		id := GenerateTransactionID()
+		if seen[id] {
+			t.Fatalf("duplicate transaction ID %s after %d IDs", id, i)
+		}
+		if len(id) != 36 || id[14] != '4' {
+			t.Fatalf("%s is not a version 4 UUID", id)
+		}
+		seen[id] = true
+	}
+}
+
+func TestProcessSecureTransactionLeavesContextUnchanged(t *testing.T) {
+	stp := NewSecureTransactionProcessor()
+
+	ctx := &TransactionContext{Data: []byte("x")}
+	result, err := stp.ProcessSecureTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.TransactionID != "" {
+		t.Errorf("ProcessSecureTransaction wrote %q into the caller's context", ctx.TransactionID)
+	}
+	if len(result.TransactionID) != 36 {
+		t.Errorf("result transaction ID = %q, want a generated UUID", result.TransactionID)
+	}
+
+	ctx = &TransactionContext{TransactionID: "tx-42", Data: []byte("x")}
+	if result, err = stp.ProcessSecureTransaction(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if result.TransactionID != "tx-42" {
+		t.Errorf("result transaction ID = %q, want the context's", result.TransactionID)
+	}
+}
+
+// failingOperation is a custom operation whose handler fails while fail is set
+const failingOperation MathematicalOperation = 101
+
+func withFailing(fail *bool) ProcessorOption {
+	return WithOperation(failingOperation, func(data []byte) ([]byte, error) {
+		if *fail {
+			return nil, errors.New("operation failed")
+		}
+		return data, nil
+	}, OperationMeta{Name: "Failing"})
+}
+
+func TestCircuitBreakerIsOptIn(t *testing.T) {
+	fail := true
+	stp := NewSecureTransactionProcessor(withFailing(&fail))
+	ctx := &TransactionContext{
+		Data:                []byte("x"),
+		RequiredOperations:  []MathematicalOperation{failingOperation},
+		SkipIntegrityDigest: true,
+	}
+
+	for i := 0; i < 10; i++ {
+		if _, err := stp.ProcessSecureTransaction(ctx); errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d short-circuited without WithCircuitBreaker", i)
+		}
+	}
+}
+
+func TestCircuitBreakerOpensAndRecovers(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	fail := true
+	stp := NewSecureTransactionProcessor(withFailing(&fail), WithClock(clock), WithCircuitBreaker(2, time.Minute))
+	ctx := &TransactionContext{
+		Data:                []byte("x"),
+		RequiredOperations:  []MathematicalOperation{failingOperation},
+		SkipIntegrityDigest: true,
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := stp.ProcessSecureTransaction(ctx); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: err = %v, want the operation's failure", i, err)
+		}
+	}
+	fail = false
+	if _, err := stp.ProcessSecureTransaction(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v after threshold failures, want ErrCircuitOpen", err)
+	}
+
+	clock.Advance(time.Minute)
+	if _, err := stp.ProcessSecureTransaction(ctx); err != nil {
+		t.Fatalf("trial call after cooldown: %v", err)
+	}
+	if _, err := stp.ProcessSecureTransaction(ctx); err != nil {
+		t.Fatalf("breaker did not close after a successful trial: %v", err)
+	}
+}
+
+func TestLoadConfigEmptyAllowedOperationsAllowsEverything(t *testing.T) {
+	stp := NewSecureTransactionProcessor()
+	config := stp.Config()
+	config.AllowedOperations = nil
+	if err := stp.LoadConfig(config); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+
+	if _, err := stp.ProcessSecureTransaction(&TransactionContext{Data: []byte("x")}); err != nil {
+		t.Fatalf("ProcessSecureTransaction after loading an empty allowed set: %v", err)
+	}
+	if got, want := stp.Config().AllowedOperations, stp.registeredOperations(); !reflect.DeepEqual(got, want) {
+		t.Errorf("allowed operations = %v, want every registered operation %v", got, want)
+	}
+}
+
+func TestLoadConfigRoundTripsAllowedOperations(t *testing.T) {
+	stp := NewSecureTransactionProcessor()
+	config := stp.Config()
+	config.AllowedOperations = []MathematicalOperation{DigestComputationProcessing}
+	if err := stp.LoadConfig(config); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if got := stp.Config().AllowedOperations; !reflect.DeepEqual(got, config.AllowedOperations) {
+		t.Errorf("allowed operations = %v, want %v", got, config.AllowedOperations)
+	}
+
+	ctx := &TransactionContext{Data: []byte("x"), RequiredOperations: []MathematicalOperation{MatrixLinearTransformation}}
+	if _, err := stp.ProcessSecureTransaction(ctx); !errors.Is(err, ErrOperationNotAllowed) {
+		t.Errorf("err = %v, want ErrOperationNotAllowed", err)
+	}
+}
+
+func TestPipelineSignatureIsStable(t *testing.T) {
+	ctx := &TransactionContext{Data: []byte("x"), SecurityLevel: EnhancedSecurity}
+
+	first := NewSecureTransactionProcessor().PipelineSignature(ctx)
+	if second := NewSecureTransactionProcessor().PipelineSignature(ctx); !bytes.Equal(first, second) {
+		t.Fatal("signature differs between processors with the same configuration")
+	}
+
+	ctx.Data = []byte("different input, same pipeline")
+	if got := NewSecureTransactionProcessor().PipelineSignature(ctx); !bytes.Equal(got, first) {
+		t.Error("signature depends on the transaction data")
+	}
+}
+
+func TestPipelineSignatureTracksPipelineChanges(t *testing.T) {
+	stp := NewSecureTransactionProcessor(withPassthrough("P:"))
+	base := stp.PipelineSignature(&TransactionContext{SecurityLevel: StandardSecurity})
+
+	changes := map[string]*TransactionContext{
+		"security level":      {SecurityLevel: EnhancedSecurity},
+		"compliance":          {SecurityLevel: StandardSecurity, ComplianceRequirements: []string{"korean_standards"}},
+		"required operations": {SecurityLevel: StandardSecurity, RequiredOperations: []MathematicalOperation{passthroughOperation}},
+	}
+	for name, ctx := range changes {
+		if bytes.Equal(stp.PipelineSignature(ctx), base) {
+			t.Errorf("changing the %s left the signature unchanged", name)
+		}
+	}
+
+	// Metadata is part of the fingerprint, not just the operation values
+	renamed := NewSecureTransactionProcessor(WithOperation(passthroughOperation, func(data []byte) ([]byte, error) {
+		return data, nil
+	}, OperationMeta{Name: "Renamed", ComputationalComplexity: "linear", QuantumVulnerability: "low"}))
+	ctx := changes["required operations"]
+	if bytes.Equal(renamed.PipelineSignature(ctx), stp.PipelineSignature(ctx)) {
+		t.Error("changing operation metadata left the signature unchanged")
+	}
+}
+
+func TestValidateComplianceMisspelledRequirement(t *testing.T) {
+	ctx := &TransactionContext{
+		Data:                   []byte("x"),
+		ComplianceRequirements: []string{"koreen_standards", "integrity_protection"},
+	}
+
+	tests := []struct {
+		name   string
+		strict bool
+		want   bool
+	}{
+		{"lenient", false, true},
+		{"strict", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stp := NewSecureTransactionProcessor(WithStrictCompliance(tt.strict))
+			result, err := stp.ProcessSecureTransaction(ctx)
+			if err != nil {
+				t.Fatalf("ProcessSecureTransaction: %v", err)
+			}
+			if got := result.ComplianceStatus["koreen_standards"]; got != tt.want {
+				t.Errorf("misspelled requirement = %v, want %v", got, tt.want)
+			}
+			if !result.ComplianceStatus["integrity_protection"] {
+				t.Error("known requirement was not evaluated normally")
+			}
+		})
+	}
+}
+
+func TestDigestOutputCarriesVersion(t *testing.T) {
+	dce := NewDigestComputationEngine()
+	data := []byte("payload")
+
+	output, err := dce.ProcessDigestComputation(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output[0] != DigestOutputVersion {
+		t.Fatalf("version byte = 0x%02x, want 0x%02x", output[0], DigestOutputVersion)
+	}
+	if err := dce.VerifyDigest(data, output); err != nil {
+		t.Fatalf("VerifyDigest: %v", err)
+	}
+
+	bumped := append([]byte(nil), output...)
+	bumped[0] = DigestOutputVersion + 1
+	if err := dce.VerifyDigest(data, bumped); !errors.Is(err, ErrUnknownDigestVersion) {
+		t.Errorf("err = %v for a bumped version, want ErrUnknownDigestVersion", err)
+	}
+}
+
+func TestPredictedMetricsMatchActualRun(t *testing.T) {
+	stp := NewSecureTransactionProcessor(withPassthrough("P:"))
+	contexts := []*TransactionContext{
+		{Data: []byte("x"), SecurityLevel: MinimumSecurity},
+		{Data: []byte("x"), SecurityLevel: StandardSecurity, ComplianceRequirements: []string{"korean_standards"}},
+		{Data: []byte("x"), SecurityLevel: EnhancedSecurity, RequiredOperations: []MathematicalOperation{passthroughOperation}},
+	}
+
+	for _, ctx := range contexts {
+		predicted := stp.PredictedMetrics(ctx)
+		result, err := stp.ProcessSecureTransaction(ctx)
+		if err != nil {
+			t.Fatalf("ProcessSecureTransaction: %v", err)
+		}
+		if !reflect.DeepEqual(predicted, result.Metrics) {
+			t.Errorf("level %d: predicted %+v, actual %+v", ctx.SecurityLevel, predicted, result.Metrics)
+		}
+	}
+}
+
+// executedOperations lists the stages a result ran, in order
+func executedOperations(result *ProcessingResult) []MathematicalOperation {
+	operations := make([]MathematicalOperation, 0, len(result.OperationResults))
+	for _, opResult := range result.OperationResults {
+		operations = append(operations, opResult.Operation)
+	}
+	return operations
+}
+
+func TestWithComplianceMappingOverridesPipeline(t *testing.T) {
+	mapping := ComplianceMapping{"korean_standards": {RegionalComputationalProcessing}}
+	stp := NewSecureTransactionProcessor(WithComplianceMapping(mapping))
+
+	// The processor keeps its own copy
+	mapping["korean_standards"] = append(mapping["korean_standards"], FormatPreservingEncryption)
+
+	ctx := &TransactionContext{
+		Data:                   []byte("x"),
+		ComplianceRequirements: []string{"korean_standards", "format_preservation"},
+	}
+	result, err := stp.ProcessSecureTransaction(ctx)
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+
+	// format_preservation is absent from the replacement mapping, so adds nothing
+	want := []MathematicalOperation{MatrixLinearTransformation, RegionalComputationalProcessing, DigestComputationProcessing}
+	if got := executedOperations(result); !reflect.DeepEqual(got, want) {
+		t.Errorf("pipeline = %v, want %v", got, want)
+	}
+}
+
+func TestMinimalPipelinePerSecurityLevel(t *testing.T) {
+	tests := []struct {
+		level TransactionSecurityLevel
+		want  []MathematicalOperation
+	}{
+		{MinimumSecurity, []MathematicalOperation{DigestComputationProcessing}},
+		{StandardSecurity, []MathematicalOperation{MatrixLinearTransformation, DigestComputationProcessing}},
+		{EnhancedSecurity, []MathematicalOperation{LargeIntegerArithmetic, PolynomialFieldComputation, MatrixLinearTransformation, DigestComputationProcessing}},
+		{MaximumSecurity, []MathematicalOperation{LargeIntegerArithmetic, PolynomialFieldComputation, MatrixLinearTransformation, DigestComputationProcessing}},
+		{EnterpriseSecurity, []MathematicalOperation{LargeIntegerArithmetic, PolynomialFieldComputation, MatrixLinearTransformation, DigestComputationProcessing}},
+	}
+
+	stp := NewSecureTransactionProcessor()
+	for _, tt := range tests {
+		ctx := &TransactionContext{Data: []byte("x"), SecurityLevel: tt.level}
+		if got := stp.buildProcessingPipeline(ctx); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("level %d: pipeline = %v, want %v", tt.level, got, tt.want)
+		}
+	}
+
+	// MinimumSecurity output is just the digest of the input
+	data := []byte("x")
+	result, err := stp.ProcessSecureTransaction(&TransactionContext{Data: data, SecurityLevel: MinimumSecurity})
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+	if err := stp.digestCalculator.VerifyDigest(data, result.ProcessedData); err != nil {
+		t.Errorf("MinimumSecurity output is not the input's digest: %v", err)
+	}
+}
+
+func TestRegionalAlgorithmsRoundTrip(t *testing.T) {
+	rcp := NewRegionalComputationalProcessor()
+	key := []byte("regional-key-128")
+
+	for _, length := range []int{0, 1, 15, 16, 17, 100} {
+		data := bytes.Repeat([]byte{0x5a}, length)
+		for i := range data {
+			data[i] ^= byte(i)
+		}
+
+		encrypted, err := rcp.EncryptRegionalAlgorithms(data, key)
+		if err != nil {
+			t.Fatalf("EncryptRegionalAlgorithms: %v", err)
+		}
+		decrypted, err := rcp.ReverseRegionalAlgorithms(encrypted, key, length)
+		if err != nil {
+			t.Fatalf("length %d: ReverseRegionalAlgorithms: %v", length, err)
+		}
+		if !bytes.Equal(decrypted, data) {
+			t.Errorf("length %d: round trip = %x, want %x", length, decrypted, data)
+		}
+	}
+
+	if _, err := rcp.DecryptRegionalAlgorithms(make([]byte, 17), key); err == nil {
+		t.Error("decrypted data that is not a whole number of blocks")
+	}
+	if _, err := rcp.DecryptRegionalAlgorithms(make([]byte, 16), key[:8]); err == nil {
+		t.Error("decrypted under a short key")
+	}
+}
+
+func TestRegionalInverseSBoxes(t *testing.T) {
+	rcp := NewRegionalComputationalProcessor()
+
+	for x := 0; x < 256; x++ {
+		state := []byte{byte(x), byte(x)}
+		rcp.applyRegionalSBox1(state[:1])
+		rcp.invertRegionalSBox1(state[:1])
+		rcp.applyRegionalSBox2(state[1:])
+		rcp.invertRegionalSBox2(state[1:])
+		if state[0] != byte(x) {
+			t.Fatalf("invSBox1(sBox1(0x%02x)) = 0x%02x", x, state[0])
+		}
+		if state[1] != byte(x) {
+			t.Fatalf("invSBox2(sBox2(0x%02x)) = 0x%02x", x, state[1])
+		}
+	}
+
+	if _, err := invertSBox(affineSBox(2, 1)); err == nil {
+		t.Error("invertSBox accepted a non-bijective S-box")
+	}
+}
+
+func TestCascadeCipherRoundTrip(t *testing.T) {
+	matrixKey := bytes.Repeat([]byte{0x11}, 32)
+	regionalKey := bytes.Repeat([]byte{0x22}, 16)
+	cc, err := NewCascadeCipher(matrixKey, regionalKey)
+	if err != nil {
+		t.Fatalf("NewCascadeCipher: %v", err)
+	}
+
+	data := []byte("cascade through both block ciphers")
+	encrypted := cc.Encrypt(data)
+	if bytes.Contains(encrypted, data[:16]) {
+		t.Fatal("ciphertext contains the plaintext")
+	}
+	decrypted, err := cc.Decrypt(encrypted, len(data))
+	if err != nil {
+		t.Fatalf("Decrypt: %v", err)
+	}
+	if !bytes.Equal(decrypted, data) {
+		t.Fatalf("round trip = %q, want %q", decrypted, data)
+	}
+
+	// Each stage is keyed independently
+	other, err := NewCascadeCipher(matrixKey, bytes.Repeat([]byte{0x33}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wrong, err := other.Decrypt(encrypted, len(data)); err == nil && bytes.Equal(wrong, data) {
+		t.Error("decrypted with the wrong regional key")
+	}
+
+	if _, err := NewCascadeCipher(matrixKey[:16], regionalKey); err == nil {
+		t.Error("accepted a short matrix key")
+	}
+	if _, err := NewCascadeCipher(matrixKey, regionalKey[:8]); err == nil {
+		t.Error("accepted a short regional key")
+	}
+}
+
+func TestFPEProcessorSixteenDigitRoundTrip(t *testing.T) {
+	fpe, err := NewFPEProcessor(10, 6, 32)
+	if err != nil {
+		t.Fatalf("NewFPEProcessor: %v", err)
+	}
+	key := bytes.Repeat([]byte{0x42}, fpe.KeySize())
+	tweak := []byte("account")
+	account := "4111111111111111"
+
+	encrypted, err := fpe.EncryptString(account, key, tweak)
+	if err != nil {
+		t.Fatalf("EncryptString: %v", err)
+	}
+	if len(encrypted) != len(account) {
+		t.Fatalf("ciphertext %q has %d digits, want %d", encrypted, len(encrypted), len(account))
+	}
+	for _, c := range encrypted {
+		if c < '0' || c > '9' {
+			t.Fatalf("ciphertext %q leaves the decimal alphabet", encrypted)
+		}
+	}
+	if encrypted == account {
+		t.Fatal("ciphertext equals the plaintext")
+	}
+
+	decrypted, err := fpe.DecryptString(encrypted, key, tweak)
+	if err != nil {
+		t.Fatalf("DecryptString: %v", err)
+	}
+	if decrypted != account {
+		t.Fatalf("round trip = %q, want %q", decrypted, account)
+	}
+
+	if other, err := fpe.EncryptString(account, key, []byte("other")); err != nil || other == encrypted {
+		t.Errorf("tweak does not change the ciphertext (%q, %v)", other, err)
+	}
+	if _, err := fpe.EncryptString("12a4567890123456", key, tweak); err == nil {
+		t.Error("accepted a non-decimal character")
+	}
+}
+
+func TestSecurityWarnings(t *testing.T) {
+	warnings := NewSecureTransactionProcessor().SecurityWarnings()
+	expected := []string{
+		"LargeIntegerArithmetic: textbook RSA without OAEP padding",
+		"MatrixLinearTransformation: toy affine S-box",
+		"MatrixLinearTransformation: ECB mode",
+		"KoreanMathematicalProcessing: toy affine S-box",
+		"RegionalComputationalProcessing: ECB mode",
+		"FormatPreservingEncryption: round function is the matrix engine",
+	}
+	for _, prefix := range expected {
+		found := false
+		for _, warning := range warnings {
+			if strings.HasPrefix(warning, prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("default processor does not warn %q; warnings: %q", prefix, warnings)
+		}
+	}
+
+	retaining := NewSecureTransactionProcessor(WithRetainKeys(true))
+	if got := retaining.SecurityWarnings(); len(got) != len(warnings)+1 {
+		t.Errorf("retaining keys gave %d warnings, want %d", len(got), len(warnings)+1)
+	}
+
+	// Allowing only the digest stage leaves no weak engine reachable
+	hardened := NewSecureTransactionProcessor()
+	config := hardened.Config()
+	config.AllowedOperations = []MathematicalOperation{DigestComputationProcessing}
+	if err := hardened.LoadConfig(config); err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if got := hardened.SecurityWarnings(); len(got) != 0 {
+		t.Errorf("hardened processor warns %q", got)
+	}
+}
+
+func TestMaxPipelineLength(t *testing.T) {
+	stp := NewSecureTransactionProcessor(withPassthrough("P:"))
+
+	required := make([]MathematicalOperation, DefaultMaxPipelineLength+1)
+	for i := range required {
+		required[i] = passthroughOperation
+	}
+	ctx := &TransactionContext{Data: []byte("x"), RequiredOperations: required}
+	if _, err := stp.ProcessSecureTransaction(ctx); !errors.Is(err, ErrPipelineTooLong) {
+		t.Fatalf("err = %v for %d required operations, want ErrPipelineTooLong", err, len(required))
+	}
+
+	// The planned pipeline is checked too: matrix, three passthroughs and the digest
+	limited := NewSecureTransactionProcessor(withPassthrough("P:"), WithMaxPipelineLength(4))
+	ctx.RequiredOperations = required[:3]
+	if _, err := limited.ProcessSecureTransaction(ctx); !errors.Is(err, ErrPipelineTooLong) {
+		t.Errorf("err = %v for a five-stage pipeline, want ErrPipelineTooLong", err)
+	}
+	ctx.RequiredOperations = required[:2]
+	if _, err := limited.ProcessSecureTransaction(ctx); err != nil {
+		t.Errorf("four-stage pipeline rejected: %v", err)
+	}
+
+	unlimited := NewSecureTransactionProcessor(withPassthrough("P:"), WithMaxPipelineLength(0))
+	ctx.RequiredOperations = required
+	if _, err := unlimited.ProcessSecureTransaction(ctx); err != nil {
+		t.Errorf("limit 0 still rejected a long pipeline: %v", err)
+	}
+}
+
+// blockingOperation is a custom operation whose handler waits for release
+const blockingOperation MathematicalOperation = 102
+
+func withBlocking(entered chan<- struct{}, release <-chan struct{}) ProcessorOption {
+	return WithOperation(blockingOperation, func(data []byte) ([]byte, error) {
+		entered <- struct{}{}
+		<-release
+		return data, nil
+	}, OperationMeta{Name: "Blocking"})
+}
+
+func TestBufferLimitBackpressure(t *testing.T) {
+	for _, policy := range []BackpressurePolicy{BackpressureReject, BackpressureBlock} {
+		entered := make(chan struct{}, 2)
+		release := make(chan struct{})
+		stp := NewSecureTransactionProcessor(withBlocking(entered, release), WithBufferLimit(1, policy))
+		blocking := &TransactionContext{
+			Data:                []byte("x"),
+			RequiredOperations:  []MathematicalOperation{blockingOperation},
+			SkipIntegrityDigest: true,
+		}
+
+		held := make(chan error, 1)
+		go func() {
+			_, err := stp.ProcessSecureTransaction(blocking)
+			held <- err
+		}()
+		<-entered
+
+		excess := make(chan error, 1)
+		go func() {
+			_, err := stp.ProcessSecureTransaction(&TransactionContext{Data: []byte("y")})
+			excess <- err
+		}()
+
+		switch policy {
+		case BackpressureReject:
+			if err := <-excess; !errors.Is(err, ErrBackpressure) {
+				t.Errorf("reject: excess request err = %v, want ErrBackpressure", err)
+			}
+			close(release)
+		case BackpressureBlock:
+			select {
+			case err := <-excess:
+				t.Errorf("block: excess request returned %v while the buffer was held", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			close(release)
+			if err := <-excess; err != nil {
+				t.Errorf("block: excess request failed after release: %v", err)
+			}
+		}
+		if err := <-held; err != nil {
+			t.Errorf("policy %d: held transaction failed: %v", policy, err)
+		}
+	}
+}
+
+func TestDetectAnomaliesFlagsTimingSpike(t *testing.T) {
+	pm := NewPerformanceMonitor()
+	operation := MatrixLinearTransformation
+
+	// Normal variation: 100µs ± 5µs
+	for i := 0; i < 20; i++ {
+		pm.RecordOperation(operation, time.Duration(100+i%3*5-5)*time.Microsecond)
+	}
+	if got := pm.DetectAnomalies(operation); len(got) != 0 {
+		t.Fatalf("normal variation flagged: %+v", got)
+	}
+
+	for i := 0; i < anomalyRecentSamples-1; i++ {
+		pm.RecordOperation(operation, 100*time.Microsecond)
+	}
+	pm.RecordOperation(operation, 2*time.Millisecond)
+
+	anomalies := pm.DetectAnomalies(operation)
+	if len(anomalies) != 1 {
+		t.Fatalf("anomalies = %+v, want only the spike", anomalies)
+	}
+	if anomalies[0].Duration != 2*time.Millisecond || anomalies[0].Deviations <= anomalyThreshold {
+		t.Errorf("anomaly = %+v, want the 2ms spike beyond %v standard deviations", anomalies[0], anomalyThreshold)
+	}
+
+	if got := NewPerformanceMonitor().DetectAnomalies(operation); got != nil {
+		t.Errorf("anomalies without history = %+v, want nil", got)
+	}
+}
+
+func TestKeyStoreReusesImportedKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stp := NewSecureTransactionProcessor()
+	der := x509.MarshalPKCS1PrivateKey(key)
+	if err := stp.KeyStore().ImportKey("signer", der); err != nil {
+		t.Fatalf("ImportKey: %v", err)
+	}
+	if exported, err := stp.KeyStore().ExportKey("signer"); err != nil || !bytes.Equal(exported, der) {
+		t.Fatalf("ExportKey = %x, %v; want the imported key", exported, err)
+	}
+	pub, err := stp.KeyStore().PublicKey("signer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("transaction to sign")
+	var signatures [][]byte
+	for i := 0; i < 2; i++ {
+		signature, _, err := stp.executeOperation(LargeIntegerArithmetic, data, "signer")
+		if err != nil {
+			t.Fatalf("modular arithmetic stage: %v", err)
+		}
+		if err := stp.largeNumberProcessor.VerifyModularSignature(pub, data, signature); err != nil {
+			t.Fatalf("transaction %d: %v", i, err)
+		}
+		signatures = append(signatures, signature)
+	}
+	// Textbook RSA is deterministic, so equal signatures mean the same modulus
+	if !bytes.Equal(signatures[0], signatures[1]) {
+		t.Error("transactions with the same key ID signed under different keys")
+	}
+
+	ctx := &TransactionContext{Data: data, SecurityLevel: EnhancedSecurity, KeyID: "missing"}
+	if _, err := stp.ProcessSecureTransaction(ctx); !errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("err = %v for an unknown key ID, want ErrKeyNotFound", err)
+	}
+	ctx.KeyID = "signer"
+	if _, err := stp.ProcessSecureTransaction(ctx); err != nil {
+		t.Errorf("ProcessSecureTransaction with a stored key: %v", err)
+	}
+}
+
+func TestProcessDigestComputationNTruncation(t *testing.T) {
+	dce := NewDigestComputationEngine()
+	data := []byte("index me")
+
+	longest, err := dce.ProcessDigestComputationN(data, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := hash_256.Sum256(data)
+	if !bytes.Equal(longest, want[:]) {
+		t.Fatalf("32-byte fingerprint = %x, want the plain hash %x", longest, want)
+	}
+	for outLen := MinDigestTruncation; outLen < 32; outLen++ {
+		got, err := dce.ProcessDigestComputationN(data, outLen)
+		if err != nil {
+			t.Fatalf("ProcessDigestComputationN(%d): %v", outLen, err)
+		}
+		if !bytes.Equal(got, longest[:outLen]) {
+			t.Errorf("%d-byte fingerprint is not a prefix of the 32-byte one", outLen)
+		}
+	}
+
+	// Past 32 bytes the randomized authHash follows the same prefix
+	if full, err := dce.ProcessDigestComputationN(data, 64); err != nil || !bytes.HasPrefix(full, longest) {
+		t.Errorf("64-byte output = %x, %v; want it to extend the plain hash", full, err)
+	}
+
+	for _, outLen := range []int{0, MinDigestTruncation - 1, 65} {
+		if _, err := dce.ProcessDigestComputationN(data, outLen); err == nil {
+			t.Errorf("ProcessDigestComputationN(%d) accepted an out-of-range length", outLen)
+		}
+	}
+}
+
+func TestWALRecordsAndReplaysTransactions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transactions.wal")
+	wal, err := OpenWAL(path)
+	if err != nil {
+		t.Fatalf("OpenWAL: %v", err)
+	}
+	stp := NewSecureTransactionProcessor(WithWAL(wal))
+
+	contexts := []*TransactionContext{
+		{TransactionID: "tx-1", Data: []byte("one"), SecurityLevel: MinimumSecurity},
+		{TransactionID: "tx-2", Data: []byte("two")},
+		{TransactionID: "tx-3", Data: []byte("three"), ComplianceRequirements: []string{"korean_standards"}},
+	}
+	results := make(map[string]*ProcessingResult)
+	for _, ctx := range contexts {
+		result, err := stp.ProcessSecureTransaction(ctx)
+		if err != nil {
+			t.Fatalf("ProcessSecureTransaction(%s): %v", ctx.TransactionID, err)
+		}
+		results[ctx.TransactionID] = result
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash mid-append leaves a torn final line, which replay skips
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"transaction_id":"torn`)
+	file.Close()
+
+	var replayed []string
+	err = ReplayWAL(path, func(entry WALEntry) error {
+		ctx := contexts[len(replayed)]
+		replayed = append(replayed, entry.TransactionID)
+		return stp.VerifyWALEntry(entry, ctx, results[entry.TransactionID])
+	})
+	if err != nil {
+		t.Fatalf("ReplayWAL: %v", err)
+	}
+	if want := []string{"tx-1", "tx-2", "tx-3"}; !reflect.DeepEqual(replayed, want) {
+		t.Fatalf("replayed %v, want %v", replayed, want)
+	}
+
+	// Replaying against a different pipeline is detected
+	err = ReplayWAL(path, func(entry WALEntry) error {
+		changed := &TransactionContext{Data: []byte("x"), SecurityLevel: EnhancedSecurity}
+		return stp.VerifyWALEntry(entry, changed, results[entry.TransactionID])
+	})
+	if err == nil {
+		t.Error("VerifyWALEntry accepted a changed pipeline")
+	}
+}
+
+// recordingAuditSink keeps every compliance decision and warning it receives
+type recordingAuditSink struct {
+	mutex    sync.Mutex
+	statuses map[string]map[string]bool
+	times    map[string]time.Time
+	warnings map[string][]string
+}
+
+func (s *recordingAuditSink) RecordCompliance(txID string, status map[string]bool, ts time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.statuses == nil {
+		s.statuses = make(map[string]map[string]bool)
+		s.times = make(map[string]time.Time)
+	}
+	s.statuses[txID] = status
+	s.times[txID] = ts
+}
+
+func (s *recordingAuditSink) RecordWarning(txID, warning string, ts time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.warnings == nil {
+		s.warnings = make(map[string][]string)
+	}
+	s.warnings[txID] = append(s.warnings[txID], warning)
+}
+
+func TestAuditSinkCapturesProcessedTransaction(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC))
+	sink := &recordingAuditSink{}
+	stp := NewSecureTransactionProcessor(WithClock(clock))
+	stp.SetAuditSink(sink)
+
+	result, err := stp.ProcessSecureTransaction(&TransactionContext{
+		TransactionID:          "tx-audited",
+		Data:                   []byte("transfer 100"),
+		ComplianceRequirements: []string{"integrity_protection", "quantum_awareness"},
+	})
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+
+	status, ok := sink.statuses["tx-audited"]
+	if !ok {
+		t.Fatalf("no audit record for the transaction; got %v", sink.statuses)
+	}
+	if !reflect.DeepEqual(status, result.ComplianceStatus) {
+		t.Errorf("audited status = %v, result has %v", status, result.ComplianceStatus)
+	}
+	if !sink.times["tx-audited"].Equal(clock.Now()) {
+		t.Errorf("audit timestamp = %v, want the processor clock's %v", sink.times["tx-audited"], clock.Now())
+	}
+
+	// Without a sink nothing is recorded and processing is unaffected
+	if _, err := NewSecureTransactionProcessor().ProcessSecureTransaction(&TransactionContext{Data: []byte("x")}); err != nil {
+		t.Fatalf("ProcessSecureTransaction without a sink: %v", err)
+	}
+}
+
+func TestComplianceSeverityGating(t *testing.T) {
+	// StandardSecurity plans no asymmetric stage, so quantum_awareness fails
+	ctx := &TransactionContext{
+		TransactionID:          "tx-1",
+		Data:                   []byte("x"),
+		ComplianceRequirements: []string{"quantum_awareness", "integrity_protection"},
+	}
+
+	advisory := NewSecureTransactionProcessor()
+	result, err := advisory.ProcessSecureTransaction(ctx)
+	if err != nil {
+		t.Fatalf("advisory failure returned an error: %v", err)
+	}
+	if result.ComplianceStatus["quantum_awareness"] || !result.ComplianceStatus["integrity_protection"] {
+		t.Errorf("compliance status = %v, want quantum_awareness failed and integrity_protection passed", result.ComplianceStatus)
+	}
+
+	sink := &recordingAuditSink{}
+	mandatory := NewSecureTransactionProcessor(WithComplianceSeverity("quantum_awareness", ComplianceMandatory))
+	mandatory.SetAuditSink(sink)
+	if _, err := mandatory.ProcessSecureTransaction(ctx); !errors.Is(err, ErrMandatoryComplianceFailed) {
+		t.Fatalf("err = %v, want ErrMandatoryComplianceFailed", err)
+	}
+	if status, ok := sink.statuses["tx-1"]; !ok || status["quantum_awareness"] {
+		t.Errorf("audit sink got %v, want the mandatory failure recorded", status)
+	}
+
+	// A passing mandatory requirement does not gate
+	ctx.SecurityLevel = EnhancedSecurity
+	if _, err := mandatory.ProcessSecureTransaction(ctx); err != nil {
+		t.Errorf("passing mandatory requirement returned %v", err)
+	}
+}
+
+// panickingOperation is a custom operation whose handler always panics
+const panickingOperation MathematicalOperation = 103
+
+func TestPanickingOperationBecomesInternalError(t *testing.T) {
+	stp := NewSecureTransactionProcessor(WithOperation(panickingOperation, func(data []byte) ([]byte, error) {
+		panic("handler bug")
+	}, OperationMeta{Name: "Panicking"}), WithCircuitBreaker(1, time.Minute))
+	ctx := &TransactionContext{
+		Data:               []byte("x"),
+		RequiredOperations: []MathematicalOperation{panickingOperation},
+	}
+
+	if _, err := stp.ProcessSecureTransaction(ctx); !errors.Is(err, ErrInternal) {
+		t.Fatalf("err = %v, want ErrInternal", err)
+	}
+	// The panic counted as a failure, so the breaker is now open
+	if _, err := stp.ProcessSecureTransaction(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("err = %v after a panicking call, want ErrCircuitOpen", err)
+	}
+	if _, err := stp.ProcessSecureTransaction(&TransactionContext{Data: []byte("x")}); err != nil {
+		t.Errorf("processor unusable after a recovered panic: %v", err)
+	}
+}
+
+func TestQuantumPostureReportCoversEveryOperation(t *testing.T) {
+	stp := NewSecureTransactionProcessor(withPassthrough("P:"))
+	operations := stp.ListOperations()
+	report := stp.QuantumPostureReport()
+	if len(report) != len(operations) {
+		t.Fatalf("report has %d entries, want one per operation (%d)", len(report), len(operations))
+	}
+
+	for i, posture := range report {
+		info := operations[i]
+		if posture.Operation != info.Operation || posture.Name != info.Name {
+			t.Errorf("entry %d = %d %q, want %d %q", i, posture.Operation, posture.Name, info.Operation, info.Name)
+			continue
+		}
+		if posture.QuantumVulnerability != info.QuantumVulnerability {
+			t.Errorf("%s: vulnerability %q, ListOperations says %q", posture.Name, posture.QuantumVulnerability, info.QuantumVulnerability)
+		}
+		if posture.PQAlternative != (posture.Alternative != "") {
+			t.Errorf("%s: PQAlternative = %v with alternative %q", posture.Name, posture.PQAlternative, posture.Alternative)
+		}
+		if posture.MigrationNote == "" {
+			t.Errorf("%s: no migration note", posture.Name)
+		}
+		if isBuiltinOperation(posture.Operation) != posture.PQAlternative {
+			t.Errorf("%s: PQAlternative = %v, want guidance for exactly the built-in operations", posture.Name, posture.PQAlternative)
+		}
+	}
+
+	if again := stp.QuantumPostureReport(); !reflect.DeepEqual(again, report) {
+		t.Error("report differs between calls")
+	}
+}
+
+// macOperation is a custom operation in the hash category
+const macOperation MathematicalOperation = 104
+
+func TestSkippedDigestWarningGoesToAuditSink(t *testing.T) {
+	sink := &recordingAuditSink{}
+	stp := NewSecureTransactionProcessor(WithOperation(macOperation, func(data []byte) ([]byte, error) {
+		return append(data, 0), nil
+	}, OperationMeta{Name: "MAC", Categories: []string{CategoryHash}}))
+	stp.SetAuditSink(sink)
+
+	unprotected := &TransactionContext{
+		TransactionID:       "tx-unprotected",
+		Data:                []byte("payload"),
+		SecurityLevel:       StandardSecurity,
+		SkipIntegrityDigest: true,
+	}
+	result, err := stp.ProcessSecureTransaction(unprotected)
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+	if got := executedOperations(result); !reflect.DeepEqual(got, []MathematicalOperation{MatrixLinearTransformation}) {
+		t.Fatalf("pipeline = %v, want the matrix stage alone", got)
+	}
+	if estimate := stp.EstimateOutputSize(unprotected); estimate < len(result.ProcessedData) {
+		t.Errorf("EstimateOutputSize = %d, below the actual %d bytes", estimate, len(result.ProcessedData))
+	}
+	if len(sink.warnings["tx-unprotected"]) != 1 {
+		t.Errorf("warnings = %v, want one for the unauthenticated output", sink.warnings)
+	}
+
+	// A caller-supplied hash stage still authenticates the output
+	hashed := &TransactionContext{
+		TransactionID:       "tx-hashed",
+		Data:                []byte("payload"),
+		RequiredOperations:  []MathematicalOperation{macOperation},
+		SkipIntegrityDigest: true,
+	}
+	if _, err := stp.ProcessSecureTransaction(hashed); err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+	if warnings := sink.warnings["tx-hashed"]; len(warnings) != 0 {
+		t.Errorf("warned %v although a hash stage was planned", warnings)
+	}
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	logSink, err := NewJSONLinesAuditSink(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stp.SetAuditSink(logSink)
+	if _, err := stp.ProcessSecureTransaction(unprotected); err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+	if err := logSink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(written), `"warning":"integrity digest skipped`) {
+		t.Errorf("audit log has no warning record:\n%s", written)
+	}
+}
+
+func TestSecretHandlingStages(t *testing.T) {
+	stp := NewSecureTransactionProcessor(withPassthrough("P:"))
+	result, err := stp.ProcessSecureTransaction(&TransactionContext{
+		Data:               []byte("payload"),
+		SecurityLevel:      EnhancedSecurity,
+		RequiredOperations: []MathematicalOperation{passthroughOperation},
+	})
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+
+	// The digest stage keys its authentication hash, so it handles secrets too
+	want := []MathematicalOperation{
+		LargeIntegerArithmetic, PolynomialFieldComputation, MatrixLinearTransformation, DigestComputationProcessing,
+	}
+	if got := result.SecretHandlingStages(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SecretHandlingStages = %v, want %v without the passthrough stage", got, want)
+	}
+	for _, opResult := range result.OperationResults {
+		if opResult.HandledSecrets != stp.handlesSecrets(opResult.Operation) {
+			t.Errorf("operation %d: HandledSecrets = %v, disagrees with its metadata", opResult.Operation, opResult.HandledSecrets)
+		}
+	}
+}
+
+// decodeHex decodes a test vector, ignoring the spaces that group its words
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		t.Fatalf("bad test vector %q: %v", s, err)
+	}
+	return b
+}
+
+func TestAESBlockCipherFIPS197Vectors(t *testing.T) {
+	// FIPS-197 appendix C
+	vectors := []struct {
+		key, ciphertext string
+	}{
+		{"000102030405060708090a0b0c0d0e0f", "69c4e0d86a7b0430d8cdb78070b4c55a"},
+		{"000102030405060708090a0b0c0d0e0f1011121314151617", "dda97ca4864cdfe06eaf70a0ec0d7191"},
+		{"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f", "8ea2b7ca516745bfeafc49904b496089"},
+	}
+	plaintext := decodeHex(t, "00112233445566778899aabbccddeeff")
+
+	for _, v := range vectors {
+		block, err := NewAESBlockCipher(decodeHex(t, v.key))
+		if err != nil {
+			t.Fatalf("NewAESBlockCipher: %v", err)
+		}
+		got := make([]byte, aesBlockSize)
+		block.Encrypt(got, plaintext)
+		if want := decodeHex(t, v.ciphertext); !bytes.Equal(got, want) {
+			t.Errorf("AES-%d: ciphertext %x, want %x", len(v.key)*4, got, want)
+		}
+		block.Decrypt(got, got)
+		if !bytes.Equal(got, plaintext) {
+			t.Errorf("AES-%d: decrypted %x, want %x", len(v.key)*4, got, plaintext)
+		}
+	}
+
+	if _, err := NewAESBlockCipher(make([]byte, 20)); err == nil {
+		t.Error("accepted a 20-byte AES key")
+	}
+}
+
+func TestKeyWrapRFC3394Vectors(t *testing.T) {
+	// RFC 3394 section 4
+	vectors := []struct {
+		kek, key, wrapped string
+	}{
+		{
+			"000102030405060708090A0B0C0D0E0F",
+			"00112233445566778899AABBCCDDEEFF",
+			"1FA68B0A8112B447 AEF34BD8FB5A7B82 9D3E862371D2CFE5",
+		},
+		{
+			"000102030405060708090A0B0C0D0E0F1011121314151617",
+			"00112233445566778899AABBCCDDEEFF",
+			"96778B25AE6CA435 F92B5B97C050AED2 468AB8A17AD84E5D",
+		},
+		{
+			"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF",
+			"64E8C3F9CE0F5BA2 63E9777905818A2A 93C8191E7D6E8AE7",
+		},
+		{
+			"000102030405060708090A0B0C0D0E0F1011121314151617",
+			"00112233445566778899AABBCCDDEEFF0001020304050607",
+			"031D33264E15D332 68F24EC260743EDC E1C6C7DDEE725A93 6BA814915C6762D2",
+		},
+		{
+			"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF0001020304050607",
+			"A8F9BC1612C68B3F F6E6F4FBE30E71E4 769C8B80A32CB895 8CD5D17D6B254DA1",
+		},
+		{
+			"000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F",
+			"00112233445566778899AABBCCDDEEFF000102030405060708090A0B0C0D0E0F",
+			"28C9F404C4B810F4 CBCCB35CFB87F826 3F5786E2D80ED326 CBC7F0E71A99F43B FB988B9B7A02DD21",
+		},
+	}
+
+	for i, v := range vectors {
+		kek, key, want := decodeHex(t, v.kek), decodeHex(t, v.key), decodeHex(t, v.wrapped)
+		wrapped, err := WrapKey(kek, key)
+		if err != nil {
+			t.Fatalf("vector %d: WrapKey: %v", i+1, err)
+		}
+		if !bytes.Equal(wrapped, want) {
+			t.Errorf("vector %d: wrapped %x, want %x", i+1, wrapped, want)
+		}
+
+		unwrapped, err := UnwrapKey(kek, want)
+		if err != nil {
+			t.Fatalf("vector %d: UnwrapKey: %v", i+1, err)
+		}
+		if !bytes.Equal(unwrapped, key) {
+			t.Errorf("vector %d: unwrapped %x, want %x", i+1, unwrapped, key)
+		}
+
+		want[len(want)-1] ^= 1
+		if _, err := UnwrapKey(kek, want); !errors.Is(err, ErrKeyUnwrapFailed) {
+			t.Errorf("vector %d: corrupted wrap err = %v, want ErrKeyUnwrapFailed", i+1, err)
+		}
+	}
+}
+
+func TestKeyTransportRequirementRunsKeyWrap(t *testing.T) {
+	kek := decodeHex(t, "000102030405060708090A0B0C0D0E0F101112131415161718191A1B1C1D1E1F")
+	ctx := &TransactionContext{
+		Data:                   decodeHex(t, "00112233445566778899AABBCCDDEEFF"),
+		SecurityLevel:          MinimumSecurity,
+		ComplianceRequirements: []string{"key_transport"},
+		SkipIntegrityDigest:    true,
+	}
+
+	stp := NewSecureTransactionProcessor(WithKeyWrapKEK(kek))
+	result, err := stp.ProcessSecureTransaction(ctx)
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+	if got := executedOperations(result); !reflect.DeepEqual(got, []MathematicalOperation{AESKeyWrap}) {
+		t.Fatalf("pipeline = %v, want the key wrap stage alone", got)
+	}
+	if want := decodeHex(t, "64E8C3F9CE0F5BA2 63E9777905818A2A 93C8191E7D6E8AE7"); !bytes.Equal(result.ProcessedData, want) {
+		t.Errorf("processed data = %x, want the RFC 3394 wrap %x", result.ProcessedData, want)
+	}
+	if !result.ComplianceStatus["key_transport"] {
+		t.Errorf("compliance status = %v, want key_transport passed", result.ComplianceStatus)
+	}
+	if got := stp.EstimateOutputSize(ctx); got != len(result.ProcessedData) {
+		t.Errorf("EstimateOutputSize = %d, want %d", got, len(result.ProcessedData))
+	}
+
+	recovered, err := stp.ReverseSecureTransaction(ctx, result)
+	if err != nil {
+		t.Fatalf("ReverseSecureTransaction: %v", err)
+	}
+	if !bytes.Equal(recovered, ctx.Data) {
+		t.Errorf("recovered %x, want %x", recovered, ctx.Data)
+	}
+
+	if _, err := NewSecureTransactionProcessor().ProcessSecureTransaction(ctx); !errors.Is(err, ErrKeyWrapNotConfigured) {
+		t.Errorf("without a KEK: err = %v, want ErrKeyWrapNotConfigured", err)
+	}
+}
+
+func TestOAEPInteroperatesWithRFC8017(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lnp := NewLargeNumberProcessor()
+	msg := []byte("content key 0123456789abcdef0123")
+
+	ciphertext, err := lnp.EncryptOAEP(&key.PublicKey, msg)
+	if err != nil {
+		t.Fatalf("EncryptOAEP: %v", err)
+	}
+	if got, err := rsa.DecryptOAEP(hash_256.New(), nil, key, ciphertext, nil); err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("reference decryption = %q, %v; want %q", got, err, msg)
+	}
+
+	reference, err := rsa.EncryptOAEP(hash_256.New(), rand.Reader, &key.PublicKey, msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := lnp.DecryptOAEP(key, reference); err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("DecryptOAEP of reference ciphertext = %q, %v; want %q", got, err, msg)
+	}
+
+	reference[len(reference)-1] ^= 1
+	if _, err := lnp.DecryptOAEP(key, reference); !errors.Is(err, ErrOAEPDecryption) {
+		t.Errorf("corrupted ciphertext: err = %v, want ErrOAEPDecryption", err)
+	}
+	if _, err := lnp.EncryptOAEP(&key.PublicKey, make([]byte, key.Size()-2*oaepHashSize-1)); err == nil {
+		t.Error("encrypted a message too long for the modulus")
+	}
+}
+
+func TestMultiRecipientEncryptionThreeRecipients(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pfc := NewPolynomialFieldComputer()
+	privateKeys := []crypto.PrivateKey{rsaKey}
+	publicKeys := []crypto.PublicKey{&rsaKey.PublicKey}
+	for i := 0; i < 2; i++ {
+		scalar, err := pfc.GenerateScalar()
+		if err != nil {
+			t.Fatal(err)
+		}
+		point, err := pfc.PublicKey(scalar)
+		if err != nil {
+			t.Fatal(err)
+		}
+		privateKeys = append(privateKeys, scalar)
+		publicKeys = append(publicKeys, point)
+	}
+
+	ctx := &TransactionContext{
+		Data:                   []byte("payload for three parties"),
+		SecurityLevel:          MinimumSecurity,
+		ComplianceRequirements: []string{"multi_recipient"},
+		SkipIntegrityDigest:    true,
+	}
+	sender := NewSecureTransactionProcessor(WithRecipients(publicKeys...))
+	result, err := sender.ProcessSecureTransaction(ctx)
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+	if got := executedOperations(result); !reflect.DeepEqual(got, []MathematicalOperation{MultiRecipientEncryption}) {
+		t.Fatalf("pipeline = %v, want the multi-recipient stage alone", got)
+	}
+	if !result.ComplianceStatus["multi_recipient"] {
+		t.Errorf("compliance status = %v, want multi_recipient passed", result.ComplianceStatus)
+	}
+	if got := sender.EstimateOutputSize(ctx); got != len(result.ProcessedData) {
+		t.Errorf("EstimateOutputSize = %d, want %d", got, len(result.ProcessedData))
+	}
+
+	var envelope RecipientEnvelope
+	if err := envelope.UnmarshalBinary(result.ProcessedData); err != nil {
+		t.Fatalf("UnmarshalBinary: %v", err)
+	}
+	if len(envelope.Recipients) != 3 {
+		t.Fatalf("envelope has %d recipients, want 3", len(envelope.Recipients))
+	}
+
+	// Each recipient opens the stage with only its own key
+	for i, key := range privateKeys {
+		recipient := NewSecureTransactionProcessor(WithRecipientKey(key))
+		recovered, err := recipient.ReverseSecureTransaction(ctx, result)
+		if err != nil {
+			t.Fatalf("recipient %d: ReverseSecureTransaction: %v", i, err)
+		}
+		if !bytes.Equal(recovered, ctx.Data) {
+			t.Errorf("recipient %d recovered %q, want %q", i, recovered, ctx.Data)
+		}
+	}
+
+	outsider, err := pfc.GenerateScalar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sender.DecryptAsRecipient(&envelope, outsider); !errors.Is(err, ErrNotARecipient) {
+		t.Errorf("outsider: err = %v, want ErrNotARecipient", err)
+	}
+	envelope.Ciphertext[0] ^= 1
+	if _, err := sender.DecryptAsRecipient(&envelope, privateKeys[1]); err == nil {
+		t.Error("opened a tampered envelope")
+	}
+
+	if _, err := NewSecureTransactionProcessor().ProcessSecureTransaction(ctx); !errors.Is(err, ErrNoRecipients) {
+		t.Errorf("without recipients: err = %v, want ErrNoRecipients", err)
+	}
+	if _, err := NewSecureTransactionProcessor().ReverseSecureTransaction(ctx, result); !errors.Is(err, ErrRecipientKeyNotConfigured) {
+		t.Errorf("without a recipient key: err = %v, want ErrRecipientKeyNotConfigured", err)
+	}
+	small, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sender.EncryptForRecipients(ctx.Data, []crypto.PublicKey{&small.PublicKey}); err == nil {
+		t.Error("accepted a 1024-bit RSA recipient")
+	}
+}
+
+func TestPipelineOrderingPolicies(t *testing.T) {
+	ctx := &TransactionContext{
+		Data:                   []byte("x"),
+		SecurityLevel:          EnterpriseSecurity,
+		ComplianceRequirements: []string{"korean_standards"},
+		RequiredOperations:     []MathematicalOperation{passthroughOperation},
+	}
+	asymmetric := []MathematicalOperation{LargeIntegerArithmetic, PolynomialFieldComputation}
+	rest := []MathematicalOperation{
+		MatrixLinearTransformation, KoreanMathematicalProcessing, RegionalComputationalProcessing, passthroughOperation,
+	}
+	concat := func(parts ...[]MathematicalOperation) []MathematicalOperation {
+		var pipeline []MathematicalOperation
+		for _, part := range parts {
+			pipeline = append(pipeline, part...)
+		}
+		return pipeline
+	}
+	digest := []MathematicalOperation{DigestComputationProcessing}
+
+	tests := []struct {
+		name string
+		opts []ProcessorOption
+		want []MathematicalOperation
+	}{
+		{"default", nil, concat(asymmetric, rest, digest)},
+		{"asymmetric first", []ProcessorOption{WithPipelineOrdering(AsymmetricFirst)}, concat(asymmetric, rest, digest)},
+		{"symmetric first", []ProcessorOption{WithPipelineOrdering(SymmetricFirst)}, concat(rest, asymmetric, digest)},
+		{"unknown policy ignored", []ProcessorOption{WithPipelineOrdering(PipelineOrderingPolicy(7))}, concat(asymmetric, rest, digest)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stp := NewSecureTransactionProcessor(append([]ProcessorOption{withPassthrough("P:")}, tt.opts...)...)
+			if got := stp.buildProcessingPipeline(ctx); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pipeline = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldOperationsDependOnlyOnTheHashedScalar(t *testing.T) {
+	pfc := NewPolynomialFieldComputer()
+	// pointBytes encodes a point the way ProcessFieldOperations does
+	pointBytes := func(scalar *big.Int) []byte {
+		point := pfc.scalarMultiplication(new(big.Int).Set(scalar), &EllipticPoint{X: pfc.generatorX, Y: pfc.generatorY})
+		size := pfc.coordinateSize()
+		return append(point.X.FillBytes(make([]byte, size)), point.Y.FillBytes(make([]byte, size))...)
+	}
+
+	inputs := [][]byte{
+		{},
+		{0x01},
+		bytes.Repeat([]byte{0xAB}, 31),
+		bytes.Repeat([]byte{0xAB}, 33),
+		bytes.Repeat([]byte{0xFF}, 1000),
+	}
+	seen := make(map[string]bool)
+	for _, input := range inputs {
+		scalar := pfc.hashToScalar(input)
+		if scalar.Sign() < 0 || scalar.Cmp(pfc.curveOrder) >= 0 {
+			t.Fatalf("%d-byte input: scalar outside [0, n)", len(input))
+		}
+
+		got, err := pfc.ProcessFieldOperations(input)
+		if err != nil {
+			t.Fatalf("ProcessFieldOperations: %v", err)
+		}
+		if want := pointBytes(scalar); !bytes.Equal(got, want) {
+			t.Errorf("%d-byte input: point differs from its hashed scalar times G", len(input))
+		}
+		if seen[string(got)] {
+			t.Errorf("%d-byte input collides with another input", len(input))
+		}
+		seen[string(got)] = true
+	}
+
+	// Scalars equal modulo the order, as inputs of any length reduce to, give
+	// equal points
+	scalar := pfc.hashToScalar([]byte("short"))
+	congruent := new(big.Int).Add(scalar, pfc.curveOrder)
+	if !bytes.Equal(pointBytes(scalar), pointBytes(congruent)) {
+		t.Error("congruent scalars gave different points")
+	}
+}
+
+func TestMultiProcessorRouting(t *testing.T) {
+	fips := NewSecureTransactionProcessor(withPassthrough("fips:"))
+	korean := NewSecureTransactionProcessor(withPassthrough("korean:"))
+	fallback := NewSecureTransactionProcessor(withPassthrough("default:"))
+
+	mp := NewMultiProcessor(fallback)
+	mp.Register(RequiresCompliance("fips"), fips)
+	mp.Register(RequiresCompliance("korean_standards"), korean)
+
+	cases := []struct {
+		requirements []string
+		want         *SecureTransactionProcessor
+		prefix       string
+	}{
+		{[]string{"fips"}, fips, "fips:"},
+		{[]string{"korean_standards"}, korean, "korean:"},
+		{[]string{"korean_standards", "fips"}, fips, "fips:"}, // first registered route wins
+		{[]string{"pci_dss"}, fallback, "default:"},
+		{nil, fallback, "default:"},
+	}
+	for _, c := range cases {
+		ctx := &TransactionContext{
+			Data:                   []byte("x"),
+			SecurityLevel:          MinimumSecurity,
+			ComplianceRequirements: c.requirements,
+			RequiredOperations:     []MathematicalOperation{passthroughOperation},
+			SkipIntegrityDigest:    true,
+		}
+
+		routed, err := mp.Route(ctx)
+		if err != nil {
+			t.Fatalf("%v: Route: %v", c.requirements, err)
+		}
+		if routed != c.want {
+			t.Errorf("%v: routed to the wrong processor", c.requirements)
+		}
+
+		result, err := mp.ProcessSecureTransaction(ctx)
+		if err != nil {
+			t.Fatalf("%v: ProcessSecureTransaction: %v", c.requirements, err)
+		}
+		if !bytes.HasPrefix(result.ProcessedData, []byte(c.prefix)) {
+			t.Errorf("%v: processed as %q, want prefix %q", c.requirements, result.ProcessedData, c.prefix)
+		}
+	}
+
+	// Without a default, unmatched transactions are an error
+	strict := NewMultiProcessor(nil)
+	strict.Register(RequiresCompliance("fips"), fips)
+	if _, err := strict.ProcessSecureTransaction(&TransactionContext{Data: []byte("x")}); err == nil {
+		t.Error("unmatched transaction was processed without a default")
+	}
+}
+
+func TestPointAtInfinityArithmetic(t *testing.T) {
+	pfc := NewPolynomialFieldComputer()
+	p, err := pfc.PublicKey(big.NewInt(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	negP := &EllipticPoint{X: new(big.Int).Set(p.X), Y: new(big.Int).Sub(pfc.fieldPrime, p.Y)}
+	if !pfc.IsOnCurve(negP) {
+		t.Fatal("-P is not on the curve")
+	}
+
+	if sum := pfc.pointAddition(p, negP); !sum.Infinity {
+		t.Errorf("P + (-P) = (%x, %x), want infinity", sum.X, sum.Y)
+	}
+	infinity := newInfinityPoint()
+	for _, sum := range []*EllipticPoint{pfc.pointAddition(infinity, p), pfc.pointAddition(p, infinity)} {
+		if sum.Infinity || sum.X.Cmp(p.X) != 0 || sum.Y.Cmp(p.Y) != 0 {
+			t.Errorf("infinity + P = %+v, want P", sum)
+		}
+	}
+	if sum := pfc.pointAddition(infinity, newInfinityPoint()); !sum.Infinity {
+		t.Error("infinity + infinity is not infinity")
+	}
+
+	// (0, 0) is an ordinary coordinate pair, not the identity
+	origin := &EllipticPoint{X: big.NewInt(0), Y: big.NewInt(0)}
+	if sum := pfc.pointAddition(origin, p); !sum.Infinity && sum.X.Cmp(p.X) == 0 && sum.Y.Cmp(p.Y) == 0 {
+		t.Error("(0, 0) + P = P; (0, 0) is being treated as infinity")
+	}
+
+	// n*G is the identity
+	generator := &EllipticPoint{X: pfc.generatorX, Y: pfc.generatorY}
+	if nG := pfc.scalarMultiplication(new(big.Int).Set(pfc.curveOrder), generator); !nG.Infinity {
+		t.Error("n*G is not infinity")
+	}
+}
+
+func TestPublicKeyDerivation(t *testing.T) {
+	pfc := NewPolynomialFieldComputer()
+
+	priv, err := pfc.GenerateScalar()
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := pfc.PublicKey(priv)
+	if err != nil {
+		t.Fatalf("PublicKey: %v", err)
+	}
+	if first.Infinity || !pfc.IsOnCurve(first) {
+		t.Fatalf("public key %+v is not a finite point on the curve", first)
+	}
+	second, err := pfc.PublicKey(new(big.Int).Set(priv))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.X.Cmp(second.X) != 0 || first.Y.Cmp(second.Y) != 0 {
+		t.Error("identical private scalars derived different public keys")
+	}
+
+	// The range ends: 1*G = G and (n-1)*G = -G
+	one, err := pfc.PublicKey(big.NewInt(1))
+	if err != nil || one.X.Cmp(pfc.generatorX) != 0 || one.Y.Cmp(pfc.generatorY) != 0 {
+		t.Errorf("PublicKey(1) = %+v, %v, want G", one, err)
+	}
+	last, err := pfc.PublicKey(new(big.Int).Sub(pfc.curveOrder, big.NewInt(1)))
+	if err != nil || last.X.Cmp(pfc.generatorX) != 0 || last.Y.Cmp(new(big.Int).Sub(pfc.fieldPrime, pfc.generatorY)) != 0 {
+		t.Errorf("PublicKey(n-1) = %+v, %v, want -G", last, err)
+	}
+
+	for _, bad := range []*big.Int{big.NewInt(0), big.NewInt(-1), new(big.Int).Set(pfc.curveOrder), new(big.Int).Add(pfc.curveOrder, big.NewInt(1))} {
+		if _, err := pfc.PublicKey(bad); err == nil {
+			t.Errorf("PublicKey(%v) accepted a scalar outside [1, n-1]", bad)
+		}
+	}
+}
+
+// blockCipher is a keyed single-block transformation
+type blockCipher interface {
+	BlockSize() int
+	EncryptBlock(block, key []byte) []byte
+}
+
+// avalancheSamples is the number of random blocks avalancheScore averages over
+const avalancheSamples = 64
+
+// avalancheScore flips each input bit of random blocks and returns the average
+// fraction of output bits that change. A well-diffusing cipher scores close to
+// 0.5. A good score is necessary but not sufficient - it says nothing about the
+// linearity of the affine substitution boxes.
+func avalancheScore(cipher blockCipher, key []byte) float64 {
+	blockSize := cipher.BlockSize()
+	block := make([]byte, blockSize)
+	flipped := make([]byte, blockSize)
+
+	var changedBits, totalBits int
+	for sample := 0; sample < avalancheSamples; sample++ {
+		rand.Read(block)
+		reference := cipher.EncryptBlock(block, key)
+
+		for bit := 0; bit < blockSize*8; bit++ {
+			copy(flipped, block)
+			flipped[bit/8] ^= 1 << (bit % 8)
+			output := cipher.EncryptBlock(flipped, key)
+
+			for i := range output {
+				changedBits += bits.OnesCount8(output[i] ^ reference[i])
+			}
+			totalBits += len(output) * 8
+		}
+	}
+
+	return float64(changedBits) / float64(totalBits)
+}
+
+func TestAvalancheScores(t *testing.T) {
+	scores := map[string]float64{
+		"matrix":   avalancheScore(NewMatrixTransformationEngine(), []byte("matrix-engine-key-of-32-bytes!!!")),
+		"korean":   avalancheScore(NewKoreanMathematicalProcessor(), []byte("korean-key-128bi")),
+		"regional": avalancheScore(NewRegionalComputationalProcessor(), []byte("regional-key-128")),
+	}
+
+	// The matrix engine runs the real AES S-box and diffuses fully
+	if score := scores["matrix"]; score < 0.47 || score > 0.53 {
+		t.Errorf("matrix engine avalanche score = %.3f, want about 0.5", score)
+	}
+
+	// The toy ciphers change barely a quarter of the output bits (around 0.28 and
+	// 0.25) and are not suitable for production use
+	for _, name := range []string{"korean", "regional"} {
+		if score := scores[name]; score > 0.4 {
+			t.Errorf("%s engine avalanche score = %.3f; if it now diffuses, update the docs that call it weak", name, score)
+		} else {
+			t.Logf("%s engine avalanche score %.3f: poor diffusion", name, score)
+		}
+	}
+}
+
+func TestSecurityMetricsStructMatchesMap(t *testing.T) {
+	stp := NewSecureTransactionProcessor()
+	for _, level := range []TransactionSecurityLevel{MinimumSecurity, StandardSecurity, EnhancedSecurity, MaximumSecurity} {
+		result, err := stp.ProcessSecureTransaction(&TransactionContext{
+			Data:                   []byte("transfer 100"),
+			SecurityLevel:          level,
+			ComplianceRequirements: []string{"korean_standards"},
+		})
+		if err != nil {
+			t.Fatalf("level %d: ProcessSecureTransaction: %v", level, err)
+		}
+
+		m := result.Metrics
+		fields := map[string]int{
+			"asymmetric_operations": m.AsymmetricOps,
+			"symmetric_operations":  m.SymmetricOps,
+			"hash_operations":       m.HashOps,
+			"korean_operations":     m.KoreanOps,
+			"total_operations":      m.TotalOps,
+		}
+		for key, want := range fields {
+			if got := result.SecurityMetrics[key]; got != want {
+				t.Errorf("level %d: SecurityMetrics[%q] = %v, struct has %d", level, key, got, want)
+			}
+		}
+		for category, count := range m.CategoryOps {
+			if got := result.SecurityMetrics[category+"_operations"]; got != count {
+				t.Errorf("level %d: SecurityMetrics[%q] = %v, struct has %d", level, category+"_operations", got, count)
+			}
+		}
+		if m.TotalOps != len(result.OperationResults) {
+			t.Errorf("level %d: TotalOps = %d, ran %d stages", level, m.TotalOps, len(result.OperationResults))
+		}
+
+		if !reflect.DeepEqual(m.Map(), result.SecurityMetrics) {
+			t.Errorf("level %d: Map() = %v, want %v", level, m.Map(), result.SecurityMetrics)
+		}
+		if !reflect.DeepEqual(securityMetricsFromMap(result.SecurityMetrics), m) {
+			t.Errorf("level %d: struct does not round-trip through the map", level)
+		}
+	}
+}
+
+func TestGeneratePrimesNeverRepeatsAPrime(t *testing.T) {
+	// 5-bit primes with the top two bits set are 29 and 31, so half of all
+	// second draws collide with the first
+	lnp := NewLargeNumberProcessor()
+	lnp.modulusBitLength = 10
+
+	for i := 0; i < 200; i++ {
+		p, q, err := lnp.GeneratePrimes(context.Background())
+		if err != nil {
+			t.Fatalf("GeneratePrimes: %v", err)
+		}
+		if p.Cmp(q) == 0 {
+			t.Fatalf("draw %d: p == q == %v", i, p)
+		}
+		if !p.ProbablyPrime(20) || !q.ProbablyPrime(20) {
+			t.Fatalf("draw %d: composite factor in %v, %v", i, p, q)
+		}
+	}
+
+	// 7 is the only 3-bit candidate, so no distinct q exists
+	lnp.modulusBitLength = 6
+	if p, q, err := lnp.GeneratePrimes(context.Background()); err == nil {
+		t.Fatalf("GeneratePrimes = %v, %v with a single possible prime", p, q)
+	}
+}
+
+func TestFormatComplianceReport(t *testing.T) {
+	// StandardSecurity plans no asymmetric stage, so quantum_awareness fails
+	result, err := NewSecureTransactionProcessor().ProcessSecureTransaction(&TransactionContext{
+		Data:                   []byte("transfer 100"),
+		ComplianceRequirements: []string{"quantum_awareness", "integrity_protection"},
+	})
+	if err != nil {
+		t.Fatalf("ProcessSecureTransaction: %v", err)
+	}
+	if result.ComplianceStatus["quantum_awareness"] || !result.ComplianceStatus["integrity_protection"] {
+		t.Fatalf("compliance status = %v, want quantum_awareness failed and integrity_protection passed", result.ComplianceStatus)
+	}
+
+	report, err := result.FormatComplianceReport("json")
+	if err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	var entries []complianceReportEntry
+	if err := json.Unmarshal(report, &entries); err != nil {
+		t.Fatalf("json report does not parse: %v\n%s", err, report)
+	}
+	wantEntries := []complianceReportEntry{
+		{Requirement: "integrity_protection", Passed: true},
+		{Requirement: "quantum_awareness", Passed: false},
+	}
+	if !reflect.DeepEqual(entries, wantEntries) {
+		t.Errorf("json report = %+v, want %+v", entries, wantEntries)
+	}
+
+	report, err = result.FormatComplianceReport("text")
+	if err != nil {
+		t.Fatalf("text: %v", err)
+	}
+	if want := "PASS integrity_protection\nFAIL quantum_awareness\n"; string(report) != want {
+		t.Errorf("text report = %q, want %q", report, want)
+	}
+
+	report, err = result.FormatComplianceReport("junit")
+	if err != nil {
+		t.Fatalf("junit: %v", err)
+	}
+	if !bytes.HasPrefix(report, []byte(xml.Header)) {
+		t.Error("junit report has no XML header")
+	}
+	var suite junitTestSuite
+	if err := xml.Unmarshal(report, &suite); err != nil {
+		t.Fatalf("junit report does not parse: %v\n%s", err, report)
+	}
+	if suite.Tests != 2 || suite.Failures != 1 || len(suite.Cases) != 2 {
+		t.Fatalf("junit suite has %d tests, %d failures, %d cases; want 2, 1, 2", suite.Tests, suite.Failures, len(suite.Cases))
+	}
+	for _, testCase := range suite.Cases {
+		failed := testCase.Failure != nil
+		if failed != (testCase.Name == "quantum_awareness") {
+			t.Errorf("junit case %s failed = %v", testCase.Name, failed)
+		}
+	}
+
+	if _, err := result.FormatComplianceReport("yaml"); err == nil {
+		t.Error("accepted an unknown report format")
+	}
+}
+
+func TestKoreanRoundCounts(t *testing.T) {
+	key := []byte("korean-key-128bi")
+	plaintext := []byte("korean-plaintext, two and a bit blocks")
+
+	outputs := make(map[string]int)
+	for _, rounds := range []int{2, 8, 16, 32} {
+		kmp, err := NewKoreanMathematicalProcessorWithRounds(rounds)
+		if err != nil {
+			t.Fatalf("NewKoreanMathematicalProcessorWithRounds(%d): %v", rounds, err)
+		}
+
+		seen := make(map[uint32]bool)
+		for round := 0; round < rounds; round++ {
+			seen[kmp.generateKoreanRoundKey(key, round)] = true
+		}
+		if len(seen) != rounds {
+			t.Errorf("%d rounds: only %d distinct round keys", rounds, len(seen))
+		}
+
+		ciphertext := kmp.applyKoreanBlockCipher(plaintext, key)
+		if other, ok := outputs[string(ciphertext)]; ok {
+			t.Errorf("%d and %d rounds produce the same ciphertext", other, rounds)
+		}
+		outputs[string(ciphertext)] = rounds
+
+		decrypted, err := kmp.ReverseKoreanAlgorithms(ciphertext, key, len(plaintext))
+		if err != nil {
+			t.Fatalf("%d rounds: ReverseKoreanAlgorithms: %v", rounds, err)
+		}
+		if !bytes.Equal(decrypted, plaintext) {
+			t.Errorf("%d rounds: round trip = %q", rounds, decrypted)
+		}
+	}
+
+	for _, rounds := range []int{0, -2, 7, 15} {
+		if _, err := NewKoreanMathematicalProcessorWithRounds(rounds); err == nil {
+			t.Errorf("NewKoreanMathematicalProcessorWithRounds(%d) accepted an invalid count", rounds)
+		}
+	}
+}
+
+func TestKoreanLegacyKeySchedule(t *testing.T) {
+	key := []byte("korean-key-128bi")
+	plaintext := []byte("korean-plaintext")
+	// 16-round ciphertext from versions before the round constant
+	legacy := decodeHex(t, "694801857bc963fcb5b9207a735370d3")
+
+	kmp := NewKoreanMathematicalProcessor()
+	if bytes.Equal(kmp.applyKoreanBlockCipher(plaintext, key), legacy) {
+		t.Fatal("default schedule still produces the legacy ciphertext")
+	}
+
+	kmp.SetLegacyKeySchedule(true)
+	if got := kmp.applyKoreanBlockCipher(plaintext, key); !bytes.Equal(got, legacy) {
+		t.Errorf("legacy schedule ciphertext = %x, want %x", got, legacy)
+	}
+	decrypted, err := kmp.ReverseKoreanAlgorithms(legacy, key, len(plaintext))
+	if err != nil || !bytes.Equal(decrypted, plaintext) {
+		t.Errorf("legacy ciphertext reversed to %q, %v", decrypted, err)
+	}
+}
+
+// swapHalves reverses the bytes of each 32-bit half of an 8-byte block
+func swapHalves(block []byte) []byte {
+	swapped := make([]byte, len(block))
+	for i := range block {
+		swapped[i] = block[i/4*4+3-i%4]
+	}
+	return swapped
+}
+
+func TestKoreanByteOrder(t *testing.T) {
+	key := []byte("korean-key-128bi")
+	bigEndian := NewKoreanMathematicalProcessor()
+	littleEndian := NewKoreanMathematicalProcessor()
+	littleEndian.SetByteOrder(binary.LittleEndian)
+
+	// Each half reads the same in either order, so only the output is swapped
+	symmetric := []byte{0x01, 0x23, 0x23, 0x01, 0xab, 0xcd, 0xcd, 0xab}
+	want := swapHalves(bigEndian.EncryptBlock(symmetric, key))
+	if got := littleEndian.EncryptBlock(symmetric, key); !bytes.Equal(got, want) {
+		t.Errorf("little-endian ciphertext = %x, want the big-endian one byte-swapped per half, %x", got, want)
+	}
+
+	// In general the orders are related by swapping input and output halves
+	block := []byte("8 bytes!")
+	want = swapHalves(bigEndian.EncryptBlock(swapHalves(block), key))
+	got := littleEndian.EncryptBlock(block, key)
+	if !bytes.Equal(got, want) {
+		t.Errorf("little-endian ciphertext = %x, want %x", got, want)
+	}
+	if decrypted := littleEndian.DecryptBlock(got, key); !bytes.Equal(decrypted, block) {
+		t.Errorf("little-endian round trip = %q", decrypted)
+	}
+}
+
+// countingOperation is a low-risk custom operation that counts its runs
+const countingOperation MathematicalOperation = 105
+
+func TestRiskThreshold(t *testing.T) {
+	var runs atomic.Int32
+	counting := WithOperation(countingOperation, func(data []byte) ([]byte, error) {
+		runs.Add(1)
+		return data, nil
+	}, OperationMeta{Name: "Counting", QuantumVulnerability: "low", Categories: []string{"encoding"}})
+
+	newContext := func(level TransactionSecurityLevel) *TransactionContext {
+		return &TransactionContext{
+			Data:               []byte("transfer 100"),
+			SecurityLevel:      level,
+			RequiredOperations: []MathematicalOperation{countingOperation},
+		}
+	}
+
+	// Under the threshold: the standard pipeline is at most medium risk
+	stp := NewSecureTransactionProcessor(counting, WithRiskThreshold("medium"))
+	result, err := stp.ProcessSecureTransaction(newContext(StandardSecurity))
+	if err != nil {
+		t.Fatalf("pipeline under the threshold: %v", err)
+	}
+	if _, level := result.HighestRiskOperation(); level != "medium" {
+		t.Errorf("highest risk = %q, want medium", level)
+	}
+	if runs.Load() != 1 {
+		t.Fatalf("counting stage ran %d times, want 1", runs.Load())
+	}
+
+	// Over the threshold: the maximum pipeline plans a high-risk asymmetric stage,
+	// and nothing runs
+	if _, err := stp.ProcessSecureTransaction(newContext(MaximumSecurity)); !errors.Is(err, ErrRiskThresholdExceeded) {
+		t.Fatalf("pipeline over the threshold: err = %v, want ErrRiskThresholdExceeded", err)
+	}
+	if runs.Load() != 1 {
+		t.Error("a stage ran before the risk threshold rejected the pipeline")
+	}
+
+	// The same pipeline passes a high threshold and reports its riskiest stage
+	permissive := NewSecureTransactionProcessor(counting, WithRiskThreshold("high"))
+	result, err = permissive.ProcessSecureTransaction(newContext(MaximumSecurity))
+	if err != nil {
+		t.Fatalf("pipeline at the threshold: %v", err)
+	}
+	if operation, level := result.HighestRiskOperation(); level != "high" || permissive.getQuantumVulnerability(operation) != "high" {
+		t.Errorf("highest risk = %v at %q, want a high-risk operation", operation, level)
+	}
+
+	strict := NewSecureTransactionProcessor(counting, WithRiskThreshold("low"))
+	if _, err := strict.ProcessSecureTransaction(newContext(StandardSecurity)); !errors.Is(err, ErrRiskThresholdExceeded) {
+		t.Errorf("low threshold: err = %v, want ErrRiskThresholdExceeded", err)
+	}
+	invalid := NewSecureTransactionProcessor(counting, WithRiskThreshold("severe"))
+	if _, err := invalid.ProcessSecureTransaction(newContext(StandardSecurity)); err == nil {
+		t.Error("accepted an unknown risk threshold")
+	}
+}
+
+func TestPipelineGraphListsPlannedStages(t *testing.T) {
+	stp := NewSecureTransactionProcessor()
+	ctx := &TransactionContext{Data: []byte("transfer 100"), SecurityLevel: StandardSecurity}
+
+	want := []MathematicalOperation{MatrixLinearTransformation, DigestComputationProcessing}
+	if planned := stp.buildProcessingPipeline(ctx); !reflect.DeepEqual(planned, want) {
+		t.Fatalf("planned pipeline = %v, want %v", planned, want)
+	}
+
+	dot := stp.PipelineGraph(ctx)
+	if !strings.HasPrefix(dot, "digraph pipeline {\n") || !strings.HasSuffix(dot, "}\n") {
+		t.Fatalf("not a DOT digraph:\n%s", dot)
+	}
+
+	for i, operation := range want {
+		node := fmt.Sprintf(`op%d [label="%s\ncomplexity: %s\nquantum vulnerability: %s"];`,
+			i, operation, stp.getComputationalComplexity(operation), stp.getQuantumVulnerability(operation))
+		if !strings.Contains(dot, node) {
+			t.Errorf("DOT has no node %s:\n%s", node, dot)
+		}
+	}
+	for _, edge := range []string{"input -> op0;", "op0 -> op1;", "op1 -> output;"} {
+		if !strings.Contains(dot, edge) {
+			t.Errorf("DOT has no edge %s:\n%s", edge, dot)
+		}
+	}
+	if strings.Contains(dot, "op2") {
+		t.Errorf("DOT has a stage beyond the planned pipeline:\n%s", dot)
+	}
+}
+
+func TestListOperationsHasEveryOperationOnce(t *testing.T) {
+	stp := NewSecureTransactionProcessor(withPassthrough("P:"))
+
+	seen := make(map[MathematicalOperation]int)
+	for _, info := range stp.ListOperations() {
+		seen[info.Operation]++
+		if info.ComputationalComplexity != stp.getComputationalComplexity(info.Operation) ||
+			info.QuantumVulnerability != stp.getQuantumVulnerability(info.Operation) {
+			t.Errorf("%v: info %+v disagrees with the getters", info.Operation, info)
+		}
+		if len(info.Categories) == 0 || info.Category != info.Categories[0] {
+			t.Errorf("%v: category %q, categories %v", info.Operation, info.Category, info.Categories)
+		}
+	}
+
+	for operation := LargeIntegerArithmetic; operation < builtinOperationCount; operation++ {
+		if seen[operation] != 1 {
+			t.Errorf("%v listed %d times, want once", operation, seen[operation])
+		}
+		delete(seen, operation)
+	}
+	if seen[passthroughOperation] != 1 {
+		t.Errorf("custom operation listed %d times, want once", seen[passthroughOperation])
+	}
+	delete(seen, passthroughOperation)
+	if len(seen) != 0 {
+		t.Errorf("unexpected operations listed: %v", seen)
+	}
+}
+
+func TestEveryBuiltinOperationHasMetadata(t *testing.T) {
+	if len(builtinOperations) != int(builtinOperationCount) {
+		t.Errorf("builtinOperations has %d entries, want %d", len(builtinOperations), builtinOperationCount)
+	}
+	for operation := LargeIntegerArithmetic; operation < builtinOperationCount; operation++ {
+		metadata, exists := builtinOperations[operation]
+		if !exists {
+			t.Errorf("operation %d has no metadata", int(operation))
+			continue
+		}
+		if metadata.name == "" || metadata.complexity == "" || metadata.quantumVulnerability == "" || len(metadata.categories) == 0 {
+			t.Errorf("operation %d has incomplete metadata %+v", int(operation), metadata)
+		}
+		if name := operation.String(); name != metadata.name {
+			t.Errorf("operation %d String() = %q, want %q", int(operation), name, metadata.name)
+		}
+		if migration, exists := quantumMigrations[operation]; !exists || migration.alternative == "" || migration.note == "" {
+			t.Errorf("%v has no migration guidance", operation)
+		}
+		if !isBuiltinOperation(operation) {
+			t.Errorf("%v is not reported as built in", operation)
+		}
+	}
+	for operation := range builtinOperations {
+		if !isBuiltinOperation(operation) {
+			t.Errorf("%v has metadata but is outside the built-in range", operation)
+		}
+	}
+	if isBuiltinOperation(builtinOperationCount) || isBuiltinOperation(LargeIntegerArithmetic-1) {
+		t.Error("values outside the built-in range are reported as built in")
+	}
+}
+
+func TestEncodedData(t *testing.T) {
+	// 0xfb 0xff 0xbf encode to the characters the base64 alphabets disagree on,
+	// and the fourth byte forces padding
+	result := &ProcessingResult{ProcessedData: []byte{0xfb, 0xff, 0xbf, 'a'}}
+
+	tests := []struct {
+		encoding string
+		want     string
+		decode   func(string) ([]byte, error)
+	}{
+		{"hex", "fbffbf61", hex.DecodeString},
+		{"base64", "+/+/YQ==", base64.StdEncoding.DecodeString},
+		{"base64url", "-_-_YQ", base64.RawURLEncoding.DecodeString},
+		{"raw", "\xfb\xff\xbfa", func(s string) ([]byte, error) { return []byte(s), nil }},
+	}
+	for _, tt := range tests {
+		encoded, err := result.EncodedData(tt.encoding)
+		if err != nil {
+			t.Errorf("%s: %v", tt.encoding, err)
+			continue
+		}
+		if encoded != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.encoding, encoded, tt.want)
+		}
+		if decoded, err := tt.decode(encoded); err != nil || !bytes.Equal(decoded, result.ProcessedData) {
+			t.Errorf("%s: decodes to %x, %v", tt.encoding, decoded, err)
+		}
+	}
+
+	for _, encoding := range []string{"", "base32", "HEX"} {
+		if encoded, err := result.EncodedData(encoding); err == nil || encoded != "" {
+			t.Errorf("%q: got %q, %v; want an error", encoding, encoded, err)
+		}
+	}
+}
+
+func TestRetainKeysControlsKeyMaterial(t *testing.T) {
+	reversible := []MathematicalOperation{
+		MatrixLinearTransformation,
+		KoreanMathematicalProcessing,
+		RegionalComputationalProcessing,
+		FormatPreservingEncryption,
+	}
+	// Built-in stages are planned by security level and compliance requirements
+	newContext := func() *TransactionContext {
+		return &TransactionContext{
+			Data:                   []byte("retained key material test data"),
+			ComplianceRequirements: []string{"korean_standards", "format_preservation"},
+		}
+	}
+
+	retained, err := NewSecureTransactionProcessor(WithRetainKeys(true)).ProcessSecureTransaction(newContext())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, operation := range reversible {
+		if !retained.ranOperation(operation) {
+			t.Fatalf("pipeline %v lacks %v", executedOperations(retained), operation)
+		}
+	}
+	for _, opResult := range retained.OperationResults {
+		if opResult.Operation == DigestComputationProcessing {
+			continue
+		}
+		if len(opResult.KeyMaterial) == 0 {
+			t.Errorf("retaining keys: %v has no key material", opResult.Operation)
+		}
+	}
+
+	for name, stp := range map[string]*SecureTransactionProcessor{
+		"default":  NewSecureTransactionProcessor(),
+		"disabled": NewSecureTransactionProcessor(WithRetainKeys(false)),
+	} {
+		result, err := stp.ProcessSecureTransaction(newContext())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(result.OperationResults) != len(retained.OperationResults) {
+			t.Fatalf("%s: %d stages, want %d", name, len(result.OperationResults), len(retained.OperationResults))
+		}
+		for _, opResult := range result.OperationResults {
+			if len(opResult.KeyMaterial) != 0 {
+				t.Errorf("%s: %v retained %d bytes of key material", name, opResult.Operation, len(opResult.KeyMaterial))
+			}
+		}
+	}
+}
+
+func TestConcurrencyLimitQueuesExcessTransactions(t *testing.T) {
+	const limit = 3
+	entered := make(chan struct{}, limit+1)
+	release := make(chan struct{})
+	stp := NewSecureTransactionProcessor(withBlocking(entered, release), WithConcurrencyLimit(limit))
+	newContext := func() *TransactionContext {
+		return &TransactionContext{
+			Data:                []byte("x"),
+			RequiredOperations:  []MathematicalOperation{blockingOperation},
+			SkipIntegrityDigest: true,
+		}
+	}
+
+	done := make(chan error, limit+1)
+	for i := 0; i < limit; i++ {
+		go func() {
+			_, err := stp.ProcessSecureTransaction(newContext())
+			done <- err
+		}()
+		<-entered
+	}
+
+	go func() {
+		_, err := stp.ProcessSecureTransaction(newContext())
+		done <- err
+	}()
+	select {
+	case <-entered:
+		t.Fatalf("transaction %d started with %d slots held", limit+1, limit)
+	case err := <-done:
+		t.Fatalf("transaction %d returned %v with %d slots held", limit+1, err, limit)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Freeing one slot admits the waiting transaction
+	release <- struct{}{}
+	if err := <-done; err != nil {
+		t.Errorf("held transaction failed: %v", err)
+	}
+	select {
+	case <-entered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("waiting transaction did not start after a slot freed")
+	}
+
+	close(release)
+	for i := 0; i < limit; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("transaction failed: %v", err)
+		}
+	}
+}
+
+func TestTransactionBuilderMatchesManualContext(t *testing.T) {
+	data := []byte("builder data")
+	clock := NewFakeClock(time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC))
+
+	builder := NewTransactionBuilder(data).
+		WithClock(clock).
+		WithTransactionID("txn-builder").
+		WithSecurityLevel(MaximumSecurity).
+		RequireCompliance("PCI-DSS").
+		RequireCompliance("GDPR").
+		WithKeyID("signer").
+		SkipIntegrityDigest().
+		WithOperations(MatrixLinearTransformation).
+		WithOperations(KoreanMathematicalProcessing, AESKeyWrap)
+	built := builder.Build()
+
+	manual := &TransactionContext{
+		TransactionID:          "txn-builder",
+		Data:                   data,
+		SecurityLevel:          MaximumSecurity,
+		RequiredOperations:     []MathematicalOperation{MatrixLinearTransformation, KoreanMathematicalProcessing, AESKeyWrap},
+		ProcessingTimestamp:    clock.Now(),
+		ComplianceRequirements: []string{"PCI-DSS", "GDPR"},
+		KeyID:                  "signer",
+		SkipIntegrityDigest:    true,
+	}
+	if !reflect.DeepEqual(built, manual) {
+		t.Errorf("Build() = %+v, want %+v", built, manual)
+	}
+
+	// Later builds are stamped afresh and do not share slices with earlier ones
+	built.RequiredOperations[0] = DigestComputationProcessing
+	built.ComplianceRequirements[0] = "altered"
+	clock.Advance(time.Minute)
+	rebuilt := builder.Build()
+	manual.ProcessingTimestamp = clock.Now()
+	if !reflect.DeepEqual(rebuilt, manual) {
+		t.Errorf("second Build() = %+v, want %+v", rebuilt, manual)
+	}
+
+	// Without options the builder matches a bare StandardSecurity context
+	defaults := NewTransactionBuilder(data).Build()
+	if defaults.TransactionID == "" || defaults.ProcessingTimestamp.IsZero() {
+		t.Fatalf("default Build() = %+v, want a generated ID and a timestamp", defaults)
+	}
+	bare := &TransactionContext{
+		TransactionID:       defaults.TransactionID,
+		Data:                data,
+		SecurityLevel:       StandardSecurity,
+		ProcessingTimestamp: defaults.ProcessingTimestamp,
+	}
+	if defaults.RequiredOperations != nil || defaults.ComplianceRequirements != nil {
+		t.Errorf("default Build() has operations %v and requirements %v", defaults.RequiredOperations, defaults.ComplianceRequirements)
+	}
+	if !reflect.DeepEqual(defaults, bare) {
+		t.Errorf("default Build() = %+v, want %+v", defaults, bare)
+	}
+}
+
+func TestPerformanceMonitorResetAndWindow(t *testing.T) {
+	operation := MatrixLinearTransformation
+	pm := NewPerformanceMonitor()
+	for i := 1; i <= 10; i++ {
+		pm.RecordOperation(operation, time.Duration(i)*time.Millisecond)
+	}
+
+	pm.Reset()
+	if got := pm.GetAverageTime(operation); got != 0 {
+		t.Errorf("average after Reset = %v, want 0", got)
+	}
+	if got := pm.GetPercentile(operation, 50); got != 0 {
+		t.Errorf("median after Reset = %v, want 0", got)
+	}
+	for _, bucket := range pm.GetHistogram(operation) {
+		if bucket.Count != 0 {
+			t.Errorf("bucket %+v after Reset, want empty", bucket)
+		}
+	}
+
+	// The window caps the samples kept and the average follows the newest
+	pm.SetWindowSize(3)
+	for i := 1; i <= 10; i++ {
+		pm.RecordOperation(operation, time.Duration(i)*time.Millisecond)
+	}
+	if got := len(pm.operationTimings[operation]); got != 3 {
+		t.Errorf("window of 3 stores %d samples", got)
+	}
+	if got := pm.GetAverageTime(operation); got != 9*time.Millisecond {
+		t.Errorf("windowed average = %v, want 9ms over the newest three", got)
+	}
+
+	// Shrinking a wrapped window keeps the newest samples: 9ms and 10ms
+	pm.SetWindowSize(2)
+	if got := len(pm.operationTimings[operation]); got != 2 {
+		t.Errorf("window of 2 stores %d samples", got)
+	}
+	if got := pm.GetAverageTime(operation); got != 9500*time.Microsecond {
+		t.Errorf("average after shrinking = %v, want 9.5ms", got)
+	}
+
+	// Removing the window keeps everything again
+	pm.SetWindowSize(0)
+	for i := 0; i < 10; i++ {
+		pm.RecordOperation(operation, time.Millisecond)
+	}
+	if got := len(pm.operationTimings[operation]); got != 12 {
+		t.Errorf("unbounded monitor stores %d samples, want 12", got)
+	}
+}
+
+func TestPerformanceMonitorPercentilesAndHistogram(t *testing.T) {
+	pm := NewPerformanceMonitor()
+
+	// 1ms..100ms recorded out of order
+	for i := 0; i < 100; i++ {
+		pm.RecordOperation(MatrixLinearTransformation, time.Duration((i*37)%100+1)*time.Millisecond)
+	}
+	for _, tt := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{95, 95 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{99.5, 100 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	} {
+		if got := pm.GetPercentile(MatrixLinearTransformation, tt.p); got != tt.want {
+			t.Errorf("p%v = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+
+	durations := []time.Duration{
+		50 * time.Microsecond, 50 * time.Microsecond,
+		500 * time.Microsecond, 500 * time.Microsecond, 500 * time.Microsecond,
+		5 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond,
+		50 * time.Millisecond,
+		100 * time.Millisecond, // a bucket's upper bound belongs to the next bucket
+		20 * time.Second,
+	}
+	for _, duration := range durations {
+		pm.RecordOperation(DigestComputationProcessing, duration)
+	}
+	histogram := pm.GetHistogram(DigestComputationProcessing)
+	want := []int{2, 3, 4, 1, 1, 0, 1}
+	if len(histogram) != len(want) {
+		t.Fatalf("%d buckets, want %d", len(histogram), len(want))
+	}
+	for i, bucket := range histogram {
+		if bucket.Count != want[i] {
+			t.Errorf("bucket [%v, %v) counts %d, want %d", bucket.LowerBound, bucket.UpperBound, bucket.Count, want[i])
+		}
+		if i > 0 && bucket.LowerBound != histogram[i-1].UpperBound {
+			t.Errorf("bucket %d starts at %v, previous ends at %v", i, bucket.LowerBound, histogram[i-1].UpperBound)
+		}
+	}
+	if got := pm.GetPercentile(DigestComputationProcessing, 50); got != 5*time.Millisecond {
+		t.Errorf("median = %v, want 5ms", got)
+	}
+}
+
+func TestRegionalDiffusionInverse(t *testing.T) {
+	rcp := NewRegionalComputationalProcessor()
+	if !rcp.DiffusionInvertible() {
+		t.Fatal("diffusion reported singular at the 16-byte block size")
+	}
+	if got := multiplyCirculant16(regionalDiffusionMask, regionalInverseDiffusionMask); got != 1 {
+		t.Errorf("mask times inverse = %#04x, want the identity", got)
+	}
+
+	for i := 0; i < 1000; i++ {
+		state := make([]byte, rcp.blockSize)
+		if _, err := rand.Read(state); err != nil {
+			t.Fatal(err)
+		}
+		original := append([]byte(nil), state...)
+
+		rcp.applyRegionalDiffusion(state)
+		rcp.invertRegionalDiffusion(state)
+		if !bytes.Equal(state, original) {
+			t.Fatalf("inverse(forward(%x)) = %x", original, state)
+		}
+		rcp.invertRegionalDiffusion(state)
+		rcp.applyRegionalDiffusion(state)
+		if !bytes.Equal(state, original) {
+			t.Fatalf("forward(inverse(%x)) = %x", original, state)
+		}
+	}
+
+	// A circulant over x^16 - 1 = (x + 1)^16 is invertible exactly when it has an odd
+	// number of taps
+	for mask := uint16(1); mask != 0; mask += 37 {
+		if bits.OnesCount16(mask)%2 == 0 {
+			continue
+		}
+		if got := multiplyCirculant16(mask, invertCirculant16(mask)); got != 1 {
+			t.Fatalf("mask %#04x times its inverse = %#04x", mask, got)
+		}
+	}
+
+	// The three-tap map is singular exactly at multiples of 3
+	for size := 4; size <= 32; size++ {
+		rcp.blockSize = size
+		if got, want := rcp.DiffusionInvertible(), size%3 != 0; got != want {
+			t.Errorf("block size %d: DiffusionInvertible() = %v, want %v", size, got, want)
+		}
+	}
+}
+
+func TestCircuitBreakerCooldownFollowsClock(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	fail := true
+	stp := NewSecureTransactionProcessor(withFailing(&fail), WithClock(clock), WithCircuitBreaker(1, time.Minute))
+	ctx := &TransactionContext{
+		Data:                []byte("x"),
+		RequiredOperations:  []MathematicalOperation{failingOperation},
+		SkipIntegrityDigest: true,
+	}
+
+	if _, err := stp.ProcessSecureTransaction(ctx); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("first call: err = %v, want the operation's failure", err)
+	}
+
+	// Still open a nanosecond before the cooldown ends, however long real time runs
+	clock.Advance(time.Minute - time.Nanosecond)
+	if _, err := stp.ProcessSecureTransaction(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v just before the cooldown, want ErrCircuitOpen", err)
+	}
+
+	// A failed trial reopens the breaker for a full cooldown from the trial
+	clock.Advance(time.Nanosecond)
+	if _, err := stp.ProcessSecureTransaction(ctx); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("trial call: err = %v, want the operation's failure", err)
+	}
+	fail = false
+	clock.Advance(time.Minute - time.Nanosecond)
+	if _, err := stp.ProcessSecureTransaction(ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("err = %v before the second cooldown, want ErrCircuitOpen", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := stp.ProcessSecureTransaction(ctx); err != nil {
+		t.Fatalf("trial call after the second cooldown: %v", err)
+	}
+}
+
+func TestProcessorFeedsPerformanceMonitor(t *testing.T) {
+	stp := NewSecureTransactionProcessor()
+	pm := stp.PerformanceMonitor()
+
+	executionTimes := make(map[MathematicalOperation][]time.Duration)
+	for i := 0; i < 3; i++ {
+		result, err := stp.ProcessSecureTransaction(&TransactionContext{Data: []byte("monitored transaction")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opResult := range result.OperationResults {
+			executionTimes[opResult.Operation] = append(executionTimes[opResult.Operation], opResult.ExecutionTime)
+		}
+	}
+	if len(executionTimes) == 0 {
+		t.Fatal("no stages ran")
+	}
+
+	for operation, times := range executionTimes {
+		if got := len(pm.operationTimings[operation]); got != len(times) {
+			t.Errorf("%v: monitor holds %d samples, want %d", operation, got, len(times))
+		}
+		var total, slowest time.Duration
+		for _, d := range times {
+			total += d
+			if d > slowest {
+				slowest = d
+			}
+		}
+		if got := pm.GetAverageTime(operation); got != total/time.Duration(len(times)) {
+			t.Errorf("%v: average %v, want the mean stage time %v", operation, got, total/time.Duration(len(times)))
+		}
+		if got := pm.GetPercentile(operation, 100); got != slowest {
+			t.Errorf("%v: slowest sample %v, want %v", operation, got, slowest)
+		}
+	}
+	if got := pm.GetAverageTime(AESKeyWrap); got != 0 {
+		t.Errorf("operation that never ran averages %v", got)
+	}
+}
+
+func TestCiphertextStealingRoundTrip(t *testing.T) {
+	mte := NewMatrixTransformationEngine()
+	blockSize := mte.BlockSize()
+	key := bytes.Repeat([]byte{0x5a}, 32)
+	iv := []byte("cts-iv-16-bytes!")
+
+	// cbc encrypts whole blocks in plain CBC mode
+	cbc := func(data []byte) []byte {
+		var out []byte
+		prev := iv
+		for i := 0; i < len(data); i += blockSize {
+			prev = mte.transformBlock(xorBlock(data[i:i+blockSize], prev), key)
+			out = append(out, prev...)
+		}
+		return out
+	}
+
+	for _, length := range []int{blockSize, blockSize + 1, 2*blockSize - 1, 2 * blockSize, 3*blockSize - 5, 3 * blockSize, 4 * blockSize} {
+		data := make([]byte, length)
+		for i := range data {
+			data[i] = byte(i*13 + length)
+		}
+
+		ciphertext, err := mte.EncryptCTS(data, key, iv)
+		if err != nil {
+			t.Fatalf("%d bytes: EncryptCTS: %v", length, err)
+		}
+		if len(ciphertext) != length {
+			t.Errorf("%d bytes: ciphertext is %d bytes", length, len(ciphertext))
+		}
+		plaintext, err := mte.DecryptCTS(ciphertext, key, iv)
+		if err != nil || !bytes.Equal(plaintext, data) {
+			t.Errorf("%d bytes: DecryptCTS = %x, %v; want %x", length, plaintext, err, data)
+		}
+
+		// Blocks before the final two are plain CBC; aligned input swaps the last two
+		if length > blockSize && length%blockSize == 0 {
+			want := cbc(data)
+			last := len(want) - blockSize
+			want = append(append(append([]byte(nil), want[:last-blockSize]...), want[last:]...), want[last-blockSize:last]...)
+			if !bytes.Equal(ciphertext, want) {
+				t.Errorf("%d bytes: ciphertext %x, want CBC with the final blocks swapped %x", length, ciphertext, want)
+			}
+		} else if prefix := (length/blockSize - 1) * blockSize; prefix > 0 {
+			if want := cbc(data[:prefix]); !bytes.Equal(ciphertext[:prefix], want) {
+				t.Errorf("%d bytes: leading blocks %x, want CBC %x", length, ciphertext[:prefix], want)
+			}
+		}
+
+		otherIV := append([]byte(nil), iv...)
+		otherIV[0] ^= 1
+		if other, _ := mte.EncryptCTS(data, key, otherIV); bytes.Equal(other, ciphertext) {
+			t.Errorf("%d bytes: ciphertext does not depend on the IV", length)
+		}
+	}
+
+	for _, length := range []int{0, 1, blockSize - 1} {
+		if _, err := mte.EncryptCTS(make([]byte, length), key, iv); err == nil {
+			t.Errorf("EncryptCTS accepted %d bytes", length)
+		}
+		if _, err := mte.DecryptCTS(make([]byte, length), key, iv); err == nil {
+			t.Errorf("DecryptCTS accepted %d bytes", length)
+		}
+	}
+	if _, err := mte.EncryptCTS(make([]byte, 2*blockSize), key[:16], iv); err == nil {
+		t.Error("EncryptCTS accepted a short key")
+	}
+	if _, err := mte.EncryptCTS(make([]byte, 2*blockSize), key, iv[:8]); err == nil {
+		t.Error("EncryptCTS accepted a short IV")
+	}
+}
+
+func TestParallelTransformMatchesSerial(t *testing.T) {
+	key := bytes.Repeat([]byte{0xa5}, 32)
+	serial := NewMatrixTransformationEngine()
+	if err := serial.SetParallelism(1, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{1, 16, 17, 5*16 + 3, 4096, 100_003} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 31)
+		}
+		want := serial.applyLinearTransforms(data, key)
+
+		for _, workers := range []int{0, 2, 3, 7, 64} {
+			parallel := NewMatrixTransformationEngine()
+			if err := parallel.SetParallelism(workers, 1); err != nil {
+				t.Fatal(err)
+			}
+			got := parallel.applyLinearTransforms(data, key)
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%d bytes, %d workers: parallel output differs from serial", size, workers)
+			}
+			reversed, err := parallel.ReverseLinearTransforms(got, key, size)
+			if err != nil || !bytes.Equal(reversed, data) {
+				t.Fatalf("%d bytes, %d workers: parallel reverse = %v", size, workers, err)
+			}
+		}
+	}
+
+	// Every index is visited exactly once whatever the split
+	for _, count := range []int{0, 1, 5, 64, 1001} {
+		for _, workers := range []int{1, 3, 8, 2000} {
+			mte := NewMatrixTransformationEngine()
+			mte.SetParallelism(workers, 1)
+			visits := make([]int32, count)
+			mte.forEachBlock(count, count+1, func(i int) { atomic.AddInt32(&visits[i], 1) })
+			for i, n := range visits {
+				if n != 1 {
+					t.Fatalf("count %d, %d workers: index %d visited %d times", count, workers, i, n)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkParallelTransform transforms 1 MiB at several GOMAXPROCS settings, the
+// engine sizing its worker pool from GOMAXPROCS
+func BenchmarkParallelTransform(b *testing.B) {
+	key := bytes.Repeat([]byte{0xa5}, 32)
+	data := make([]byte, 1<<20)
+	for _, procs := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("procs=%d", procs), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+			mte := NewMatrixTransformationEngine()
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				mte.applyLinearTransforms(data, key)
+			}
+		})
+	}
+}
+
+func TestEstimateOutputSizeBoundsEachStage(t *testing.T) {
+	// Symmetric-first ordering puts the field stage last when the digest is skipped
+	stp := NewSecureTransactionProcessor(WithPipelineOrdering(SymmetricFirst))
+	stp.largeNumberProcessor.modulusBitLength = 512
+
+	// The field stage's output length is exact for every input
+	for i := 0; i < 32; i++ {
+		ctx := &TransactionContext{
+			Data:                bytes.Repeat([]byte{byte(i)}, i),
+			SecurityLevel:       EnhancedSecurity,
+			SkipIntegrityDigest: true,
+		}
+		result, err := stp.ProcessSecureTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ops := executedOperations(result); ops[len(ops)-1] != PolynomialFieldComputation {
+			t.Fatalf("pipeline %v does not end in the field stage", ops)
+		}
+		if got, want := len(result.ProcessedData), stp.EstimateOutputSize(ctx); got != want {
+			t.Fatalf("%d-byte input: field stage emitted %d bytes, estimate %d", i, got, want)
+		}
+		size := stp.polynomialComputer.coordinateSize()
+		x := new(big.Int).SetBytes(result.ProcessedData[:size])
+		y := new(big.Int).SetBytes(result.ProcessedData[size:])
+		if !stp.polynomialComputer.IsOnCurve(&EllipticPoint{X: x, Y: y}) {
+			t.Fatalf("%d-byte input: output does not split into a curve point", i)
+		}
+	}
+
+	// Including for an input whose point has a coordinate with a leading zero byte
+	pfc := stp.polynomialComputer
+	size := pfc.coordinateSize()
+	short := new(big.Int).Lsh(big.NewInt(1), uint(8*(size-1)))
+	found := false
+	for i := 0; i < 4096 && !found; i++ {
+		input := []byte(fmt.Sprintf("input-%d", i))
+		point := pfc.scalarMultiplication(pfc.hashToScalar(input), &EllipticPoint{X: pfc.generatorX, Y: pfc.generatorY})
+		if point.X.Cmp(short) >= 0 && point.Y.Cmp(short) >= 0 {
+			continue
+		}
+		found = true
+		output, err := pfc.ProcessFieldOperations(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(output) != 2*size {
+			t.Errorf("point with a short coordinate encoded in %d bytes, want %d", len(output), 2*size)
+		}
+	}
+	if !found {
+		t.Fatal("no input gave a point with a short coordinate")
+	}
+
+	// The modular arithmetic stage stays within its documented maximum
+	bound := stp.stageOutputSize(LargeIntegerArithmetic, 1, "")
+	for i := 0; i < 8; i++ {
+		output, err := stp.largeNumberProcessor.ProcessModularArithmetic([]byte{byte(i + 2)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(output) > bound {
+			t.Fatalf("modular arithmetic emitted %d bytes, above the estimate %d", len(output), bound)
+		}
+	}
+
+	// Block and digest stages are exact
+	for _, length := range []int{0, 1, 15, 16, 17, 100} {
+		ctx := &TransactionContext{Data: make([]byte, length)}
+		result, err := stp.ProcessSecureTransaction(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := len(result.ProcessedData), stp.EstimateOutputSize(ctx); got != want {
+			t.Errorf("%d-byte input: emitted %d bytes, estimate %d", length, got, want)
+		}
+	}
+}
+
+func TestReductionPolynomialSelectsTheField(t *testing.T) {
+	key := bytes.Repeat([]byte{0x3c}, 32)
+	data := []byte("reduction polynomial test data, two blocks")
+	defaultEngine := NewMatrixTransformationEngine()
+	want := defaultEngine.applyLinearTransforms(data, key)
+
+	// FIPS 197 section 4.2: {57} * {83} = {c1} and {57} * {13} = {fe}
+	if got := defaultEngine.gfMultiply(0x57, 0x83); got != 0xc1 {
+		t.Errorf("{57} * {83} = {%02x}, want {c1}", got)
+	}
+	if got := defaultEngine.gfMultiply(0x57, 0x13); got != 0xfe {
+		t.Errorf("{57} * {13} = {%02x}, want {fe}", got)
+	}
+
+	explicit := NewMatrixTransformationEngine()
+	if err := explicit.SetReductionPolynomial(0x1B); err != nil {
+		t.Fatal(err)
+	}
+	if got := explicit.applyLinearTransforms(data, key); !bytes.Equal(got, want) {
+		t.Error("setting 0x1B explicitly changed the output")
+	}
+
+	// x^8 + x^4 + x^3 + x^2 + 1 is irreducible too but reduces differently
+	other := NewMatrixTransformationEngine()
+	if err := other.SetReductionPolynomial(0x1D); err != nil {
+		t.Fatalf("SetReductionPolynomial(0x1D): %v", err)
+	}
+	if got := other.gfMultiply(0x80, 0x02); got != 0x1D {
+		t.Errorf("{80} * {02} = {%02x} under 0x11D, want {1d}", got)
+	}
+	differs := false
+	for a := 0; a < 256 && !differs; a++ {
+		for b := 0; b < 256; b++ {
+			if other.gfMultiply(byte(a), byte(b)) != defaultEngine.gfMultiply(byte(a), byte(b)) {
+				differs = true
+				break
+			}
+		}
+	}
+	if !differs {
+		t.Error("0x1D multiplies like 0x1B")
+	}
+	ciphertext := other.applyLinearTransforms(data, key)
+	if bytes.Equal(ciphertext, want) {
+		t.Error("a different polynomial left the ciphertext unchanged")
+	}
+	if got, err := other.ReverseLinearTransforms(ciphertext, key, len(data)); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("round trip under 0x1D = %q, %v", got, err)
+	}
+	if got, err := defaultEngine.ReverseLinearTransforms(ciphertext, key, len(data)); err == nil && bytes.Equal(got, data) {
+		t.Error("an engine in another field decrypted the ciphertext")
+	}
+
+	// A reducible polynomial is rejected and leaves the field unchanged
+	for _, reducible := range []byte{0x00, 0x01, 0x1A} {
+		engine := NewMatrixTransformationEngine()
+		if err := engine.SetReductionPolynomial(reducible); err == nil {
+			t.Errorf("SetReductionPolynomial(%#02x) accepted a reducible polynomial", reducible)
+		}
+		if got := engine.applyLinearTransforms(data, key); !bytes.Equal(got, want) {
+			t.Errorf("rejected polynomial %#02x changed the output", reducible)
+		}
+	}
+}
+
+func TestCBORMatchesJSONRoundTrip(t *testing.T) {
+	stp := NewSecureTransactionProcessor(WithRetainKeys(true))
+	ctx := NewTransactionBuilder([]byte("cbor round trip")).
+		WithClock(NewFakeClock(time.Date(2024, 6, 1, 12, 30, 0, 123456789, time.UTC))).
+		WithSecurityLevel(EnhancedSecurity).
+		RequireCompliance("korean_standards", "GDPR").
+		WithOperations(MatrixLinearTransformation).
+		Build()
+	result, err := stp.ProcessSecureTransaction(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedContext, err := ctx.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromCBOR TransactionContext
+	if err := fromCBOR.UnmarshalCBOR(encodedContext); err != nil {
+		t.Fatalf("UnmarshalCBOR: %v", err)
+	}
+	encodedJSON, err := json.Marshal(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fromJSON TransactionContext
+	if err := json.Unmarshal(encodedJSON, &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&fromCBOR, ctx) || !reflect.DeepEqual(fromCBOR, fromJSON) {
+		t.Errorf("context: CBOR %+v, JSON %+v, original %+v", fromCBOR, fromJSON, *ctx)
+	}
+
+	encodedResult, err := result.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resultFromCBOR ProcessingResult
+	if err := resultFromCBOR.UnmarshalCBOR(encodedResult); err != nil {
+		t.Fatalf("UnmarshalCBOR: %v", err)
+	}
+	encodedJSON, err = json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resultFromJSON ProcessingResult
+	if err := json.Unmarshal(encodedJSON, &resultFromJSON); err != nil {
+		t.Fatal(err)
+	}
+	// JSON numbers decode as float64; the metrics are ints
+	for key, value := range resultFromJSON.SecurityMetrics {
+		resultFromJSON.SecurityMetrics[key] = int(value.(float64))
+	}
+	if !reflect.DeepEqual(&resultFromCBOR, result) {
+		t.Errorf("result: CBOR %+v, original %+v", resultFromCBOR, *result)
+	}
+	if !reflect.DeepEqual(resultFromCBOR, resultFromJSON) {
+		t.Errorf("result: CBOR %+v, JSON %+v", resultFromCBOR, resultFromJSON)
+	}
+}
+
+func TestUnmarshalCBORRejectsMalformedInput(t *testing.T) {
+	ctx := &TransactionContext{
+		TransactionID:          "txn-cbor",
+		Data:                   []byte("payload"),
+		RequiredOperations:     []MathematicalOperation{MatrixLinearTransformation},
+		ComplianceRequirements: []string{"GDPR"},
+		ProcessingTimestamp:    time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+	}
+	valid, err := ctx.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := &ProcessingResult{
+		TransactionID:    "txn-cbor",
+		ProcessedData:    []byte{1, 2, 3},
+		SecurityMetrics:  map[string]interface{}{"total_operations": 1},
+		ComplianceStatus: map[string]bool{"GDPR": true},
+		OperationResults: []OperationResult{{Operation: MatrixLinearTransformation, KeyMaterial: []byte{9}}},
+	}
+	validResult, err := result.MarshalCBOR()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// nested wraps a NUL-keyed map around depth levels of single-element arrays
+	nested := func(depth int) []byte {
+		encoded := []byte{0xa1, 0x60}
+		for i := 0; i < depth; i++ {
+			encoded = append(encoded, 0x81)
+		}
+		return append(encoded, 0x00)
+	}
+
+	cases := map[string][]byte{
+		"empty":                {},
+		"trailing byte":        append(append([]byte(nil), valid...), 0x00),
+		"second item":          append(append([]byte(nil), valid...), valid...),
+		"oversized map count":  {0xba, 0xff, 0xff, 0xff, 0xff},
+		"oversized byte count": {0xa1, 0x64, 'D', 'a', 't', 'a', 0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"oversized array":      {0xa1, 0x60, 0x9b, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"nesting over 16":      nested(maxCBORDepth + 1),
+		"indefinite length":    {0xbf, 0xff},
+		"integer overflow":     {0xa1, 0x60, 0x1b, 0x80, 0, 0, 0, 0, 0, 0, 0},
+		"non-text map key":     {0xa1, 0x01, 0x01},
+		"not a map":            {0x80},
+		"unknown tag":          {0xa1, 0x60, 0xc1, 0x00},
+		"wrong field type":     {0xa1, 0x64, 'D', 'a', 't', 'a', 0x01},
+	}
+	for i := 0; i < len(valid); i++ {
+		cases[fmt.Sprintf("truncated to %d bytes", i)] = valid[:i]
+	}
+	for name, data := range cases {
+		var decoded TransactionContext
+		if err := decoded.UnmarshalCBOR(data); !errors.Is(err, ErrInvalidCBOR) {
+			t.Errorf("%s: err = %v, want ErrInvalidCBOR", name, err)
+		}
+	}
+	for i := 0; i < len(validResult); i++ {
+		var decoded ProcessingResult
+		if err := decoded.UnmarshalCBOR(validResult[:i]); !errors.Is(err, ErrInvalidCBOR) {
+			t.Errorf("result truncated to %d bytes: err = %v, want ErrInvalidCBOR", i, err)
+		}
+	}
+
+	// Nesting up to the limit is a shape problem, not a depth one
+	var decoded TransactionContext
+	if err := decoded.UnmarshalCBOR(nested(maxCBORDepth - 1)); err != nil && strings.Contains(err.Error(), "nesting") {
+		t.Errorf("nesting within the limit rejected: %v", err)
+	}
+	if err := decoded.UnmarshalCBOR(valid); err != nil || !reflect.DeepEqual(&decoded, ctx) {
+		t.Errorf("valid input decoded to %+v, %v", decoded, err)
+	}
+}